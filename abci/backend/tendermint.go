@@ -27,6 +27,7 @@ import (
 	"github.com/loomnetwork/go-loom/auth"
 	"github.com/loomnetwork/go-loom/util"
 	"github.com/loomnetwork/loomchain/log"
+	"github.com/loomnetwork/loomchain/store"
 	abci_server "github.com/tendermint/tendermint/abci/server"
 	tmcmn "github.com/tendermint/tendermint/libs/common"
 
@@ -90,6 +91,21 @@ func CreateNewCachedDBProvider(config *cfg.Config) (node.DBProvider, error) {
 	}, nil
 }
 
+// notifyingDBProvider returns a DB provider that calls onLoad with every DB loaded by the given
+// provider.
+func notifyingDBProvider(
+	provider node.DBProvider, onLoad func(ctx *node.DBContext, db dbm.DB),
+) node.DBProvider {
+	return func(ctx *node.DBContext) (dbm.DB, error) {
+		db, err := provider(ctx)
+		if err != nil {
+			return nil, err
+		}
+		onLoad(ctx, db)
+		return db, nil
+	}
+}
+
 type Backend interface {
 	ChainID() (string, error)
 	Init() (*loom.Validator, error)
@@ -118,6 +134,8 @@ type TendermintBackend struct {
 	genesisValidators []*loom.Validator
 
 	FnRegistry fnConsensus.FnRegistry
+	// Called with each DB loaded by the Tendermint node, may be nil.
+	OnDBLoaded func(ctx *node.DBContext, db dbm.DB)
 }
 
 // ParseConfig retrieves the default environment configuration,
@@ -402,7 +420,12 @@ func (b *TendermintBackend) Start(app abci.Application) error {
 		if err != nil {
 			return err
 		}
+	}
+	if b.OnDBLoaded != nil {
+		dbProvider = notifyingDBProvider(dbProvider, b.OnDBLoaded)
+	}
 
+	if b.FnRegistry != nil {
 		fnConsensusReactor, err := CreateFnConsensusReactor(b.OverrideCfg.ChainID, privVal, b.FnRegistry, cfg, nodeLogger,
 			dbProvider, b.OverrideCfg.FnConsensusReactorConfig)
 		if err != nil {
@@ -444,6 +467,7 @@ func (b *TendermintBackend) Start(app abci.Application) error {
 			return err
 		}
 		b.node = n
+		store.SetTendermintNodeStarted()
 	}
 	return nil
 }
@@ -10,7 +10,9 @@ import (
 	"github.com/loomnetwork/go-loom/config"
 	"github.com/loomnetwork/go-loom/util"
 	"github.com/loomnetwork/loomchain/eth/utils"
+	"github.com/loomnetwork/loomchain/features"
 	"github.com/loomnetwork/loomchain/registry"
+	"github.com/pkg/errors"
 
 	"github.com/go-kit/kit/metrics"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
@@ -24,11 +26,15 @@ import (
 	evmaux "github.com/loomnetwork/loomchain/store/evm_aux"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	abci "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/merkle"
 	"github.com/tendermint/tendermint/libs/common"
+	ttypes "github.com/tendermint/tendermint/types"
 )
 
 type ReadOnlyState interface {
 	store.KVReader
+	// NewIterator returns an iterator over the keys with the given prefix, see store.IterableKVReader.
+	NewIterator(prefix []byte, opts store.RangeOptions) store.Iterator
 	Validators() []*loom.Validator
 	Block() types.BlockHeader
 	// Release should free up any underlying system resources. Must be safe to invoke multiple times.
@@ -100,6 +106,10 @@ func (s *StoreState) Range(prefix []byte) plugin.RangeData {
 	return s.store.Range(prefix)
 }
 
+func (s *StoreState) NewIterator(prefix []byte, opts store.RangeOptions) store.Iterator {
+	return store.NewIterator(s.store, prefix, opts)
+}
+
 func (s *StoreState) Get(key []byte) []byte {
 	return s.store.Get(key)
 }
@@ -259,6 +269,21 @@ func (s *StoreStateSnapshot) Release() {
 	}
 }
 
+// NewScratchState creates a writable state on top of the given state, writes to the new state are
+// buffered in memory and never reach the underlying state. This makes it possible to execute txs
+// against a read-only snapshot without persisting any changes.
+func NewScratchState(state State) *StoreState {
+	return &StoreState{
+		ctx:        state.Context(),
+		store:      store.WrapAtomic(state).BeginTx(),
+		block:      state.Block(),
+		validators: loom.NewValidatorSet(),
+		getValidatorSet: func(_ State) (loom.ValidatorSet, error) {
+			return loom.NewValidatorSet(state.Validators()...), nil
+		},
+	}
+}
+
 // For all the times you need a read-only store.KVStore but you only have a store.KVReader.
 type readOnlyKVStoreAdapter struct {
 	store.KVReader
@@ -335,6 +360,17 @@ type Application struct {
 	GetValidatorSet             GetValidatorSet
 	EventStore                  store.EventStore
 	config                      *cctypes.Config
+	// Accumulates the changes made by txs that passed CheckTx since the last block was committed,
+	// so that CheckTx can validate txs that depend on pending txs that haven't been included in
+	// a block yet. Reset each time a block is committed.
+	checkTxStore store.KVStoreTx
+	// Used to look up the txs in the current block when parallel tx execution is enabled.
+	BlockStore store.BlockStore
+	// Speculative execution of the txs in the current block, nil if the txs in the current block
+	// are being executed serially.
+	speculation *BlockSpeculation
+	// Takes consistent backups of the node DBs at the end of a block commit, may be nil.
+	Backups *store.BackupManager
 }
 
 var _ abci.Application = &Application{}
@@ -531,9 +567,59 @@ func (a *Application) BeginBlock(req abci.RequestBeginBlock) abci.ResponseBeginB
 
 	storeTx.Commit()
 
+	a.speculation = nil
+	if a.BlockStore != nil && state.FeatureEnabled(features.ParallelTxExecutionFeature, false) {
+		a.speculateBlock(block.Height)
+	}
+
 	return abci.ResponseBeginBlock{}
 }
 
+// speculateBlock executes the txs in the block at the given height in parallel, the results will
+// be committed in order as the txs are delivered. If the txs can't be loaded from the block store
+// the txs will be executed serially.
+func (a *Application) speculateBlock(height int64) {
+	txs, err := a.blockTxs(height)
+	if err == store.ErrBlockStoreUnavailable {
+		// The Tendermint block store isn't accessible while blocks are being replayed on startup
+		log.Debug("block store unavailable, falling back to serial execution", "height", height)
+		return
+	} else if err != nil {
+		log.Error("failed to load block txs, falling back to serial execution", "height", height, "err", err)
+		return
+	}
+	if len(txs) < 2 {
+		return
+	}
+	executor := NewParallelTxExecutor(0, a.runSpeculativeTx)
+	a.speculation = executor.Speculate(a.Store, txs)
+}
+
+func (a *Application) blockTxs(height int64) ([][]byte, error) {
+	block, err := a.BlockStore.GetBlockByHeight(&height)
+	if err != nil {
+		return nil, err
+	}
+	txs := make([][]byte, len(block.Block.Data.Txs))
+	for i, tx := range block.Block.Data.Txs {
+		txs[i] = tx
+	}
+	return txs, nil
+}
+
+func (a *Application) runSpeculativeTx(
+	kvStore store.KVStore, effects *TxEffects, txBytes []byte,
+) (TxHandlerResult, error) {
+	state := NewStoreState(
+		WithTxEffects(context.Background(), effects),
+		kvStore,
+		a.curBlockHeader,
+		a.curBlockHash,
+		a.GetValidatorSet,
+	).WithOnChainConfig(a.config)
+	return a.TxHandler.ProcessTx(state, txBytes, false)
+}
+
 func (a *Application) EndBlock(req abci.RequestEndBlock) abci.ResponseEndBlock {
 	defer func(begin time.Time) {
 		lvs := []string{"method", "EndBlock"}
@@ -544,6 +630,11 @@ func (a *Application) EndBlock(req abci.RequestEndBlock) abci.ResponseEndBlock {
 		panic(fmt.Sprintf("app height %d doesn't match EndBlock height %d", a.height(), req.Height))
 	}
 
+	if a.speculation != nil {
+		log.Debug("parallel tx execution done", "height", req.Height, "reexecuted", a.speculation.NumReExecuted)
+		a.speculation = nil
+	}
+
 	storeTx := store.WrapAtomic(a.Store).BeginTx()
 	state := NewStoreState(
 		context.Background(),
@@ -637,7 +728,12 @@ func (a *Application) DeliverTx(txBytes []byte) abci.ResponseDeliverTx {
 		deliverTxLatency.With(lvs...).Observe(time.Since(begin).Seconds())
 	}(time.Now())
 
-	r, err := a.processTx(txBytes, false)
+	var r TxHandlerResult
+	if a.speculation != nil {
+		r, err = a.deliverSpeculativeTx(txBytes)
+	} else {
+		r, err = a.processTx(txBytes, false)
+	}
 	if err != nil {
 		log.Error(fmt.Sprintf("DeliverTx: %s", err.Error()))
 		return abci.ResponseDeliverTx{Code: 1, Log: err.Error()}
@@ -650,9 +746,12 @@ func (a *Application) DeliverTx(txBytes []byte) abci.ResponseDeliverTx {
 
 func (a *Application) processTx(txBytes []byte, isCheckTx bool) (TxHandlerResult, error) {
 	var err error
-	//TODO we should be keeping this across multiple checktx, and only rolling back after they all complete
-	// for now the nonce will have a special cache that it rolls back each block
-	storeTx := store.WrapAtomic(a.Store).BeginTx()
+	var storeTx store.KVStoreTx
+	if isCheckTx {
+		storeTx = store.WrapAtomic(a.checkTxOverlay()).BeginTx()
+	} else {
+		storeTx = store.WrapAtomic(a.Store).BeginTx()
+	}
 
 	state := NewStoreState(
 		context.Background(),
@@ -662,32 +761,90 @@ func (a *Application) processTx(txBytes []byte, isCheckTx bool) (TxHandlerResult
 		a.GetValidatorSet,
 	).WithOnChainConfig(a.config)
 
-	receiptHandler := a.ReceiptHandlerProvider.Store()
 	r, err := a.TxHandler.ProcessTx(state, txBytes, isCheckTx)
 	if err != nil {
 		storeTx.Rollback()
-		// TODO: save receipt & hash of failed EVM tx to node-local persistent cache (not app state)
-		receiptHandler.DiscardCurrentReceipt()
-		return r, err
+		if isCheckTx {
+			a.ReceiptHandlerProvider.Store().DiscardCurrentReceipt()
+			return r, err
+		}
+	} else {
+		// In CheckTx this only commits the changes to the CheckTx overlay, not the app store
+		storeTx.Commit()
 	}
-
 	if !isCheckTx {
-		if r.Info == utils.CallEVM || r.Info == utils.DeployEvm {
-			err := a.EventHandler.LegacyEthSubscriptionSet().EmitTxEvent(r.Data, r.Info)
-			if err != nil {
-				log.Error("Emit Tx Event error", "err", err)
-			}
-			reader := a.ReceiptHandlerProvider.Reader()
-			if reader.GetCurrentReceipt() != nil {
-				if err = a.EventHandler.EthSubscriptionSet().EmitTxEvent(reader.GetCurrentReceipt().TxHash); err != nil {
-					log.Error("failed to load receipt", "err", err)
-				}
+		a.commitTxReceipt(txBytes, r, err)
+	}
+	return r, err
+}
+
+// deliverSpeculativeTx commits the outcome of the speculative execution of the given tx, or
+// re-executes the tx if the outcome is stale.
+func (a *Application) deliverSpeculativeTx(txBytes []byte) (TxHandlerResult, error) {
+	reader := a.ReceiptHandlerProvider.Reader()
+	delivery := a.speculation.Deliver(
+		a.Store, txBytes, int32(len(reader.GetPendingTxHashList())), a.runDeliveredTx,
+	)
+	if effects := delivery.Effects(); effects != nil {
+		effects.PostEvents(a.EventHandler)
+		if effects.Receipt != nil {
+			a.ReceiptHandlerProvider.Store().SetCurrentReceipt(
+				effects.Receipt, effects.RevertData, effects.InternalTxs,
+			)
+		}
+	}
+	a.commitTxReceipt(txBytes, delivery.Result, delivery.Err)
+	return delivery.Result, delivery.Err
+}
+
+// runDeliveredTx executes the serial middlewares for a tx that has been executed speculatively,
+// and then applies the outcome of the speculative execution.
+func (a *Application) runDeliveredTx(
+	kvStore store.KVStore, delivery *TxDelivery, txBytes []byte,
+) (TxHandlerResult, error) {
+	state := NewStoreState(
+		WithTxDelivery(context.Background(), delivery),
+		kvStore,
+		a.curBlockHeader,
+		a.curBlockHash,
+		a.GetValidatorSet,
+	).WithOnChainConfig(a.config)
+	return a.TxHandler.ProcessTx(state, txBytes, false)
+}
+
+// commitTxReceipt adds the receipt of the EVM tx executed by the given tx (if any) to the
+// pending receipts of the current block.
+func (a *Application) commitTxReceipt(txBytes []byte, r TxHandlerResult, txErr error) {
+	receiptHandler := a.ReceiptHandlerProvider.Store()
+	if txErr != nil {
+		// The receipt of a failed EVM tx isn't part of the app state, it's only stored by
+		// this node so clients can look up why the tx failed.
+		receiptHandler.CommitFailedReceipt(ttypes.Tx(txBytes).Hash())
+		return
+	}
+
+	if r.Info == utils.CallEVM || r.Info == utils.DeployEvm {
+		err := a.EventHandler.LegacyEthSubscriptionSet().EmitTxEvent(r.Data, r.Info)
+		if err != nil {
+			log.Error("Emit Tx Event error", "err", err)
+		}
+		reader := a.ReceiptHandlerProvider.Reader()
+		if reader.GetCurrentReceipt() != nil {
+			if err = a.EventHandler.EthSubscriptionSet().EmitTxEvent(reader.GetCurrentReceipt().TxHash); err != nil {
+				log.Error("failed to load receipt", "err", err)
 			}
-			receiptHandler.CommitCurrentReceipt()
 		}
-		storeTx.Commit()
+		receiptHandler.CommitCurrentReceipt()
+	}
+}
+
+// checkTxOverlay returns the store CheckTx should execute txs against. Changes made by txs that
+// pass CheckTx are written to this store, but never make it to the app store.
+func (a *Application) checkTxOverlay() store.KVStore {
+	if a.checkTxStore == nil {
+		a.checkTxStore = store.WrapAtomic(a.Store).BeginTx()
 	}
-	return r, nil
+	return a.checkTxStore
 }
 
 // Commit commits the current block
@@ -699,12 +856,29 @@ func (a *Application) Commit() abci.ResponseCommit {
 		commitBlockLatency.With(lvs...).Observe(time.Since(begin).Seconds())
 		log.Info(fmt.Sprintf("commit took %f seconds-----\n", time.Since(begin).Seconds())) //todo we can remove these once performance comes back to normal state
 	}(time.Now())
-	appHash, _, err := a.Store.SaveVersion()
+	height := a.curBlockHeader.GetHeight()
+	// The events must be recorded before the app state is saved, otherwise they'd be lost if the
+	// node crashed in between, since Tendermint won't replay a block that has been committed.
+	if err := a.EventHandler.SaveBlockEvents(uint64(height), a.curBlockHeader.Time); err != nil {
+		panic(err)
+	}
+	state := NewStoreState(
+		context.Background(),
+		a.Store,
+		a.curBlockHeader,
+		a.curBlockHash,
+		a.GetValidatorSet,
+	).WithOnChainConfig(a.config)
+	// The hash of each block is stored in the app state so it's available to the EVM in the
+	// following blocks, so all nodes must store it starting at the same height.
+	if state.FeatureEnabled(features.EvmBlockHashFeature, false) {
+		SetEVMBlockHash(state, uint64(height), a.curBlockHash)
+	}
+	appHash, version, err := a.Store.SaveVersion()
 	if err != nil {
 		panic(err)
 	}
 
-	height := a.curBlockHeader.GetHeight()
 	go func(height int64, blockHeader abci.Header) {
 		if err := a.EventHandler.EmitBlockTx(uint64(height), blockHeader.Time); err != nil {
 			log.Error("Emit Block Event error", "err", err)
@@ -718,6 +892,10 @@ func (a *Application) Commit() abci.ResponseCommit {
 	}(height, a.curBlockHeader)
 	a.lastBlockHeader = a.curBlockHeader
 
+	// Discard all pending CheckTx changes, Tendermint will recheck any txs that remain in the
+	// mempool, which will rebuild the overlay on top of the newly committed state.
+	a.checkTxStore = nil
+
 	if err := a.Store.Prune(); err != nil {
 		log.Error("failed to prune app.db", "err", err)
 	}
@@ -726,22 +904,85 @@ func (a *Application) Commit() abci.ResponseCommit {
 		a.BlockIndexStore.SetBlockHashAtHeight(uint64(height), a.curBlockHash)
 	}
 
+	// All the DBs written by the app are now at the same height
+	if a.Backups != nil {
+		a.Backups.Checkpoint(version)
+	}
+
 	return abci.ResponseCommit{
 		Data: appHash,
 	}
 }
 
+// StoreQueryPath is the ABCI query path used to look up raw app store keys, the query data should
+// contain the key. Set RequestQuery.Prove to obtain a Merkle proof of the key's existence or absence.
+const StoreQueryPath = "/store"
+
 func (a *Application) Query(req abci.RequestQuery) abci.ResponseQuery {
+	if req.Path == StoreQueryPath {
+		return a.queryStore(req)
+	}
+	if req.Prove {
+		return abci.ResponseQuery{Code: 1, Log: "proofs are only available for " + StoreQueryPath + " queries"}
+	}
 	if a.QueryHandler == nil {
 		return abci.ResponseQuery{Code: 1, Log: "not implemented"}
 	}
 
-	result, err := a.QueryHandler.Handle(a.ReadOnlyState(), req.Path, req.Data)
+	var snapshot State
+	if req.Height == 0 {
+		snapshot = a.ReadOnlyState()
+	} else {
+		var err error
+		snapshot, err = a.ReadOnlyStateAt(req.Height)
+		if err != nil {
+			return abci.ResponseQuery{Code: 1, Log: err.Error()}
+		}
+	}
+	defer snapshot.Release()
+
+	result, err := a.QueryHandler.Handle(snapshot, req.Path, req.Data)
 	if err != nil {
 		return abci.ResponseQuery{Code: 1, Log: err.Error()}
 	}
 
-	return abci.ResponseQuery{Code: abci.CodeTypeOK, Value: result}
+	return abci.ResponseQuery{Code: abci.CodeTypeOK, Value: result, Height: snapshot.Block().Height}
+}
+
+// queryStore looks up the raw app store key in the query data, if the query requests a proof then
+// the response will contain an IAVL existence or absence proof for the key.
+func (a *Application) queryStore(req abci.RequestQuery) abci.ResponseQuery {
+	if len(req.Data) == 0 {
+		return abci.ResponseQuery{Code: 1, Log: "missing key"}
+	}
+	value, proof, height, err := a.GetWithProof(req.Data, req.Height)
+	if err != nil {
+		return abci.ResponseQuery{Code: 1, Log: err.Error()}
+	}
+	resp := abci.ResponseQuery{Code: abci.CodeTypeOK, Key: req.Data, Value: value, Height: height}
+	if req.Prove {
+		resp.Proof = proof
+	}
+	return resp
+}
+
+// GetWithProof returns the value of the given app store key at the given height, along with a
+// proof of existence (or absence if the key doesn't exist) that can be verified against the app
+// hash computed at the end of the block at that height. Note that Tendermint stores this app hash
+// in the header of the next block. If height is zero the latest committed state is used.
+func (a *Application) GetWithProof(key []byte, height int64) ([]byte, *merkle.Proof, int64, error) {
+	provable, ok := a.Store.(store.ProvableStore)
+	if !ok {
+		return nil, nil, 0, errors.New("app store doesn't support proofs")
+	}
+	if height == 0 {
+		height = a.Store.Version()
+	}
+	value, proof, err := provable.GetWithProof(key, height)
+	if err != nil {
+		return nil, nil, 0, errors.Wrapf(err, "failed to prove key at height %d", height)
+	}
+	return value, proof, height, nil
 }
 
 func (a *Application) height() int64 {
@@ -759,6 +1000,34 @@ func (a *Application) ReadOnlyState() State {
 	)
 }
 
+// ReadOnlyStateAt returns a read-only snapshot of the app state as it was at the end of the block
+// at the given height. Only heights that haven't been pruned from the app store can be loaded.
+// NOTE: Historical snapshots only have the chain ID & height of the block header populated.
+func (a *Application) ReadOnlyStateAt(height int64) (State, error) {
+	if height == a.lastBlockHeader.Height {
+		return a.ReadOnlyState(), nil
+	}
+	if height > a.lastBlockHeader.Height {
+		return nil, fmt.Errorf(
+			"height %d exceeds last committed block height %d", height, a.lastBlockHeader.Height,
+		)
+	}
+	snap, err := a.Store.GetSnapshotAt(height)
+	if err != nil {
+		return nil, errors.Wrapf(err, "state at height %d is not available", height)
+	}
+	return NewStoreStateSnapshot(
+		nil,
+		snap,
+		abci.Header{
+			ChainID: a.lastBlockHeader.ChainID,
+			Height:  height,
+		},
+		nil,
+		a.GetValidatorSet,
+	), nil
+}
+
 func loadOnChainConfig(kvStore store.KVReader) *cctypes.Config {
 	configBytes := kvStore.Get([]byte(configKey))
 	cfg := config.DefaultConfig()
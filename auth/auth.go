@@ -96,9 +96,10 @@ func Nonce(state loomchain.ReadOnlyState, addr loom.Address) uint64 {
 	return loomchain.NewSequence(nonceKey(addr)).Value(state)
 }
 
+// NonceHandler checks that the sequence number of each tx matches the next nonce of the tx origin.
+// In CheckTx the nonce is read from the CheckTx overlay, so the nonces of pending txs that haven't
+// been included in a block yet are taken into account.
 type NonceHandler struct {
-	nonceCache map[string]uint64
-	lastHeight int64
 }
 
 func (n *NonceHandler) Nonce(
@@ -113,14 +114,12 @@ func (n *NonceHandler) Nonce(
 	if origin.IsEmpty() {
 		return r, errors.New("transaction has no origin [nonce]")
 	}
-	if n.lastHeight != state.Block().Height {
-		n.lastHeight = state.Block().Height
-		n.nonceCache = make(map[string]uint64)
-		//clear the cache for each block
-	}
 	var seq uint64
 	if state.FeatureEnabled(features.IncrementNonceOnFailedTxFeature, false) && !isCheckTx {
 		// Unconditionally increment the nonce in DeliverTx, regardless of whether the tx succeeds
+		if effects := loomchain.TxEffectsFromContext(state.Context()); effects != nil {
+			kvStore = effects.Store
+		}
 		seq = loomchain.NewSequence(nonceKey(origin)).Next(kvStore)
 	} else {
 		seq = loomchain.NewSequence(nonceKey(origin)).Next(state)
@@ -132,15 +131,6 @@ func (n *NonceHandler) Nonce(
 		return r, err
 	}
 
-	//TODO nonce cache is temporary until we have a separate atomic state for the entire checktx flow
-	cacheSeq := n.nonceCache[origin.String()]
-	//If we have a client send multiple transactions in a single block we can run into this problem
-	if cacheSeq != 0 && isCheckTx { //only run this code during checktx
-		seq = cacheSeq
-	} else {
-		n.nonceCache[origin.String()] = seq
-	}
-
 	if tx.Sequence != seq {
 		nonceErrorCount.Add(1)
 		return r, fmt.Errorf("sequence number does not match expected %d got %d", seq, tx.Sequence)
@@ -149,27 +139,7 @@ func (n *NonceHandler) Nonce(
 	return next(state, tx.Inner, isCheckTx)
 }
 
-func (n *NonceHandler) IncNonce(state loomchain.State,
-	txBytes []byte,
-	result loomchain.TxHandlerResult,
-	postcommit loomchain.PostCommitHandler,
-) error {
-
-	origin := Origin(state.Context())
-	if origin.IsEmpty() {
-		return errors.New("transaction has no origin [IncNonce]")
-	}
-
-	//We only increment the nonce if the transaction is successful
-	//There are situations in checktx where we may not have committed the transaction to the statestore yet
-	n.nonceCache[origin.String()] = n.nonceCache[origin.String()] + 1
-
-	return nil
-}
-
-var NonceTxHandler = NonceHandler{nonceCache: make(map[string]uint64), lastHeight: 0}
-
-var NonceTxPostNonceMiddleware = loomchain.PostCommitMiddlewareFunc(NonceTxHandler.IncNonce)
+var NonceTxHandler = NonceHandler{}
 
 var NonceTxMiddleware = func(kvStore store.KVStore) loomchain.TxMiddlewareFunc {
 	nonceTxMiddleware := func(
@@ -59,81 +59,52 @@ func TestSignatureTxMiddlewareMultipleTxSameBlock(t *testing.T) {
 	}
 
 	ctx := context.WithValue(context.Background(), ContextKeyOrigin, origin)
-	ctx = context.WithValue(ctx, ContextKeyCheckTx, true)
-	kvStore := store.NewMemStore()
-	state := loomchain.NewStoreState(ctx, kvStore, abci.Header{Height: 27}, nil, nil)
-	state.SetFeature(features.IncrementNonceOnFailedTxFeature, true)
-
-	_, err = NonceTxHandler.Nonce(state, kvStore, nonceTxBytes,
-		func(state loomchain.State, txBytes []byte, isCheckTx bool) (loomchain.TxHandlerResult, error) {
-			return loomchain.TxHandlerResult{}, nil
-		}, false,
-	)
-	require.Nil(t, err)
-	NonceTxPostNonceMiddleware(state, nonceTxBytes, loomchain.TxHandlerResult{}, nil)
-
-	//State is reset on every run
-	ctx2 := context.WithValue(context.Background(), ContextKeyOrigin, origin)
-	kvStore2 := store.NewMemStore()
-	state2 := loomchain.NewStoreState(ctx2, kvStore2, abci.Header{Height: 27}, nil, nil)
-	state2.SetFeature(features.IncrementNonceOnFailedTxFeature, true)
-	ctx2 = context.WithValue(ctx2, ContextKeyCheckTx, true)
-
-	//If we get the same sequence number in same block we should get an error
-	_, err = NonceTxHandler.Nonce(state2, kvStore2, nonceTxBytes,
-		func(state2 loomchain.State, txBytes []byte, isCheckTx bool) (loomchain.TxHandlerResult, error) {
-			return loomchain.TxHandlerResult{}, nil
-		}, true,
-	)
-	require.Errorf(t, err, "sequence number does not match")
-	//	NonceTxPostNonceMiddleware shouldnt get called on an error
-
-	//State is reset on every run
-	ctx3 := context.WithValue(context.Background(), ContextKeyOrigin, origin)
-	kvStore3 := store.NewMemStore()
-	state3 := loomchain.NewStoreState(ctx3, kvStore3, abci.Header{Height: 27}, nil, nil)
-	state3.SetFeature(features.IncrementNonceOnFailedTxFeature, true)
-	ctx3 = context.WithValue(ctx3, ContextKeyCheckTx, true)
-
-	//If we get to tx with incrementing sequence numbers we should be fine in the same block
-	_, err = NonceTxHandler.Nonce(state3, kvStore3, nonceTxBytes2,
-		func(state3 loomchain.State, txBytes []byte, isCheckTx bool) (loomchain.TxHandlerResult, error) {
-			return loomchain.TxHandlerResult{}, nil
-		}, true,
-	)
-	require.Nil(t, err)
-	NonceTxPostNonceMiddleware(state, nonceTxBytes2, loomchain.TxHandlerResult{}, nil)
+	appStore := store.NewMemStore()
+	// CheckTx runs on top of an overlay that accumulates the changes made by all the txs that
+	// passed CheckTx since the last block was committed
+	checkTxStore := store.WrapAtomic(appStore).BeginTx()
+	checkTx := func(txBytes []byte) error {
+		storeTx := store.WrapAtomic(checkTxStore).BeginTx()
+		state := loomchain.NewStoreState(ctx, storeTx, abci.Header{Height: 27}, nil, nil)
+		state.SetFeature(features.IncrementNonceOnFailedTxFeature, true)
+		_, err := NonceTxHandler.Nonce(state, appStore, txBytes,
+			func(state loomchain.State, txBytes []byte, isCheckTx bool) (loomchain.TxHandlerResult, error) {
+				return loomchain.TxHandlerResult{}, nil
+			}, true,
+		)
+		if err != nil {
+			storeTx.Rollback()
+			return err
+		}
+		storeTx.Commit()
+		return nil
+	}
 
-	//Try a deliverTx at same height it should be fine
-	ctx3Dx := context.WithValue(context.Background(), ContextKeyOrigin, origin)
-	kvStore3Dx := store.NewMemStore()
-	state3Dx := loomchain.NewStoreState(ctx3Dx, kvStore3Dx, abci.Header{Height: 27}, nil, nil)
-	state3Dx.SetFeature(features.IncrementNonceOnFailedTxFeature, true)
-	ctx3Dx = context.WithValue(ctx3Dx, ContextKeyCheckTx, true)
+	require.NoError(t, checkTx(nonceTxBytes))
+	// If we get the same sequence number in the same block we should get an error
+	require.Error(t, checkTx(nonceTxBytes))
+	// Txs with incrementing sequence numbers should be fine in the same block
+	require.NoError(t, checkTx(nonceTxBytes2))
+	// The app store shouldn't be affected by CheckTx
+	require.Equal(t, uint64(0), Nonce(loomchain.NewStoreState(ctx, appStore, abci.Header{}, nil, nil), origin))
 
-	_, err = NonceTxHandler.Nonce(state3Dx, kvStore3Dx, nonceTxBytes,
-		func(state3 loomchain.State, txBytes []byte, isCheckTx bool) (loomchain.TxHandlerResult, error) {
+	// Try a DeliverTx at the same height, it should be fine
+	storeTx := store.WrapAtomic(appStore).BeginTx()
+	state := loomchain.NewStoreState(ctx, storeTx, abci.Header{Height: 27}, nil, nil)
+	state.SetFeature(features.IncrementNonceOnFailedTxFeature, true)
+	_, err = NonceTxHandler.Nonce(state, appStore, nonceTxBytes,
+		func(state loomchain.State, txBytes []byte, isCheckTx bool) (loomchain.TxHandlerResult, error) {
 			return loomchain.TxHandlerResult{}, nil
 		}, false,
 	)
-	require.Nil(t, err)
-	NonceTxPostNonceMiddleware(state, nonceTxBytes, loomchain.TxHandlerResult{}, nil)
-
-	///--------------increase block height should kill cache
-	//State is reset on every run
-	ctx4 := context.WithValue(nil, ContextKeyOrigin, origin)
-	kvStore4 := store.NewMemStore()
-	state4 := loomchain.NewStoreState(ctx4, kvStore4, abci.Header{Height: 28}, nil, nil)
-	state4.SetFeature(features.IncrementNonceOnFailedTxFeature, true)
-	//If we get to tx with incrementing sequence numbers we should be fine in the same block
-	_, err = NonceTxHandler.Nonce(state4, kvStore4, nonceTxBytes,
-		func(state4 loomchain.State, txBytes []byte, isCheckTx bool) (loomchain.TxHandlerResult, error) {
-			return loomchain.TxHandlerResult{}, nil
-		}, true,
-	)
-	require.Nil(t, err)
-	NonceTxPostNonceMiddleware(state, nonceTxBytes, loomchain.TxHandlerResult{}, nil)
+	require.NoError(t, err)
+	storeTx.Commit()
 
+	// After the block is committed the overlay is reset, so only the tx that was included in the
+	// block should be reflected in the nonce
+	checkTxStore = store.WrapAtomic(appStore).BeginTx()
+	require.Error(t, checkTx(nonceTxBytes))
+	require.NoError(t, checkTx(nonceTxBytes2))
 }
 
 func TestRevertedTxNonceMiddleware(t *testing.T) {
@@ -177,7 +148,6 @@ func TestRevertedTxNonceMiddleware(t *testing.T) {
 		}, false,
 	)
 	require.Nil(t, err)
-	NonceTxPostNonceMiddleware(state, nonceTxBytes, loomchain.TxHandlerResult{}, nil)
 	storeTx.Commit()
 	storeTx.Rollback()
 
@@ -188,7 +158,6 @@ func TestRevertedTxNonceMiddleware(t *testing.T) {
 		}, false,
 	)
 	require.Error(t, err)
-	NonceTxPostNonceMiddleware(state, nonceTxBytes, loomchain.TxHandlerResult{}, nil)
 	storeTx.Rollback()
 
 	currentNonce = Nonce(state, origin)
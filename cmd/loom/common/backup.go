@@ -0,0 +1,50 @@
+package common
+
+import (
+	"path/filepath"
+
+	"github.com/loomnetwork/loomchain/config"
+	cdb "github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/store"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+// BackupDBPath returns the path of a DB relative to the node root dir, which is where the DB will be
+// restored to from a backup.
+func BackupDBPath(cfg *config.Config, dbPath string) string {
+	absPath, err := filepath.Abs(dbPath)
+	if err != nil {
+		return dbPath
+	}
+	relPath, err := filepath.Rel(cfg.RootPath(), absPath)
+	if err != nil {
+		return absPath
+	}
+	return relPath
+}
+
+// TendermintBackupSource returns a backup source for one of the Tendermint DBs, e.g. blockstore.
+func TendermintBackupSource(
+	cfg *config.Config, name, dbDir, backend string, db dbm.DB,
+) store.BackupSource {
+	// Tendermint refers to goleveldb as leveldb
+	if backend == string(dbm.LevelDBBackend) {
+		backend = cdb.GoLevelDBBackend
+	}
+	src := store.BackupSource{
+		Name:    name,
+		Path:    BackupDBPath(cfg, filepath.Join(dbDir, name+".db")),
+		Backend: backend,
+		Snapshot: func() cdb.Snapshot {
+			return cdb.SnapshotDB(db)
+		},
+		// Only the block store keeps track of its height
+		Height: func(cdb.Snapshot) int64 {
+			return 0
+		},
+	}
+	if name == "blockstore" {
+		src.Height = store.TendermintBlockStoreHeight
+	}
+	return src
+}
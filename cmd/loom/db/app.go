@@ -5,9 +5,13 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/loomnetwork/loomchain/cmd/loom/common"
+	"github.com/loomnetwork/loomchain/config"
+	cdb "github.com/loomnetwork/loomchain/db"
 	"github.com/loomnetwork/loomchain/store"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	dbm "github.com/tendermint/tendermint/libs/db"
@@ -15,22 +19,74 @@ import (
 
 func newPruneDBCommand() *cobra.Command {
 	var numVersions int64
+	var pruneEVM bool
+	var keepVersions int64
 	cmd := &cobra.Command{
 		Use:   "prune",
 		Short: "Deletes older tree versions from app.db",
+		Long: "Deletes older tree versions from app.db, or with --evm deletes EVM state from evm.db " +
+			"that's no longer reachable from the most recent versions",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := common.ParseConfig()
 			if err != nil {
 				return err
 			}
-			return store.PruneDatabase(cfg.DBName, cfg.RootPath(), numVersions)
+			if !pruneEVM {
+				return store.PruneDatabase(cfg.DBName, cfg.RootPath(), numVersions)
+			}
+			if keepVersions == 0 {
+				keepVersions = cfg.AppStore.MaxVersions
+			}
+			return pruneEVMDatabase(cfg, keepVersions)
 		},
 	}
 	flags := cmd.Flags()
 	flags.Int64VarP(&numVersions, "versions", "n", 0, "Number of tree versions to prune")
+	flags.BoolVar(&pruneEVM, "evm", false, "Prune stale EVM state from evm.db instead of app.db")
+	flags.Int64Var(
+		&keepVersions, "keep-versions", 0,
+		"Number of most recent versions of EVM state to keep (defaults to AppStore.MaxVersions)",
+	)
 	return cmd
 }
 
+func pruneEVMDatabase(cfg *config.Config, keepVersions int64) error {
+	if keepVersions < 2 {
+		return fmt.Errorf("at least 2 versions of EVM state must be kept, use --keep-versions")
+	}
+
+	appDB, err := cdb.LoadDB(
+		cfg.DBBackend, cfg.DBName, cfg.RootPath(),
+		cfg.DBBackendConfig.CacheSizeMegs, cfg.DBBackendConfig.WriteBufferMegs, false,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to load app.db")
+	}
+	defer appDB.Close()
+
+	iavlStore, err := store.NewIAVLStore(appDB, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	evmDB, err := cdb.LoadDB(
+		cfg.EvmStore.DBBackend, cfg.EvmStore.DBName, cfg.RootPath(),
+		cfg.EvmStore.CacheSizeMegs, cfg.EvmStore.WriteBufferMegs, false,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to load evm.db")
+	}
+	defer evmDB.Close()
+
+	startTime := time.Now()
+	stats, err := store.PruneEvmDatabase(evmDB, iavlStore.Version(), keepVersions, cfg.EvmStore.PruneBatchSize)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pruned evm.db in %v secs: %s\n", time.Since(startTime).Seconds(), stats)
+	return nil
+}
+
 func newCompactDBCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "compact",
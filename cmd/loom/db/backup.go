@@ -0,0 +1,257 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/loomnetwork/go-loom/client"
+	"github.com/loomnetwork/loomchain/cmd/loom/common"
+	"github.com/loomnetwork/loomchain/config"
+	cdb "github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/rpc"
+	"github.com/loomnetwork/loomchain/store"
+	evmaux "github.com/loomnetwork/loomchain/store/evm_aux"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	amino "github.com/tendermint/go-amino"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+// Tendermint DBs that are included in offline backups.
+var tendermintBackupDBs = []string{"blockstore", "state", "tx_index", "evidence", "fnConsensus"}
+
+func newBackupDBCommand() *cobra.Command {
+	var unsafeRPCURI string
+	cmd := &cobra.Command{
+		Use:   "backup <path/to/backup/dir>",
+		Short: "Backs up all the node DBs at a single block height",
+		Long: "Backs up app.db, evm.db, the receipts & block index DBs, and the Tendermint DBs at a single " +
+			"block height. If the node is running the backup must be requested via the unsafe RPC " +
+			"interface with --unsafe-rpc, in which case the backup is written by the node in the " +
+			"background, and is complete once manifest.json is written to the backup dir.\n" +
+			"The event store isn't included in the backup since events are indexed asynchronously.",
+		Example: "  loom db backup backups/node-1\n" +
+			"  loom db backup /var/backups/node-1 --unsafe-rpc http://127.0.0.1:26680",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			backupDir, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+			if unsafeRPCURI != "" {
+				return requestNodeBackup(unsafeRPCURI, backupDir)
+			}
+			cfg, err := common.ParseConfig()
+			if err != nil {
+				return err
+			}
+			return backupNodeDBs(cfg, backupDir)
+		},
+	}
+	cmd.Flags().StringVar(
+		&unsafeRPCURI, "unsafe-rpc", "", "URI of the unsafe RPC interface of a running node to back up",
+	)
+	return cmd
+}
+
+func requestNodeBackup(unsafeRPCURI, backupDir string) error {
+	var rawResult json.RawMessage
+	c := client.NewJSONRPCClient(unsafeRPCURI)
+	if err := c.Call("unsafe_backup", map[string]interface{}{"dir": backupDir}, "1", &rawResult); err != nil {
+		return errors.Wrap(err, "failed to call unsafe_backup")
+	}
+	var result rpc.BackupResult
+	if err := amino.NewCodec().UnmarshalJSON(rawResult, &result); err != nil {
+		return errors.Wrap(err, "failed to unmarshal rpc response result")
+	}
+	fmt.Printf("Node is writing backup at height %d to %s\n", result.Height, result.Dir)
+	return nil
+}
+
+// backupNodeDBs backs up the DBs of a node that isn't running.
+func backupNodeDBs(cfg *config.Config, backupDir string) error {
+	backups := store.NewBackupManager()
+
+	appDB, err := cdb.LoadDB(
+		cfg.DBBackend, cfg.DBName, cfg.RootPath(), cfg.DBBackendConfig.CacheSizeMegs,
+		cfg.DBBackendConfig.WriteBufferMegs, false,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to load app.db")
+	}
+	defer appDB.Close()
+	iavlStore, err := store.NewIAVLStore(appDB, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+	backups.AddSource(store.BackupSource{
+		Name:     store.BackupAppDBName,
+		Path:     common.BackupDBPath(cfg, filepath.Join(cfg.RootPath(), cfg.DBName+".db")),
+		Backend:  cfg.DBBackend,
+		Snapshot: appDB.GetSnapshot,
+	})
+
+	if cfg.AppStore.Version == 3 {
+		evmDB, err := cdb.LoadDB(
+			cfg.EvmStore.DBBackend, cfg.EvmStore.DBName, cfg.RootPath(), cfg.EvmStore.CacheSizeMegs,
+			cfg.EvmStore.WriteBufferMegs, false,
+		)
+		if err != nil {
+			return errors.Wrap(err, "failed to load evm.db")
+		}
+		defer evmDB.Close()
+		backups.AddSource(store.BackupSource{
+			Name:     cfg.EvmStore.DBName,
+			Path:     common.BackupDBPath(cfg, filepath.Join(cfg.RootPath(), cfg.EvmStore.DBName+".db")),
+			Backend:  cfg.EvmStore.DBBackend,
+			Snapshot: evmDB.GetSnapshot,
+		})
+	}
+
+	if _, err := os.Stat(evmaux.EvmAuxDBName); err == nil {
+		evmAuxStore, err := evmaux.LoadStore()
+		if err != nil {
+			return errors.Wrap(err, "failed to load receipts DB")
+		}
+		defer evmAuxStore.Close()
+		backups.AddSource(store.BackupSource{
+			Name:    evmaux.EvmAuxDBName,
+			Path:    common.BackupDBPath(cfg, evmaux.EvmAuxDBName),
+			Backend: cdb.GoLevelDBBackend,
+			Snapshot: func() cdb.Snapshot {
+				snapshot, err := evmAuxStore.DB().GetSnapshot()
+				if err != nil {
+					panic(err)
+				}
+				return &cdb.GoLevelDBSnapshot{Snapshot: snapshot}
+			},
+		})
+	}
+
+	if cfg.BlockIndexStore.Enabled {
+		blockIndexDB, err := cdb.LoadDB(
+			cfg.BlockIndexStore.DBBackend, cfg.BlockIndexStore.DBName, cfg.RootPath(),
+			cfg.BlockIndexStore.CacheSizeMegs, cfg.BlockIndexStore.WriteBufferMegs, false,
+		)
+		if err != nil {
+			return errors.Wrap(err, "failed to load block index DB")
+		}
+		defer blockIndexDB.Close()
+		backups.AddSource(store.BackupSource{
+			Name: cfg.BlockIndexStore.DBName,
+			Path: common.BackupDBPath(
+				cfg, filepath.Join(cfg.RootPath(), cfg.BlockIndexStore.DBName+".db"),
+			),
+			Backend:  cfg.BlockIndexStore.DBBackend,
+			Snapshot: blockIndexDB.GetSnapshot,
+		})
+	}
+
+	// Assumes the default Tendermint DB dir & backend.
+	tmDBDir := filepath.Join(cfg.RootPath(), "chaindata", "data")
+	for _, name := range tendermintBackupDBs {
+		if _, err := os.Stat(filepath.Join(tmDBDir, name+".db")); err != nil {
+			continue
+		}
+		tmDB := dbm.NewDB(name, dbm.LevelDBBackend, tmDBDir)
+		defer tmDB.Close()
+		backups.AddSource(common.TendermintBackupSource(
+			cfg, name, tmDBDir, string(dbm.LevelDBBackend), tmDB,
+		))
+	}
+
+	startTime := time.Now()
+	manifest, err := backups.Backup(backupDir, iavlStore.Version())
+	if err != nil {
+		return err
+	}
+	for _, entry := range manifest.DBs {
+		fmt.Printf("Backed up %s (%d keys)\n", entry.Path, entry.NumKeys)
+	}
+	fmt.Printf(
+		"Backed up node at height %d to %s in %v secs\n",
+		manifest.Height, backupDir, time.Since(startTime).Seconds(),
+	)
+	return nil
+}
+
+func newRestoreDBCommand() *cobra.Command {
+	var batchSize int
+	cmd := &cobra.Command{
+		Use:   "restore <path/to/backup/dir>",
+		Short: "Restores all the node DBs from a backup",
+		Long: "Verifies the backup against its manifest, restores the DBs to a staging dir, and then " +
+			"swaps them into place. The DBs that are replaced are moved to a pre-restore-<timestamp> " +
+			"dir in the node root dir. The node must be stopped while the DBs are restored.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := common.ParseConfig()
+			if err != nil {
+				return err
+			}
+			return restoreNodeDBs(cfg, args[0], batchSize)
+		},
+	}
+	cmd.Flags().IntVar(&batchSize, "batch-size", 10000, "Number of keys to write to a DB at a time")
+	return cmd
+}
+
+func restoreNodeDBs(cfg *config.Config, backupDir string, batchSize int) error {
+	// A running node holds a lock on app.db
+	appDB, err := cdb.LoadDB(
+		cfg.DBBackend, cfg.DBName, cfg.RootPath(), cfg.DBBackendConfig.CacheSizeMegs,
+		cfg.DBBackendConfig.WriteBufferMegs, false,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to load app.db, the node must be stopped before restoring")
+	}
+	appDB.Close()
+
+	stagingDir := filepath.Join(cfg.RootPath(), "restore.tmp")
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	manifest, err := store.RestoreBackup(backupDir, func(entry store.BackupDBManifest) (dbm.DB, error) {
+		fmt.Printf("Restoring %s\n", entry.Path)
+		return cdb.LoadDB(entry.Backend, entry.Name, stagingDir, 256, 64, false)
+	}, batchSize)
+	if err != nil {
+		return err
+	}
+
+	oldDir := filepath.Join(cfg.RootPath(), fmt.Sprintf("pre-restore-%d", time.Now().Unix()))
+	for _, entry := range manifest.DBs {
+		dest := entry.Path
+		if !filepath.IsAbs(dest) {
+			dest = filepath.Join(cfg.RootPath(), dest)
+		}
+		if _, err := os.Stat(dest); err == nil {
+			old := filepath.Join(oldDir, entry.Name+".db")
+			if err := os.MkdirAll(oldDir, 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(dest, old); err != nil {
+				return errors.Wrapf(err, "failed to move %s to %s", dest, old)
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(stagingDir, entry.Name+".db"), dest); err != nil {
+			return errors.Wrapf(err, "failed to move restored %s into place", entry.Name)
+		}
+	}
+	fmt.Printf("Restored node DBs at height %d from %s\n", manifest.Height, backupDir)
+	if _, err := os.Stat(oldDir); err == nil {
+		fmt.Printf("Replaced DBs were moved to %s\n", oldDir)
+	}
+	return nil
+}
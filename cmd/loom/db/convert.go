@@ -0,0 +1,95 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	cdb "github.com/loomnetwork/loomchain/db"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+type convertDBFlags struct {
+	SrcBackend      string
+	DestBackend     string
+	BatchSize       int
+	CacheSizeMegs   int
+	WriteBufferMegs int
+}
+
+func newConvertDBCommand() *cobra.Command {
+	var flags convertDBFlags
+	cmd := &cobra.Command{
+		Use:   "convert <path/to/src.db> <path/to/dest.db>",
+		Short: "Copies all the data in a DB to a new DB that uses a different backend",
+		Example: "  loom db convert chaindata/evm.db chaindata/evm-badger.db " +
+			"--src-backend goleveldb --dest-backend badgerdb",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			srcName, srcDir, err := parseDBPath(args[0])
+			if err != nil {
+				return err
+			}
+			destName, destDir, err := parseDBPath(args[1])
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(filepath.Join(srcDir, srcName+".db")); err != nil {
+				return errors.Wrapf(err, "failed to find source DB %s", args[0])
+			}
+			if _, err := os.Stat(filepath.Join(destDir, destName+".db")); err == nil {
+				return fmt.Errorf("destination DB %s already exists", args[1])
+			}
+
+			srcDB, err := cdb.LoadDB(
+				flags.SrcBackend, srcName, srcDir, flags.CacheSizeMegs, flags.WriteBufferMegs, false,
+			)
+			if err != nil {
+				return errors.Wrap(err, "failed to load source DB")
+			}
+			defer srcDB.Close()
+
+			destDB, err := cdb.LoadDB(
+				flags.DestBackend, destName, destDir, flags.CacheSizeMegs, flags.WriteBufferMegs, false,
+			)
+			if err != nil {
+				return errors.Wrap(err, "failed to create destination DB")
+			}
+			defer destDB.Close()
+
+			numKeys := cdb.CopyDB(srcDB, destDB, flags.BatchSize, func(numKeys int64) {
+				fmt.Printf("Copied %d keys\n", numKeys)
+			})
+			fmt.Printf("Converted %s (%s) to %s (%s), %d keys copied\n",
+				args[0], flags.SrcBackend, args[1], flags.DestBackend, numKeys)
+			return nil
+		},
+	}
+	cmdFlags := cmd.Flags()
+	cmdFlags.StringVar(&flags.SrcBackend, "src-backend", cdb.GoLevelDBBackend, "Backend of the source DB")
+	cmdFlags.StringVar(
+		&flags.DestBackend, "dest-backend", cdb.BadgerDBBackend,
+		fmt.Sprintf("Backend of the destination DB (%s, %s, %s, or %s)",
+			cdb.GoLevelDBBackend, cdb.CLevelDBBackend, cdb.BadgerDBBackend, cdb.BoltDBBackend),
+	)
+	cmdFlags.IntVar(&flags.BatchSize, "batch-size", 10000, "Number of keys to write to the destination DB at a time")
+	cmdFlags.IntVar(&flags.CacheSizeMegs, "cache-size", 256, "Cache size (in megabytes) of both DBs")
+	cmdFlags.IntVar(&flags.WriteBufferMegs, "write-buffer-size", 64, "Write buffer size (in megabytes) of both DBs")
+	return cmd
+}
+
+// parseDBPath splits the path to a DB into the DB name and the directory the DB is stored in,
+// e.g. chaindata/app.db -> app, chaindata
+func parseDBPath(dbPath string) (string, string, error) {
+	absPath, err := filepath.Abs(dbPath)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to resolve DB path '%s'", dbPath)
+	}
+	if path.Ext(absPath) != ".db" {
+		return "", "", fmt.Errorf("DB path '%s' must end with .db", dbPath)
+	}
+	return strings.TrimSuffix(path.Base(absPath), ".db"), path.Dir(absPath), nil
+}
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/loomnetwork/go-loom"
+	"github.com/loomnetwork/go-loom/cli"
+	cdb "github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+type diffDBFlags struct {
+	FromVersion int64
+	ToVersion   int64
+	Backend     string
+	EvmBackend  string
+	Contract    string
+	ChainID     string
+	Prefix      string
+	Format      string
+	NoEVM       bool
+}
+
+// appStoreDiff is the JSON output of the diff command.
+type appStoreDiff struct {
+	FromVersion int64                   `json:"fromVersion"`
+	ToVersion   int64                   `json:"toVersion"`
+	Keys        []*store.KeyDiff        `json:"keys"`
+	EvmAccounts []*store.EvmAccountDiff `json:"evmAccounts,omitempty"`
+}
+
+func newDiffDBCommand() *cobra.Command {
+	var flags diffDBFlags
+	cmd := &cobra.Command{
+		Use:   "diff <path/to/app.db> [path/to/other/app.db]",
+		Short: "Shows the state that differs between two versions of the app store",
+		Long: "Compares two versions of app.db, which may be in the same DB or in two different DBs, " +
+			"and shows the keys that were added, removed, or changed. If there's an evm.db alongside " +
+			"app.db the EVM state in it is compared account by account, otherwise the EVM state " +
+			"stored in app.db is compared. By default the last version is compared to the one before it.",
+		Example: "  loom db diff app.db --from-version 100 --to-version 105\n" +
+			"  loom db diff node-1/app.db node-2/app.db --to-version 105 --format json\n" +
+			"  loom db diff app.db --contract 0xe288d6eec7150d6a22fde33f0aa2d81e06591c4d",
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if flags.Format != "text" && flags.Format != "json" {
+				return fmt.Errorf("invalid format %s, must be text or json", flags.Format)
+			}
+			if flags.Contract != "" && flags.Prefix != "" {
+				return errors.New("--contract and --prefix can't be used together")
+			}
+			from, err := loadDiffSource(args[0], flags)
+			if err != nil {
+				return err
+			}
+			defer from.close()
+			to := from
+			if len(args) > 1 {
+				to, err = loadDiffSource(args[1], flags)
+				if err != nil {
+					return err
+				}
+				defer to.close()
+			}
+			return diffAppStores(from, to, flags)
+		},
+	}
+	cmdFlags := cmd.Flags()
+	cmdFlags.Int64Var(
+		&flags.FromVersion, "from-version", 0,
+		"Version to compare against (defaults to the version before --to-version, or the last version "+
+			"of the first DB if two DBs are specified)",
+	)
+	cmdFlags.Int64Var(&flags.ToVersion, "to-version", 0, "Version to compare (defaults to the last version)")
+	cmdFlags.StringVar(&flags.Backend, "backend", cdb.GoLevelDBBackend, "Backend of app.db")
+	cmdFlags.StringVar(&flags.EvmBackend, "evm-backend", cdb.GoLevelDBBackend, "Backend of evm.db")
+	cmdFlags.StringVar(
+		&flags.Contract, "contract", "",
+		"Only compare the state of the Go contract, or EVM contract, with the given address",
+	)
+	cmdFlags.StringVar(&flags.ChainID, "chain-id", "default", "Chain ID of the --contract address")
+	cmdFlags.StringVar(&flags.Prefix, "prefix", "", "Only compare the app store keys with the given prefix")
+	cmdFlags.StringVar(&flags.Format, "format", "text", "Output format (text or json)")
+	cmdFlags.BoolVar(&flags.NoEVM, "no-evm", false, "Don't compare the EVM state")
+	return cmd
+}
+
+// diffSource provides access to the versions of the app store in a single app.db, along with the
+// matching evm.db (if any).
+type diffSource struct {
+	appDB    cdb.DBWrapper
+	evmDB    cdb.DBWrapper
+	iavl     *store.IAVLStore
+	evmStore *store.EvmStore
+}
+
+func loadDiffSource(appDBPath string, flags diffDBFlags) (*diffSource, error) {
+	dbName, dbDir, err := parseDBPath(appDBPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dbDir, dbName+".db")); err != nil {
+		return nil, errors.Wrapf(err, "failed to find %s", appDBPath)
+	}
+	src := &diffSource{}
+	src.appDB, err = cdb.LoadDB(flags.Backend, dbName, dbDir, 256, 4, false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load %s", appDBPath)
+	}
+	src.iavl, err = store.NewIAVLStore(src.appDB, 0, 0, 0)
+	if err != nil {
+		src.close()
+		return nil, err
+	}
+	if flags.NoEVM {
+		return src, nil
+	}
+	if _, err := os.Stat(filepath.Join(dbDir, "evm.db")); err == nil {
+		src.evmDB, err = cdb.LoadDB(flags.EvmBackend, "evm", dbDir, 256, 4, false)
+		if err != nil {
+			src.close()
+			return nil, errors.Wrapf(err, "failed to load evm.db in %s", dbDir)
+		}
+		src.evmStore = store.NewEvmStore(src.evmDB, 100)
+	}
+	return src, nil
+}
+
+// snapshot returns the app store & EVM state at the given version.
+func (s *diffSource) snapshot(version int64) (store.Snapshot, store.EvmStateReader, func(), error) {
+	appSnapshot, err := s.iavl.GetSnapshotAt(version)
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "failed to load app store version %d", version)
+	}
+	if s.evmStore == nil {
+		return appSnapshot, appSnapshot, appSnapshot.Release, nil
+	}
+	evmSnapshot := s.evmStore.GetSnapshot(version)
+	return appSnapshot, evmSnapshot, func() {
+		appSnapshot.Release()
+		evmSnapshot.Release()
+	}, nil
+}
+
+func (s *diffSource) close() {
+	if s.evmDB != nil {
+		s.evmDB.Close()
+	}
+	s.appDB.Close()
+}
+
+func diffAppStores(from, to *diffSource, flags diffDBFlags) error {
+	toVersion := flags.ToVersion
+	if toVersion == 0 {
+		toVersion = to.iavl.Version()
+	}
+	fromVersion := flags.FromVersion
+	if fromVersion == 0 {
+		if from == to {
+			fromVersion = toVersion - 1
+		} else {
+			fromVersion = from.iavl.Version()
+		}
+	}
+	if fromVersion <= 0 || toVersion <= 0 {
+		return fmt.Errorf("invalid versions %d & %d", fromVersion, toVersion)
+	}
+
+	fromApp, fromEVM, releaseFrom, err := from.snapshot(fromVersion)
+	if err != nil {
+		return err
+	}
+	defer releaseFrom()
+	toApp, toEVM, releaseTo, err := to.snapshot(toVersion)
+	if err != nil {
+		return err
+	}
+	defer releaseTo()
+
+	prefix := []byte(flags.Prefix)
+	var evmAddress []byte
+	if flags.Contract != "" {
+		addr, err := cli.ParseAddress(flags.Contract, flags.ChainID)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse contract address")
+		}
+		prefix = loom.DataPrefix(addr)
+		evmAddress = addr.Local
+	}
+	diffEVM := !flags.NoEVM && (len(prefix) == 0 || evmAddress != nil)
+
+	result := &appStoreDiff{FromVersion: fromVersion, ToVersion: toVersion}
+	if flags.Format == "text" {
+		fmt.Printf("--- app store version %d\n+++ app store version %d\n", fromVersion, toVersion)
+	}
+
+	err = store.DiffKVReaders(fromApp, toApp, prefix, func(diff *store.KeyDiff) error {
+		// the EVM state is compared separately
+		if diffEVM && diff.Category == store.KeyCategoryEVM {
+			return nil
+		}
+		if flags.Format == "json" {
+			result.Keys = append(result.Keys, diff)
+		} else {
+			printKeyDiff(diff)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to diff app store")
+	}
+
+	if diffEVM {
+		err = store.DiffEvmState(fromEVM, toEVM, evmAddress, func(diff *store.EvmAccountDiff) error {
+			if flags.Format == "json" {
+				result.EvmAccounts = append(result.EvmAccounts, diff)
+			} else {
+				printEvmAccountDiff(diff)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to diff EVM state")
+		}
+	}
+
+	if flags.Format == "json" {
+		output, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+	}
+	return nil
+}
+
+func printKeyDiff(diff *store.KeyDiff) {
+	switch diff.Kind {
+	case store.DiffAdded:
+		fmt.Printf("+ %s: %s\n", diff.Description, store.FormatAppStoreValue(diff.Category, diff.NewValue))
+	case store.DiffRemoved:
+		fmt.Printf("- %s: %s\n", diff.Description, store.FormatAppStoreValue(diff.Category, diff.OldValue))
+	default:
+		fmt.Printf(
+			"~ %s: %s -> %s\n", diff.Description,
+			store.FormatAppStoreValue(diff.Category, diff.OldValue),
+			store.FormatAppStoreValue(diff.Category, diff.NewValue),
+		)
+	}
+}
+
+func printEvmAccountDiff(diff *store.EvmAccountDiff) {
+	account := "0x" + hex.EncodeToString(diff.Address)
+	if diff.Address == nil {
+		account = "<unknown address with hash 0x" + hex.EncodeToString(diff.AddressHash) + ">"
+	}
+	switch diff.Kind {
+	case store.DiffAdded:
+		fmt.Printf("+ evm account %s: nonce %d, balance %v\n", account, diff.New.Nonce, diff.New.Balance)
+	case store.DiffRemoved:
+		fmt.Printf("- evm account %s: nonce %d, balance %v\n", account, diff.Old.Nonce, diff.Old.Balance)
+	default:
+		fmt.Printf(
+			"~ evm account %s: nonce %d -> %d, balance %v -> %v\n",
+			account, diff.Old.Nonce, diff.New.Nonce, diff.Old.Balance, diff.New.Balance,
+		)
+		if diff.Old.CodeHash.String() != diff.New.CodeHash.String() {
+			fmt.Printf("    code hash %s -> %s\n", diff.Old.CodeHash, diff.New.CodeHash)
+		}
+	}
+	for _, slot := range diff.Storage {
+		key := "0x" + hex.EncodeToString(slot.Key)
+		if slot.Key == nil {
+			key = "<unknown slot with hash 0x" + hex.EncodeToString(slot.KeyHash) + ">"
+		}
+		switch slot.Kind {
+		case store.DiffAdded:
+			fmt.Printf("    + storage %s: %s\n", key, slot.NewValue)
+		case store.DiffRemoved:
+			fmt.Printf("    - storage %s: %s\n", key, slot.OldValue)
+		default:
+			fmt.Printf("    ~ storage %s: %s -> %s\n", key, slot.OldValue, slot.NewValue)
+		}
+	}
+}
@@ -14,6 +14,13 @@ func NewDBCommand() *cobra.Command {
 	cmd.AddCommand(
 		newPruneDBCommand(),
 		newCompactDBCommand(),
+		newConvertDBCommand(),
+		newBackupDBCommand(),
+		newRestoreDBCommand(),
+		newDiffDBCommand(),
+		newOutboxCommand(),
+		newReindexCommand(),
+		newImportReceiptsCommand(),
 	)
 	return cmd
 }
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/loomnetwork/loomchain/cmd/loom/common"
+	"github.com/loomnetwork/loomchain/config"
+	cdb "github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func newOutboxCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "outbox",
+		Short: "Inspect & manage the delivery cursors of the event outbox",
+		Long: "The event outbox records the events emitted in each block until every event dispatcher " +
+			"has delivered them. The node must be stopped while these commands are used.",
+	}
+	cmd.AddCommand(
+		newOutboxStatusCommand(),
+		newOutboxResetCursorCommand(),
+		newOutboxRemoveCursorCommand(),
+	)
+	return cmd
+}
+
+type outboxStatus struct {
+	LastHeight uint64                `json:"lastHeight"`
+	Cursors    []*store.OutboxCursor `json:"cursors"`
+}
+
+func newOutboxStatusCommand() *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Shows how far behind each event dispatcher is",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("invalid format %s, must be text or json", format)
+			}
+			outbox, closeDB, err := loadEventOutbox()
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			status := &outboxStatus{
+				LastHeight: outbox.LastHeight(),
+				Cursors:    outbox.Cursors(),
+			}
+			if format == "json" {
+				output, err := json.MarshalIndent(status, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(output))
+				return nil
+			}
+			fmt.Printf("Last block recorded in outbox: %d\n\n", status.LastHeight)
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "DISPATCHER\tHEIGHT\tLAG")
+			for _, cursor := range status.Cursors {
+				fmt.Fprintf(w, "%s\t%d\t%d\n", cursor.Dispatcher, cursor.Height, cursor.Lag)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "text", "Output format (text or json)")
+	return cmd
+}
+
+func newOutboxResetCursorCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "reset-cursor <dispatcher> [height]",
+		Short: "Moves the cursor of an event dispatcher to the given height",
+		Long: "Moves the cursor of an event dispatcher to the given height, the dispatcher will resume " +
+			"delivering events from the block after that height. If the height is omitted the cursor " +
+			"is moved to the last block recorded in the outbox, skipping all undelivered events. " +
+			"Events that have already been delivered by all the dispatchers are pruned from the " +
+			"outbox, so moving a cursor back won't redeliver those events.",
+		Example: "  loom db outbox reset-cursor db_indexer 1000",
+		Args:    cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outbox, closeDB, err := loadEventOutbox()
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			height := outbox.LastHeight()
+			if len(args) > 1 {
+				if height, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+					return errors.Wrap(err, "invalid height")
+				}
+			}
+			prevHeight, exists := outbox.Cursor(args[0])
+			outbox.SetCursor(args[0], height)
+			if exists {
+				fmt.Printf("Moved cursor of %s from %d to %d\n", args[0], prevHeight, height)
+			} else {
+				fmt.Printf("Created cursor of %s at %d\n", args[0], height)
+			}
+			return nil
+		},
+	}
+}
+
+func newOutboxRemoveCursorCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove-cursor <dispatcher>",
+		Short: "Removes the cursor of an event dispatcher that's no longer in use",
+		Long: "Removes the cursor of an event dispatcher that's no longer in use, so it doesn't prevent " +
+			"events that have been delivered by the remaining dispatchers from being pruned.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outbox, closeDB, err := loadEventOutbox()
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			if _, exists := outbox.Cursor(args[0]); !exists {
+				return fmt.Errorf("dispatcher %s doesn't have a cursor", args[0])
+			}
+			outbox.DeleteCursor(args[0])
+			fmt.Printf("Removed cursor of %s\n", args[0])
+			return nil
+		},
+	}
+}
+
+// loadEventOutbox opens the outbox DB specified in the node config.
+func loadEventOutbox() (*store.EventOutbox, func(), error) {
+	cfg, err := common.ParseConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	outboxCfg := cfg.EventDispatcher.Outbox
+	if outboxCfg == nil {
+		outboxCfg = config.DefaultConfig().EventDispatcher.Outbox
+	}
+	if _, err := os.Stat(filepath.Join(cfg.RootPath(), outboxCfg.DBName+".db")); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to find event outbox DB")
+	}
+	db, err := cdb.LoadDB(outboxCfg.DBBackend, outboxCfg.DBName, cfg.RootPath(), 20, 4, false)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to load event outbox DB")
+	}
+	return store.NewEventOutbox(db), db.Close, nil
+}
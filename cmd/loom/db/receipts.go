@@ -0,0 +1,68 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/loomnetwork/loomchain/cmd/loom/common"
+	"github.com/loomnetwork/loomchain/receipts/leveldb"
+	evmaux "github.com/loomnetwork/loomchain/store/evm_aux"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+)
+
+func newImportReceiptsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import-receipts <archive file or dir>...",
+		Short: "Imports EVM tx receipts from receipt archive files into receipts_db",
+		Long: "Imports EVM tx receipts from the archive files written by the node when receipt archival " +
+			"is enabled, so the node can serve the receipts again. If a directory is specified all " +
+			"the archive files in it are imported. Receipts that are already in receipts_db are " +
+			"skipped, and imported receipts are never deleted by the receipt retention policy. " +
+			"The node must be stopped while this command is running.",
+		Example: "  loom db import-receipts receipts_archive",
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := common.ParseConfig()
+			if err != nil {
+				return err
+			}
+			var files []string
+			for _, arg := range args {
+				paths, err := leveldb.ReceiptArchiveFiles(arg)
+				if err != nil {
+					return err
+				}
+				files = append(files, paths...)
+			}
+			if len(files) == 0 {
+				return errors.New("no receipt archive files found")
+			}
+
+			dbPath := filepath.Join(cfg.RootPath(), evmaux.EvmAuxDBName)
+			if _, err := os.Stat(dbPath); err != nil {
+				return errors.Wrap(err, "failed to find receipts DB")
+			}
+			db, err := goleveldb.OpenFile(dbPath, nil)
+			if err != nil {
+				return errors.Wrap(err, "failed to load receipts DB")
+			}
+			evmAuxStore := evmaux.NewEvmAuxStore(db)
+			defer evmAuxStore.Close()
+
+			for _, file := range files {
+				stats, err := leveldb.ImportReceiptArchive(evmAuxStore, file)
+				if err != nil {
+					return errors.Wrapf(err, "failed to import %s", file)
+				}
+				fmt.Printf(
+					"%s: imported %d receipts, skipped %d existing receipts, restored indexes of %d blocks\n",
+					filepath.Base(file), stats.Imported, stats.Skipped, stats.Blocks,
+				)
+			}
+			return nil
+		},
+	}
+}
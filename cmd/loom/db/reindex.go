@@ -0,0 +1,263 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/loomnetwork/loomchain/cmd/loom/common"
+	"github.com/loomnetwork/loomchain/config"
+	"github.com/loomnetwork/loomchain/receipts/handler"
+	"github.com/loomnetwork/loomchain/receipts/leveldb"
+	blockindex "github.com/loomnetwork/loomchain/store/block_index"
+	evmaux "github.com/loomnetwork/loomchain/store/evm_aux"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	goleveldb "github.com/syndtr/goleveldb/leveldb"
+	"github.com/tendermint/tendermint/blockchain"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+const (
+	reindexBlockIndex = "block-index"
+	reindexEvmAux     = "evm-aux"
+
+	// Name of the file in the node root dir that tracks the progress of an interrupted reindex.
+	reindexProgressFile = "reindex_progress.json"
+	// How often progress is reported & saved.
+	reindexProgressInterval = 5 * time.Second
+)
+
+func newReindexCommand() *cobra.Command {
+	var fromHeight, toHeight uint64
+	var blockIndex, evmAux, restart bool
+	cmd := &cobra.Command{
+		Use:   "reindex",
+		Short: "Rebuilds the block index & EVM bloom filters from the block store & receipts",
+		Long: "Rebuilds the block hash -> height index (block_index.db) from the Tendermint block store, " +
+			"and the bloom filters & tx hash lists of each block (receipts_db) from the EVM receipts. " +
+			"Only blocks that still have receipts in receipts_db can be reindexed, receipts that have " +
+			"been lost can only be regenerated by resyncing the node. Reindexing a block more than " +
+			"once produces the same result. If the command is interrupted it will resume from the " +
+			"last reindexed block the next time it's run with the same height range, unless " +
+			"--restart is specified. The node must be stopped while this command is running.",
+		Example: "  loom db reindex --block-index --from 1 --to 100000",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := common.ParseConfig()
+			if err != nil {
+				return err
+			}
+			if !blockIndex && !evmAux {
+				blockIndex, evmAux = true, true
+			}
+			if fromHeight == 0 {
+				fromHeight = 1
+			}
+
+			blockStoreDir := filepath.Join(cfg.RootPath(), "chaindata", "data")
+			if _, err := os.Stat(filepath.Join(blockStoreDir, "blockstore.db")); err != nil {
+				return errors.Wrap(err, "failed to find block store")
+			}
+			blockStoreDB := dbm.NewDB("blockstore", "leveldb", blockStoreDir)
+			defer blockStoreDB.Close()
+			blockStore := blockchain.NewBlockStore(blockStoreDB)
+			if toHeight == 0 || toHeight > uint64(blockStore.Height()) {
+				toHeight = uint64(blockStore.Height())
+			}
+			if toHeight < fromHeight {
+				return fmt.Errorf("nothing to reindex, last block in block store is %d", toHeight)
+			}
+
+			progress, err := loadReindexProgress(cfg)
+			if err != nil {
+				return err
+			}
+			if restart {
+				progress.Heights = map[string]uint64{}
+			}
+			if blockIndex {
+				start := progress.resumeHeight(reindexBlockIndex, fromHeight, toHeight)
+				if err := reindexBlockHashes(cfg, blockStore, start, toHeight, progress); err != nil {
+					return err
+				}
+			}
+			if evmAux {
+				start := progress.resumeHeight(reindexEvmAux, fromHeight, toHeight)
+				if err := reindexEvmAuxStore(cfg, start, toHeight, progress); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.Uint64Var(&fromHeight, "from", 1, "First block to reindex")
+	flags.Uint64Var(&toHeight, "to", 0, "Last block to reindex (defaults to the last block in the block store)")
+	flags.BoolVar(&blockIndex, "block-index", false, "Rebuild the block index")
+	flags.BoolVar(&evmAux, "evm-aux", false, "Rebuild the bloom filters & tx hash lists")
+	flags.BoolVar(&restart, "restart", false, "Ignore the progress of any previously interrupted reindex")
+	return cmd
+}
+
+func reindexBlockHashes(
+	cfg *config.Config, blockStore *blockchain.BlockStore, fromHeight, toHeight uint64,
+	progress *reindexProgress,
+) error {
+	if !cfg.BlockIndexStore.Enabled {
+		fmt.Println("Block index store is disabled in the node config, it won't be used until it's enabled")
+	}
+	blockIndexStore, err := blockindex.NewBlockIndexStore(
+		cfg.BlockIndexStore.DBBackend,
+		cfg.BlockIndexStore.DBName,
+		cfg.RootPath(),
+		cfg.BlockIndexStore.CacheSizeMegs,
+		cfg.BlockIndexStore.WriteBufferMegs,
+		false,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to load block index store")
+	}
+	defer blockIndexStore.Close()
+
+	reporter := newReindexReporter(reindexBlockIndex, fromHeight, toHeight, progress)
+	for height := fromHeight; height <= toHeight; height++ {
+		blockMeta := blockStore.LoadBlockMeta(int64(height))
+		if blockMeta == nil {
+			return fmt.Errorf("block %d not found in block store", height)
+		}
+		blockIndexStore.SetBlockHashAtHeight(height, blockMeta.BlockID.Hash)
+		if err := reporter.reindexed(height); err != nil {
+			return err
+		}
+	}
+	return reporter.done()
+}
+
+func reindexEvmAuxStore(cfg *config.Config, fromHeight, toHeight uint64, progress *reindexProgress) error {
+	if cfg.ReceiptsVersion != handler.ReceiptHandlerLevelDb {
+		return errors.New("bloom filters can only be rebuilt when receipts are stored in receipts_db")
+	}
+	dbPath := filepath.Join(cfg.RootPath(), evmaux.EvmAuxDBName)
+	if _, err := os.Stat(dbPath); err != nil {
+		return errors.Wrap(err, "failed to find receipts DB")
+	}
+	db, err := goleveldb.OpenFile(dbPath, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to load receipts DB")
+	}
+	evmAuxStore := evmaux.NewEvmAuxStore(db)
+	defer evmAuxStore.Close()
+
+	reporter := newReindexReporter(reindexEvmAux, fromHeight, toHeight, progress)
+	if err := leveldb.RebuildEvmAuxIndex(evmAuxStore, fromHeight, toHeight, reporter.reindexed); err != nil {
+		return err
+	}
+	return reporter.done()
+}
+
+// reindexProgress tracks the last block reindexed by each reindex target, so an interrupted reindex
+// can be resumed.
+type reindexProgress struct {
+	Heights map[string]uint64 `json:"heights"`
+	path    string
+}
+
+func loadReindexProgress(cfg *config.Config) (*reindexProgress, error) {
+	progress := &reindexProgress{
+		Heights: map[string]uint64{},
+		path:    filepath.Join(cfg.RootPath(), reindexProgressFile),
+	}
+	data, err := ioutil.ReadFile(progress.path)
+	if os.IsNotExist(err) {
+		return progress, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, progress); err != nil {
+		return nil, errors.Wrapf(err, "failed to load %s", progress.path)
+	}
+	if progress.Heights == nil {
+		progress.Heights = map[string]uint64{}
+	}
+	return progress, nil
+}
+
+// resumeHeight returns the height the given target should start reindexing from.
+func (p *reindexProgress) resumeHeight(target string, fromHeight, toHeight uint64) uint64 {
+	lastHeight, ok := p.Heights[target]
+	if !ok || lastHeight < fromHeight || lastHeight >= toHeight {
+		return fromHeight
+	}
+	fmt.Printf("Resuming %s reindex from block %d\n", target, lastHeight+1)
+	return lastHeight + 1
+}
+
+func (p *reindexProgress) save() error {
+	if len(p.Heights) == 0 {
+		if err := os.Remove(p.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	tmpPath := p.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, p.path)
+}
+
+// reindexReporter periodically prints & saves the progress of a reindex target.
+type reindexReporter struct {
+	target     string
+	fromHeight uint64
+	toHeight   uint64
+	progress   *reindexProgress
+	numBlocks  uint64
+	lastReport time.Time
+	startTime  time.Time
+}
+
+func newReindexReporter(target string, fromHeight, toHeight uint64, progress *reindexProgress) *reindexReporter {
+	fmt.Printf("Reindexing %s from block %d to %d\n", target, fromHeight, toHeight)
+	now := time.Now()
+	return &reindexReporter{
+		target:     target,
+		fromHeight: fromHeight,
+		toHeight:   toHeight,
+		progress:   progress,
+		lastReport: now,
+		startTime:  now,
+	}
+}
+
+func (r *reindexReporter) reindexed(height uint64) error {
+	r.numBlocks++
+	if time.Since(r.lastReport) < reindexProgressInterval {
+		return nil
+	}
+	r.lastReport = time.Now()
+	fmt.Printf(
+		"%s: reindexed block %d of %d (%.1f%%)\n",
+		r.target, height, r.toHeight,
+		float64(height-r.fromHeight+1)*100/float64(r.toHeight-r.fromHeight+1),
+	)
+	r.progress.Heights[r.target] = height
+	return r.progress.save()
+}
+
+func (r *reindexReporter) done() error {
+	fmt.Printf(
+		"%s: reindexed %d blocks in %v\n",
+		r.target, r.numBlocks, time.Since(r.startTime).Round(time.Second),
+	)
+	delete(r.progress.Heights, r.target)
+	return r.progress.save()
+}
@@ -16,7 +16,6 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/push"
-	"github.com/tendermint/tendermint/libs/db"
 
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	"github.com/gogo/protobuf/proto"
@@ -72,6 +71,8 @@ import (
 	"github.com/pkg/errors"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
+	dbm "github.com/tendermint/tendermint/libs/db"
+	"github.com/tendermint/tendermint/node"
 	"golang.org/x/crypto/ed25519"
 )
 
@@ -240,7 +241,7 @@ func newInitCommand() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			backend := initBackend(cfg, "", nil)
+			backend := initBackend(cfg, "", nil, nil)
 			if force {
 				err = backend.Destroy()
 				if err != nil {
@@ -282,7 +283,7 @@ func newResetCommand() *cobra.Command {
 				return err
 			}
 
-			backend := initBackend(cfg, "", nil)
+			backend := initBackend(cfg, "", nil, nil)
 			err = backend.Reset(0)
 			if err != nil {
 				return err
@@ -316,7 +317,7 @@ func newNodeKeyCommand() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			backend := initBackend(cfg, "", nil)
+			backend := initBackend(cfg, "", nil, nil)
 			key, err := backend.NodeKey()
 			if err != nil {
 				fmt.Printf("Error in determining Node Key")
@@ -331,6 +332,7 @@ func newNodeKeyCommand() *cobra.Command {
 func newRunCommand() *cobra.Command {
 	var abciServerAddr string
 	var appHeight int64
+	var snapshotDir string
 
 	cfg, err := common.ParseConfig()
 
@@ -367,7 +369,8 @@ func newRunCommand() *cobra.Command {
 					loaders = append(loaders, plugin.NewExternalLoader(cfg.PluginsPath()))
 				}
 			}
-			backend := initBackend(cfg, abciServerAddr, fnRegistry)
+			backups := store.NewBackupManager()
+			backend := initBackend(cfg, abciServerAddr, fnRegistry, backups)
 			loader := plugin.NewMultiLoader(loaders...)
 			termChan := make(chan os.Signal)
 			go func(c <-chan os.Signal, l plugin.Loader) {
@@ -386,6 +389,12 @@ func newRunCommand() *cobra.Command {
 				return err
 			}
 
+			if snapshotDir != "" {
+				if err := restoreAppStoreFromSnapshot(cfg, snapshotDir); err != nil {
+					return err
+				}
+			}
+
 			// Load app height from app.db
 			appDB, err := cdb.LoadDB(
 				cfg.DBBackend, cfg.DBName, cfg.RootPath(), cfg.DBBackendConfig.CacheSizeMegs,
@@ -401,7 +410,7 @@ func newRunCommand() *cobra.Command {
 			}
 			appDB.Close()
 
-			app, err := loadApp(chainID, cfg, loader, backend, appHeight)
+			app, err := loadApp(chainID, cfg, loader, backend, appHeight, backups)
 			if err != nil {
 				return err
 			}
@@ -414,7 +423,7 @@ func newRunCommand() *cobra.Command {
 				return err
 			}
 
-			if err := initQueryService(app, chainID, cfg, loader, app.ReceiptHandlerProvider); err != nil {
+			if err := initQueryService(app, chainID, cfg, loader, app.ReceiptHandlerProvider, backups); err != nil {
 				return err
 			}
 
@@ -438,6 +447,10 @@ func newRunCommand() *cobra.Command {
 	cmd.Flags().StringVarP(&cfg.Peers, "peers", "p", "", "peers")
 	cmd.Flags().StringVar(&cfg.PersistentPeers, "persistent-peers", "", "persistent peers")
 	cmd.Flags().StringVar(&abciServerAddr, "abci-server", "", "Serve ABCI app at specified address")
+	cmd.Flags().StringVar(
+		&snapshotDir, "from-snapshot", "",
+		"Restore app.db & evm.db from the app store snapshot in the specified directory before starting the node",
+	)
 	cmd.Flags().Int64Var(&appHeight, "app-height", 0, "Start at the given block instead of the last block saved")
 	return cmd
 }
@@ -594,7 +607,12 @@ func destroyReceiptsDB(cfg *config.Config) {
 
 func destroyBlockIndexDB(cfg *config.Config) error {
 	// todo support for cleveldb
-	if cfg.BlockIndexStore.Enabled && cfg.BlockIndexStore.DBBackend == string(db.GoLevelDBBackend) {
+	switch cfg.BlockIndexStore.DBBackend {
+	case cdb.GoLevelDBBackend, cdb.BadgerDBBackend, cdb.BoltDBBackend:
+	default:
+		return nil
+	}
+	if cfg.BlockIndexStore.Enabled {
 		err := os.RemoveAll(filepath.Join(cfg.RootPath(), cfg.BlockIndexStore.DBName+".db"))
 		if err != nil {
 			return err
@@ -603,13 +621,43 @@ func destroyBlockIndexDB(cfg *config.Config) error {
 	return nil
 }
 
-func loadAppStore(cfg *config.Config, logger *loom.Logger, targetVersion int64) (store.VersionedKVStore, error) {
+func newReceiptRetentionPolicy(cfg *config.Config) (*leveldb.ReceiptRetentionPolicy, error) {
+	retentionCfg := cfg.ReceiptRetention
+	policy := &leveldb.ReceiptRetentionPolicy{
+		MaxBlocks: retentionCfg.RetainBlocks,
+		MaxAge:    retentionCfg.RetainSeconds,
+	}
+	if retentionCfg.ArchiveEnabled {
+		dir := retentionCfg.ArchiveDir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(cfg.RootPath(), dir)
+		}
+		archive, err := leveldb.NewReceiptArchive(dir, retentionCfg.ArchiveSegmentBlocks)
+		if err != nil {
+			return nil, err
+		}
+		policy.Archive = archive
+	}
+	return policy, nil
+}
+
+func loadAppStore(
+	cfg *config.Config, logger *loom.Logger, targetVersion int64, backups *store.BackupManager,
+) (store.VersionedKVStore, error) {
 	db, err := cdb.LoadDB(
 		cfg.DBBackend, cfg.DBName, cfg.RootPath(), cfg.DBBackendConfig.CacheSizeMegs, cfg.DBBackendConfig.WriteBufferMegs, cfg.Metrics.Database,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if backups != nil {
+		backups.AddSource(store.BackupSource{
+			Name:     store.BackupAppDBName,
+			Path:     common.BackupDBPath(cfg, filepath.Join(cfg.RootPath(), cfg.DBName+".db")),
+			Backend:  cfg.DBBackend,
+			Snapshot: db.GetSnapshot,
+		})
+	}
 
 	if cfg.AppStore.CompactOnLoad {
 		logger.Info("Compacting app store...")
@@ -648,14 +696,39 @@ func loadAppStore(cfg *config.Config, logger *loom.Logger, targetVersion int64)
 		if err != nil {
 			return nil, err
 		}
-		evmStore, err := loadEvmStore(cfg, iavlStore.Version())
+		evmStore, err := loadEvmStore(cfg, iavlStore.Version(), backups)
 		if err != nil {
 			return nil, err
 		}
-		appStore, err = store.NewMultiWriterAppStore(iavlStore, evmStore, cfg.AppStore.SaveEVMStateToIAVL)
+		if cfg.EvmStore.PruningEnabled {
+			if cfg.AppStore.MaxVersions == 0 {
+				logger.Info("EVM store pruning disabled because AppStore.MaxVersions is zero")
+			} else {
+				logger.Info("EVM store pruning enabled")
+				err := evmStore.EnablePruning(store.EvmStorePruningConfig{
+					MaxVersions: cfg.AppStore.MaxVersions,
+					BatchSize:   cfg.EvmStore.PruneBatchSize,
+					Interval:    time.Duration(cfg.EvmStore.PruneInterval) * time.Second,
+					Logger:      logger,
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		multiWriterStore, err := store.NewMultiWriterAppStore(iavlStore, evmStore, cfg.AppStore.SaveEVMStateToIAVL)
 		if err != nil {
 			return nil, err
 		}
+		if cfg.AppStore.SnapshotInterval > 0 {
+			multiWriterStore.EnableSnapshots(store.NewSnapshotExporter(db, store.SnapshotConfig{
+				Interval:  cfg.AppStore.SnapshotInterval,
+				Dir:       appStoreSnapshotDir(cfg),
+				NumToKeep: cfg.AppStore.NumSnapshotsToKeep,
+				ChunkSize: cfg.AppStore.SnapshotChunkSize,
+			}))
+		}
+		appStore = multiWriterStore
 	} else {
 		return nil, errors.New("Invalid AppStore.Version config setting")
 	}
@@ -690,10 +763,59 @@ func loadEventStore(cfg *config.Config, logger *loom.Logger) (store.EventStore,
 	}
 
 	eventStore := store.NewKVEventStore(db)
+	if eventStoreCfg.PruningEnabled() {
+		logger.Info("Event store pruning enabled")
+		pruningCfg := store.EventStorePruningConfig{
+			Retention: store.EventRetentionPolicy{
+				MaxBlocks: eventStoreCfg.RetainBlocks,
+				MaxAge:    time.Duration(eventStoreCfg.RetainSeconds) * time.Second,
+			},
+			ContractRetention: map[string]store.EventRetentionPolicy{},
+			BatchSize:         eventStoreCfg.PruneBatchSize,
+			Interval:          time.Duration(eventStoreCfg.PruneInterval) * time.Second,
+			Logger:            logger,
+		}
+		for contract, retention := range eventStoreCfg.ContractRetention {
+			if retention == nil {
+				continue
+			}
+			pruningCfg.ContractRetention[contract] = store.EventRetentionPolicy{
+				MaxBlocks: retention.RetainBlocks,
+				MaxAge:    time.Duration(retention.RetainSeconds) * time.Second,
+			}
+		}
+		if err := eventStore.EnablePruning(pruningCfg); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
 	return eventStore, nil
 }
 
-func loadEvmStore(cfg *config.Config, targetVersion int64) (*store.EvmStore, error) {
+// loadEventOutbox creates a dispatcher that delivers events to the given dispatchers via the outbox,
+// the outbox cursor of each dispatcher is named after the dispatcher.
+func loadEventOutbox(
+	cfg *config.Config, dispatchers map[string]loomchain.EventDispatcher,
+) (*events.OutboxEventDispatcher, error) {
+	outboxCfg := cfg.EventDispatcher.Outbox
+	db, err := cdb.LoadDB(
+		outboxCfg.DBBackend, outboxCfg.DBName, cfg.RootPath(), 20, 4, cfg.Metrics.Database,
+	)
+	if err != nil {
+		return nil, err
+	}
+	outboxDispatcher, err := events.NewOutboxEventDispatcher(store.NewEventOutbox(db), outboxCfg, dispatchers)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	outboxDispatcher.Start()
+	return outboxDispatcher, nil
+}
+
+func loadEvmStore(
+	cfg *config.Config, targetVersion int64, backups *store.BackupManager,
+) (*store.EvmStore, error) {
 	evmStoreCfg := cfg.EvmStore
 	db, err := cdb.LoadDB(
 		evmStoreCfg.DBBackend,
@@ -706,6 +828,14 @@ func loadEvmStore(cfg *config.Config, targetVersion int64) (*store.EvmStore, err
 	if err != nil {
 		return nil, err
 	}
+	if backups != nil {
+		backups.AddSource(store.BackupSource{
+			Name:     evmStoreCfg.DBName,
+			Path:     common.BackupDBPath(cfg, filepath.Join(cfg.RootPath(), evmStoreCfg.DBName+".db")),
+			Backend:  evmStoreCfg.DBBackend,
+			Snapshot: db.GetSnapshot,
+		})
+	}
 	evmStore := store.NewEvmStore(db, evmStoreCfg.NumCachedRoots)
 	if err := evmStore.LoadVersion(targetVersion); err != nil {
 		return nil, err
@@ -719,38 +849,75 @@ func loadApp(
 	loader plugin.Loader,
 	b backend.Backend,
 	appHeight int64,
+	backups *store.BackupManager,
 ) (*loomchain.Application, error) {
 	logger := log.Root
 
-	appStore, err := loadAppStore(cfg, log.Default, appHeight)
+	appStore, err := loadAppStore(cfg, log.Default, appHeight, backups)
 
 	if err != nil {
 		return nil, err
 	}
 
 	var eventStore store.EventStore
-	var eventDispatcher loomchain.EventDispatcher
-	switch cfg.EventDispatcher.Dispatcher {
-	case events.DispatcherDBIndexer:
-		logger.Info("Using DB indexer event dispatcher")
-		eventStore, err = loadEventStore(cfg, log.Default)
-		if err != nil {
-			return nil, err
+	dispatchers := map[string]loomchain.EventDispatcher{}
+	for _, name := range cfg.EventDispatcher.Dispatchers() {
+		switch name {
+		case events.DispatcherDBIndexer:
+			logger.Info("Using DB indexer event dispatcher")
+			eventStore, err = loadEventStore(cfg, log.Default)
+			if err != nil {
+				return nil, err
+			}
+			dispatchers[name] = events.NewDBIndexerEventDispatcher(eventStore)
+
+		case events.DispatcherRedis:
+			uri := cfg.EventDispatcher.Redis.URI
+			logger.Info("Using Redis event dispatcher", "uri", uri)
+			dispatchers[name], err = events.NewRedisEventDispatcher(uri)
+			if err != nil {
+				return nil, err
+			}
+		case events.DispatcherLog:
+			logger.Info("Using simple log event dispatcher")
+			dispatchers[name] = events.NewLogEventDispatcher()
+		case events.DispatcherWebhook:
+			if len(cfg.EventDispatcher.Webhooks) == 0 {
+				return nil, errors.New("webhook event dispatcher requires at least one webhook")
+			}
+			// each endpoint gets its own dispatcher, so they can be delivered to independently
+			for _, webhookCfg := range cfg.EventDispatcher.Webhooks {
+				logger.Info("Using webhook event dispatcher", "name", webhookCfg.Name, "url", webhookCfg.URL)
+				dispatcherName := events.DispatcherWebhook + ":" + webhookCfg.Name
+				if _, exists := dispatchers[dispatcherName]; exists {
+					return nil, fmt.Errorf("duplicate webhook name %s", webhookCfg.Name)
+				}
+				dispatchers[dispatcherName], err = events.NewWebhookEventDispatcher(webhookCfg)
+				if err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("invalid event dispatcher %s", name)
 		}
-		eventDispatcher = events.NewDBIndexerEventDispatcher(eventStore)
+	}
+	if len(dispatchers) == 0 {
+		return nil, errors.New("no event dispatcher specified")
+	}
 
-	case events.DispatcherRedis:
-		uri := cfg.EventDispatcher.Redis.URI
-		logger.Info("Using Redis event dispatcher", "uri", uri)
-		eventDispatcher, err = events.NewRedisEventDispatcher(uri)
+	var eventDispatcher loomchain.EventDispatcher
+	if outboxCfg := cfg.EventDispatcher.Outbox; outboxCfg != nil && outboxCfg.Enabled {
+		logger.Info("Using event outbox", "db", outboxCfg.DBName)
+		eventDispatcher, err = loadEventOutbox(cfg, dispatchers)
 		if err != nil {
 			return nil, err
 		}
-	case events.DispatcherLog:
-		logger.Info("Using simple log event dispatcher")
-		eventDispatcher = events.NewLogEventDispatcher()
-	default:
-		return nil, fmt.Errorf("invalid event dispatcher %s", cfg.EventDispatcher.Dispatcher)
+	} else if len(dispatchers) == 1 {
+		for _, dispatcher := range dispatchers {
+			eventDispatcher = dispatcher
+		}
+	} else {
+		eventDispatcher = events.NewFanOutEventDispatcher(dispatchers)
 	}
 
 	var eventHandler loomchain.EventHandler = loomchain.NewDefaultEventHandler(eventDispatcher)
@@ -775,8 +942,30 @@ func loadApp(
 	if err != nil {
 		return nil, err
 	}
+	if backups != nil {
+		backups.AddSource(store.BackupSource{
+			Name:    evmaux.EvmAuxDBName,
+			Path:    common.BackupDBPath(cfg, evmaux.EvmAuxDBName),
+			Backend: cdb.GoLevelDBBackend,
+			Snapshot: func() cdb.Snapshot {
+				snapshot, err := evmAuxStore.DB().GetSnapshot()
+				if err != nil {
+					panic(err)
+				}
+				return &cdb.GoLevelDBSnapshot{Snapshot: snapshot}
+			},
+		})
+	}
 
 	receiptHandlerProvider := receipts.NewReceiptHandlerProvider(eventHandler, cfg.EVMPersistentTxReceiptsMax, evmAuxStore)
+	if cfg.ReceiptRetention != nil {
+		policy, err := newReceiptRetentionPolicy(cfg)
+		if err != nil {
+			return nil, err
+		}
+		receiptHandlerProvider.SetRetentionPolicy(policy)
+	}
+	receiptHandlerProvider.SetInternalTxIndexing(cfg.EVMInternalTxIndexEnabled)
 
 	var newABMFactory plugin.NewAccountBalanceManagerFactoryFunc
 	if evm.EVMEnabled && cfg.EVMAccountsEnabled {
@@ -799,25 +988,32 @@ func loadApp(
 
 	if evm.EVMEnabled {
 		vmManager.Register(vm.VMType_EVM, func(state loomchain.State) (vm.VM, error) {
+			pvm := plugin.NewPluginVM(
+				loader,
+				state,
+				createRegistry(state),
+				eventHandler,
+				log.Default,
+				newABMFactory,
+				receiptHandlerProvider.Writer(),
+				receiptHandlerProvider.Reader(),
+			)
 			var createABM evm.AccountBalanceManagerFactoryFunc
 			var err error
 			if newABMFactory != nil {
-				pvm := plugin.NewPluginVM(
-					loader,
-					state,
-					createRegistry(state),
-					eventHandler,
-					log.Default,
-					newABMFactory,
-					receiptHandlerProvider.Writer(),
-					receiptHandlerProvider.Reader(),
-				)
 				createABM, err = newABMFactory(pvm)
 				if err != nil {
 					return nil, err
 				}
 			}
-			return evm.NewLoomVm(state, eventHandler, receiptHandlerProvider.Writer(), createABM, cfg.EVMDebugEnabled), nil
+			return evm.NewLoomVm(
+				state,
+				eventHandler,
+				receiptHandlerProvider.Writer(),
+				createABM,
+				plugin.NewGoContractCaller(pvm),
+				cfg.EVMDebugEnabled,
+			), nil
 		})
 	}
 	evm.LogEthDbBatch = cfg.LogEthDbBatch
@@ -933,24 +1129,28 @@ func loadApp(
 
 	createKarmaContractCtx := getContractCtx("karma", vmManager)
 
+	// The throttling middlewares keep track of the txs they've seen in memory, so they must be
+	// executed serially when txs are executed in parallel.
 	if cfg.Karma.Enabled {
-		txMiddleWare = append(txMiddleWare, throttle.GetKarmaMiddleWare(
+		txMiddleWare = append(txMiddleWare, loomchain.SerialTxMiddleware(throttle.GetKarmaMiddleWare(
 			cfg.Karma.Enabled,
 			cfg.Karma.MaxCallCount,
 			cfg.Karma.SessionDuration,
 			createKarmaContractCtx,
-		))
+		)))
 	}
 
 	if cfg.TxLimiter.Enabled {
-		txMiddleWare = append(txMiddleWare, throttle.NewTxLimiterMiddleware(cfg.TxLimiter))
+		txMiddleWare = append(
+			txMiddleWare, loomchain.SerialTxMiddleware(throttle.NewTxLimiterMiddleware(cfg.TxLimiter)),
+		)
 	}
 
 	if cfg.ContractTxLimiter.Enabled {
 		contextFactory := getContractCtx("user-deployer-whitelist", vmManager)
-		txMiddleWare = append(
-			txMiddleWare, throttle.NewContractTxLimiterMiddleware(cfg.ContractTxLimiter, contextFactory),
-		)
+		txMiddleWare = append(txMiddleWare, loomchain.SerialTxMiddleware(
+			throttle.NewContractTxLimiterMiddleware(cfg.ContractTxLimiter, contextFactory),
+		))
 	}
 
 	if cfg.DeployerWhitelist.ContractEnabled {
@@ -1083,11 +1283,22 @@ func loadApp(
 		if err != nil {
 			return nil, err
 		}
+		if backups != nil {
+			backups.AddSource(store.BackupSource{
+				Name: cfg.BlockIndexStore.DBName,
+				Path: common.BackupDBPath(
+					cfg, filepath.Join(cfg.RootPath(), cfg.BlockIndexStore.DBName+".db"),
+				),
+				Backend:  cfg.BlockIndexStore.DBBackend,
+				Snapshot: blockIndexStore.GetSnapshot,
+			})
+		}
 	}
 
-	// We need to make sure nonce post commit middleware is last
-	// as it doesn't pass control to other middlewares after it.
-	postCommitMiddlewares = append(postCommitMiddlewares, auth.NonceTxPostNonceMiddleware)
+	blockStore, err := store.NewBlockStore(cfg.BlockStore)
+	if err != nil {
+		return nil, err
+	}
 
 	return &loomchain.Application{
 		Store: appStore,
@@ -1106,6 +1317,8 @@ func loadApp(
 		EventStore:                  eventStore,
 		GetValidatorSet:             getValidatorSet,
 		EvmAuxStore:                 evmAuxStore,
+		BlockStore:                  blockStore,
+		Backups:                     backups,
 	}, nil
 }
 
@@ -1177,7 +1390,10 @@ func getContractStaticCtx(pluginName string, vmManager *vm.Manager) staticContex
 	}
 }
 
-func initBackend(cfg *config.Config, abciServerAddr string, fnRegistry fnConsensus.FnRegistry) backend.Backend {
+func initBackend(
+	cfg *config.Config, abciServerAddr string, fnRegistry fnConsensus.FnRegistry,
+	backups *store.BackupManager,
+) backend.Backend {
 	ovCfg := &backend.OverrideConfig{
 		LogLevel:                 cfg.BlockchainLogLevel,
 		Peers:                    cfg.Peers,
@@ -1189,17 +1405,25 @@ func initBackend(cfg *config.Config, abciServerAddr string, fnRegistry fnConsens
 		HsmConfig:                cfg.HsmConfig,
 		FnConsensusReactorConfig: cfg.FnConsensus.Reactor,
 	}
-	return &backend.TendermintBackend{
+	b := &backend.TendermintBackend{
 		RootPath:    path.Join(cfg.RootPath(), "chaindata"),
 		OverrideCfg: ovCfg,
 		SocketPath:  abciServerAddr,
 		FnRegistry:  fnRegistry,
 	}
+	if backups != nil {
+		b.OnDBLoaded = func(ctx *node.DBContext, db dbm.DB) {
+			backups.AddSource(common.TendermintBackupSource(
+				cfg, ctx.ID, ctx.Config.DBDir(), ctx.Config.DBBackend, db,
+			))
+		}
+	}
+	return b
 }
 
 func initQueryService(
 	app *loomchain.Application, chainID string, cfg *config.Config, loader plugin.Loader,
-	receiptHandlerProvider loomchain.ReceiptHandlerProvider,
+	receiptHandlerProvider loomchain.ReceiptHandlerProvider, backups *store.BackupManager,
 ) error {
 	// metrics
 	fieldKeys := []string{"method", "error"}
@@ -1252,11 +1476,15 @@ func initQueryService(
 		BlockIndexStore:        app.BlockIndexStore,
 		EventStore:             app.EventStore,
 		AuthCfg:                cfg.Auth,
+		RPCGasCap:              cfg.RPCGasCap,
+		MaxTraceTimeout:        time.Duration(cfg.DebugRPCMaxTraceTimeout) * time.Second,
 		EvmAuxStore:            app.EvmAuxStore,
+		ResumableSubscriptions: rpc.NewResumableEventSubscriptions(),
 	}
 	bus := &rpc.QueryEventBus{
-		Subs:    *app.EventHandler.SubscriptionSet(),
-		EthSubs: *app.EventHandler.LegacyEthSubscriptionSet(),
+		Subs:          *app.EventHandler.SubscriptionSet(),
+		EthSubs:       *app.EventHandler.LegacyEthSubscriptionSet(),
+		ResumableSubs: qs.ResumableSubscriptions,
 	}
 	// query service
 	var qsvc rpc.QueryService
@@ -1265,7 +1493,10 @@ func initQueryService(
 		qsvc = rpc.NewInstrumentingMiddleWare(requestCount, requestLatency, qsvc)
 	}
 	logger := log.Root.With("module", "query-server")
-	err = rpc.RPCServer(qsvc, logger, bus, cfg.RPCBindAddress, cfg.UnsafeRPCEnabled, cfg.UnsafeRPCBindAddress)
+	err = rpc.RPCServer(
+		qsvc, logger, bus, cfg.RPCBindAddress, cfg.DebugRPCEnabled, cfg.UnsafeRPCEnabled, cfg.UnsafeRPCBindAddress,
+		backups,
+	)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/loomnetwork/loomchain/config"
+	cdb "github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/log"
+	"github.com/loomnetwork/loomchain/store"
+	"github.com/pkg/errors"
+	"github.com/tendermint/tendermint/blockchain"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+func appStoreSnapshotDir(cfg *config.Config) string {
+	if filepath.IsAbs(cfg.AppStore.SnapshotDir) {
+		return cfg.AppStore.SnapshotDir
+	}
+	return filepath.Join(cfg.RootPath(), cfg.AppStore.SnapshotDir)
+}
+
+// restoreAppStoreFromSnapshot restores app.db & evm.db from the snapshot in the given directory.
+// The snapshot is verified against the app hash recorded in the Tendermint block store, so the
+// block store must contain the block that follows the snapshot height.
+func restoreAppStoreFromSnapshot(cfg *config.Config, snapshotDir string) error {
+	if cfg.AppStore.Version != 3 {
+		return errors.New("app store snapshots require AppStore.Version 3")
+	}
+	manifest, err := store.ReadSnapshotManifest(snapshotDir)
+	if err != nil {
+		return err
+	}
+	appHash, err := loadAppHashFromBlockStore(cfg, manifest.Height)
+	if err != nil {
+		return err
+	}
+
+	appDB, err := cdb.LoadDB(
+		cfg.DBBackend, cfg.DBName, cfg.RootPath(), cfg.DBBackendConfig.CacheSizeMegs,
+		cfg.DBBackendConfig.WriteBufferMegs, false,
+	)
+	if err != nil {
+		return err
+	}
+	defer appDB.Close()
+	iavlStore, err := store.NewIAVLStore(appDB, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+	if iavlStore.Version() != 0 {
+		return fmt.Errorf(
+			"%s.db already contains state at height %d, it must be removed before restoring from a snapshot",
+			cfg.DBName, iavlStore.Version(),
+		)
+	}
+
+	evmDB, err := cdb.LoadDB(
+		cfg.EvmStore.DBBackend, cfg.EvmStore.DBName, cfg.RootPath(), cfg.EvmStore.CacheSizeMegs,
+		cfg.EvmStore.WriteBufferMegs, false,
+	)
+	if err != nil {
+		return err
+	}
+	defer evmDB.Close()
+
+	log.Info("Restoring app store from snapshot", "height", manifest.Height, "dir", snapshotDir)
+	if _, err := store.RestoreSnapshot(snapshotDir, appDB, evmDB, appHash); err != nil {
+		return errors.Wrap(err, "failed to restore app store from snapshot")
+	}
+	log.Info("Restored app store from snapshot", "height", manifest.Height)
+	return nil
+}
+
+func loadAppHashFromBlockStore(cfg *config.Config, height int64) ([]byte, error) {
+	blockStoreDB := dbm.NewDB("blockstore", "leveldb", path.Join(cfg.RootPath(), "chaindata", "data"))
+	defer blockStoreDB.Close()
+
+	// The app hash in the header of a block is the app hash produced by the previous block
+	blockMeta := blockchain.NewBlockStore(blockStoreDB).LoadBlockMeta(height + 1)
+	if blockMeta == nil {
+		return nil, fmt.Errorf("block %d not found in block store, can't verify snapshot", height+1)
+	}
+	return []byte(blockMeta.Header.AppHash), nil
+}
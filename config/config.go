@@ -17,6 +17,7 @@ import (
 	"github.com/loomnetwork/loomchain/evm"
 	hsmpv "github.com/loomnetwork/loomchain/privval/hsm"
 	receipts "github.com/loomnetwork/loomchain/receipts/handler"
+	"github.com/loomnetwork/loomchain/receipts/leveldb"
 	registry "github.com/loomnetwork/loomchain/registry/factory"
 	"github.com/loomnetwork/loomchain/store"
 	blockindex "github.com/loomnetwork/loomchain/store/block_index"
@@ -29,6 +30,9 @@ import (
 	"github.com/loomnetwork/loomchain/fnConsensus"
 )
 
+// DefaultRPCGasCap is the default value of Config.RPCGasCap.
+const DefaultRPCGasCap = uint64(50000000)
+
 type (
 	Genesis        = genesiscfg.Genesis
 	ContractConfig = genesiscfg.ContractConfig
@@ -57,6 +61,13 @@ type Config struct {
 	RPCBindAddress       string
 	UnsafeRPCBindAddress string
 	UnsafeRPCEnabled     bool
+	// Caps the amount of gas eth_estimateGas & debug_traceCall will give a tx.
+	RPCGasCap uint64
+	// Enables the debug_trace* Web3 JSON-RPC methods, these re-execute EVM txs so they're disabled
+	// by default, and shouldn't be enabled on public nodes.
+	DebugRPCEnabled bool
+	// Maximum number of seconds the debug_trace* methods can spend tracing a single tx.
+	DebugRPCMaxTraceTimeout int64
 
 	Peers           string
 	PersistentPeers string
@@ -99,6 +110,10 @@ type Config struct {
 	// Blockstore config
 	BlockStore      *store.BlockStoreConfig
 	BlockIndexStore *blockindex.BlockIndexStoreConfig
+	// Retention & archival of EVM tx receipts
+	ReceiptRetention *leveldb.ReceiptRetentionConfig
+	// Index the internal txs (call tree) of EVM txs in the receipts DB
+	EVMInternalTxIndexEnabled bool
 	// Cashing store
 	CachingStoreConfig *store.CachingStoreConfig
 
@@ -160,6 +175,8 @@ func DefaultFnConsensusConfig() *FnConsensusConfig {
 	}
 }
 
+// DBBackendConfig contains the settings of the app store DB backend, the cache size is ignored by
+// the badgerdb & boltdb backends, and the write buffer size is ignored by the boltdb backend.
 type DBBackendConfig struct {
 	CacheSizeMegs   int
 	WriteBufferMegs int
@@ -370,6 +387,9 @@ func DefaultConfig() *Config {
 		RPCBindAddress:             "tcp://0.0.0.0:46658",
 		UnsafeRPCEnabled:           false,
 		UnsafeRPCBindAddress:       "tcp://127.0.0.1:26680",
+		RPCGasCap:                  DefaultRPCGasCap,
+		DebugRPCEnabled:            false,
+		DebugRPCMaxTraceTimeout:    30,
 		CreateEmptyBlocks:          true,
 		ContractLoaders:            []string{"static"},
 		LogStateDB:                 false,
@@ -379,6 +399,7 @@ func DefaultConfig() *Config {
 		EVMPersistentTxReceiptsMax: receipts.DefaultMaxReceipts,
 		SessionDuration:            600,
 		EVMAccountsEnabled:         false,
+		EVMInternalTxIndexEnabled:  false,
 		EVMDebugEnabled:            false,
 
 		Oracle:                 "",
@@ -401,6 +422,7 @@ func DefaultConfig() *Config {
 	cfg.CachingStoreConfig = store.DefaultCachingStoreConfig()
 	cfg.BlockStore = store.DefaultBlockStoreConfig()
 	cfg.BlockIndexStore = blockindex.DefaultBlockIndexStoreConfig()
+	cfg.ReceiptRetention = leveldb.DefaultReceiptRetentionConfig()
 	cfg.Metrics = DefaultMetrics()
 	cfg.Karma = DefaultKarmaConfig()
 	cfg.ChainConfig = DefaultChainConfigConfig(cfg.RPCProxyPort)
@@ -443,6 +465,7 @@ func (c *Config) Clone() *Config {
 	clone.EventStore = c.EventStore.Clone()
 	clone.EventDispatcher = c.EventDispatcher.Clone()
 	clone.Auth = c.Auth.Clone()
+	clone.ReceiptRetention = c.ReceiptRetention.Clone()
 	return &clone
 }
 
@@ -514,6 +537,9 @@ RPCProxyPort: {{ .RPCProxyPort }}
 RPCBindAddress: "{{ .RPCBindAddress }}"
 UnsafeRPCEnabled: {{ .UnsafeRPCEnabled }}
 UnsafeRPCBindAddress: "{{ .UnsafeRPCBindAddress }}"
+RPCGasCap: {{ .RPCGasCap }}
+DebugRPCEnabled: {{ .DebugRPCEnabled }}
+DebugRPCMaxTraceTimeout: {{ .DebugRPCMaxTraceTimeout }}
 Peers: "{{ .Peers }}"
 PersistentPeers: "{{ .PersistentPeers }}"
 #
@@ -611,11 +637,31 @@ BlockStore:
   CacheSize: {{ .BlockStore.CacheSize }}
 BlockIndexStore:  
   Enabled: {{ .BlockIndexStore.Enabled }}
-  # goleveldb | cleveldb | memdb
+  # goleveldb | cleveldb | badgerdb | boltdb | memdb
   DBBackend: {{ .BlockIndexStore.DBBackend }}
   DBName: {{ .BlockIndexStore.DBName }}
   CacheSizeMegs: {{ .BlockIndexStore.CacheSizeMegs }}
   WriteBufferMegs: {{ .BlockIndexStore.WriteBufferMegs }}
+{{- if .ReceiptRetention }}
+#
+# EVM tx receipt retention
+#
+ReceiptRetention:
+  # Max number of recent blocks to keep receipts for, zero means no limit.
+  RetainBlocks: {{ .ReceiptRetention.RetainBlocks }}
+  # Max age (in seconds) of the receipts to keep, relative to the latest block, zero means no limit.
+  RetainSeconds: {{ .ReceiptRetention.RetainSeconds }}
+  # Export receipts to compressed archive files before they're deleted.
+  ArchiveEnabled: {{ .ReceiptRetention.ArchiveEnabled }}
+  ArchiveDir: "{{ .ReceiptRetention.ArchiveDir }}"
+  # Number of blocks covered by each archive file.
+  ArchiveSegmentBlocks: {{ .ReceiptRetention.ArchiveSegmentBlocks }}
+{{- end}}
+#
+# Index the internal txs (contract-to-contract calls & value transfers) made by EVM txs, they can be
+# looked up with loom_getInternalTransactions, and are included in eth_getTransactionReceipt.
+#
+EVMInternalTxIndexEnabled: {{ .EVMInternalTxIndexEnabled }}
 #
 # Cashing store 
 #
@@ -688,6 +734,16 @@ AppStore:
   # If true the app store will write EVM state to both IAVLStore and EvmStore
   # This config works with AppStore Version 3 (MultiWriterAppStore) only
   SaveEVMStateToIAVL: {{ .AppStore.SaveEVMStateToIAVL }}
+  # Number of blocks between app store snapshots, if zero snapshots won't be exported.
+  # Only versions that have been flushed to disk can be exported, so this should be a multiple
+  # of IAVLFlushInterval. This config works with AppStore Version 3 (MultiWriterAppStore) only.
+  SnapshotInterval: {{ .AppStore.SnapshotInterval }}
+  # Directory snapshots are written to, relative to the node root path.
+  SnapshotDir: "{{ .AppStore.SnapshotDir }}"
+  # Number of most recent snapshots to keep, older snapshots will be deleted.
+  NumSnapshotsToKeep: {{ .AppStore.NumSnapshotsToKeep }}
+  # Max number of DB entries per snapshot chunk.
+  SnapshotChunkSize: {{ .AppStore.SnapshotChunkSize }}
 {{if .EventStore -}}
 #
 # EventStore
@@ -695,6 +751,25 @@ AppStore:
 EventStore:
   DBName: {{.EventStore.DBName}}
   DBBackend: {{.EventStore.DBBackend}}
+  # Number of most recent blocks to retain events for, if zero events won't be pruned based on
+  # block height.
+  RetainBlocks: {{.EventStore.RetainBlocks}}
+  # Number of seconds to retain events for, if zero events won't be pruned based on age.
+  RetainSeconds: {{.EventStore.RetainSeconds}}
+  # Number of seconds to wait between event store pruning cycles
+  PruneInterval: {{.EventStore.PruneInterval}}
+  # Maximum number of events to delete from the event store in a single batch
+  PruneBatchSize: {{.EventStore.PruneBatchSize}}
+  {{- if .EventStore.ContractRetention}}
+  # Overrides the retention policy for the events emitted by specific contracts, keyed by the
+  # plugin name of a Go contract, or the chain:0x... address of an EVM contract.
+  ContractRetention:
+    {{- range $k, $v := .EventStore.ContractRetention}}
+    {{$k}}:
+      RetainBlocks: {{$v.RetainBlocks}}
+      RetainSeconds: {{$v.RetainSeconds}}
+    {{- end}}
+  {{- end}}
 {{end}}
 
 {{if .EvmStore -}}
@@ -705,12 +780,19 @@ EvmStore:
   # DBName defines evm database file name
   DBName: {{.EvmStore.DBName}}
   # DBBackend defines backend EVM store type
-  # available backend types are 'goleveldb', or 'cleveldb'
+  # available backend types are 'goleveldb', 'cleveldb', 'badgerdb', or 'boltdb'
   DBBackend: {{.EvmStore.DBBackend}}
   # CacheSizeMegs defines cache size (in megabytes) of EVM store
   CacheSizeMegs: {{.EvmStore.CacheSizeMegs}}
   # NumCachedRoots defines a number of in-memory cached EVM roots
   NumCachedRoots: {{.EvmStore.NumCachedRoots}}
+  # If true EVM state that's no longer reachable from the versions retained by the app store
+  # (see AppStore.MaxVersions) will be periodically deleted from the EVM store.
+  PruningEnabled: {{.EvmStore.PruningEnabled}}
+  # Number of seconds to wait between EVM store pruning cycles
+  PruneInterval: {{.EvmStore.PruneInterval}}
+  # Maximum number of stale keys to delete from the EVM store in a single batch
+  PruneBatchSize: {{.EvmStore.PruneBatchSize}}
 {{end}}
 
 # 
@@ -733,13 +815,56 @@ FnConsensus:
 # EventDispatcher
 #
 EventDispatcher:
-  # Available dispatcher: "db_indexer" | "log" | "redis"
-  Dispatcher: {{.EventDispatcher.Dispatcher}}
-  {{if eq .EventDispatcher.Dispatcher "redis"}}
-  # Redis will be use when Dispatcher is "redis"
+  # Available dispatchers: "db_indexer" | "log" | "redis" | "webhook"
+  # Events can be sent to multiple dispatchers by separating them with commas, e.g. "db_indexer,webhook"
+  Dispatcher: "{{.EventDispatcher.Dispatcher}}"
+  {{if .EventDispatcher.Redis}}
+  # Redis will be use when Dispatcher includes "redis"
   Redis:
     URI: "{{.EventDispatcher.Redis.URI}}"
   {{end}}
+  {{- if .EventDispatcher.Webhooks}}
+  # Endpoints events will be posted to when Dispatcher includes "webhook"
+  Webhooks:
+    {{- range .EventDispatcher.Webhooks}}
+    - Name: "{{.Name}}"
+      URL: "{{.URL}}"
+      # Secret used to sign the request body with HMAC-SHA256, the signature is sent in the
+      # X-Loom-Signature header.
+      Secret: "{{.Secret}}"
+      # Only events emitted by these contracts will be posted (all contracts if empty)
+      Contracts:
+        {{- range .Contracts}}
+        - "{{.}}"
+        {{- end}}
+      # Only events with at least one of these topics will be posted (all topics if empty)
+      Topics:
+        {{- range .Topics}}
+        - "{{.}}"
+        {{- end}}
+      BatchSize: {{.BatchSize}}
+      # Number of seconds to wait for the endpoint to respond
+      Timeout: {{.Timeout}}
+      MaxRetries: {{.MaxRetries}}
+      # Number of seconds to wait before retrying, doubled after each retry
+      RetryInterval: {{.RetryInterval}}
+    {{- end}}
+  {{- end}}
+  {{- if .EventDispatcher.Outbox}}
+  Outbox:
+    # If true the events emitted in each block are recorded in the outbox DB before the block is
+    # committed, and are then delivered to the dispatcher in the background. Events that fail to be
+    # delivered are retried until they succeed, even across node restarts.
+    Enabled: {{.EventDispatcher.Outbox.Enabled}}
+    DBName: "{{.EventDispatcher.Outbox.DBName}}"
+    # available backend types are 'goleveldb', 'cleveldb', 'badgerdb', or 'boltdb'
+    DBBackend: "{{.EventDispatcher.Outbox.DBBackend}}"
+    # Number of seconds to wait before retrying delivery after the first failure, the delay is
+    # doubled after each subsequent failure.
+    MinRetryInterval: {{.EventDispatcher.Outbox.MinRetryInterval}}
+    # Maximum number of seconds to wait before retrying delivery.
+    MaxRetryInterval: {{.EventDispatcher.Outbox.MaxRetryInterval}}
+  {{- end}}
 #
 # Tx signing & accounts
 #
@@ -753,6 +878,9 @@ Auth:
 # These should pretty much never be changed
 RootDir: "{{ .RootDir }}"
 DBName: "{{ .DBName }}"
+# App store DB backend: goleveldb | cleveldb | badgerdb | boltdb
+# Use "loom db convert" to migrate an existing app.db to a different backend.
+DBBackend: "{{ .DBBackend }}"
 GenesisFile: "{{ .GenesisFile }}"
 PluginsDir: "{{ .PluginsDir }}"
 #
@@ -0,0 +1,297 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+// BadgerDB is a DBWrapper backed by BadgerDB, a pure-Go LSM tree that stores values separately
+// from keys, which keeps compaction of large DBs (like the EVM store) relatively cheap.
+type BadgerDB struct {
+	db *badger.DB
+}
+
+var _ DBWrapper = &BadgerDB{}
+
+// LoadBadgerDB opens (or creates) a BadgerDB in the <dir>/<name>.db directory. BadgerDB doesn't
+// have a block cache, so unlike LevelDB only the write buffer size can be configured.
+func LoadBadgerDB(name, dir string, bufferSizeMeg int) (*BadgerDB, error) {
+	opts := badger.DefaultOptions(filepath.Join(dir, name+".db"))
+	if bufferSizeMeg > 0 {
+		opts.MaxTableSize = int64(bufferSizeMeg) << 20
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open BadgerDB %s", name)
+	}
+	return &BadgerDB{db: db}, nil
+}
+
+func (b *BadgerDB) Get(key []byte) []byte {
+	requireKey(BadgerDBBackend, key)
+	var value []byte
+	err := b.db.View(func(txn *badger.Txn) error {
+		var err error
+		value, err = badgerGet(txn, key)
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+func (b *BadgerDB) Has(key []byte) bool {
+	return b.Get(key) != nil
+}
+
+func (b *BadgerDB) Set(key, value []byte) {
+	requireKey(BadgerDBBackend, key)
+	err := b.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (b *BadgerDB) SetSync(key, value []byte) {
+	b.Set(key, value)
+}
+
+func (b *BadgerDB) Delete(key []byte) {
+	requireKey(BadgerDBBackend, key)
+	err := b.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (b *BadgerDB) DeleteSync(key []byte) {
+	b.Delete(key)
+}
+
+func (b *BadgerDB) Iterator(start, end []byte) dbm.Iterator {
+	return newBadgerDBIterator(b.db.NewTransaction(false), true, start, end, false)
+}
+
+func (b *BadgerDB) ReverseIterator(start, end []byte) dbm.Iterator {
+	return newBadgerDBIterator(b.db.NewTransaction(false), true, start, end, true)
+}
+
+func (b *BadgerDB) Close() {
+	b.db.Close()
+}
+
+// NewBatch returns a batch that's written in a single BadgerDB transaction. BadgerDB limits the
+// size of a transaction to a fraction of the max table size (which is set from the buffer size),
+// writing a batch that exceeds the limit fails rather than splitting the batch into multiple
+// transactions, which would make the batch non-atomic.
+func (b *BadgerDB) NewBatch() dbm.Batch {
+	return newOpBatch(b.writeBatch)
+}
+
+func (b *BadgerDB) writeBatch(ops []batchOp) error {
+	txn := b.db.NewTransaction(true)
+	defer txn.Discard()
+	for _, op := range ops {
+		if err := applyBadgerOp(txn, op); err != nil {
+			if err == badger.ErrTxnTooBig {
+				return errors.Wrapf(err, "batch of %d ops exceeds the max BadgerDB transaction size", len(ops))
+			}
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+func applyBadgerOp(txn *badger.Txn, op batchOp) error {
+	requireKey(BadgerDBBackend, op.key)
+	if op.delete {
+		return txn.Delete(op.key)
+	}
+	return txn.Set(op.key, op.value)
+}
+
+func (b *BadgerDB) Print() {
+	printDB(b)
+}
+
+func (b *BadgerDB) Stats() map[string]string {
+	lsmSize, vlogSize := b.db.Size()
+	return map[string]string{
+		"database.type": "badgerdb",
+		"lsm.size":      fmt.Sprintf("%d", lsmSize),
+		"vlog.size":     fmt.Sprintf("%d", vlogSize),
+	}
+}
+
+// Compact merges all the LSM tree levels into one, and then garbage collects the value log files
+// until there's nothing left to reclaim.
+func (b *BadgerDB) Compact() error {
+	if err := b.db.Flatten(1); err != nil {
+		return errors.Wrap(err, "failed to flatten LSM tree")
+	}
+	for {
+		err := b.db.RunValueLogGC(0.5)
+		if err == badger.ErrNoRewrite {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to GC value log")
+		}
+	}
+}
+
+func (b *BadgerDB) GetSnapshot() Snapshot {
+	return &BadgerDBSnapshot{txn: b.db.NewTransaction(false)}
+}
+
+// BadgerDBSnapshot is a read-only BadgerDB transaction, which provides a consistent view of the
+// DB until it's released.
+type BadgerDBSnapshot struct {
+	txn *badger.Txn
+}
+
+var _ Snapshot = &BadgerDBSnapshot{}
+
+func (s *BadgerDBSnapshot) Get(key []byte) []byte {
+	requireKey(BadgerDBBackend, key)
+	value, err := badgerGet(s.txn, key)
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+func (s *BadgerDBSnapshot) Has(key []byte) bool {
+	return s.Get(key) != nil
+}
+
+func (s *BadgerDBSnapshot) NewIterator(start, end []byte) dbm.Iterator {
+	return newBadgerDBIterator(s.txn, false, start, end, false)
+}
+
+func (s *BadgerDBSnapshot) Release() {
+	s.txn.Discard()
+}
+
+// badgerGet returns nil if the key doesn't exist, and a non-nil value otherwise (even if the
+// value is empty).
+func badgerGet(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value, nil
+}
+
+// badgerDBIterator iterates over the keys in the range [start, end) within a read-only BadgerDB
+// transaction.
+type badgerDBIterator struct {
+	txn        *badger.Txn
+	ownsTxn    bool // if true the transaction is discarded when the iterator is closed
+	iter       *badger.Iterator
+	start, end []byte
+	reverse    bool
+}
+
+var _ dbm.Iterator = &badgerDBIterator{}
+
+func newBadgerDBIterator(txn *badger.Txn, ownsTxn bool, start, end []byte, reverse bool) *badgerDBIterator {
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = reverse
+	iter := txn.NewIterator(opts)
+	if reverse {
+		if end == nil {
+			iter.Rewind()
+		} else {
+			// in reverse mode Seek finds the largest key <= end, but end is exclusive
+			iter.Seek(end)
+			if iter.Valid() && bytes.Equal(iter.Item().Key(), end) {
+				iter.Next()
+			}
+		}
+	} else {
+		if start == nil {
+			iter.Rewind()
+		} else {
+			iter.Seek(start)
+		}
+	}
+	return &badgerDBIterator{
+		txn:     txn,
+		ownsTxn: ownsTxn,
+		iter:    iter,
+		start:   start,
+		end:     end,
+		reverse: reverse,
+	}
+}
+
+func (it *badgerDBIterator) Domain() ([]byte, []byte) {
+	return it.start, it.end
+}
+
+func (it *badgerDBIterator) Valid() bool {
+	if !it.iter.Valid() {
+		return false
+	}
+	key := it.iter.Item().Key()
+	if it.reverse {
+		return it.start == nil || bytes.Compare(key, it.start) >= 0
+	}
+	return it.end == nil || bytes.Compare(key, it.end) < 0
+}
+
+func (it *badgerDBIterator) Next() {
+	it.assertValid()
+	it.iter.Next()
+}
+
+func (it *badgerDBIterator) Key() []byte {
+	it.assertValid()
+	return it.iter.Item().KeyCopy(nil)
+}
+
+func (it *badgerDBIterator) Value() []byte {
+	it.assertValid()
+	value, err := it.iter.Item().ValueCopy(nil)
+	if err != nil {
+		panic(err)
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value
+}
+
+func (it *badgerDBIterator) Close() {
+	it.iter.Close()
+	if it.ownsTxn {
+		it.txn.Discard()
+	}
+}
+
+func (it *badgerDBIterator) assertValid() {
+	if !it.Valid() {
+		panic("badgerDBIterator is invalid")
+	}
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+// batchOp is a set or delete queued in an opBatch.
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// opBatch is a dbm.Batch that queues sets & deletes in memory until the batch is written, it's
+// used by backends that don't have a native batch type compatible with dbm.Batch.
+type opBatch struct {
+	ops   []batchOp
+	write func(ops []batchOp) error
+}
+
+var _ dbm.Batch = &opBatch{}
+
+func newOpBatch(write func(ops []batchOp) error) *opBatch {
+	return &opBatch{write: write}
+}
+
+func (b *opBatch) Set(key, value []byte) {
+	b.ops = append(b.ops, batchOp{
+		key:   append([]byte{}, key...),
+		value: append([]byte{}, value...),
+	})
+}
+
+func (b *opBatch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{
+		key:    append([]byte{}, key...),
+		delete: true,
+	})
+}
+
+func (b *opBatch) Write() {
+	if err := b.write(b.ops); err != nil {
+		panic(errors.Wrap(err, "failed to write batch"))
+	}
+	b.ops = nil
+}
+
+func (b *opBatch) WriteSync() {
+	b.Write()
+}
+
+// requireKey panics if the given key is empty, unlike LevelDB the BadgerDB & bbolt backends can't
+// store values under an empty key.
+func requireKey(backend string, key []byte) {
+	if len(key) == 0 {
+		panic(fmt.Sprintf("%s: empty keys are not supported", backend))
+	}
+}
+
+func printDB(db dbm.DB) {
+	itr := db.Iterator(nil, nil)
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		fmt.Printf("[%X]:\t[%X]\n", itr.Key(), itr.Value())
+	}
+}
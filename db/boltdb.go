@@ -0,0 +1,410 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	dbm "github.com/tendermint/tendermint/libs/db"
+	bolt "go.etcd.io/bbolt"
+)
+
+// All the keys are stored in a single bucket.
+var boltBucket = []byte("loom")
+
+const (
+	// Number of keys loaded at a time by iterators over a BoltDB.
+	boltIteratorBatchSize = 1000
+	// Number of keys written per transaction when a BoltDB is compacted.
+	boltCompactBatchSize = 10000
+)
+
+// BoltDB is a DBWrapper backed by bbolt, a pure-Go B+tree stored in a single memory-mapped file.
+// Reads never stall on compaction, at the cost of slower writes than LSM-based backends.
+type BoltDB struct {
+	db   *bolt.DB
+	path string
+}
+
+var _ DBWrapper = &BoltDB{}
+
+// LoadBoltDB opens (or creates) a bbolt DB in the <dir>/<name>.db file. bbolt relies on the OS
+// page cache, so it doesn't have any cache or write buffer settings.
+func LoadBoltDB(name, dir string) (*BoltDB, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	b := &BoltDB{path: filepath.Join(dir, name+".db")}
+	if err := b.open(); err != nil {
+		return nil, errors.Wrapf(err, "failed to open BoltDB %s", name)
+	}
+	return b, nil
+}
+
+func (b *BoltDB) open() error {
+	db, err := bolt.Open(b.path, 0600, &bolt.Options{
+		// fail instead of blocking forever if another process has the DB open
+		Timeout: time.Second,
+		// the freelist is rebuilt on load instead of being written on every commit, which speeds
+		// up writes to large DBs considerably
+		NoFreelistSync: true,
+		FreelistType:   bolt.FreelistMapType,
+	})
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	b.db = db
+	return nil
+}
+
+func (b *BoltDB) Get(key []byte) []byte {
+	requireKey(BoltDBBackend, key)
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value = boltGet(tx, key)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
+func (b *BoltDB) Has(key []byte) bool {
+	return b.Get(key) != nil
+}
+
+func (b *BoltDB) Set(key, value []byte) {
+	requireKey(BoltDBBackend, key)
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, value)
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (b *BoltDB) SetSync(key, value []byte) {
+	b.Set(key, value)
+}
+
+func (b *BoltDB) Delete(key []byte) {
+	requireKey(BoltDBBackend, key)
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(key)
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (b *BoltDB) DeleteSync(key []byte) {
+	b.Delete(key)
+}
+
+func (b *BoltDB) Iterator(start, end []byte) dbm.Iterator {
+	return newBoltDBIterator(b.db.View, start, end, false)
+}
+
+func (b *BoltDB) ReverseIterator(start, end []byte) dbm.Iterator {
+	return newBoltDBIterator(b.db.View, start, end, true)
+}
+
+func (b *BoltDB) Close() {
+	b.db.Close()
+}
+
+// NewBatch returns a batch that's written in a single bbolt transaction.
+func (b *BoltDB) NewBatch() dbm.Batch {
+	return newOpBatch(func(ops []batchOp) error {
+		return b.db.Update(func(tx *bolt.Tx) error {
+			return applyBoltOps(tx.Bucket(boltBucket), ops)
+		})
+	})
+}
+
+func applyBoltOps(bucket *bolt.Bucket, ops []batchOp) error {
+	for _, op := range ops {
+		requireKey(BoltDBBackend, op.key)
+		var err error
+		if op.delete {
+			err = bucket.Delete(op.key)
+		} else {
+			err = bucket.Put(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BoltDB) Print() {
+	printDB(b)
+}
+
+func (b *BoltDB) Stats() map[string]string {
+	stats := b.db.Stats()
+	return map[string]string{
+		"database.type":  "boltdb",
+		"free.pages":     fmt.Sprintf("%d", stats.FreePageN),
+		"pending.pages":  fmt.Sprintf("%d", stats.PendingPageN),
+		"free.alloc":     fmt.Sprintf("%d", stats.FreeAlloc),
+		"read.txs":       fmt.Sprintf("%d", stats.TxN),
+		"open.read.txs":  fmt.Sprintf("%d", stats.OpenTxN),
+		"freelist.inuse": fmt.Sprintf("%d", stats.FreelistInuse),
+	}
+}
+
+// Compact rewrites the DB into a new file to release the pages freed by deletes back to the OS,
+// bbolt reuses freed pages but never shrinks the DB file. The DB must not be in use by any other
+// goroutine while it's being compacted.
+func (b *BoltDB) Compact() error {
+	compactPath := b.path + ".compact"
+	if err := os.RemoveAll(compactPath); err != nil {
+		return err
+	}
+	dest, err := bolt.Open(compactPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return errors.Wrap(err, "failed to create compacted DB")
+	}
+	if err := copyBoltDB(b.db, dest); err != nil {
+		dest.Close()
+		os.Remove(compactPath)
+		return errors.Wrap(err, "failed to copy DB")
+	}
+	if err := dest.Close(); err != nil {
+		os.Remove(compactPath)
+		return err
+	}
+	if err := b.db.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(compactPath, b.path); err != nil {
+		// leave the DB usable even if the compacted copy couldn't replace it
+		if openErr := b.open(); openErr != nil {
+			return errors.Wrapf(openErr, "failed to reopen DB after rename error: %v", err)
+		}
+		return err
+	}
+	return b.open()
+}
+
+// copyBoltDB copies all the keys in the source DB to the destination DB, the keys are written in
+// batches to keep the size of the write transactions in check.
+func copyBoltDB(src, dest *bolt.DB) error {
+	return src.View(func(srcTx *bolt.Tx) error {
+		c := srcTx.Bucket(boltBucket).Cursor()
+		k, v := c.First()
+		for {
+			done := false
+			err := dest.Update(func(destTx *bolt.Tx) error {
+				bucket, err := destTx.CreateBucketIfNotExists(boltBucket)
+				if err != nil {
+					return err
+				}
+				// keys are inserted in order, so the pages can be filled completely
+				bucket.FillPercent = 1.0
+				for i := 0; i < boltCompactBatchSize; i++ {
+					if k == nil {
+						done = true
+						return nil
+					}
+					if err := bucket.Put(k, v); err != nil {
+						return err
+					}
+					k, v = c.Next()
+				}
+				return nil
+			})
+			if err != nil || done {
+				return err
+			}
+		}
+	})
+}
+
+// GetSnapshot returns a snapshot backed by a read-only bbolt transaction. bbolt can't grow the
+// memory-mapped DB file while a read transaction is open, so snapshots should be released as soon
+// as possible to avoid stalling writes.
+func (b *BoltDB) GetSnapshot() Snapshot {
+	tx, err := b.db.Begin(false)
+	if err != nil {
+		panic(err)
+	}
+	return &BoltDBSnapshot{tx: tx}
+}
+
+// BoltDBSnapshot provides a consistent view of a BoltDB until it's released.
+type BoltDBSnapshot struct {
+	tx *bolt.Tx
+}
+
+var _ Snapshot = &BoltDBSnapshot{}
+
+func (s *BoltDBSnapshot) Get(key []byte) []byte {
+	requireKey(BoltDBBackend, key)
+	return boltGet(s.tx, key)
+}
+
+func (s *BoltDBSnapshot) Has(key []byte) bool {
+	return s.Get(key) != nil
+}
+
+func (s *BoltDBSnapshot) NewIterator(start, end []byte) dbm.Iterator {
+	view := func(fn func(*bolt.Tx) error) error {
+		return fn(s.tx)
+	}
+	return newBoltDBIterator(view, start, end, false)
+}
+
+func (s *BoltDBSnapshot) Release() {
+	s.tx.Rollback()
+}
+
+// boltGet returns a copy of the value stored under the given key, since the value returned by
+// bbolt is only valid for the lifetime of the transaction.
+func boltGet(tx *bolt.Tx, key []byte) []byte {
+	value := tx.Bucket(boltBucket).Get(key)
+	if value == nil {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
+
+// boltDBIterator iterates over the keys in the range [start, end) by loading a batch of keys at a
+// time, each batch is loaded in a separate read-only transaction so the iterator doesn't hold a
+// transaction open (and block the DB file from growing) for its entire lifetime. When iterating
+// over the DB directly (rather than a snapshot) writes that happen while the iterator is open may
+// be visible in subsequent batches.
+type boltDBIterator struct {
+	view         func(fn func(*bolt.Tx) error) error
+	domainStart  []byte
+	domainEnd    []byte
+	start, end   []byte // bounds of the next batch
+	reverse      bool
+	keys, values [][]byte
+	pos          int
+	done         bool
+}
+
+var _ dbm.Iterator = &boltDBIterator{}
+
+func newBoltDBIterator(
+	view func(fn func(*bolt.Tx) error) error, start, end []byte, reverse bool,
+) *boltDBIterator {
+	it := &boltDBIterator{
+		view:        view,
+		domainStart: start,
+		domainEnd:   end,
+		start:       start,
+		end:         end,
+		reverse:     reverse,
+	}
+	it.loadBatch()
+	return it
+}
+
+func (it *boltDBIterator) loadBatch() {
+	it.keys = it.keys[:0]
+	it.values = it.values[:0]
+	it.pos = 0
+	err := it.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		var k, v []byte
+		if it.reverse {
+			if it.end == nil {
+				k, v = c.Last()
+			} else if k, v = c.Seek(it.end); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		} else if it.start == nil {
+			k, v = c.First()
+		} else {
+			k, v = c.Seek(it.start)
+		}
+		for ; k != nil && len(it.keys) < boltIteratorBatchSize; k, v = it.advance(c) {
+			if it.reverse && it.start != nil && bytes.Compare(k, it.start) < 0 {
+				break
+			}
+			if !it.reverse && it.end != nil && bytes.Compare(k, it.end) >= 0 {
+				break
+			}
+			it.keys = append(it.keys, append([]byte{}, k...))
+			it.values = append(it.values, append([]byte{}, v...))
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	if len(it.keys) < boltIteratorBatchSize {
+		it.done = true
+		return
+	}
+	lastKey := it.keys[len(it.keys)-1]
+	if it.reverse {
+		it.end = lastKey
+	} else {
+		// the smallest key that follows the last key
+		it.start = append(append(make([]byte, 0, len(lastKey)+1), lastKey...), 0)
+	}
+}
+
+func (it *boltDBIterator) advance(c *bolt.Cursor) ([]byte, []byte) {
+	if it.reverse {
+		return c.Prev()
+	}
+	return c.Next()
+}
+
+func (it *boltDBIterator) Domain() ([]byte, []byte) {
+	return it.domainStart, it.domainEnd
+}
+
+func (it *boltDBIterator) Valid() bool {
+	return it.pos < len(it.keys)
+}
+
+func (it *boltDBIterator) Next() {
+	it.assertValid()
+	it.pos++
+	if it.pos >= len(it.keys) && !it.done {
+		it.loadBatch()
+	}
+}
+
+func (it *boltDBIterator) Key() []byte {
+	it.assertValid()
+	return it.keys[it.pos]
+}
+
+func (it *boltDBIterator) Value() []byte {
+	it.assertValid()
+	return it.values[it.pos]
+}
+
+func (it *boltDBIterator) Close() {
+	it.keys = nil
+	it.values = nil
+	it.done = true
+}
+
+func (it *boltDBIterator) assertValid() {
+	if !it.Valid() {
+		panic("boltDBIterator is invalid")
+	}
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+// CopyDB copies all the keys in the source DB to the destination DB, the keys are written in
+// batches of (at most) batchSize keys. If progress is not nil it's called after each batch is
+// written with the total number of keys copied so far. Returns the number of keys copied.
+func CopyDB(src, dest dbm.DB, batchSize int, progress func(numKeys int64)) int64 {
+	itr := src.Iterator(nil, nil)
+	defer itr.Close()
+
+	var numKeys int64
+	batch := dest.NewBatch()
+	batchKeys := 0
+	for ; itr.Valid(); itr.Next() {
+		batch.Set(itr.Key(), itr.Value())
+		batchKeys++
+		numKeys++
+		if batchKeys >= batchSize {
+			batch.Write()
+			batch = dest.NewBatch()
+			batchKeys = 0
+			if progress != nil {
+				progress(numKeys)
+			}
+		}
+	}
+	if batchKeys > 0 {
+		batch.Write()
+		if progress != nil {
+			progress(numKeys)
+		}
+	}
+	return numKeys
+}
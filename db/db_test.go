@@ -0,0 +1,143 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("k%05d", i))
+}
+
+func iteratorKeys(itr dbm.Iterator) []string {
+	defer itr.Close()
+	keys := []string{}
+	for ; itr.Valid(); itr.Next() {
+		keys = append(keys, string(itr.Key()))
+	}
+	return keys
+}
+
+func expectedKeys(from, to int, reverse bool) []string {
+	keys := []string{}
+	for i := from; i < to; i++ {
+		keys = append(keys, string(testKey(i)))
+	}
+	if reverse {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	return keys
+}
+
+func testDBBackend(t *testing.T, backend string) {
+	dir, err := ioutil.TempDir("", "loom-db-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := LoadDB(backend, "test", dir, 1, 1, false)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// more keys than fit into a single BoltDB iterator batch
+	numKeys := boltIteratorBatchSize*2 + 10
+	batch := db.NewBatch()
+	for i := 0; i < numKeys; i++ {
+		batch.Set(testKey(i), []byte(fmt.Sprintf("v%d", i)))
+	}
+	batch.Write()
+
+	require.Equal(t, []byte("v10"), db.Get(testKey(10)), backend)
+	require.True(t, db.Has(testKey(10)), backend)
+	require.Nil(t, db.Get([]byte("missing")), backend)
+	require.False(t, db.Has([]byte("missing")), backend)
+
+	db.Set([]byte("empty"), []byte{})
+	require.NotNil(t, db.Get([]byte("empty")), backend)
+	require.True(t, db.Has([]byte("empty")), backend)
+	db.Delete([]byte("empty"))
+	require.False(t, db.Has([]byte("empty")), backend)
+
+	require.Equal(t, expectedKeys(0, numKeys, false), iteratorKeys(db.Iterator(nil, nil)), backend)
+	require.Equal(t, expectedKeys(0, numKeys, true), iteratorKeys(db.ReverseIterator(nil, nil)), backend)
+	require.Equal(t, expectedKeys(5, 1500, false), iteratorKeys(db.Iterator(testKey(5), testKey(1500))), backend)
+	require.Equal(t, expectedKeys(5, 1500, true), iteratorKeys(db.ReverseIterator(testKey(5), testKey(1500))), backend)
+	itr := db.Iterator(testKey(7), nil)
+	require.Equal(t, []byte("v7"), itr.Value(), backend)
+	itr.Close()
+
+	snap := db.GetSnapshot()
+	batch = db.NewBatch()
+	batch.Delete(testKey(0))
+	batch.Set(testKey(1), []byte("updated"))
+	batch.WriteSync()
+	require.False(t, db.Has(testKey(0)), backend)
+	require.Equal(t, []byte("updated"), db.Get(testKey(1)), backend)
+	// the snapshot shouldn't see any changes made after it was created
+	if backend != MemDBackend {
+		require.True(t, snap.Has(testKey(0)), backend)
+		require.Equal(t, []byte("v1"), snap.Get(testKey(1)), backend)
+		require.Equal(t, expectedKeys(0, numKeys, false), iteratorKeys(snap.NewIterator(nil, nil)), backend)
+	}
+	snap.Release()
+
+	require.NoError(t, db.Compact(), backend)
+	require.Equal(t, []byte("updated"), db.Get(testKey(1)), backend)
+	require.Equal(t, expectedKeys(1, numKeys, false), iteratorKeys(db.Iterator(nil, nil)), backend)
+}
+
+func TestDBBackends(t *testing.T) {
+	for _, backend := range []string{MemDBackend, GoLevelDBBackend, BadgerDBBackend, BoltDBBackend} {
+		testDBBackend(t, backend)
+	}
+}
+
+func TestCopyDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loom-db-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src, err := LoadDB(GoLevelDBBackend, "src", dir, 1, 1, false)
+	require.NoError(t, err)
+	defer src.Close()
+	for i := 0; i < 25; i++ {
+		src.Set(testKey(i), testKey(i))
+	}
+
+	dest, err := LoadDB(BoltDBBackend, "dest", dir, 1, 1, false)
+	require.NoError(t, err)
+	defer dest.Close()
+	var progress []int64
+	numKeys := CopyDB(src, dest, 10, func(n int64) {
+		progress = append(progress, n)
+	})
+	require.Equal(t, int64(25), numKeys)
+	require.Equal(t, []int64{10, 20, 25}, progress)
+	require.Equal(t, expectedKeys(0, 25, false), iteratorKeys(dest.Iterator(nil, nil)))
+	require.Equal(t, testKey(3), dest.Get(testKey(3)))
+}
+
+func TestBadgerDBBatchTooBig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loom-db-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// With a 1MB buffer a transaction can only contain a couple of thousand keys
+	db, err := LoadBadgerDB("badger", dir, 1)
+	require.NoError(t, err)
+	defer db.Close()
+	batch := db.NewBatch()
+	for i := 0; i < 10000; i++ {
+		batch.Set(testKey(i), testKey(i))
+	}
+	// The batch should fail as a whole instead of being split across transactions
+	require.Panics(t, batch.Write)
+	require.Nil(t, db.Get(testKey(0)))
+	require.Equal(t, []string{}, iteratorKeys(db.Iterator(nil, nil)))
+}
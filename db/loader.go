@@ -10,6 +10,8 @@ const (
 	GoLevelDBBackend = "goleveldb"
 	CLevelDBBackend  = "cleveldb"
 	MemDBackend      = "memdb"
+	BadgerDBBackend  = "badgerdb"
+	BoltDBBackend    = "boltdb"
 )
 
 type DBWrapper interface {
@@ -37,6 +39,10 @@ func LoadDB(
 		return LoadCLevelDB(name, directory)
 	case MemDBackend:
 		return LoadMemDB()
+	case BadgerDBBackend:
+		return LoadBadgerDB(name, directory, bufferSizeMeg)
+	case BoltDBBackend:
+		return LoadBoltDB(name, directory)
 	default:
 		return nil, fmt.Errorf("unknown db backend: %s", dbBackend)
 	}
@@ -0,0 +1,21 @@
+package db
+
+import (
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+// SnapshotDB returns a snapshot of a DB that wasn't necessarily loaded via LoadDB, e.g. one of the
+// Tendermint DBs. GoLevelDB snapshots are cheap, any other kind of DB is copied into memory, so
+// this should only be used with other kinds of DBs in tests.
+func SnapshotDB(db dbm.DB) Snapshot {
+	switch d := db.(type) {
+	case DBWrapper:
+		return d.GetSnapshot()
+	case *dbm.GoLevelDB:
+		return (&GoLevelDB{GoLevelDB: d}).GetSnapshot()
+	default:
+		memDB := dbm.NewMemDB()
+		CopyDB(db, memDB, 10000, nil)
+		return &MemDB{MemDB: memDB}
+	}
+}
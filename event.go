@@ -13,6 +13,7 @@ import (
 	"github.com/loomnetwork/loomchain/eth/subs"
 	"github.com/loomnetwork/loomchain/log"
 	pubsub "github.com/phonkee/go-pubsub"
+	"github.com/pkg/errors"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 )
 
@@ -20,6 +21,9 @@ type EventData types.EventData
 
 type EventHandler interface {
 	Post(height uint64, e *types.EventData) error
+	// SaveBlockEvents durably records the events emitted in the given block if the event dispatcher
+	// supports it, this must be called before the block is committed.
+	SaveBlockEvents(height uint64, blockTime time.Time) error
 	EmitBlockTx(height uint64, blockTime time.Time) error
 	SubscriptionSet() *SubscriptionSet
 	EthSubscriptionSet() *subs.EthSubscriptionSet
@@ -28,7 +32,16 @@ type EventHandler interface {
 
 type EventDispatcher interface {
 	Send(blockHeight uint64, eventIndex int, msg []byte) error
-	Flush()
+	Flush() error
+}
+
+// DurableEventDispatcher is implemented by event dispatchers that durably record the events
+// emitted in each block before the block is committed, so the events can be delivered at least
+// once even if the node crashes.
+type DurableEventDispatcher interface {
+	EventDispatcher
+	// SaveBlockEvents must not return until the given events have been synced to disk.
+	SaveBlockEvents(blockHeight uint64, msgs [][]byte) error
 }
 
 type DefaultEventHandler struct {
@@ -71,6 +84,28 @@ func (ed *DefaultEventHandler) Post(height uint64, msg *types.EventData) error {
 	return nil
 }
 
+func (ed *DefaultEventHandler) SaveBlockEvents(height uint64, blockTime time.Time) error {
+	dispatcher, ok := ed.dispatcher.(DurableEventDispatcher)
+	if !ok {
+		return nil
+	}
+	msgs, err := ed.stash.fetch(height)
+	if err != nil {
+		return err
+	}
+	timestamp := blockTime.Unix()
+	emitMsgs := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		msg.BlockTime = timestamp
+		emitMsg, err := json.Marshal(&msg)
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal event emitted at height %d", height)
+		}
+		emitMsgs = append(emitMsgs, emitMsg)
+	}
+	return dispatcher.SaveBlockEvents(height, emitMsgs)
+}
+
 func (ed *DefaultEventHandler) EmitBlockTx(height uint64, blockTime time.Time) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -115,7 +150,9 @@ func (ed *DefaultEventHandler) EmitBlockTx(height uint64, blockTime time.Time) (
 			log.Debug("published WS event", "topic", topic)
 		}
 	}
-	ed.dispatcher.Flush()
+	if err := ed.dispatcher.Flush(); err != nil {
+		log.Default.Error("Failed to flush event dispatcher", "err", err, "height", height)
+	}
 	ed.stash.purge(height)
 	return nil
 }
@@ -157,6 +194,17 @@ func (m InstrumentingEventHandler) Post(height uint64, e *types.EventData) (err
 	return
 }
 
+// SaveBlockEvents captures the metrics
+func (m InstrumentingEventHandler) SaveBlockEvents(height uint64, blockTime time.Time) (err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "SaveBlockEvents", "error", fmt.Sprint(err != nil)}
+		m.methodDuration.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	err = m.next.SaveBlockEvents(height, blockTime)
+	return
+}
+
 // EmitBlockTx captures the metrics
 func (m InstrumentingEventHandler) EmitBlockTx(height uint64, blockTime time.Time) (err error) {
 	defer func(begin time.Time) {
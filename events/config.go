@@ -1,24 +1,65 @@
 package events
 
+import "strings"
+
 const (
 	DispatcherDBIndexer = "db_indexer"
 	DispatcherRedis     = "redis"
 	DispatcherLog       = "log"
+	DispatcherWebhook   = "webhook"
 )
 
 type EventStoreConfig struct {
 	// DBName defines database file name
 	DBName string
 	// DBBackend defines backend event store type
-	// available backend types are 'goleveldb', or 'cleveldb'
+	// available backend types are 'goleveldb', 'cleveldb', 'badgerdb', or 'boltdb'
 	DBBackend string
+	// Number of most recent blocks to retain events for, if zero events won't be pruned based on
+	// block height.
+	RetainBlocks uint64
+	// Number of seconds to retain events for, if zero events won't be pruned based on age.
+	RetainSeconds int64
+	// Overrides the retention policy for the events emitted by specific contracts, keyed by the
+	// plugin name of a Go contract, or the chain:0x... address of an EVM contract.
+	ContractRetention map[string]*EventRetentionConfig
+	// Number of seconds to wait between event store pruning cycles
+	PruneInterval int64
+	// Maximum number of events to delete from the event store in a single batch
+	PruneBatchSize int
+}
+
+// EventRetentionConfig specifies how long the events emitted by a contract should be retained for,
+// an event is pruned once it exceeds either limit.
+type EventRetentionConfig struct {
+	// Number of most recent blocks to retain events for, zero means there's no limit.
+	RetainBlocks uint64
+	// Number of seconds to retain events for, zero means there's no limit.
+	RetainSeconds int64
 }
 
 func DefaultEventStoreConfig() *EventStoreConfig {
 	return &EventStoreConfig{
-		DBName:    "events",
-		DBBackend: "goleveldb",
+		DBName:         "events",
+		DBBackend:      "goleveldb",
+		RetainBlocks:   0,
+		RetainSeconds:  0,
+		PruneInterval:  600,
+		PruneBatchSize: 1000,
+	}
+}
+
+// PruningEnabled returns true if events should be pruned from the event store.
+func (c *EventStoreConfig) PruningEnabled() bool {
+	if c.RetainBlocks > 0 || c.RetainSeconds > 0 {
+		return true
+	}
+	for _, retention := range c.ContractRetention {
+		if retention != nil && (retention.RetainBlocks > 0 || retention.RetainSeconds > 0) {
+			return true
+		}
 	}
+	return false
 }
 
 // Clone returns a deep clone of the config.
@@ -27,12 +68,55 @@ func (c *EventStoreConfig) Clone() *EventStoreConfig {
 		return nil
 	}
 	clone := *c
+	if c.ContractRetention != nil {
+		clone.ContractRetention = make(map[string]*EventRetentionConfig, len(c.ContractRetention))
+		for contract, retention := range c.ContractRetention {
+			if retention != nil {
+				r := *retention
+				retention = &r
+			}
+			clone.ContractRetention[contract] = retention
+		}
+	}
 	return &clone
 }
 
 type EventDispatcherConfig struct {
+	// Comma separated list of the dispatchers events should be sent to, e.g. "db_indexer,webhook"
 	Dispatcher string
 	Redis      *RedisEventDispatcherConfig
+	Outbox     *EventOutboxConfig
+	// Endpoints events should be posted to when the webhook dispatcher is enabled.
+	Webhooks []*WebhookConfig
+}
+
+// Dispatchers returns the names of all the dispatchers events should be sent to.
+func (c *EventDispatcherConfig) Dispatchers() []string {
+	var names []string
+	for _, name := range strings.Split(c.Dispatcher, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// EventOutboxConfig contains the settings of the outbox used to deliver events reliably.
+type EventOutboxConfig struct {
+	// If true the events emitted in each block are recorded in the outbox DB before the block is
+	// committed, and are then delivered to the dispatcher in the background. Events that fail to be
+	// delivered are retried until they succeed, even across node restarts.
+	Enabled bool
+	// DBName defines the outbox database file name
+	DBName string
+	// DBBackend defines the backend type of the outbox database
+	// available backend types are 'goleveldb', 'cleveldb', 'badgerdb', or 'boltdb'
+	DBBackend string
+	// Number of seconds to wait before retrying delivery after the first failure, the delay is
+	// doubled after each subsequent failure.
+	MinRetryInterval int64
+	// Maximum number of seconds to wait before retrying delivery.
+	MaxRetryInterval int64
 }
 
 func DefaultEventDispatcherConfig() *EventDispatcherConfig {
@@ -41,6 +125,13 @@ func DefaultEventDispatcherConfig() *EventDispatcherConfig {
 		Redis: &RedisEventDispatcherConfig{
 			URI: "127.0.0.1",
 		},
+		Outbox: &EventOutboxConfig{
+			Enabled:          false,
+			DBName:           "event_outbox",
+			DBBackend:        "goleveldb",
+			MinRetryInterval: 1,
+			MaxRetryInterval: 60,
+		},
 	}
 }
 
@@ -50,6 +141,19 @@ func (c *EventDispatcherConfig) Clone() *EventDispatcherConfig {
 		return nil
 	}
 	clone := *c
-	*clone.Redis = *c.Redis
+	if c.Redis != nil {
+		redis := *c.Redis
+		clone.Redis = &redis
+	}
+	if c.Outbox != nil {
+		outbox := *c.Outbox
+		clone.Outbox = &outbox
+	}
+	if c.Webhooks != nil {
+		clone.Webhooks = make([]*WebhookConfig, len(c.Webhooks))
+		for i, webhook := range c.Webhooks {
+			clone.Webhooks[i] = webhook.Clone()
+		}
+	}
 	return &clone
 }
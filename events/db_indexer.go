@@ -37,7 +37,7 @@ func (ed *DBIndexerEventDispatcher) Send(blockHeight uint64, eventIndex int, msg
 	return nil
 }
 
-func (ed *DBIndexerEventDispatcher) Flush() {
+func (ed *DBIndexerEventDispatcher) Flush() error {
 	var flushEvents []*types.EventData
 	ed.Lock()
 	flushEvents = ed.events
@@ -45,5 +45,7 @@ func (ed *DBIndexerEventDispatcher) Flush() {
 	ed.Unlock()
 	if err := ed.EventStore.BatchSaveEvents(flushEvents); err != nil {
 		log.Printf("Event dispatcher flush error: %s", err)
+		return err
 	}
+	return nil
 }
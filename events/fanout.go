@@ -0,0 +1,59 @@
+package events
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/loomnetwork/loomchain"
+	"github.com/pkg/errors"
+)
+
+// FanOutEventDispatcher sends every event to multiple dispatchers, a dispatcher that fails doesn't
+// prevent events from being sent to the others.
+type FanOutEventDispatcher struct {
+	names       []string
+	dispatchers []loomchain.EventDispatcher
+}
+
+var _ loomchain.EventDispatcher = &FanOutEventDispatcher{}
+
+// NewFanOutEventDispatcher creates a dispatcher that sends events to each of the given dispatchers,
+// which are keyed by name.
+func NewFanOutEventDispatcher(dispatchers map[string]loomchain.EventDispatcher) *FanOutEventDispatcher {
+	d := &FanOutEventDispatcher{}
+	for name := range dispatchers {
+		d.names = append(d.names, name)
+	}
+	sort.Strings(d.names)
+	for _, name := range d.names {
+		d.dispatchers = append(d.dispatchers, dispatchers[name])
+	}
+	return d
+}
+
+func (d *FanOutEventDispatcher) Send(blockHeight uint64, eventIndex int, msg []byte) error {
+	var errs []string
+	for i, dispatcher := range d.dispatchers {
+		if err := dispatcher.Send(blockHeight, eventIndex, msg); err != nil {
+			errs = append(errs, d.names[i]+": "+err.Error())
+		}
+	}
+	return combineDispatcherErrors(errs)
+}
+
+func (d *FanOutEventDispatcher) Flush() error {
+	var errs []string
+	for i, dispatcher := range d.dispatchers {
+		if err := dispatcher.Flush(); err != nil {
+			errs = append(errs, d.names[i]+": "+err.Error())
+		}
+	}
+	return combineDispatcherErrors(errs)
+}
+
+func combineDispatcherErrors(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New("event dispatchers failed: " + strings.Join(errs, "; "))
+}
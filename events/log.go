@@ -22,5 +22,6 @@ func (ed *LogEventDispatcher) Send(index uint64, eventIdex int, msg []byte) erro
 	return nil
 }
 
-func (ed *LogEventDispatcher) Flush() {
+func (ed *LogEventDispatcher) Flush() error {
+	return nil
 }
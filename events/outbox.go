@@ -0,0 +1,168 @@
+package events
+
+import (
+	"sort"
+	"time"
+
+	"github.com/loomnetwork/loomchain"
+	"github.com/loomnetwork/loomchain/log"
+	"github.com/loomnetwork/loomchain/store"
+	"github.com/pkg/errors"
+)
+
+// Max number of blocks loaded from the outbox at a time while a dispatcher is catching up.
+const outboxBatchBlocks = 100
+
+// OutboxEventDispatcher records the events emitted in each block in an outbox before the block is
+// committed, and delivers them to one or more dispatchers in the background. Each dispatcher has its
+// own delivery cursor, so a dispatcher that's unavailable doesn't hold up the others. Events that
+// fail to be delivered are retried with exponential backoff, and any events that weren't delivered
+// before the node shut down are delivered when it restarts, so every event is delivered at least
+// once.
+type OutboxEventDispatcher struct {
+	outbox *store.EventOutbox
+	relays []*outboxRelay
+}
+
+var _ loomchain.DurableEventDispatcher = &OutboxEventDispatcher{}
+
+// NewOutboxEventDispatcher creates a dispatcher that delivers events from the given outbox to each
+// of the given dispatchers, which are keyed by the name of their outbox cursor. Dispatchers that
+// don't have a cursor yet will start with the next block recorded in the outbox. Call Start to
+// begin delivering events.
+func NewOutboxEventDispatcher(
+	outbox *store.EventOutbox, cfg *EventOutboxConfig, dispatchers map[string]loomchain.EventDispatcher,
+) (*OutboxEventDispatcher, error) {
+	if len(dispatchers) == 0 {
+		return nil, errors.New("outbox requires at least one event dispatcher")
+	}
+	if cfg.MinRetryInterval <= 0 || cfg.MaxRetryInterval < cfg.MinRetryInterval {
+		return nil, errors.New("invalid outbox retry intervals")
+	}
+	names := make([]string, 0, len(dispatchers))
+	for name := range dispatchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	d := &OutboxEventDispatcher{outbox: outbox}
+	for _, name := range names {
+		if _, ok := outbox.Cursor(name); !ok {
+			outbox.SetCursor(name, outbox.LastHeight())
+		}
+		d.relays = append(d.relays, &outboxRelay{
+			name:             name,
+			outbox:           outbox,
+			dispatcher:       dispatchers[name],
+			minRetryInterval: time.Duration(cfg.MinRetryInterval) * time.Second,
+			maxRetryInterval: time.Duration(cfg.MaxRetryInterval) * time.Second,
+			notify:           make(chan struct{}, 1),
+		})
+	}
+	return d, nil
+}
+
+// Start starts delivering events to the dispatchers, beginning with any events that weren't
+// delivered before the node was last shut down.
+func (d *OutboxEventDispatcher) Start() {
+	for _, relay := range d.relays {
+		go relay.run()
+	}
+}
+
+// SaveBlockEvents records the events emitted in a block in the outbox.
+func (d *OutboxEventDispatcher) SaveBlockEvents(blockHeight uint64, msgs [][]byte) error {
+	return d.outbox.SaveBlock(blockHeight, msgs)
+}
+
+// Send does nothing, events are delivered from the outbox.
+func (d *OutboxEventDispatcher) Send(blockHeight uint64, eventIndex int, msg []byte) error {
+	return nil
+}
+
+// Flush notifies the dispatchers that the events of another block are ready to be delivered.
+func (d *OutboxEventDispatcher) Flush() error {
+	for _, relay := range d.relays {
+		select {
+		case relay.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// outboxRelay delivers events from the outbox to a single dispatcher.
+type outboxRelay struct {
+	name             string
+	outbox           *store.EventOutbox
+	dispatcher       loomchain.EventDispatcher
+	minRetryInterval time.Duration
+	maxRetryInterval time.Duration
+	notify           chan struct{}
+}
+
+func (r *outboxRelay) run() {
+	retryInterval := r.minRetryInterval
+	for {
+		if err := r.deliverPending(); err != nil {
+			log.Error(
+				"Failed to deliver events from outbox", "dispatcher", r.name, "err", err,
+				"retryIn", retryInterval,
+			)
+			time.Sleep(retryInterval)
+			retryInterval *= 2
+			if retryInterval > r.maxRetryInterval {
+				retryInterval = r.maxRetryInterval
+			}
+			continue
+		}
+		retryInterval = r.minRetryInterval
+		r.outbox.Prune()
+		select {
+		case <-r.notify:
+		case <-time.After(r.maxRetryInterval):
+		}
+	}
+}
+
+// deliverPending delivers all the events recorded in the outbox after the dispatcher's cursor, the
+// cursor is advanced after each block is delivered.
+func (r *outboxRelay) deliverPending() error {
+	// blocks that didn't emit any events aren't stored, so the cursor is advanced to the last
+	// height that was recorded before looking for pending blocks
+	lastHeight := r.outbox.LastHeight()
+	cursor, _ := r.outbox.Cursor(r.name)
+	for {
+		blocks, err := r.outbox.PendingBlocks(cursor, outboxBatchBlocks)
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			if err := r.deliverBlock(block); err != nil {
+				return errors.Wrapf(err, "failed to deliver events emitted at height %d", block.Height)
+			}
+			cursor = block.Height
+			r.outbox.SetCursor(r.name, cursor)
+		}
+		if len(blocks) < outboxBatchBlocks {
+			break
+		}
+	}
+	if lastHeight > cursor {
+		r.outbox.SetCursor(r.name, lastHeight)
+	}
+	return nil
+}
+
+func (r *outboxRelay) deliverBlock(block *store.OutboxBlock) error {
+	for i, msg := range block.Events {
+		if err := r.dispatcher.Send(block.Height, i, msg); err != nil {
+			// Flush whatever the dispatcher buffered so it isn't mixed up with the events sent when
+			// delivery is retried, the whole block will be sent again so dispatchers must be able
+			// to handle events that are delivered more than once.
+			r.dispatcher.Flush()
+			return err
+		}
+	}
+	return r.dispatcher.Flush()
+}
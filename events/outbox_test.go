@@ -0,0 +1,86 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/loomnetwork/loomchain"
+	"github.com/loomnetwork/loomchain/store"
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+type sentEvent struct {
+	height uint64
+	index  int
+	msg    string
+}
+
+// flakyEventDispatcher fails to send events while it's down.
+type flakyEventDispatcher struct {
+	down    bool
+	pending []sentEvent
+	flushed []sentEvent
+}
+
+func (d *flakyEventDispatcher) Send(blockHeight uint64, eventIndex int, msg []byte) error {
+	if d.down {
+		return errors.New("dispatcher is down")
+	}
+	d.pending = append(d.pending, sentEvent{blockHeight, eventIndex, string(msg)})
+	return nil
+}
+
+func (d *flakyEventDispatcher) Flush() error {
+	d.flushed = append(d.flushed, d.pending...)
+	d.pending = nil
+	return nil
+}
+
+func TestOutboxEventDispatcher(t *testing.T) {
+	outbox := store.NewEventOutbox(dbm.NewMemDB())
+	require.NoError(t, outbox.SaveBlock(1, [][]byte{[]byte("a")}))
+
+	up := &flakyEventDispatcher{}
+	down := &flakyEventDispatcher{down: true}
+	dispatcher, err := NewOutboxEventDispatcher(
+		outbox,
+		DefaultEventDispatcherConfig().Outbox,
+		map[string]loomchain.EventDispatcher{"up": up, "down": down},
+	)
+	require.NoError(t, err)
+	// new dispatchers start from the next block recorded in the outbox
+	for _, cursor := range outbox.Cursors() {
+		require.Equal(t, uint64(1), cursor.Height)
+	}
+
+	require.NoError(t, dispatcher.SaveBlockEvents(2, [][]byte{[]byte("b"), []byte("c")}))
+	require.NoError(t, dispatcher.SaveBlockEvents(3, nil))
+	require.NoError(t, dispatcher.SaveBlockEvents(4, [][]byte{[]byte("d")}))
+
+	require.Equal(t, "down", dispatcher.relays[0].name)
+	require.Error(t, dispatcher.relays[0].deliverPending())
+	require.NoError(t, dispatcher.relays[1].deliverPending())
+	require.Equal(t, []sentEvent{{2, 0, "b"}, {2, 1, "c"}, {4, 0, "d"}}, up.flushed)
+
+	height, _ := outbox.Cursor("down")
+	require.Equal(t, uint64(1), height)
+	height, _ = outbox.Cursor("up")
+	require.Equal(t, uint64(4), height)
+	// undelivered events must be retained
+	outbox.Prune()
+	blocks, err := outbox.PendingBlocks(1, 10)
+	require.NoError(t, err)
+	require.Len(t, blocks, 2)
+
+	// the failed block is delivered in full once the dispatcher comes back up
+	down.down = false
+	require.NoError(t, dispatcher.relays[0].deliverPending())
+	require.Equal(t, []sentEvent{{2, 0, "b"}, {2, 1, "c"}, {4, 0, "d"}}, down.flushed)
+	height, _ = outbox.Cursor("down")
+	require.Equal(t, uint64(4), height)
+	outbox.Prune()
+	blocks, err = outbox.PendingBlocks(0, 10)
+	require.NoError(t, err)
+	require.Len(t, blocks, 0)
+}
@@ -41,5 +41,6 @@ func (ed *RedisEventDispatcher) Send(index uint64, eventIndex int, msg []byte) e
 	return nil
 }
 
-func (ed *RedisEventDispatcher) Flush() {
+func (ed *RedisEventDispatcher) Flush() error {
+	return nil
 }
@@ -0,0 +1,237 @@
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	loom "github.com/loomnetwork/go-loom"
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/loomchain"
+	"github.com/pkg/errors"
+)
+
+const (
+	// WebhookSignatureHeader is the header that contains the HMAC-SHA256 signature of the request
+	// body, formatted as sha256=<hex encoded signature>.
+	WebhookSignatureHeader = "X-Loom-Signature"
+	// WebhookBlockHeightHeader is the header that contains the height of the block that emitted the
+	// events in the request.
+	WebhookBlockHeightHeader = "X-Loom-Block-Height"
+)
+
+// WebhookConfig specifies an endpoint the webhook dispatcher should post events to.
+type WebhookConfig struct {
+	// Unique name of the endpoint, identifies the endpoint in logs & outbox cursors.
+	Name string
+	URL  string
+	// Secret used to sign the request body, if empty requests won't be signed.
+	Secret string
+	// Only events emitted by these contracts will be posted, Go contracts are matched by plugin name
+	// and EVM contracts by address, if empty events emitted by any contract will be posted.
+	Contracts []string
+	// Only events with at least one of these topics will be posted, if empty events will be posted
+	// regardless of their topics.
+	Topics []string
+	// Max number of events to post in a single request.
+	BatchSize int
+	// Number of seconds to wait for the endpoint to respond to a request.
+	Timeout int64
+	// Number of times a request is retried before the events are considered undeliverable.
+	MaxRetries int
+	// Number of seconds to wait before retrying a request, the delay is doubled after each retry.
+	RetryInterval int64
+}
+
+func DefaultWebhookConfig() *WebhookConfig {
+	return &WebhookConfig{
+		BatchSize:     100,
+		Timeout:       10,
+		MaxRetries:    3,
+		RetryInterval: 1,
+	}
+}
+
+// Clone returns a deep clone of the config.
+func (c *WebhookConfig) Clone() *WebhookConfig {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	clone.Contracts = append([]string(nil), c.Contracts...)
+	clone.Topics = append([]string(nil), c.Topics...)
+	return &clone
+}
+
+// WebhookPayload is the body of the requests sent by the webhook dispatcher.
+type WebhookPayload struct {
+	BlockHeight uint64            `json:"blockHeight"`
+	Events      []json.RawMessage `json:"events"`
+}
+
+// WebhookEventDispatcher posts batches of events to an HTTP endpoint. The events emitted in a block
+// are buffered until the dispatcher is flushed, and then posted in one or more requests (depending
+// on the batch size). Each request body is signed with HMAC-SHA256 using the endpoint secret, so the
+// receiver can verify the events came from the node.
+type WebhookEventDispatcher struct {
+	cfg       *WebhookConfig
+	client    *http.Client
+	contracts map[string]bool
+	topics    map[string]bool
+	pending   []webhookEvent
+	sync.Mutex
+}
+
+type webhookEvent struct {
+	blockHeight uint64
+	msg         []byte
+}
+
+var _ loomchain.EventDispatcher = &WebhookEventDispatcher{}
+
+func NewWebhookEventDispatcher(cfg *WebhookConfig) (*WebhookEventDispatcher, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook %s has no URL", cfg.Name)
+	}
+	// settings that weren't specified fall back to the defaults
+	cfg = cfg.Clone()
+	defaults := DefaultWebhookConfig()
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = defaults.RetryInterval
+	}
+	d := &WebhookEventDispatcher{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		contracts: map[string]bool{},
+		topics:    map[string]bool{},
+	}
+	for _, contract := range cfg.Contracts {
+		d.contracts[strings.ToLower(contract)] = true
+	}
+	for _, topic := range cfg.Topics {
+		d.topics[topic] = true
+	}
+	return d, nil
+}
+
+// Send buffers the event if it matches the endpoint's filters.
+func (d *WebhookEventDispatcher) Send(blockHeight uint64, eventIndex int, msg []byte) error {
+	var event types.EventData
+	if err := json.Unmarshal(msg, &event); err != nil {
+		return err
+	}
+	if !d.matches(&event) {
+		return nil
+	}
+	d.Lock()
+	d.pending = append(d.pending, webhookEvent{blockHeight: blockHeight, msg: msg})
+	d.Unlock()
+	return nil
+}
+
+func (d *WebhookEventDispatcher) matches(event *types.EventData) bool {
+	if len(d.contracts) > 0 {
+		matched := event.PluginName != "" && d.contracts[strings.ToLower(event.PluginName)]
+		if !matched && event.Address != nil {
+			addr := loom.UnmarshalAddressPB(event.Address)
+			matched = d.contracts[strings.ToLower(addr.String())] ||
+				d.contracts[strings.ToLower(addr.Local.String())]
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(d.topics) > 0 {
+		for _, topic := range event.Topics {
+			if d.topics[topic] {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Flush posts all the buffered events to the endpoint, in batches of events emitted in the same
+// block. Failed requests are retried, if a request still fails after the last retry an error is
+// returned and the events that weren't posted are discarded.
+func (d *WebhookEventDispatcher) Flush() error {
+	d.Lock()
+	events := d.pending
+	d.pending = nil
+	d.Unlock()
+
+	for len(events) > 0 {
+		payload := &WebhookPayload{BlockHeight: events[0].blockHeight}
+		for len(events) > 0 && len(payload.Events) < d.cfg.BatchSize &&
+			events[0].blockHeight == payload.BlockHeight {
+			payload.Events = append(payload.Events, json.RawMessage(events[0].msg))
+			events = events[1:]
+		}
+		if err := d.post(payload); err != nil {
+			return errors.Wrapf(err, "failed to post events to webhook %s", d.cfg.Name)
+		}
+	}
+	return nil
+}
+
+func (d *WebhookEventDispatcher) post(payload *WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	retryInterval := time.Duration(d.cfg.RetryInterval) * time.Second
+	for attempt := 0; ; attempt++ {
+		err = d.postOnce(payload.BlockHeight, body)
+		if err == nil || attempt >= d.cfg.MaxRetries {
+			return err
+		}
+		time.Sleep(retryInterval)
+		retryInterval *= 2
+	}
+}
+
+func (d *WebhookEventDispatcher) postOnce(blockHeight uint64, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, d.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookBlockHeightHeader, fmt.Sprint(blockHeight))
+	if d.cfg.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(d.cfg.Secret, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhookPayload returns the value of the signature header for the given request body.
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
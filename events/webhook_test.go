@@ -0,0 +1,126 @@
+package events
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	loom "github.com/loomnetwork/go-loom"
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/loomchain"
+	"github.com/stretchr/testify/require"
+)
+
+type webhookServer struct {
+	*httptest.Server
+	secret      string
+	numFailures int
+	payloads    []*WebhookPayload
+	sync.Mutex
+}
+
+func newWebhookServer(t *testing.T, secret string) *webhookServer {
+	s := &webhookServer{secret: secret}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		if s.secret == "" {
+			require.Empty(t, r.Header.Get(WebhookSignatureHeader))
+		} else {
+			require.Equal(t, SignWebhookPayload(s.secret, body), r.Header.Get(WebhookSignatureHeader))
+		}
+
+		s.Lock()
+		defer s.Unlock()
+		if s.numFailures > 0 {
+			s.numFailures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload WebhookPayload
+		require.NoError(t, json.Unmarshal(body, &payload))
+		s.payloads = append(s.payloads, &payload)
+	}))
+	return s
+}
+
+func TestWebhookEventDispatcher(t *testing.T) {
+	server := newWebhookServer(t, "secret")
+	defer server.Close()
+
+	evmContract := loom.MustParseAddress("default:0x5cecd1f7261e1f4c684e297be3edf03b825e01c4")
+	dispatcher, err := NewWebhookEventDispatcher(&WebhookConfig{
+		Name:       "test",
+		URL:        server.URL,
+		Secret:     "secret",
+		Contracts:  []string{"coin", evmContract.Local.String()},
+		Topics:     []string{"event:transfer", "event:approval"},
+		BatchSize:  2,
+		MaxRetries: 1,
+	})
+	require.NoError(t, err)
+
+	send := func(height uint64, events ...*types.EventData) {
+		for i, event := range events {
+			msg, err := json.Marshal(event)
+			require.NoError(t, err)
+			require.NoError(t, dispatcher.Send(height, i, msg))
+		}
+	}
+	send(
+		1,
+		&types.EventData{PluginName: "coin", Topics: []string{"event:transfer"}},
+		// filtered out by contract
+		&types.EventData{PluginName: "dposV3", Topics: []string{"event:transfer"}},
+		// filtered out by topic
+		&types.EventData{PluginName: "coin", Topics: []string{"event:mint"}},
+		&types.EventData{Address: evmContract.MarshalPB(), Topics: []string{"event:approval"}},
+		&types.EventData{PluginName: "coin", Topics: []string{"event:mint", "event:approval"}},
+	)
+	require.NoError(t, dispatcher.Flush())
+	require.Len(t, server.payloads, 2)
+	require.Equal(t, uint64(1), server.payloads[0].BlockHeight)
+	require.Len(t, server.payloads[0].Events, 2)
+	require.Len(t, server.payloads[1].Events, 1)
+
+	// failed requests should be retried
+	server.numFailures = 1
+	send(2, &types.EventData{PluginName: "coin", Topics: []string{"event:transfer"}})
+	require.NoError(t, dispatcher.Flush())
+	require.Len(t, server.payloads, 3)
+	require.Equal(t, uint64(2), server.payloads[2].BlockHeight)
+
+	// until the retries run out
+	server.numFailures = 2
+	send(3, &types.EventData{PluginName: "coin", Topics: []string{"event:transfer"}})
+	require.Error(t, dispatcher.Flush())
+	require.Len(t, server.payloads, 3)
+
+	// blocks without matching events shouldn't be posted
+	send(4, &types.EventData{PluginName: "dposV3", Topics: []string{"event:transfer"}})
+	require.NoError(t, dispatcher.Flush())
+	require.Len(t, server.payloads, 3)
+}
+
+func TestFanOutEventDispatcher(t *testing.T) {
+	server := newWebhookServer(t, "")
+	defer server.Close()
+	webhook, err := NewWebhookEventDispatcher(&WebhookConfig{Name: "all", URL: server.URL})
+	require.NoError(t, err)
+	down := &flakyEventDispatcher{down: true}
+	dispatcher := NewFanOutEventDispatcher(map[string]loomchain.EventDispatcher{
+		"webhook:all": webhook,
+		"down":        down,
+	})
+
+	msg, err := json.Marshal(&types.EventData{PluginName: "coin"})
+	require.NoError(t, err)
+	// a failed dispatcher shouldn't prevent the event from being sent to the rest
+	require.Error(t, dispatcher.Send(1, 0, msg))
+	require.NoError(t, dispatcher.Flush())
+	require.Len(t, server.payloads, 1)
+	require.Len(t, server.payloads[0].Events, 1)
+}
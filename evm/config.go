@@ -4,7 +4,7 @@ type EvmStoreConfig struct {
 	// DBName defines database file name
 	DBName string
 	// DBBackend defines backend EVM store type
-	// available backend types are 'goleveldb', or 'cleveldb'
+	// available backend types are 'goleveldb', 'cleveldb', 'badgerdb', or 'boltdb'
 	DBBackend string
 	// CacheSizeMegs defines cache size (in megabytes) of EVM store
 	CacheSizeMegs int
@@ -12,6 +12,13 @@ type EvmStoreConfig struct {
 	WriteBufferMegs int
 	// NumCachedRoots defines a number of in-memory cached EVM roots
 	NumCachedRoots int
+	// If true EVM state that's no longer reachable from the versions retained by the app store
+	// (see AppStore.MaxVersions) will be periodically deleted from the EVM store.
+	PruningEnabled bool
+	// Number of seconds to wait between EVM store pruning cycles
+	PruneInterval int64
+	// Maximum number of stale keys to delete from the EVM store in a single batch
+	PruneBatchSize int
 }
 
 func DefaultEvmStoreConfig() *EvmStoreConfig {
@@ -21,6 +28,9 @@ func DefaultEvmStoreConfig() *EvmStoreConfig {
 		CacheSizeMegs:   256,
 		WriteBufferMegs: 4,
 		NumCachedRoots:  100,
+		PruningEnabled:  false,
+		PruneInterval:   3600,
+		PruneBatchSize:  10000,
 	}
 }
 
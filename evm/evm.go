@@ -145,6 +145,7 @@ type Evm struct {
 	chainConfig     params.ChainConfig
 	vmConfig        vm.Config
 	validateTxValue bool
+	precompiles     *precompileEnv
 }
 
 func NewEvm(sdb vm.StateDB, lstate loomchain.State, abm *evmAccountBalanceManager, debug bool) *Evm {
@@ -155,12 +156,14 @@ func NewEvm(sdb vm.StateDB, lstate loomchain.State, abm *evmAccountBalanceManage
 
 	p.vmConfig = defaultVmConfig(debug)
 	p.validateTxValue = lstate.FeatureEnabled(features.CheckTxValueFeature, false)
+	p.precompiles = &precompileEnv{
+		state:   lstate,
+		enabled: lstate.FeatureEnabled(features.EvmLoomPrecompilesFeature, false),
+	}
 	p.context = vm.Context{
 		CanTransfer: core.CanTransfer,
 		Transfer:    core.Transfer,
-		GetHash: func(n uint64) common.Hash {
-			return common.BytesToHash(crypto.Keccak256([]byte(new(big.Int).SetUint64(n).String())))
-		},
+		GetHash:     blockHashFn(lstate),
 		Coinbase:    common.BytesToAddress([]byte("myCoinBase")),
 		BlockNumber: big.NewInt(lstate.Block().Height),
 		Time:        big.NewInt(lstate.Block().Time),
@@ -179,7 +182,8 @@ func NewEvm(sdb vm.StateDB, lstate loomchain.State, abm *evmAccountBalanceManage
 	return p
 }
 
-func (e Evm) Create(caller loom.Address, code []byte, value *loom.BigUInt) ([]byte, loom.Address, error) {
+// Create deploys a contract, and returns the amount of gas consumed by the deployment.
+func (e Evm) Create(caller loom.Address, code []byte, value *loom.BigUInt) ([]byte, loom.Address, uint64, error) {
 	var err error
 	var usedGas uint64
 	defer func(begin time.Time) {
@@ -189,8 +193,19 @@ func (e Evm) Create(caller loom.Address, code []byte, value *loom.BigUInt) ([]by
 		txGas.With(lvs...).Observe(float64(usedGas))
 
 	}(time.Now())
+	var runCode []byte
+	var loomAddress loom.Address
+	runCode, loomAddress, usedGas, err = e.create(caller, code, value, gasLimit)
+	return runCode, loomAddress, usedGas, err
+}
+
+// create deploys a contract using the given gas limit, and returns the amount of gas consumed by
+// the deployment.
+func (e Evm) create(
+	caller loom.Address, code []byte, value *loom.BigUInt, gas uint64,
+) ([]byte, loom.Address, uint64, error) {
 	origin := common.BytesToAddress(caller.Local)
-	vmenv := e.NewEnv(origin)
+	vmenv := e.newEVM(origin)
 
 	var val *big.Int
 	if value == nil {
@@ -198,19 +213,19 @@ func (e Evm) Create(caller loom.Address, code []byte, value *loom.BigUInt) ([]by
 	} else {
 		val = value.Int
 		if e.validateTxValue && val.Cmp(common.Big0) < 0 {
-			return nil, loom.Address{}, errors.Errorf("value %v must be non negative", value)
+			return nil, loom.Address{}, 0, errors.Errorf("value %v must be non negative", value)
 		}
 	}
-	runCode, address, leftOverGas, err := vmenv.Create(vm.AccountRef(origin), code, gasLimit, val)
-	usedGas = gasLimit - leftOverGas
+	runCode, address, leftOverGas, err := vmenv.Create(vm.AccountRef(origin), code, gas, val)
 	loomAddress := loom.Address{
 		ChainID: caller.ChainID,
 		Local:   address.Bytes(),
 	}
-	return runCode, loomAddress, err
+	return runCode, loomAddress, gas - leftOverGas, err
 }
 
-func (e Evm) Call(caller, addr loom.Address, input []byte, value *loom.BigUInt) ([]byte, error) {
+// Call executes a contract call, and returns the amount of gas consumed by the call.
+func (e Evm) Call(caller, addr loom.Address, input []byte, value *loom.BigUInt) ([]byte, uint64, error) {
 	var err error
 	var usedGas uint64
 	defer func(begin time.Time) {
@@ -220,9 +235,19 @@ func (e Evm) Call(caller, addr loom.Address, input []byte, value *loom.BigUInt)
 		txLatency.With(lvs...).Observe(time.Since(begin).Seconds())
 
 	}(time.Now())
+	var ret []byte
+	ret, usedGas, err = e.call(caller, addr, input, value, gasLimit)
+	return ret, usedGas, err
+}
+
+// call executes a contract call using the given gas limit, and returns the amount of gas consumed
+// by the call.
+func (e Evm) call(
+	caller, addr loom.Address, input []byte, value *loom.BigUInt, gas uint64,
+) ([]byte, uint64, error) {
 	origin := common.BytesToAddress(caller.Local)
 	contract := common.BytesToAddress(addr.Local)
-	vmenv := e.NewEnv(origin)
+	vmenv := e.newEVM(origin)
 
 	var val *big.Int
 	if value == nil {
@@ -234,18 +259,17 @@ func (e Evm) Call(caller, addr loom.Address, input []byte, value *loom.BigUInt)
 			val = common.Big0
 		}
 		if e.validateTxValue && val.Cmp(common.Big0) < 0 {
-			return nil, errors.Errorf("value %v must be non negative", value)
+			return nil, 0, errors.Errorf("value %v must be non negative", value)
 		}
 	}
-	ret, leftOverGas, err := vmenv.Call(vm.AccountRef(origin), contract, input, gasLimit, val)
-	usedGas = gasLimit - leftOverGas
-	return ret, err
+	ret, leftOverGas, err := vmenv.Call(vm.AccountRef(origin), contract, input, gas, val)
+	return ret, gas - leftOverGas, err
 }
 
 func (e Evm) StaticCall(caller, addr loom.Address, input []byte) ([]byte, error) {
 	origin := common.BytesToAddress(caller.Local)
 	contract := common.BytesToAddress(addr.Local)
-	vmenv := e.NewEnv(origin)
+	vmenv := e.newEVM(origin)
 	ret, _, err := vmenv.StaticCall(vm.AccountRef(origin), contract, input, gasLimit)
 	return ret, err
 }
@@ -254,10 +278,15 @@ func (e Evm) GetCode(addr loom.Address) []byte {
 	return e.sdb.GetCode(common.BytesToAddress(addr.Local))
 }
 
-// TODO: this doesn't need to be exported, rename to newEVM
-func (e Evm) NewEnv(origin common.Address) *vm.EVM {
+// newEVM creates a Geth EVM, the Loom precompiles are only available to it while the
+// evm:loom-precompiles feature is enabled.
+func (e Evm) newEVM(origin common.Address) *vm.EVM {
 	e.context.Origin = origin
-	return vm.NewEVM(e.context, e.sdb, &e.chainConfig, e.vmConfig)
+	cfg := e.vmConfig
+	if e.precompiles.enabled {
+		cfg.Precompile = e.precompiles.precompile
+	}
+	return vm.NewEVM(e.context, e.sdb, &e.chainConfig, cfg)
 }
 
 func defaultChainConfig(enableConstantinople bool) params.ChainConfig {
@@ -326,13 +355,29 @@ func defaultVmConfig(evmDebuggingEnabled bool) vm.Config {
 	}
 }
 
+// legacyBlockHash is used by the BLOCKHASH opcode until the evm:blockhash feature is enabled,
+// it returns a fake hash derived from the block number.
+func legacyBlockHash(n uint64) common.Hash {
+	return common.BytesToHash(crypto.Keccak256([]byte(new(big.Int).SetUint64(n).String())))
+}
+
+// blockHashFn returns the function the BLOCKHASH opcode should use to look up the hashes of recent
+// blocks. Once the evm:blockhash feature is enabled the hashes of the last 256 blocks are looked up
+// in the app state, the hashes of blocks committed before the feature was enabled are zero.
+func blockHashFn(state loomchain.State) vm.GetHashFunc {
+	if !state.FeatureEnabled(features.EvmBlockHashFeature, false) {
+		return legacyBlockHash
+	}
+	return func(n uint64) common.Hash {
+		return common.BytesToHash(loomchain.EVMBlockHash(state, n))
+	}
+}
+
 func defaultContext() vm.Context {
 	return vm.Context{
 		CanTransfer: core.CanTransfer,
 		Transfer:    core.Transfer,
-		GetHash: func(n uint64) common.Hash {
-			return common.BytesToHash(crypto.Keccak256([]byte(new(big.Int).SetUint64(n).String())))
-		},
+		GetHash:     legacyBlockHash,
 		Coinbase:    common.BytesToAddress([]byte("myCoinBase")),
 		BlockNumber: new(big.Int),
 		Time:        big.NewInt(time.Now().Unix()),
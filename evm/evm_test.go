@@ -16,9 +16,14 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	ethvm "github.com/ethereum/go-ethereum/core/vm"
+	"github.com/gogo/protobuf/proto"
 	"github.com/loomnetwork/go-loom"
+	amtypes "github.com/loomnetwork/go-loom/builtin/types/address_mapper"
+	ctypes "github.com/loomnetwork/go-loom/builtin/types/coin"
+	"github.com/loomnetwork/go-loom/types"
 	"github.com/loomnetwork/loomchain"
 	"github.com/loomnetwork/loomchain/features"
+	"github.com/loomnetwork/loomchain/registry"
 	"github.com/loomnetwork/loomchain/store"
 	lvm "github.com/loomnetwork/loomchain/vm"
 	"github.com/stretchr/testify/require"
@@ -26,8 +31,7 @@ import (
 )
 
 const (
-	BlockHeight        = int64(34)
-	numLoomPreCompiles = 2
+	BlockHeight = int64(34)
 )
 
 var (
@@ -129,12 +133,10 @@ func TestPrecompilesAssembly(t *testing.T) {
 	vm, _ := manager.InitVM(lvm.VMType_EVM, state)
 	abiPc, pcAddr := deploySolContract(t, caller, "CallPrecompiles", vm)
 
-	numEthPreCompiles := len(ethvm.PrecompiledContractsByzantium)
 	AddLoomPrecompiles()
-	require.Equal(t, numEthPreCompiles+numLoomPreCompiles, len(ethvm.PrecompiledContractsByzantium))
 
 	msg := []byte("TestInput")
-	input, err := abiPc.Pack("callPFAssembly", uint64(numEthPreCompiles+1), &msg)
+	input, err := abiPc.Pack("callPFAssembly", uint64(9), &msg)
 	require.NoError(t, err, "packing parameters")
 	ret, err := vm.StaticCall(caller, pcAddr, input)
 	require.NoError(t, err, "callPFAssembly method on CallPrecompiles")
@@ -143,7 +145,7 @@ func TestPrecompilesAssembly(t *testing.T) {
 	actual := ret[:len(expected)]
 	require.Equal(t, 0, bytes.Compare(expected, actual))
 
-	input, err = abiPc.Pack("callPFAssembly", uint64(numEthPreCompiles+2), &msg)
+	input, err = abiPc.Pack("callPFAssembly", uint64(10), &msg)
 	require.NoError(t, err, "packing parameters")
 	ret, err = vm.StaticCall(caller, pcAddr, input)
 	require.NoError(t, err, "callPFAssembly method on CallPrecompiles")
@@ -153,6 +155,87 @@ func TestPrecompilesAssembly(t *testing.T) {
 	require.Equal(t, 0, bytes.Compare(expected, actual))
 }
 
+// mockGoContracts implements GoContractCaller, the ethcoin balance of every account is 42, and only
+// eth accounts are mapped.
+type mockGoContracts struct {
+	callers []loom.Address
+}
+
+func (m *mockGoContracts) Resolve(name string) (loom.Address, error) {
+	if name == "missing" {
+		return loom.Address{}, registry.ErrNotFound
+	}
+	return loom.Address{ChainID: "default", Local: common.BytesToAddress([]byte(name)).Bytes()}, nil
+}
+
+func (m *mockGoContracts) StaticCallMethod(
+	caller, addr loom.Address, method string, req, resp proto.Message,
+) error {
+	m.callers = append(m.callers, caller)
+	switch r := resp.(type) {
+	case *ctypes.BalanceOfResponse:
+		r.Balance = &types.BigUInt{Value: *loom.NewBigUIntFromInt(42)}
+	case *amtypes.AddressMapperHasMappingResponse:
+		r.HasMapping = req.(*amtypes.AddressMapperHasMappingRequest).From.ChainId == "eth"
+	case *amtypes.AddressMapperGetMappingResponse:
+		r.To = loom.MustParseAddress("default:0x0000000000000000000000000000000000001234").MarshalPB()
+	}
+	return nil
+}
+
+func TestLoomContractsPrecompile(t *testing.T) {
+	caller := loom.MustParseAddress("chain:0xb16a379ec18d4093666f8f38b11a3071c920207d")
+	state := mockState()
+	goContracts := &mockGoContracts{}
+	vm := NewLoomVm(state, nil, nil, nil, goContracts, false)
+	// CALLDATACOPY(0, 0, CALLDATASIZE), STATICCALL(GAS, <precompile>, 0, CALLDATASIZE, 0x40, 32),
+	// RETURN(0x40, 32)
+	runtime := []byte{0x36, 0x60, 0x00, 0x60, 0x00, 0x37, 0x60, 0x20, 0x60, 0x40, 0x36, 0x60, 0x00, 0x73}
+	runtime = append(runtime, LoomContractsAddress.Bytes()...)
+	runtime = append(runtime, 0x5a, 0xfa, 0x50, 0x60, 0x20, 0x60, 0x40, 0xf3)
+	_, addr, err := vm.Create(caller, deployCode(runtime), nil)
+	require.NoError(t, err)
+
+	input, err := loomContractsABI.Pack("ethCoinBalanceOf", common.HexToAddress("0x99"))
+	require.NoError(t, err)
+	// the precompile doesn't exist until the feature is enabled
+	ret, err := vm.StaticCall(caller, addr, input)
+	require.NoError(t, err)
+	require.Equal(t, common.Hash{}.Bytes(), ret)
+	require.Len(t, goContracts.callers, 0)
+
+	state.SetFeature(features.EvmLoomPrecompilesFeature, true)
+	ret, err = vm.StaticCall(caller, addr, input)
+	require.NoError(t, err)
+	require.Equal(t, common.BigToHash(big.NewInt(42)).Bytes(), ret)
+	// the Go contract should be called by the EVM contract
+	require.Equal(t, []loom.Address{{ChainID: state.Block().ChainID, Local: addr.Local}}, goContracts.callers)
+
+	input, err = loomContractsABI.Pack("resolve", "coin")
+	require.NoError(t, err)
+	ret, err = vm.StaticCall(caller, addr, input)
+	require.NoError(t, err)
+	require.Equal(t, common.BytesToHash([]byte("coin")).Bytes(), ret)
+
+	input, err = loomContractsABI.Pack("resolve", "missing")
+	require.NoError(t, err)
+	ret, err = vm.StaticCall(caller, addr, input)
+	require.NoError(t, err)
+	require.Equal(t, common.Hash{}.Bytes(), ret)
+
+	input, err = loomContractsABI.Pack("mappedAccount", "eth", common.HexToAddress("0x99"))
+	require.NoError(t, err)
+	ret, err = vm.StaticCall(caller, addr, input)
+	require.NoError(t, err)
+	require.Equal(t, common.HexToHash("0x1234").Bytes(), ret)
+
+	input, err = loomContractsABI.Pack("mappedAccount", "tron", common.HexToAddress("0x99"))
+	require.NoError(t, err)
+	ret, err = vm.StaticCall(caller, addr, input)
+	require.NoError(t, err)
+	require.Equal(t, common.Hash{}.Bytes(), ret)
+}
+
 func TestValue(t *testing.T) {
 	const negativeNumber = -34
 	const positiveNumber = 24
@@ -223,6 +306,38 @@ func TestGlobals(t *testing.T) {
 	testMsgValue(t, abiGP, caller, gPAddr, vm)
 }
 
+func TestBlockHash(t *testing.T) {
+	caller := loom.MustParseAddress("chain:0xb16a379ec18d4093666f8f38b11a3071c920207d")
+	state := mockState()
+	vm := NewLoomVm(state, nil, nil, nil, nil, false)
+	// PUSH1 0, CALLDATALOAD, BLOCKHASH, PUSH1 0, MSTORE, PUSH1 32, PUSH1 0, RETURN
+	runtime := []byte{0x60, 0x00, 0x35, 0x40, 0x60, 0x00, 0x52, 0x60, 0x20, 0x60, 0x00, 0xf3}
+	_, addr, err := vm.Create(caller, deployCode(runtime), nil)
+	require.NoError(t, err)
+	blockHash := func(height int64) []byte {
+		res, err := vm.StaticCall(caller, addr, common.BigToHash(big.NewInt(height)).Bytes())
+		require.NoError(t, err)
+		return res
+	}
+
+	hash := []byte("01234567890123456789")
+	loomchain.SetEVMBlockHash(state, uint64(BlockHeight-1), hash)
+	require.Equal(t, legacyBlockHash(uint64(BlockHeight-1)).Bytes(), blockHash(BlockHeight-1))
+
+	state.SetFeature(features.EvmBlockHashFeature, true)
+	require.Equal(t, common.BytesToHash(hash).Bytes(), blockHash(BlockHeight-1))
+	// hashes that weren't stored are zero
+	require.Equal(t, common.Hash{}.Bytes(), blockHash(BlockHeight-2))
+	// the hash of the current block isn't available yet
+	loomchain.SetEVMBlockHash(state, uint64(BlockHeight), hash)
+	require.Equal(t, common.Hash{}.Bytes(), blockHash(BlockHeight))
+
+	// only the last 256 hashes are kept
+	loomchain.SetEVMBlockHash(state, uint64(BlockHeight-1+256), []byte("abcd"))
+	require.Nil(t, loomchain.EVMBlockHash(state, uint64(BlockHeight-1)))
+	require.Equal(t, []byte("abcd"), loomchain.EVMBlockHash(state, uint64(BlockHeight-1+256)))
+}
+
 func testMsgValue(t *testing.T, abiGP abi.ABI, caller, gPAddr loom.Address, vm lvm.VM) {
 	input, err := abiGP.Pack("msgValue")
 	require.NoError(t, err, "packing parameters")
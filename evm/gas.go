@@ -0,0 +1,117 @@
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/loomnetwork/go-loom"
+)
+
+// Selector of the Error(string) function, Solidity ABI encodes revert reasons as calls to it.
+var revertReasonSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+
+// GasEstimator is implemented by VMs that can execute a tx without persisting any changes to the
+// EVM state, which makes it possible to figure out how much gas a tx will need before it's sent.
+type GasEstimator interface {
+	// DryRun executes a call to the given contract using the given gas limit, if the contract
+	// address is empty the input is treated as contract byte-code and deployed instead.
+	// Returns the amount of gas consumed, and the data returned by the EVM (which may be
+	// the revert data if the tx fails).
+	DryRun(caller, addr loom.Address, input []byte, value *loom.BigUInt, gas uint64) (uint64, []byte, error)
+}
+
+// DryRunFunc executes a tx with the given gas limit, and returns the amount of gas consumed,
+// along with the data returned by the EVM.
+type DryRunFunc func(gas uint64) (uint64, []byte, error)
+
+// ExecutionError is returned by EstimateGas when a tx fails no matter how much gas it's given.
+type ExecutionError struct {
+	Err error
+	// Data returned by the EVM, e.g. the ABI encoded reason passed to revert()
+	Data []byte
+}
+
+func (e *ExecutionError) Error() string {
+	if reason, ok := UnpackRevertReason(e.Data); ok {
+		return fmt.Sprintf("%v: %s", e.Err, reason)
+	}
+	return e.Err.Error()
+}
+
+// EstimateGas returns the smallest gas limit, up to maxGas, with which the given tx succeeds.
+// The gas consumed by a tx may be lower than the gas limit it needs to succeed (e.g. due to the
+// 63/64 rule for nested calls), so the tx is first executed with maxGas, and then the gas limit
+// is narrowed down with a binary search that starts from the amount of gas consumed.
+func EstimateGas(run DryRunFunc, maxGas uint64) (uint64, error) {
+	usedGas, ret, err := run(maxGas)
+	if err != nil {
+		return 0, &ExecutionError{Err: err, Data: ret}
+	}
+
+	// find a gas limit that's high enough, in most cases the gas consumed will suffice
+	hi := usedGas
+	for {
+		usedGas, ret, err = run(hi)
+		if err == nil {
+			break
+		}
+		if hi >= maxGas {
+			return 0, &ExecutionError{Err: err, Data: ret}
+		}
+		if hi > maxGas/2 {
+			hi = maxGas
+		} else if hi == 0 {
+			hi = 1
+		} else {
+			hi *= 2
+		}
+	}
+
+	// the smallest gas limit that succeeds is somewhere in (lo, hi]
+	lo := uint64(0)
+	if usedGas > 0 {
+		lo = usedGas - 1
+	}
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		if _, _, err := run(mid); err != nil {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, nil
+}
+
+// UnpackRevertReason extracts the reason string from revert data returned by the EVM, the second
+// return value will be false if the data doesn't contain an ABI encoded Error(string).
+func UnpackRevertReason(data []byte) (string, bool) {
+	if len(data) < 4+64 || !bytes.Equal(data[:4], revertReasonSelector) {
+		return "", false
+	}
+	data = data[4:]
+	offset, ok := abiUint(data[:32])
+	if !ok || offset > uint64(len(data)-32) {
+		return "", false
+	}
+	size, ok := abiUint(data[offset : offset+32])
+	if !ok || size > uint64(len(data))-offset-32 {
+		return "", false
+	}
+	start := offset + 32
+	return string(data[start : start+size]), true
+}
+
+// abiUint decodes a 32-byte ABI encoded uint, the second return value will be false if the value
+// is too large to be a valid offset or length.
+func abiUint(word []byte) (uint64, bool) {
+	for _, b := range word[:24] {
+		if b != 0 {
+			return 0, false
+		}
+	}
+	v := binary.BigEndian.Uint64(word[24:])
+	return v, v <= math.MaxInt32
+}
@@ -0,0 +1,72 @@
+package evm
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateGas(t *testing.T) {
+	errOutOfGas := errors.New("out of gas")
+	// tx consumes 21000 gas, but needs a gas limit of at least 21500 to succeed
+	run := func(gas uint64) (uint64, []byte, error) {
+		if gas < 21500 {
+			return gas, nil, errOutOfGas
+		}
+		return 21000, nil, nil
+	}
+	gas, err := EstimateGas(run, math.MaxUint64)
+	require.NoError(t, err)
+	require.Equal(t, uint64(21500), gas)
+
+	// tx that needs exactly the amount of gas it consumes
+	run = func(gas uint64) (uint64, []byte, error) {
+		if gas < 30000 {
+			return gas, nil, errOutOfGas
+		}
+		return 30000, nil, nil
+	}
+	gas, err = EstimateGas(run, math.MaxUint64)
+	require.NoError(t, err)
+	require.Equal(t, uint64(30000), gas)
+
+	// tx that always reverts
+	errReverted := errors.New("evm: execution reverted")
+	revertData, err := hex.DecodeString(
+		"08c379a0" +
+			"0000000000000000000000000000000000000000000000000000000000000020" +
+			"000000000000000000000000000000000000000000000000000000000000000b" +
+			"6e6f7420616c6c6f776564000000000000000000000000000000000000000000",
+	)
+	require.NoError(t, err)
+	run = func(gas uint64) (uint64, []byte, error) {
+		return 100, revertData, errReverted
+	}
+	_, err = EstimateGas(run, math.MaxUint64)
+	require.Error(t, err)
+	execErr, ok := err.(*ExecutionError)
+	require.True(t, ok)
+	require.Equal(t, revertData, execErr.Data)
+	require.Equal(t, "evm: execution reverted: not allowed", execErr.Error())
+}
+
+func TestUnpackRevertReason(t *testing.T) {
+	_, ok := UnpackRevertReason(nil)
+	require.False(t, ok)
+	_, ok = UnpackRevertReason([]byte{0x08, 0xc3, 0x79, 0xa0})
+	require.False(t, ok)
+
+	// length runs past the end of the data
+	data, err := hex.DecodeString(
+		"08c379a0" +
+			"0000000000000000000000000000000000000000000000000000000000000020" +
+			"00000000000000000000000000000000000000000000000000000000000000ff" +
+			"6e6f7420616c6c6f776564000000000000000000000000000000000000000000",
+	)
+	require.NoError(t, err)
+	_, ok = UnpackRevertReason(data)
+	require.False(t, ok)
+}
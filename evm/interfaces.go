@@ -1,6 +1,7 @@
 package evm
 
 import (
+	"github.com/gogo/protobuf/proto"
 	"github.com/loomnetwork/go-loom"
 )
 
@@ -14,3 +15,13 @@ type AccountBalanceManager interface {
 }
 
 type AccountBalanceManagerFactoryFunc func(readOnly bool) AccountBalanceManager
+
+// GoContractCaller can be implemented to allow EVM contracts to call builtin Go contracts via the
+// Loom precompiles.
+type GoContractCaller interface {
+	// Resolve looks up the address of the Go contract registered under the given name.
+	Resolve(name string) (loom.Address, error)
+	// StaticCallMethod calls a read-only method of the Go contract at the given address, the
+	// caller is the sender of the call.
+	StaticCallMethod(caller, addr loom.Address, method string, req, resp proto.Message) error
+}
@@ -4,6 +4,7 @@ package evm
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/state"
@@ -15,7 +16,9 @@ import (
 	ptypes "github.com/loomnetwork/go-loom/plugin/types"
 	"github.com/loomnetwork/loomchain"
 	"github.com/loomnetwork/loomchain/events"
+	"github.com/loomnetwork/loomchain/log"
 	"github.com/loomnetwork/loomchain/receipts"
+	rcommon "github.com/loomnetwork/loomchain/receipts/common"
 	"github.com/loomnetwork/loomchain/receipts/handler"
 	"github.com/loomnetwork/loomchain/vm"
 	"github.com/pkg/errors"
@@ -106,7 +109,7 @@ var LoomVmFactory = func(state loomchain.State) (vm.VM, error) {
 		nil,
 	)
 	receiptHandler := receiptHandlerProvider.Writer()
-	return NewLoomVm(state, eventHandler, receiptHandler, nil, debug), nil
+	return NewLoomVm(state, eventHandler, receiptHandler, nil, nil, debug), nil
 }
 
 // LoomVm implements the loomchain/vm.VM interface using the EVM.
@@ -115,6 +118,7 @@ type LoomVm struct {
 	state          loomchain.State
 	receiptHandler loomchain.WriteReceiptHandler
 	createABM      AccountBalanceManagerFactoryFunc
+	goContracts    GoContractCaller
 	debug          bool
 }
 
@@ -123,16 +127,30 @@ func NewLoomVm(
 	eventHandler loomchain.EventHandler,
 	receiptHandler loomchain.WriteReceiptHandler,
 	createABM AccountBalanceManagerFactoryFunc,
+	goContracts GoContractCaller,
 	debug bool,
 ) vm.VM {
 	return &LoomVm{
 		state:          loomState,
 		receiptHandler: receiptHandler,
 		createABM:      createABM,
+		goContracts:    goContracts,
 		debug:          debug,
 	}
 }
 
+// newLoomEvm creates an EVM that operates on the state of the VM.
+func (lvm LoomVm) newLoomEvm(
+	accountBalanceManager AccountBalanceManager, logContext *ethdbLogContext, debug bool,
+) (*LoomEvm, error) {
+	levm, err := NewLoomEvm(lvm.state, accountBalanceManager, logContext, debug)
+	if err != nil {
+		return nil, err
+	}
+	levm.precompiles.goContracts = lvm.goContracts
+	return levm, nil
+}
+
 func (lvm LoomVm) accountBalanceManager(readOnly bool) AccountBalanceManager {
 	if lvm.createABM == nil {
 		return nil
@@ -146,11 +164,15 @@ func (lvm LoomVm) Create(caller loom.Address, code []byte, value *loom.BigUInt)
 		contractAddr: loom.Address{},
 		callerAddr:   caller,
 	}
-	levm, err := NewLoomEvm(lvm.state, lvm.accountBalanceManager(false), logContext, lvm.debug)
+	levm, err := lvm.newLoomEvm(lvm.accountBalanceManager(false), logContext, lvm.debug)
 	if err != nil {
 		return nil, loom.Address{}, err
 	}
-	bytecode, addr, err := levm.Create(caller, code, value)
+	tracer := lvm.newInternalTxTracer()
+	if tracer != nil {
+		levm.vmConfig = tracingVmConfig(tracer)
+	}
+	bytecode, addr, usedGas, err := levm.Create(caller, code, value)
 	if err == nil {
 		_, err = levm.Commit()
 	}
@@ -165,9 +187,13 @@ func (lvm LoomVm) Create(caller loom.Address, code []byte, value *loom.BigUInt)
 		}
 
 		var errSaveReceipt error
-		txHash, errSaveReceipt = lvm.receiptHandler.CacheReceipt(lvm.state, caller, addr, events, err)
+		txHash, errSaveReceipt = lvm.receiptHandler.CacheReceipt(
+			lvm.state, caller, addr, events, newEvmTxError(err, usedGas, bytecode),
+		)
 		if errSaveReceipt != nil {
 			err = errors.Wrapf(err, "trouble saving receipt %v", errSaveReceipt)
+		} else if tracer != nil {
+			lvm.cacheInternalTxs(tracer, usedGas, bytecode, err)
 		}
 	}
 
@@ -191,11 +217,15 @@ func (lvm LoomVm) Call(caller, addr loom.Address, input []byte, value *loom.BigU
 		contractAddr: addr,
 		callerAddr:   caller,
 	}
-	levm, err := NewLoomEvm(lvm.state, lvm.accountBalanceManager(false), logContext, lvm.debug)
+	levm, err := lvm.newLoomEvm(lvm.accountBalanceManager(false), logContext, lvm.debug)
 	if err != nil {
 		return nil, err
 	}
-	_, err = levm.Call(caller, addr, input, value)
+	tracer := lvm.newInternalTxTracer()
+	if tracer != nil {
+		levm.vmConfig = tracingVmConfig(tracer)
+	}
+	ret, usedGas, err := levm.Call(caller, addr, input, value)
 	if err == nil {
 		_, err = levm.Commit()
 	}
@@ -210,16 +240,20 @@ func (lvm LoomVm) Call(caller, addr loom.Address, input []byte, value *loom.BigU
 		}
 
 		var errSaveReceipt error
-		txHash, errSaveReceipt = lvm.receiptHandler.CacheReceipt(lvm.state, caller, addr, events, err)
+		txHash, errSaveReceipt = lvm.receiptHandler.CacheReceipt(
+			lvm.state, caller, addr, events, newEvmTxError(err, usedGas, ret),
+		)
 		if errSaveReceipt != nil {
 			err = errors.Wrapf(err, "trouble saving receipt %v", errSaveReceipt)
+		} else if tracer != nil {
+			lvm.cacheInternalTxs(tracer, usedGas, ret, err)
 		}
 	}
 	return txHash, err
 }
 
 func (lvm LoomVm) StaticCall(caller, addr loom.Address, input []byte) ([]byte, error) {
-	levm, err := NewLoomEvm(lvm.state, lvm.accountBalanceManager(true), nil, lvm.debug)
+	levm, err := lvm.newLoomEvm(lvm.accountBalanceManager(true), nil, lvm.debug)
 	if err != nil {
 		return nil, err
 	}
@@ -227,9 +261,150 @@ func (lvm LoomVm) StaticCall(caller, addr loom.Address, input []byte) ([]byte, e
 }
 
 func (lvm LoomVm) GetCode(addr loom.Address) ([]byte, error) {
-	levm, err := NewLoomEvm(lvm.state, nil, nil, lvm.debug)
+	levm, err := lvm.newLoomEvm(nil, nil, lvm.debug)
 	if err != nil {
 		return nil, err
 	}
 	return levm.GetCode(addr), nil
 }
+
+// GetProof implements ProofProvider. Note that the account balance in the proof is the balance
+// stored in the EVM state, which doesn't include any ETH managed by the ethcoin contract.
+func (lvm LoomVm) GetProof(addr loom.Address, storageKeys [][]byte) (*AccountProof, error) {
+	levm, err := lvm.newLoomEvm(nil, nil, lvm.debug)
+	if err != nil {
+		return nil, err
+	}
+	sdb, ok := levm.sdb.(*state.StateDB)
+	if !ok {
+		return nil, errors.New("EVM state doesn't support proofs")
+	}
+	ethAddr := common.BytesToAddress(addr.Local)
+	accountProof, err := sdb.GetProof(ethAddr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get proof for account %v", ethAddr.Hex())
+	}
+	storageHash := types.EmptyRootHash
+	storageTrie := sdb.StorageTrie(ethAddr)
+	if storageTrie != nil {
+		storageHash = storageTrie.Hash()
+	}
+	result := &AccountProof{
+		Nonce:        sdb.GetNonce(ethAddr),
+		Balance:      sdb.GetBalance(ethAddr),
+		CodeHash:     sdb.GetCodeHash(ethAddr).Bytes(),
+		StorageHash:  storageHash.Bytes(),
+		Proof:        accountProof,
+		StorageProof: make([]StorageProof, 0, len(storageKeys)),
+	}
+	for _, key := range storageKeys {
+		slot := common.BytesToHash(key)
+		// accounts that don't exist don't have a storage trie, so all their slots are empty
+		var slotProof [][]byte
+		if storageTrie != nil {
+			slotProof, err = sdb.GetStorageProof(ethAddr, slot)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get proof for storage slot %v", slot.Hex())
+			}
+		}
+		result.StorageProof = append(result.StorageProof, StorageProof{
+			Key:   key,
+			Value: sdb.GetState(ethAddr, slot).Bytes(),
+			Proof: slotProof,
+		})
+	}
+	return result, nil
+}
+
+// DryRun implements GasEstimator, the tx is executed without committing any changes to the EVM
+// state. Any balance transfers are still applied to the underlying Loom state, so the VM should
+// be created with a state that will be discarded after the dry run.
+func (lvm LoomVm) DryRun(
+	caller, addr loom.Address, input []byte, value *loom.BigUInt, gas uint64,
+) (uint64, []byte, error) {
+	levm, err := lvm.newLoomEvm(lvm.accountBalanceManager(false), nil, lvm.debug)
+	if err != nil {
+		return 0, nil, err
+	}
+	if addr.IsEmpty() {
+		ret, _, usedGas, err := levm.create(caller, input, value, gas)
+		return usedGas, ret, err
+	}
+	ret, usedGas, err := levm.call(caller, addr, input, value, gas)
+	return usedGas, ret, err
+}
+
+// Trace implements TxTracer.
+func (lvm LoomVm) Trace(
+	caller, addr loom.Address, input []byte, value *loom.BigUInt, cfg TraceConfig,
+) (interface{}, error) {
+	tracer, err := newTxTracer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	levm, err := lvm.newLoomEvm(lvm.accountBalanceManager(false), nil, false)
+	if err != nil {
+		return nil, err
+	}
+	var timeout *timeoutTracer
+	if cfg.Timeout > 0 {
+		timeout = &timeoutTracer{txTracer: tracer, deadline: time.Now().Add(cfg.Timeout)}
+		levm.vmConfig = tracingVmConfig(timeout)
+	} else {
+		levm.vmConfig = tracingVmConfig(tracer)
+	}
+
+	gas := gasLimit
+	if cfg.Gas > 0 {
+		gas = cfg.Gas
+	}
+	var ret []byte
+	var usedGas uint64
+	if addr.IsEmpty() {
+		ret, _, usedGas, err = levm.create(caller, input, value, gas)
+	} else {
+		ret, usedGas, err = levm.call(caller, addr, input, value, gas)
+	}
+	if timeout != nil && timeout.timedOut {
+		return nil, errors.Errorf("execution timeout after %v", cfg.Timeout)
+	}
+	if err == nil {
+		if _, commitErr := levm.Commit(); commitErr != nil {
+			return nil, commitErr
+		}
+	}
+	return tracer.result(usedGas, ret, err), nil
+}
+
+// newInternalTxTracer returns a tracer that records the call tree of an EVM tx, or nil if the
+// receipt handler isn't indexing internal txs.
+func (lvm LoomVm) newInternalTxTracer() *callTracer {
+	if lvm.receiptHandler == nil || !lvm.receiptHandler.InternalTxIndexingEnabled() {
+		return nil
+	}
+	return newCallTracer()
+}
+
+// cacheInternalTxs passes the call tree recorded by the given tracer to the receipt handler, so it
+// can be stored along with the receipt of the tx.
+func (lvm LoomVm) cacheInternalTxs(tracer *callTracer, usedGas uint64, ret []byte, txErr error) {
+	internalTxs, err := json.Marshal(tracer.result(usedGas, ret, txErr))
+	if err != nil {
+		log.Error("Failed to marshal internal txs", "err", err)
+		return
+	}
+	lvm.receiptHandler.CacheInternalTxs(lvm.state, internalTxs)
+}
+
+// newEvmTxError returns nil if the tx succeeded, otherwise it returns an error that records the gas
+// consumed by the failed tx along with any data it returned, so they can be stored in its receipt.
+func newEvmTxError(err error, usedGas uint64, ret []byte) error {
+	if err == nil {
+		return nil
+	}
+	return &rcommon.EvmTxError{
+		Err:        err,
+		GasUsed:    usedGas,
+		RevertData: ret,
+	}
+}
@@ -3,10 +3,63 @@
 package evm
 
 import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/loomnetwork/go-loom"
+	amtypes "github.com/loomnetwork/go-loom/builtin/types/address_mapper"
+	ctypes "github.com/loomnetwork/go-loom/builtin/types/coin"
+	"github.com/loomnetwork/loomchain"
+	"github.com/loomnetwork/loomchain/registry"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Gas charged for each call to the Loom contracts precompile.
+	loomContractsGas = 5000
+	// Gas charged for each 32-byte word of input passed to the Loom contracts precompile.
+	loomContractsWordGas = 6
+)
+
+// ABI of the Loom contracts precompile, Solidity contracts can call it via this interface:
+//
+//	interface LoomContracts {
+//	    function coinBalanceOf(address owner) external view returns (uint256);
+//	    function ethCoinBalanceOf(address owner) external view returns (uint256);
+//	    function resolve(string calldata name) external view returns (address);
+//	    function mappedAccount(string calldata chainId, address account) external view returns (address);
+//	}
+const loomContractsABIJSON = `[
+	{"type":"function","name":"coinBalanceOf","constant":true,
+	 "inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"ethCoinBalanceOf","constant":true,
+	 "inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"resolve","constant":true,
+	 "inputs":[{"name":"name","type":"string"}],"outputs":[{"name":"","type":"address"}]},
+	{"type":"function","name":"mappedAccount","constant":true,
+	 "inputs":[{"name":"chainId","type":"string"},{"name":"account","type":"address"}],
+	 "outputs":[{"name":"","type":"address"}]}
+]`
+
+var (
+	// LoomContractsAddress is the address of the precompile that allows EVM contracts to query the
+	// builtin Go contracts.
+	LoomContractsAddress = common.BytesToAddress([]byte("loom"))
+
+	loomContractsABI abi.ABI
 )
 
+func init() {
+	var err error
+	loomContractsABI, err = abi.JSON(strings.NewReader(loomContractsABIJSON))
+	if err != nil {
+		panic(err)
+	}
+}
+
 func AddLoomPrecompiles() {
 	index := len(vm.PrecompiledContractsByzantium) + 1
 	vm.PrecompiledContractsByzantium[common.BytesToAddress([]byte{byte(index)})] = &TransferWithBlockchain{}
@@ -33,3 +86,125 @@ func (t TransferPlasmaToken) RequiredGas(input []byte) uint64 {
 func (t TransferPlasmaToken) Run(input []byte) ([]byte, error) {
 	return []byte("TransferPlasmaToken"), nil
 }
+
+// LoomContracts is a precompile that allows EVM contracts to query the builtin Go contracts, see
+// loomContractsABIJSON for the methods it implements. Unlike the precompiles added by
+// AddLoomPrecompiles it isn't registered globally, each EVM binds it to its own state & caller
+// (see precompileEnv.precompile).
+type LoomContracts struct {
+	*precompileEnv
+	// address of the EVM contract calling the precompile
+	caller loom.Address
+}
+
+func (p *LoomContracts) RequiredGas(input []byte) uint64 {
+	return loomContractsGas + uint64((len(input)+31)/32)*loomContractsWordGas
+}
+
+func (p *LoomContracts) Run(input []byte) ([]byte, error) {
+	if p.goContracts == nil {
+		return nil, errors.New("Go contracts are not available")
+	}
+	method, err := loomContractsABI.MethodById(input)
+	if err != nil {
+		return nil, err
+	}
+	args, err := method.Inputs.UnpackValues(input[4:])
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unpack %s args", method.Name)
+	}
+	var result interface{}
+	switch method.Name {
+	case "coinBalanceOf":
+		result, err = p.balanceOf("coin", args[0].(common.Address))
+	case "ethCoinBalanceOf":
+		result, err = p.balanceOf("ethcoin", args[0].(common.Address))
+	case "resolve":
+		result, err = p.resolve(args[0].(string))
+	case "mappedAccount":
+		result, err = p.mappedAccount(args[0].(string), args[1].(common.Address))
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s failed", method.Name)
+	}
+	return method.Outputs.Pack(result)
+}
+
+// precompileEnv contains everything the Loom precompiles called by an EVM have access to.
+type precompileEnv struct {
+	state       loomchain.State
+	goContracts GoContractCaller
+	// set if the evm:loom-precompiles feature is enabled
+	enabled bool
+}
+
+// precompile returns the Loom precompile at the given address, bound to the given caller, or nil if
+// there's no Loom precompile at that address. It's hooked into the EVM via vm.Config.Precompile,
+// which is added to go-ethereum by patches/go-ethereum/precompile-hook.patch.
+func (env *precompileEnv) precompile(caller, addr common.Address) vm.PrecompiledContract {
+	if addr != LoomContractsAddress {
+		return nil
+	}
+	return &LoomContracts{
+		precompileEnv: env,
+		caller:        loom.Address{ChainID: env.state.Block().ChainID, Local: caller.Bytes()},
+	}
+}
+
+func (p *LoomContracts) balanceOf(contractName string, owner common.Address) (*big.Int, error) {
+	contractAddr, err := p.goContracts.Resolve(contractName)
+	if err != nil {
+		return nil, err
+	}
+	req := &ctypes.BalanceOfRequest{
+		Owner: loom.Address{ChainID: p.state.Block().ChainID, Local: owner.Bytes()}.MarshalPB(),
+	}
+	var resp ctypes.BalanceOfResponse
+	if err := p.goContracts.StaticCallMethod(p.caller, contractAddr, "BalanceOf", req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Balance == nil || resp.Balance.Value.Int == nil {
+		return new(big.Int), nil
+	}
+	return resp.Balance.Value.Int, nil
+}
+
+// resolve returns the address of the contract registered under the given name, or the zero address
+// if there's no such contract.
+func (p *LoomContracts) resolve(name string) (common.Address, error) {
+	addr, err := p.goContracts.Resolve(name)
+	if err == registry.ErrNotFound {
+		return common.Address{}, nil
+	} else if err != nil {
+		return common.Address{}, err
+	}
+	return common.BytesToAddress(addr.Local), nil
+}
+
+// mappedAccount returns the address the given account is mapped to by the address mapper contract,
+// or the zero address if the account isn't mapped.
+func (p *LoomContracts) mappedAccount(chainID string, account common.Address) (common.Address, error) {
+	mapperAddr, err := p.goContracts.Resolve("addressmapper")
+	if err != nil {
+		return common.Address{}, err
+	}
+	from := loom.Address{ChainID: chainID, Local: account.Bytes()}.MarshalPB()
+	var hasResp amtypes.AddressMapperHasMappingResponse
+	err = p.goContracts.StaticCallMethod(
+		p.caller, mapperAddr, "HasMapping", &amtypes.AddressMapperHasMappingRequest{From: from}, &hasResp,
+	)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if !hasResp.HasMapping {
+		return common.Address{}, nil
+	}
+	var resp amtypes.AddressMapperGetMappingResponse
+	err = p.goContracts.StaticCallMethod(
+		p.caller, mapperAddr, "GetMapping", &amtypes.AddressMapperGetMappingRequest{From: from}, &resp,
+	)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return common.BytesToAddress(loom.UnmarshalAddressPB(resp.To).Local), nil
+}
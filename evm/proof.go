@@ -0,0 +1,35 @@
+package evm
+
+import (
+	"math/big"
+
+	"github.com/loomnetwork/go-loom"
+)
+
+// AccountProof contains the state of an EVM account along with a proof of the account & some of
+// its storage slots in the EVM state trie, as described in EIP-1186.
+type AccountProof struct {
+	Nonce       uint64
+	Balance     *big.Int
+	CodeHash    []byte
+	StorageHash []byte
+	// Patricia trie nodes on the path from the state root to the account
+	Proof        [][]byte
+	StorageProof []StorageProof
+}
+
+// StorageProof contains the value of an account storage slot along with a proof of the slot in the
+// account storage trie.
+type StorageProof struct {
+	Key   []byte
+	Value []byte
+	// Patricia trie nodes on the path from the storage root to the storage slot
+	Proof [][]byte
+}
+
+// ProofProvider is implemented by VMs that can produce Merkle proofs of the EVM state.
+type ProofProvider interface {
+	// GetProof returns the state of the given account, and the values of the given storage slots,
+	// along with proofs that can be verified against the Patricia root of the EVM state.
+	GetProof(addr loom.Address, storageKeys [][]byte) (*AccountProof, error)
+}
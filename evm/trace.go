@@ -0,0 +1,85 @@
+package evm
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/loomnetwork/go-loom"
+)
+
+const (
+	// CallTracer produces a tree of all the calls made by a tx, same as the Geth callTracer.
+	CallTracer = "callTracer"
+	// FourByteTracer counts the 4-byte function selectors (and the size of the call data that
+	// follows them) of all the calls made by a tx, same as the Geth 4byteTracer.
+	FourByteTracer = "4byteTracer"
+)
+
+// TraceConfig specifies how a tx should be traced.
+type TraceConfig struct {
+	// Name of the tracer to use, if empty the opcode level struct logs are collected.
+	Tracer string
+	// Struct log options
+	DisableStorage bool
+	DisableMemory  bool
+	DisableStack   bool
+	// Maximum number of struct logs to collect, zero means unlimited.
+	Limit int
+	// Tracing is aborted if the tx takes longer than this to execute, zero means no timeout.
+	Timeout time.Duration
+	// Gas limit of the traced tx, zero means the same gas limit as regular txs.
+	Gas uint64
+}
+
+// TxTracer is implemented by VMs that can trace the execution of a tx.
+type TxTracer interface {
+	// Trace executes a call to the given contract, if the contract address is empty the input is
+	// treated as contract byte-code and deployed instead. Returns an *ExecutionTrace, a *CallFrame,
+	// or a FourByteTrace, depending on the tracer specified in the config. Changes made by a tx that
+	// succeeds are committed to the VM state (so multiple txs can be traced in sequence), and any
+	// balance transfers are applied to the underlying Loom state, so the VM should be created with
+	// a state that will be discarded after tracing.
+	Trace(caller, addr loom.Address, input []byte, value *loom.BigUInt, cfg TraceConfig) (interface{}, error)
+}
+
+// ExecutionTrace is the result of tracing a tx with the default struct logger.
+type ExecutionTrace struct {
+	Gas         uint64
+	Failed      bool
+	ReturnValue []byte
+	StructLogs  []StructLog
+}
+
+// StructLog records the state of the EVM before an opcode was executed.
+type StructLog struct {
+	Pc      uint64
+	Op      string
+	Gas     uint64
+	GasCost uint64
+	Depth   int
+	Error   string
+	// These are only set if they weren't disabled in the trace config.
+	Stack   []*big.Int
+	Memory  []byte
+	Storage map[[32]byte][32]byte
+}
+
+// CallFrame describes a call made by a tx, and all the calls made by it in turn.
+type CallFrame struct {
+	// CALL, CALLCODE, DELEGATECALL, STATICCALL, CREATE, CREATE2, or SELFDESTRUCT
+	Type  string
+	From  []byte
+	To    []byte
+	Value *big.Int
+	// Zero if the gas given to the callee is unknown (e.g. a transfer to a plain account).
+	Gas     uint64
+	GasUsed uint64
+	Input   []byte
+	Output  []byte
+	Error   string
+	Calls   []*CallFrame
+}
+
+// FourByteTrace maps "<function selector>-<call data size>" to the number of calls made with that
+// selector & call data size.
+type FourByteTrace map[string]int
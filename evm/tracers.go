@@ -0,0 +1,377 @@
+// +build evm
+
+package evm
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/pkg/errors"
+)
+
+// Number of opcodes executed between checks of the trace timeout.
+const traceTimeoutCheckInterval = 1000
+
+// txTracer is implemented by each of the tracers that can be specified in a TraceConfig.
+type txTracer interface {
+	vm.Tracer
+	// result returns the trace of the tx, after it has been executed.
+	result(usedGas uint64, ret []byte, err error) interface{}
+}
+
+func newTxTracer(cfg TraceConfig) (txTracer, error) {
+	switch cfg.Tracer {
+	case "":
+		return &structLogTracer{
+			StructLogger: vm.NewStructLogger(&vm.LogConfig{
+				DisableMemory:  cfg.DisableMemory,
+				DisableStack:   cfg.DisableStack,
+				DisableStorage: cfg.DisableStorage,
+				Limit:          cfg.Limit,
+			}),
+		}, nil
+	case CallTracer:
+		return newCallTracer(), nil
+	case FourByteTracer:
+		return &fourByteTracer{ids: FourByteTrace{}}, nil
+	default:
+		return nil, errors.Errorf("unsupported tracer %s", cfg.Tracer)
+	}
+}
+
+// tracingVmConfig returns the EVM config that should be used to trace a tx with the given tracer.
+func tracingVmConfig(tracer vm.Tracer) vm.Config {
+	cfg := defaultVmConfig(false)
+	cfg.Debug = true
+	cfg.Tracer = tracer
+	return cfg
+}
+
+// timeoutTracer aborts the EVM execution if it takes longer than the given timeout.
+type timeoutTracer struct {
+	txTracer
+	deadline time.Time
+	steps    uint64
+	timedOut bool
+}
+
+func (t *timeoutTracer) CaptureState(
+	env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack,
+	contract *vm.Contract, depth int, err error,
+) error {
+	t.steps++
+	if !t.timedOut && t.steps%traceTimeoutCheckInterval == 0 && time.Now().After(t.deadline) {
+		t.timedOut = true
+		env.Cancel()
+	}
+	return t.txTracer.CaptureState(env, pc, op, gas, cost, memory, stack, contract, depth, err)
+}
+
+// structLogTracer collects the struct logs produced by the Geth StructLogger.
+type structLogTracer struct {
+	*vm.StructLogger
+}
+
+func (t *structLogTracer) result(usedGas uint64, ret []byte, err error) interface{} {
+	logs := t.StructLogs()
+	trace := &ExecutionTrace{
+		Gas:         usedGas,
+		Failed:      err != nil,
+		ReturnValue: ret,
+		StructLogs:  make([]StructLog, 0, len(logs)),
+	}
+	for _, log := range logs {
+		structLog := StructLog{
+			Pc:      log.Pc,
+			Op:      log.Op.String(),
+			Gas:     log.Gas,
+			GasCost: log.GasCost,
+			Depth:   log.Depth,
+			Stack:   log.Stack,
+			Memory:  log.Memory,
+		}
+		if log.Err != nil {
+			structLog.Error = log.Err.Error()
+		}
+		if log.Storage != nil {
+			structLog.Storage = make(map[[32]byte][32]byte, len(log.Storage))
+			for key, value := range log.Storage {
+				structLog.Storage[key] = value
+			}
+		}
+		trace.StructLogs = append(trace.StructLogs, structLog)
+	}
+	return trace
+}
+
+// callTracerFrame is a call that's being traced by callTracer.
+type callTracerFrame struct {
+	CallFrame
+	gasKnown bool
+	gasIn    uint64
+	gasCost  uint64
+	outOff   int64
+	outLen   int64
+}
+
+// callTracer is a native port of the Geth callTracer.
+type callTracer struct {
+	callstack []*callTracerFrame
+	// true if an internal call has just been made
+	descended bool
+
+	create  bool
+	from    common.Address
+	to      common.Address
+	input   []byte
+	gas     uint64
+	value   *big.Int
+	output  []byte
+	gasUsed uint64
+	err     error
+}
+
+func newCallTracer() *callTracer {
+	return &callTracer{callstack: []*callTracerFrame{{}}}
+}
+
+func (t *callTracer) CaptureStart(
+	from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int,
+) error {
+	t.create = create
+	t.from = from
+	t.to = to
+	t.input = common.CopyBytes(input)
+	t.gas = gas
+	t.value = new(big.Int)
+	if value != nil {
+		t.value.Set(value)
+	}
+	return nil
+}
+
+func (t *callTracer) CaptureState(
+	env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack,
+	contract *vm.Contract, depth int, err error,
+) error {
+	if err != nil {
+		return t.CaptureFault(env, pc, op, gas, cost, memory, stack, contract, depth, err)
+	}
+	switch op {
+	case vm.CREATE, vm.CREATE2:
+		inOff := stack.Back(1).Int64()
+		inLen := stack.Back(2).Int64()
+		t.callstack = append(t.callstack, &callTracerFrame{
+			CallFrame: CallFrame{
+				Type:  op.String(),
+				From:  contract.Address().Bytes(),
+				Input: memory.Get(inOff, inLen),
+				Value: new(big.Int).Set(stack.Back(0)),
+			},
+			gasIn:   gas,
+			gasCost: cost,
+		})
+		t.descended = true
+		return nil
+
+	case vm.SELFDESTRUCT:
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, &CallFrame{Type: op.String()})
+		return nil
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		to := common.BigToAddress(stack.Back(1))
+		// precompiles are just fancy opcodes
+		if isPrecompile(to) {
+			return nil
+		}
+		off := 1
+		if op == vm.DELEGATECALL || op == vm.STATICCALL {
+			off = 0
+		}
+		inOff := stack.Back(2 + off).Int64()
+		inLen := stack.Back(3 + off).Int64()
+		frame := &callTracerFrame{
+			CallFrame: CallFrame{
+				Type:  op.String(),
+				From:  contract.Address().Bytes(),
+				To:    to.Bytes(),
+				Input: memory.Get(inOff, inLen),
+			},
+			gasIn:   gas,
+			gasCost: cost,
+			outOff:  stack.Back(4 + off).Int64(),
+			outLen:  stack.Back(5 + off).Int64(),
+		}
+		if off == 1 {
+			frame.Value = new(big.Int).Set(stack.Back(2))
+		}
+		t.callstack = append(t.callstack, frame)
+		t.descended = true
+		return nil
+	}
+
+	// If an internal call has just been made retrieve the amount of gas the callee actually got,
+	// this may differ from the amount requested by the caller (due to the 2300 stipend & the
+	// 63/64 rule). If the callee is a plain account there's no way to tell.
+	if t.descended {
+		if depth >= len(t.callstack) {
+			frame := t.callstack[len(t.callstack)-1]
+			frame.Gas = gas
+			frame.gasKnown = true
+		}
+		t.descended = false
+	}
+	if op == vm.REVERT {
+		t.callstack[len(t.callstack)-1].Error = "execution reverted"
+		return nil
+	}
+	// an internal call has returned
+	if depth == len(t.callstack)-1 {
+		frame := t.callstack[len(t.callstack)-1]
+		t.callstack = t.callstack[:len(t.callstack)-1]
+
+		ret := stack.Back(0)
+		if frame.Type == vm.CREATE.String() || frame.Type == vm.CREATE2.String() {
+			frame.GasUsed = frame.gasIn - frame.gasCost - gas
+			if ret.Sign() != 0 {
+				addr := common.BigToAddress(ret)
+				frame.To = addr.Bytes()
+				frame.Output = env.StateDB.GetCode(addr)
+			} else if frame.Error == "" {
+				frame.Error = "internal failure"
+			}
+		} else if frame.gasKnown {
+			frame.GasUsed = frame.gasIn - frame.gasCost + frame.Gas - gas
+			if ret.Sign() != 0 {
+				frame.Output = memory.Get(frame.outOff, frame.outLen)
+			} else if frame.Error == "" {
+				frame.Error = "internal failure"
+			}
+		}
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, &frame.CallFrame)
+	}
+	return nil
+}
+
+func (t *callTracer) CaptureFault(
+	env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack,
+	contract *vm.Contract, depth int, err error,
+) error {
+	// if the call has already reverted there's nothing more to add
+	frame := t.callstack[len(t.callstack)-1]
+	if frame.Error != "" {
+		return nil
+	}
+	frame.Error = err.Error()
+	// all the gas given to the failed call is consumed
+	if frame.gasKnown {
+		frame.GasUsed = frame.Gas
+	}
+	// the top level call stays on the stack
+	if len(t.callstack) > 1 {
+		t.callstack = t.callstack[:len(t.callstack)-1]
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, &frame.CallFrame)
+	}
+	return nil
+}
+
+func (t *callTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	t.output = common.CopyBytes(output)
+	t.gasUsed = gasUsed
+	t.err = err
+	return nil
+}
+
+func (t *callTracer) result(usedGas uint64, ret []byte, err error) interface{} {
+	root := &CallFrame{
+		Type:    vm.CALL.String(),
+		From:    t.from.Bytes(),
+		To:      t.to.Bytes(),
+		Value:   t.value,
+		Gas:     t.gas,
+		GasUsed: t.gasUsed,
+		Input:   t.input,
+		Output:  t.output,
+		Calls:   t.callstack[0].Calls,
+	}
+	if t.create {
+		root.Type = vm.CREATE.String()
+	}
+	if t.callstack[0].Error != "" {
+		root.Error = t.callstack[0].Error
+	} else if t.err != nil {
+		root.Error = t.err.Error()
+	}
+	if root.Error != "" {
+		root.Output = nil
+	}
+	return root
+}
+
+// fourByteTracer is a native port of the Geth 4byteTracer.
+type fourByteTracer struct {
+	ids FourByteTrace
+}
+
+func (t *fourByteTracer) store(selector []byte, dataSize int64) {
+	t.ids[fmt.Sprintf("0x%x-%d", selector, dataSize)]++
+}
+
+func (t *fourByteTracer) CaptureStart(
+	from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int,
+) error {
+	if len(input) >= 4 {
+		t.store(input[:4], int64(len(input)-4))
+	}
+	return nil
+}
+
+func (t *fourByteTracer) CaptureState(
+	env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack,
+	contract *vm.Contract, depth int, err error,
+) error {
+	// stack index of the call data offset
+	var inArg int
+	switch op {
+	case vm.CALL, vm.CALLCODE:
+		inArg = 3
+	case vm.DELEGATECALL, vm.STATICCALL:
+		inArg = 2
+	default:
+		return nil
+	}
+	if err != nil || isPrecompile(common.BigToAddress(stack.Back(1))) {
+		return nil
+	}
+	inLen := stack.Back(inArg + 1).Int64()
+	if inLen >= 4 {
+		t.store(memory.Get(stack.Back(inArg).Int64(), 4), inLen-4)
+	}
+	return nil
+}
+
+func (t *fourByteTracer) CaptureFault(
+	env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack,
+	contract *vm.Contract, depth int, err error,
+) error {
+	return nil
+}
+
+func (t *fourByteTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *fourByteTracer) result(usedGas uint64, ret []byte, err error) interface{} {
+	return t.ids
+}
+
+func isPrecompile(addr common.Address) bool {
+	_, ok := vm.PrecompiledContractsByzantium[addr]
+	return ok
+}
@@ -0,0 +1,160 @@
+// +build evm
+
+package evm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/loomnetwork/go-loom"
+	ptypes "github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/loomchain"
+	"github.com/stretchr/testify/require"
+)
+
+// deployCode returns the byte-code that deploys a contract with the given runtime byte-code.
+func deployCode(runtime []byte) []byte {
+	// PUSH1 len, DUP1, PUSH1 11, PUSH1 0, CODECOPY, PUSH1 0, RETURN
+	code := []byte{0x60, byte(len(runtime)), 0x80, 0x60, 0x0b, 0x60, 0x00, 0x39, 0x60, 0x00, 0xf3}
+	return append(code, runtime...)
+}
+
+// callerCode returns the runtime byte-code of a contract that calls the given contract with the
+// selector 0xdeadbeef, and then stops.
+func callerCode(callee loom.Address) []byte {
+	runtime := []byte{
+		0x63, 0xde, 0xad, 0xbe, 0xef, 0x60, 0x00, 0x52, // MSTORE(0, 0xdeadbeef)
+		0x60, 0x00, 0x60, 0x00, 0x60, 0x04, 0x60, 0x1c, 0x60, 0x00, // out size & offset, in size & offset, value
+		0x73, // PUSH20 <callee address>
+	}
+	runtime = append(runtime, callee.Local...)
+	return append(runtime, 0x5a, 0xf1, 0x00) // GAS, CALL, STOP
+}
+
+// revertCode is the runtime byte-code of a contract that always reverts.
+var revertCode = []byte{0x60, 0x00, 0x60, 0x00, 0xfd}
+
+func TestTraceTx(t *testing.T) {
+	caller := loom.MustParseAddress("chain:0xb16a379ec18d4093666f8f38b11a3071c920207d")
+	state := mockState()
+	vm := NewLoomVm(state, nil, nil, nil, nil, false)
+
+	_, revertAddr, err := vm.Create(caller, deployCode(revertCode), nil)
+	require.NoError(t, err)
+	runtime := callerCode(revertAddr)
+	_, callerAddr, err := vm.Create(caller, deployCode(runtime), nil)
+	require.NoError(t, err)
+
+	tracer := vm.(TxTracer)
+	result, err := tracer.Trace(caller, callerAddr, nil, nil, TraceConfig{Tracer: CallTracer})
+	require.NoError(t, err)
+	root := result.(*CallFrame)
+	require.Equal(t, "CALL", root.Type)
+	require.Equal(t, []byte(caller.Local), root.From)
+	require.Equal(t, []byte(callerAddr.Local), root.To)
+	require.Equal(t, "", root.Error)
+	require.True(t, root.GasUsed > 0)
+	require.Len(t, root.Calls, 1)
+	call := root.Calls[0]
+	require.Equal(t, "CALL", call.Type)
+	require.Equal(t, []byte(callerAddr.Local), call.From)
+	require.Equal(t, []byte(revertAddr.Local), call.To)
+	require.Equal(t, []byte{0xde, 0xad, 0xbe, 0xef}, call.Input)
+	require.Equal(t, "execution reverted", call.Error)
+	require.True(t, call.Gas > 0)
+	require.True(t, call.GasUsed > 0)
+
+	result, err = tracer.Trace(caller, callerAddr, []byte{1, 2, 3, 4, 5}, nil, TraceConfig{Tracer: FourByteTracer})
+	require.NoError(t, err)
+	require.Equal(t, FourByteTrace{"0x01020304-1": 1, "0xdeadbeef-0": 1}, result)
+
+	result, err = tracer.Trace(caller, callerAddr, nil, nil, TraceConfig{DisableMemory: true})
+	require.NoError(t, err)
+	trace := result.(*ExecutionTrace)
+	require.False(t, trace.Failed)
+	require.Equal(t, root.GasUsed, trace.Gas)
+	// the opcodes of both contracts should've been logged
+	require.Len(t, trace.StructLogs, 12+3)
+	require.Equal(t, "PUSH4", trace.StructLogs[0].Op)
+	require.Equal(t, 1, trace.StructLogs[0].Depth)
+	require.Equal(t, "REVERT", trace.StructLogs[len(trace.StructLogs)-2].Op)
+	require.Equal(t, 2, trace.StructLogs[len(trace.StructLogs)-2].Depth)
+	require.Equal(t, "STOP", trace.StructLogs[len(trace.StructLogs)-1].Op)
+	require.Nil(t, trace.StructLogs[0].Memory)
+	require.Len(t, trace.StructLogs[1].Stack, 1)
+
+	// deployments can be traced too
+	result, err = tracer.Trace(caller, loom.Address{}, deployCode(runtime), nil, TraceConfig{Tracer: CallTracer})
+	require.NoError(t, err)
+	root = result.(*CallFrame)
+	require.Equal(t, "CREATE", root.Type)
+	require.Equal(t, runtime, root.Output)
+	require.Len(t, root.Calls, 0)
+	require.NotEqual(t, common.Address{}.Bytes(), root.To)
+
+	_, err = tracer.Trace(caller, callerAddr, nil, nil, TraceConfig{Tracer: "prestateTracer"})
+	require.Error(t, err)
+}
+
+// mockReceiptWriter records the internal txs passed to the receipt handler by the EVM.
+type mockReceiptWriter struct {
+	internalTxs []byte
+}
+
+func (w *mockReceiptWriter) GetEventsFromLogs(
+	logs []*types.Log, blockHeight int64, caller, contract loom.Address, input []byte,
+) []*ptypes.EventData {
+	return nil
+}
+
+func (w *mockReceiptWriter) CacheReceipt(
+	state loomchain.State, caller, addr loom.Address, events []*ptypes.EventData, err error,
+) ([]byte, error) {
+	w.internalTxs = nil
+	return []byte("txhash"), nil
+}
+
+func (w *mockReceiptWriter) InternalTxIndexingEnabled() bool {
+	return true
+}
+
+func (w *mockReceiptWriter) CacheInternalTxs(state loomchain.State, internalTxs []byte) {
+	w.internalTxs = internalTxs
+}
+
+func TestInternalTxIndexing(t *testing.T) {
+	caller := loom.MustParseAddress("chain:0xb16a379ec18d4093666f8f38b11a3071c920207d")
+	writer := &mockReceiptWriter{}
+	vm := NewLoomVm(mockState(), nil, writer, nil, nil, false)
+
+	_, revertAddr, err := vm.Create(caller, deployCode(revertCode), nil)
+	require.NoError(t, err)
+	var root CallFrame
+	require.NoError(t, json.Unmarshal(writer.internalTxs, &root))
+	require.Equal(t, "CREATE", root.Type)
+	require.Equal(t, []byte(revertAddr.Local), root.To)
+	require.Len(t, root.Calls, 0)
+
+	_, callerAddr, err := vm.Create(caller, deployCode(callerCode(revertAddr)), nil)
+	require.NoError(t, err)
+	_, err = vm.Call(caller, callerAddr, nil, nil)
+	require.NoError(t, err)
+	root = CallFrame{}
+	require.NoError(t, json.Unmarshal(writer.internalTxs, &root))
+	require.Equal(t, "CALL", root.Type)
+	require.Equal(t, []byte(callerAddr.Local), root.To)
+	require.Equal(t, "", root.Error)
+	require.Len(t, root.Calls, 1)
+	require.Equal(t, []byte(revertAddr.Local), root.Calls[0].To)
+	require.Equal(t, []byte{0xde, 0xad, 0xbe, 0xef}, root.Calls[0].Input)
+	require.Equal(t, "execution reverted", root.Calls[0].Error)
+
+	// the internal txs of failed txs are recorded too
+	_, err = vm.Call(caller, revertAddr, []byte{1, 2, 3, 4}, nil)
+	require.Error(t, err)
+	root = CallFrame{}
+	require.NoError(t, json.Unmarshal(writer.internalTxs, &root))
+	require.Equal(t, "execution reverted", root.Error)
+}
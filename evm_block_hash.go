@@ -0,0 +1,39 @@
+package loomchain
+
+import (
+	"encoding/binary"
+
+	"github.com/loomnetwork/go-loom/util"
+)
+
+const (
+	// Number of recent block hashes kept in the app state, the EVM BLOCKHASH opcode can only look up
+	// the hashes of this many blocks below the current one.
+	evmBlockHashCount  = 256
+	evmBlockHashPrefix = "blockhash"
+)
+
+func evmBlockHashKey(height uint64) []byte {
+	slot := make([]byte, 8)
+	binary.BigEndian.PutUint64(slot, height%evmBlockHashCount)
+	return util.PrefixKey([]byte(evmBlockHashPrefix), slot)
+}
+
+// SetEVMBlockHash stores the hash of the block at the given height in the app state. The hashes are
+// stored in a ring buffer, so the hash overwrites the one stored for the block 256 heights below.
+func SetEVMBlockHash(state State, height uint64, hash []byte) {
+	value := make([]byte, 8, 8+len(hash))
+	binary.BigEndian.PutUint64(value, height)
+	state.Set(evmBlockHashKey(height), append(value, hash...))
+}
+
+// EVMBlockHash returns the hash of the block at the given height, or nil if the hash isn't stored
+// in the app state (the block is too old, or was committed before the evm:blockhash feature was
+// enabled).
+func EVMBlockHash(state ReadOnlyState, height uint64) []byte {
+	value := state.Get(evmBlockHashKey(height))
+	if len(value) < 8 || binary.BigEndian.Uint64(value[:8]) != height {
+		return nil
+	}
+	return value[8:]
+}
@@ -109,4 +109,15 @@ const (
 
 	// Enables Constantinople hard fork in EVM interpreter
 	EvmConstantinopleFeature = "evm:constantinople"
+
+	// Enables optimistic parallel execution of the txs in a block
+	ParallelTxExecutionFeature = "tx:parallel"
+
+	// Makes the EVM BLOCKHASH opcode return the hashes of the last 256 blocks, which are stored in
+	// the app state when each block is committed
+	EvmBlockHashFeature = "evm:blockhash"
+
+	// Enables the Loom contracts precompile, which allows EVM contracts to query the builtin Go
+	// contracts
+	EvmLoomPrecompilesFeature = "evm:loom-precompiles"
 )
@@ -208,7 +208,7 @@ func (c *ethCoinIntegrationTestHelper) callEVM(
 	if err != nil {
 		return err
 	}
-	vm := evm.NewLoomVm(ctx.State, nil, nil, ctx.AccountBalanceManager, false)
+	vm := evm.NewLoomVm(ctx.State, nil, nil, ctx.AccountBalanceManager, nil, false)
 	_, err = vm.Call(ctx.Message().Sender, c.Address, input, loom.NewBigUIntFromInt(0))
 	if err != nil {
 		return err
@@ -223,7 +223,7 @@ func (c *ethCoinIntegrationTestHelper) staticCallEVM(
 	if err != nil {
 		return err
 	}
-	vm := evm.NewLoomVm(ctx.State, nil, nil, ctx.AccountBalanceManager, false)
+	vm := evm.NewLoomVm(ctx.State, nil, nil, ctx.AccountBalanceManager, nil, false)
 	output, err := vm.StaticCall(ctx.Message().Sender, c.Address, input)
 	if err != nil {
 		return err
@@ -239,7 +239,7 @@ func deployContractToEVM(ctx *plugin.FakeContextWithEVM, filename string, caller
 	}
 	byteCode := common.FromHex(string(hexByteCode))
 
-	vm := evm.NewLoomVm(ctx.State, nil, nil, nil, false)
+	vm := evm.NewLoomVm(ctx.State, nil, nil, nil, nil, false)
 	_, contractAddr, err = vm.Create(caller, byteCode, loom.NewBigUIntFromInt(0))
 	if err != nil {
 		return contractAddr, err
@@ -64,7 +64,20 @@ func MiddlewareTxHandler(
 		return result, err
 	})
 
+	lastSerial := -1
+	for i, m := range middlewares {
+		if _, ok := m.(serialTxMiddleware); ok {
+			lastSerial = i
+		}
+	}
+	if lastSerial == -1 {
+		next = speculativeTxHandler(next)
+	}
+
 	for i := len(middlewares) - 1; i >= 0; i-- {
+		if i == lastSerial {
+			next = speculativeTxHandler(next)
+		}
 		m := middlewares[i]
 		// Need local var otherwise infinite loop occurs
 		nextLocal := next
@@ -76,6 +89,42 @@ func MiddlewareTxHandler(
 	return next
 }
 
+// speculativeTxHandler wraps the part of the tx handler chain that's safe to execute speculatively.
+// When a tx that has been executed speculatively is delivered the chain is only executed up to &
+// including the last serial middleware, the rest of the chain is replaced by the outcome of the
+// speculative execution of the tx.
+func speculativeTxHandler(next TxHandlerFunc) TxHandlerFunc {
+	return func(state State, txBytes []byte, isCheckTx bool) (TxHandlerResult, error) {
+		if delivery := txDeliveryFromContext(state.Context()); delivery != nil {
+			return delivery.apply(state)
+		}
+		return next(state, txBytes, isCheckTx)
+	}
+}
+
+// SerialTxMiddleware marks a middleware that keeps state in memory between txs, such middlewares
+// aren't safe for concurrent use, and must see each tx exactly once, in block order. When the txs
+// in a block are executed in parallel (see ParallelTxExecutor) serial middlewares are skipped
+// during the speculative execution of each tx, and executed when the tx is delivered instead.
+// Serial middlewares must pass the state & tx they receive to the next handler unmodified, and any
+// middlewares that precede a serial middleware must not write to the store.
+func SerialTxMiddleware(m TxMiddleware) TxMiddleware {
+	return serialTxMiddleware{m}
+}
+
+type serialTxMiddleware struct {
+	TxMiddleware
+}
+
+func (m serialTxMiddleware) ProcessTx(
+	state State, txBytes []byte, next TxHandlerFunc, isCheckTx bool,
+) (TxHandlerResult, error) {
+	if TxEffectsFromContext(state.Context()) != nil {
+		return next(state, txBytes, isCheckTx)
+	}
+	return m.TxMiddleware.ProcessTx(state, txBytes, next, isCheckTx)
+}
+
 var NoopTxHandler = TxHandlerFunc(func(state State, txBytes []byte, isCheckTx bool) (TxHandlerResult, error) {
 	return TxHandlerResult{}, nil
 })
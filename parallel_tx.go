@@ -0,0 +1,483 @@
+package loomchain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/loomnetwork/go-loom/plugin"
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/store"
+)
+
+type txEffectsContextKey struct{}
+
+// TxEffects records the side effects of a tx that's being executed speculatively, so they can be
+// applied later if the tx is committed, or discarded if the tx has to be re-executed.
+type TxEffects struct {
+	// Changes that must persist even if the tx fails (e.g. nonce increments) should be written to
+	// this store instead of the app store.
+	Store store.KVStore
+	// Index the EVM tx receipt of the tx should have in the block.
+	EvmTxIndex int32
+	// Receipt of the EVM tx executed by the tx (if any).
+	Receipt *types.EvmTxReceipt
+	// Data returned by the EVM if the EVM tx failed.
+	RevertData []byte
+	// JSON encoded call tree of the internal txs made by the EVM tx (if indexed).
+	InternalTxs []byte
+
+	events []txEvent
+}
+
+type txEvent struct {
+	height uint64
+	data   *types.EventData
+}
+
+// Post records an event that should be posted to the event handler when the tx is committed.
+func (e *TxEffects) Post(height uint64, data *types.EventData) {
+	e.events = append(e.events, txEvent{height: height, data: data})
+}
+
+// PostEvents posts all the recorded events to the given event handler, in the order they were
+// emitted by the tx.
+func (e *TxEffects) PostEvents(eventHandler EventHandler) {
+	for _, event := range e.events {
+		_ = eventHandler.Post(event.height, event.data)
+	}
+}
+
+// WithTxEffects returns a copy of the given context that carries the given effects recorder.
+func WithTxEffects(ctx context.Context, effects *TxEffects) context.Context {
+	return context.WithValue(ctx, txEffectsContextKey{}, effects)
+}
+
+// TxEffectsFromContext returns the effects recorder carried by the given context, or nil if the
+// tx isn't being executed speculatively.
+func TxEffectsFromContext(ctx context.Context) *TxEffects {
+	effects, _ := ctx.Value(txEffectsContextKey{}).(*TxEffects)
+	return effects
+}
+
+// SpeculativeTxFunc executes a tx against the given store, any side effects of the tx must be
+// recorded in the given effects recorder instead of being applied directly.
+type SpeculativeTxFunc func(kvStore store.KVStore, effects *TxEffects, txBytes []byte) (TxHandlerResult, error)
+
+// TxExecution is the outcome of executing a tx against a speculative store.
+type TxExecution struct {
+	Result  TxHandlerResult
+	Err     error
+	Effects *TxEffects
+
+	reads *readSet
+	store *speculativeStore
+	// Number of leading ops in the store that were written directly to the effects store
+	numEffectOps int
+	// Set when the tx panicked, speculative panics may be caused by inconsistent state, so the tx
+	// must always be re-executed.
+	panicked bool
+}
+
+// ParallelTxExecutor executes the txs in a block concurrently, each against an isolated overlay
+// of the app store that records the keys read by the tx, and buffers the changes made by it.
+// The txs are then committed in block order, any tx that read a key written by one of the txs
+// committed before it is re-executed against the up-to-date store, so the end result is always
+// identical to executing the txs serially.
+type ParallelTxExecutor struct {
+	// Max number of txs that are executed concurrently, defaults to the number of CPUs.
+	Workers int
+	Run     SpeculativeTxFunc
+}
+
+func NewParallelTxExecutor(workers int, run SpeculativeTxFunc) *ParallelTxExecutor {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &ParallelTxExecutor{
+		Workers: workers,
+		Run:     run,
+	}
+}
+
+// Speculate executes the given txs concurrently against the given store. The store must not be
+// modified until the speculation is done, other than via BlockSpeculation.Commit.
+func (e *ParallelTxExecutor) Speculate(kvStore store.KVReader, txs [][]byte) *BlockSpeculation {
+	s := &BlockSpeculation{
+		executor: e,
+		txs:      txs,
+		execs:    make([]*TxExecution, len(txs)),
+		writes:   make(map[string]struct{}),
+	}
+	// The app store isn't safe for concurrent access, so reads from it are serialized.
+	base := &lockedKVReader{KVReader: kvStore}
+
+	// Assume every tx contains an EVM tx, this guess is correct for the most common blocks.
+	indices := make([]int, len(txs))
+	evmTxIndices := make([]int32, len(txs))
+	for i := range txs {
+		indices[i] = i
+		evmTxIndices[i] = int32(i)
+	}
+	e.speculate(s, base, indices, evmTxIndices)
+
+	// Txs that don't contain EVM txs, or that fail, don't produce receipts that end up in the
+	// block, so once the first round is done it's possible to make a better guess.
+	indices = indices[:0]
+	numReceipts := int32(0)
+	for i, exec := range s.execs {
+		if exec.Effects.Receipt == nil {
+			continue
+		}
+		if exec.Effects.EvmTxIndex != numReceipts {
+			indices = append(indices, i)
+			evmTxIndices[i] = numReceipts
+		}
+		if exec.Err == nil {
+			numReceipts++
+		}
+	}
+	e.speculate(s, base, indices, evmTxIndices)
+	return s
+}
+
+func (e *ParallelTxExecutor) speculate(s *BlockSpeculation, base store.KVReader, indices []int, evmTxIndices []int32) {
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < e.Workers && w < len(indices); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				s.execs[i] = e.execute(base, s.txs[i], evmTxIndices[i], true)
+			}
+		}()
+	}
+	for _, i := range indices {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func (e *ParallelTxExecutor) execute(base store.KVReader, txBytes []byte, evmTxIndex int32, recoverPanic bool) (exec *TxExecution) {
+	reads := newReadSet()
+	specStore := newSpeculativeStore(&readTrackingKVReader{KVReader: base, reads: reads})
+	effects := &TxEffects{
+		Store:      specStore,
+		EvmTxIndex: evmTxIndex,
+	}
+	exec = &TxExecution{
+		Effects: effects,
+		reads:   reads,
+		store:   specStore,
+	}
+	if recoverPanic {
+		defer func() {
+			if r := recover(); r != nil {
+				exec.Err = fmt.Errorf("speculative tx execution panicked: %v", r)
+				exec.panicked = true
+			}
+		}()
+	}
+
+	// Like in Application.processTx, changes made by the tx are only kept if the tx succeeds,
+	// while changes written directly to the effects store are always kept.
+	storeTx := store.WrapAtomic(specStore).BeginTx()
+	exec.Result, exec.Err = e.Run(storeTx, effects, txBytes)
+	exec.numEffectOps = len(specStore.ops)
+	if exec.Err != nil {
+		storeTx.Rollback()
+	} else {
+		storeTx.Commit()
+	}
+	return exec
+}
+
+// BlockSpeculation holds the outcome of the speculative execution of the txs in a block.
+type BlockSpeculation struct {
+	executor *ParallelTxExecutor
+	txs      [][]byte
+	execs    []*TxExecution
+	next     int
+	// Keys written to the store by the txs committed so far
+	writes map[string]struct{}
+	// Number of txs that had to be re-executed
+	NumReExecuted int
+}
+
+// Next returns the outcome of executing the next tx in the block against the given store. If the
+// speculative execution of the tx is stale the tx will be re-executed against the store.
+func (s *BlockSpeculation) Next(kvStore store.KVReader, txBytes []byte, evmTxIndex int32) *TxExecution {
+	i := s.next
+	s.next++
+	if i < len(s.txs) && bytes.Equal(s.txs[i], txBytes) {
+		exec := s.execs[i]
+		if !exec.panicked && !s.conflicts(exec.reads) &&
+			(exec.Effects.Receipt == nil || exec.Effects.EvmTxIndex == evmTxIndex) {
+			return exec
+		}
+	}
+	s.NumReExecuted++
+	return s.executor.execute(kvStore, txBytes, evmTxIndex, false)
+}
+
+// DeliverTxFunc executes a tx against the given store when the tx is delivered, the state the tx
+// is executed with must carry the given delivery (see WithTxDelivery).
+type DeliverTxFunc func(kvStore store.KVStore, delivery *TxDelivery, txBytes []byte) (TxHandlerResult, error)
+
+// Deliver delivers the next tx in the block to the given store. The serial middlewares are skipped
+// when a tx is executed speculatively, so the tx is executed again by the given func, which
+// should run the serial middlewares, and then apply the outcome of the speculative execution of
+// the tx in place of the rest of the tx handler chain (see MiddlewareTxHandler).
+func (s *BlockSpeculation) Deliver(
+	kvStore store.KVStore, txBytes []byte, evmTxIndex int32, run DeliverTxFunc,
+) *TxDelivery {
+	// All the changes made to the store while the tx is delivered are tracked, so any txs that
+	// read the changed keys during their speculative execution will be re-executed.
+	trackingStore := &writeTrackingKVStore{KVStore: kvStore, writes: s.writes}
+	delivery := &TxDelivery{
+		exec:         s.Next(kvStore, txBytes, evmTxIndex),
+		effectsStore: trackingStore,
+	}
+	// Like in Application.processTx, changes made by the tx are only kept if the tx succeeds.
+	storeTx := store.WrapAtomic(trackingStore).BeginTx()
+	delivery.Result, delivery.Err = run(storeTx, delivery, txBytes)
+	if delivery.Err != nil {
+		storeTx.Rollback()
+	} else {
+		storeTx.Commit()
+	}
+	return delivery
+}
+
+type txDeliveryContextKey struct{}
+
+// TxDelivery is the delivery of a tx that has been executed speculatively.
+type TxDelivery struct {
+	Result TxHandlerResult
+	Err    error
+
+	exec *TxExecution
+	// Changes that must persist even if the tx fails are written directly to this store.
+	effectsStore store.KVWriter
+	// Set once the outcome of the speculative execution has been applied.
+	applied bool
+}
+
+// Effects returns the side effects of the speculative execution of the tx, or nil if the tx was
+// rejected by one of the serial middlewares.
+func (d *TxDelivery) Effects() *TxEffects {
+	if !d.applied {
+		return nil
+	}
+	return d.exec.Effects
+}
+
+// apply writes the changes made by the speculative execution of the tx to the given store, and
+// returns the outcome of the execution.
+func (d *TxDelivery) apply(kvStore store.KVWriter) (TxHandlerResult, error) {
+	d.applied = true
+	for i, op := range d.exec.store.ops {
+		w := kvStore
+		if i < d.exec.numEffectOps {
+			w = d.effectsStore
+		}
+		if op.deleted {
+			w.Delete(op.key)
+		} else {
+			w.Set(op.key, op.value)
+		}
+	}
+	return d.exec.Result, d.exec.Err
+}
+
+// WithTxDelivery returns a copy of the given context that carries the given delivery.
+func WithTxDelivery(ctx context.Context, delivery *TxDelivery) context.Context {
+	return context.WithValue(ctx, txDeliveryContextKey{}, delivery)
+}
+
+func txDeliveryFromContext(ctx context.Context) *TxDelivery {
+	delivery, _ := ctx.Value(txDeliveryContextKey{}).(*TxDelivery)
+	return delivery
+}
+
+// conflicts checks if any of the keys read by a tx have been written by a tx committed earlier.
+func (s *BlockSpeculation) conflicts(reads *readSet) bool {
+	for key := range reads.keys {
+		if _, ok := s.writes[key]; ok {
+			return true
+		}
+	}
+	for _, prefix := range reads.prefixes {
+		for key := range s.writes {
+			if bytes.HasPrefix([]byte(key), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type readSet struct {
+	keys     map[string]struct{}
+	prefixes [][]byte
+}
+
+func newReadSet() *readSet {
+	return &readSet{
+		keys: make(map[string]struct{}),
+	}
+}
+
+// readTrackingKVReader records all the keys & prefixes read from the underlying store.
+type readTrackingKVReader struct {
+	store.KVReader
+	reads *readSet
+}
+
+func (r *readTrackingKVReader) Get(key []byte) []byte {
+	r.reads.keys[string(key)] = struct{}{}
+	return r.KVReader.Get(key)
+}
+
+func (r *readTrackingKVReader) Has(key []byte) bool {
+	r.reads.keys[string(key)] = struct{}{}
+	return r.KVReader.Has(key)
+}
+
+func (r *readTrackingKVReader) Range(prefix []byte) plugin.RangeData {
+	r.reads.prefixes = append(r.reads.prefixes, prefix)
+	return r.KVReader.Range(prefix)
+}
+
+// writeTrackingKVStore records all the keys written to the underlying store.
+type writeTrackingKVStore struct {
+	store.KVStore
+	writes map[string]struct{}
+}
+
+func (s *writeTrackingKVStore) Set(key, value []byte) {
+	s.writes[string(key)] = struct{}{}
+	s.KVStore.Set(key, value)
+}
+
+func (s *writeTrackingKVStore) Delete(key []byte) {
+	s.writes[string(key)] = struct{}{}
+	s.KVStore.Delete(key)
+}
+
+func (s *writeTrackingKVStore) NewIterator(prefix []byte, opts store.RangeOptions) store.Iterator {
+	return store.NewIterator(s.KVStore, prefix, opts)
+}
+
+// lockedKVReader serializes access to the underlying store.
+type lockedKVReader struct {
+	store.KVReader
+	mutex sync.Mutex
+}
+
+func (r *lockedKVReader) Get(key []byte) []byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.KVReader.Get(key)
+}
+
+func (r *lockedKVReader) Has(key []byte) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.KVReader.Has(key)
+}
+
+func (r *lockedKVReader) Range(prefix []byte) plugin.RangeData {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.KVReader.Range(prefix)
+}
+
+type speculativeOp struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+// speculativeStore buffers all the changes made by a tx, and keeps track of the order in which
+// they were made so they can be replayed in exactly the same order on the app store (the IAVL
+// tree hash depends on the order in which keys are written).
+type speculativeStore struct {
+	store.KVReader
+	cache map[string]*speculativeOp
+	ops   []*speculativeOp
+}
+
+func newSpeculativeStore(base store.KVReader) *speculativeStore {
+	return &speculativeStore{
+		KVReader: base,
+		cache:    make(map[string]*speculativeOp),
+	}
+}
+
+func (s *speculativeStore) Get(key []byte) []byte {
+	if op, ok := s.cache[string(key)]; ok {
+		return op.value
+	}
+	return s.KVReader.Get(key)
+}
+
+func (s *speculativeStore) Has(key []byte) bool {
+	if op, ok := s.cache[string(key)]; ok {
+		return !op.deleted
+	}
+	return s.KVReader.Has(key)
+}
+
+// Range merges any buffered changes into the range returned by the underlying store, which is
+// what the range would've contained if the changes were written directly to the app store.
+func (s *speculativeStore) Range(prefix []byte) plugin.RangeData {
+	ret := s.KVReader.Range(prefix)
+	changes := make(map[string]*speculativeOp)
+	for key, op := range s.cache {
+		if util.HasPrefix([]byte(key), prefix) {
+			k, err := util.UnprefixKey([]byte(key), prefix)
+			if err != nil {
+				panic(err)
+			}
+			changes[string(k)] = op
+		}
+	}
+	if len(changes) == 0 {
+		return ret
+	}
+
+	merged := make(plugin.RangeData, 0, len(ret)+len(changes))
+	for _, entry := range ret {
+		if _, ok := changes[string(entry.Key)]; !ok {
+			merged = append(merged, entry)
+		}
+	}
+	for k, op := range changes {
+		if !op.deleted {
+			merged = append(merged, &plugin.RangeEntry{Key: []byte(k), Value: op.value})
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return bytes.Compare(merged[i].Key, merged[j].Key) < 0
+	})
+	return merged
+}
+
+func (s *speculativeStore) Set(key, val []byte) {
+	op := &speculativeOp{key: key, value: val}
+	s.cache[string(key)] = op
+	s.ops = append(s.ops, op)
+}
+
+func (s *speculativeStore) Delete(key []byte) {
+	op := &speculativeOp{key: key, deleted: true}
+	s.cache[string(key)] = op
+	s.ops = append(s.ops, op)
+}
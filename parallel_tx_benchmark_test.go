@@ -0,0 +1,91 @@
+package loomchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/loomnetwork/loomchain/store"
+)
+
+const (
+	benchNumBlocks = 20
+	benchBlockSize = 100
+	// Number of hashes computed by each tx, simulates signature verification & contract execution.
+	benchTxWork = 2000
+	// Number of keys each tx reads & writes
+	benchTxKeys = 4
+)
+
+// benchTx reads & increments a few hashed keys, every conflictEvery-th tx touches a key shared by
+// all the other txs in the block.
+func generateBenchBlocks(numBlocks, blockSize, conflictEvery int) [][][]byte {
+	blocks := make([][][]byte, numBlocks)
+	for b := 0; b < numBlocks; b++ {
+		for i := 0; i < blockSize; i++ {
+			tx := make([]byte, 9)
+			binary.BigEndian.PutUint64(tx, uint64(b*blockSize+i))
+			if conflictEvery > 0 && i%conflictEvery == 0 {
+				tx[8] = 1
+			}
+			blocks[b] = append(blocks[b], tx)
+		}
+	}
+	return blocks
+}
+
+func runBenchTx(kvStore store.KVStore, effects *TxEffects, txBytes []byte) (TxHandlerResult, error) {
+	h := sha256.Sum256(txBytes)
+	for i := 0; i < benchTxWork; i++ {
+		h = sha256.Sum256(h[:])
+	}
+	keys := make([][]byte, 0, benchTxKeys+1)
+	for i := 0; i < benchTxKeys; i++ {
+		k := sha256.Sum256(append(append([]byte{}, txBytes[:8]...), byte(i)))
+		keys = append(keys, k[:])
+	}
+	if txBytes[8] == 1 {
+		keys = append(keys, []byte("shared"))
+	}
+	for _, k := range keys {
+		setUint(kvStore, k, getUint(kvStore, k)+1)
+	}
+	return TxHandlerResult{Data: h[:]}, nil
+}
+
+func benchmarkTxExecution(b *testing.B, conflictEvery int, parallel bool) {
+	blocks := generateBenchBlocks(benchNumBlocks, benchBlockSize, conflictEvery)
+	executor := NewParallelTxExecutor(0, runBenchTx)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		kvStore := newTestIAVLStore(b)
+		b.StartTimer()
+		for _, txs := range blocks {
+			if parallel {
+				speculation := executor.Speculate(kvStore, txs)
+				for _, tx := range txs {
+					speculation.Deliver(kvStore, tx, 0, applyDelivery)
+				}
+			} else {
+				executeSerially(kvStore, txs, runBenchTx)
+			}
+			_, _, err := kvStore.SaveVersion()
+			require.NoError(b, err)
+		}
+	}
+}
+
+func BenchmarkTxExecution(b *testing.B) {
+	for _, conflictEvery := range []int{0, 10, 2} {
+		b.Run(fmt.Sprintf("serial-conflict%d", conflictEvery), func(b *testing.B) {
+			benchmarkTxExecution(b, conflictEvery, false)
+		})
+		b.Run(fmt.Sprintf("parallel-conflict%d", conflictEvery), func(b *testing.B) {
+			benchmarkTxExecution(b, conflictEvery, true)
+		})
+	}
+}
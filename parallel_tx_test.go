@@ -0,0 +1,222 @@
+package loomchain
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/store"
+	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/tendermint/abci/types"
+)
+
+var (
+	testAccountPrefix = []byte("acct")
+	testNoncePrefix   = []byte("nonce")
+)
+
+// Test txs consist of 3 bytes: sender, recipient, and a flag that indicates the tx should fail,
+// or that it should sum up all the balances.
+const (
+	testTxOK   = 0
+	testTxFail = 1
+	testTxSum  = 2
+)
+
+func testTx(from, to, flag byte) []byte {
+	return []byte{from, to, flag}
+}
+
+func getUint(kvStore store.KVReader, key []byte) uint64 {
+	v := kvStore.Get(key)
+	if len(v) == 0 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func setUint(kvStore store.KVWriter, key []byte, v uint64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	kvStore.Set(key, b)
+}
+
+func runTestTx(kvStore store.KVStore, effects *TxEffects, txBytes []byte) (TxHandlerResult, error) {
+	from, to, flag := txBytes[0], txBytes[1], txBytes[2]
+	// nonces are incremented even if the tx fails
+	nonceKey := util.PrefixKey(testNoncePrefix, []byte{from})
+	setUint(effects.Store, nonceKey, getUint(effects.Store, nonceKey)+1)
+
+	fromKey := util.PrefixKey(testAccountPrefix, []byte{from})
+	toKey := util.PrefixKey(testAccountPrefix, []byte{to})
+	setUint(kvStore, fromKey, getUint(kvStore, fromKey)+10)
+	setUint(kvStore, toKey, getUint(kvStore, toKey)*3+1)
+	effects.Post(1, &types.EventData{EncodedBody: txBytes})
+
+	switch flag {
+	case testTxFail:
+		return TxHandlerResult{}, errors.New("tx failed")
+	case testTxSum:
+		sum := uint64(0)
+		for _, entry := range kvStore.Range(testAccountPrefix) {
+			sum = sum*31 + binary.BigEndian.Uint64(entry.Value)
+		}
+		setUint(kvStore, util.PrefixKey([]byte("sum"), []byte{from}), sum)
+	}
+	return TxHandlerResult{Data: kvStore.Get(toKey)}, nil
+}
+
+// applyDelivery mimics a tx handler without any serial middlewares.
+func applyDelivery(kvStore store.KVStore, delivery *TxDelivery, txBytes []byte) (TxHandlerResult, error) {
+	return delivery.apply(kvStore)
+}
+
+func newTestIAVLStore(t require.TestingT) *store.IAVLStore {
+	memDb, _ := db.LoadMemDB()
+	iavlStore, err := store.NewIAVLStore(memDb, 0, 0, 0)
+	require.NoError(t, err)
+	return iavlStore
+}
+
+// executeSerially mimics Application.processTx
+func executeSerially(
+	kvStore store.KVStore, txs [][]byte, run SpeculativeTxFunc,
+) ([]TxHandlerResult, []error, *TxEffects) {
+	results := make([]TxHandlerResult, len(txs))
+	errs := make([]error, len(txs))
+	effects := &TxEffects{Store: kvStore}
+	for i, tx := range txs {
+		storeTx := store.WrapAtomic(kvStore).BeginTx()
+		results[i], errs[i] = run(storeTx, effects, tx)
+		if errs[i] != nil {
+			storeTx.Rollback()
+		} else {
+			storeTx.Commit()
+		}
+	}
+	return results, errs, effects
+}
+
+func TestParallelTxExecutorMatchesSerialExecution(t *testing.T) {
+	blocks := [][][]byte{
+		// no conflicts
+		{testTx(1, 2, testTxOK), testTx(3, 4, testTxOK), testTx(5, 6, testTxOK)},
+		// every tx depends on the previous one
+		{testTx(1, 2, testTxOK), testTx(2, 3, testTxOK), testTx(3, 1, testTxOK), testTx(1, 2, testTxOK)},
+		// failed txs & txs that range over all the accounts
+		{
+			testTx(7, 8, testTxFail), testTx(7, 9, testTxOK), testTx(10, 11, testTxSum),
+			testTx(12, 13, testTxOK), testTx(8, 7, testTxFail), testTx(14, 15, testTxSum),
+		},
+		// duplicate txs
+		{testTx(1, 1, testTxOK), testTx(1, 1, testTxOK), testTx(1, 1, testTxOK)},
+	}
+
+	serialStore := newTestIAVLStore(t)
+	parallelStore := newTestIAVLStore(t)
+	executor := NewParallelTxExecutor(4, runTestTx)
+	for height, txs := range blocks {
+		expectedResults, expectedErrs, expectedEffects := executeSerially(serialStore, txs, runTestTx)
+
+		speculation := executor.Speculate(parallelStore, txs)
+		var events []txEvent
+		for i, tx := range txs {
+			delivery := speculation.Deliver(parallelStore, tx, 0, applyDelivery)
+			events = append(events, delivery.Effects().events...)
+			require.Equal(t, expectedResults[i], delivery.Result, "block %d tx %d", height, i)
+			require.Equal(t, expectedErrs[i] != nil, delivery.Err != nil, "block %d tx %d", height, i)
+		}
+		require.Equal(t, expectedEffects.events, events, fmt.Sprintf("block %d", height))
+
+		expectedHash, _, err := serialStore.SaveVersion()
+		require.NoError(t, err)
+		hash, _, err := parallelStore.SaveVersion()
+		require.NoError(t, err)
+		require.Equal(t, expectedHash, hash, "block %d", height)
+	}
+}
+
+func TestParallelTxExecutorReExecutesConflictingTxs(t *testing.T) {
+	kvStore := newTestIAVLStore(t)
+	txs := [][]byte{testTx(1, 2, testTxOK), testTx(3, 4, testTxOK), testTx(2, 5, testTxOK), testTx(6, 7, testTxSum)}
+	speculation := NewParallelTxExecutor(2, runTestTx).Speculate(kvStore, txs)
+	for _, tx := range txs {
+		speculation.Deliver(kvStore, tx, 0, applyDelivery)
+	}
+	// the 3rd tx reads the balance written by the 1st, and the 4th reads all the balances
+	require.Equal(t, 2, speculation.NumReExecuted)
+}
+
+func TestParallelTxExecutorRunsSerialMiddlewaresInOrder(t *testing.T) {
+	// The serial middleware isn't safe for concurrent use, and rejects any txs sent by account 9.
+	var seen []byte
+	serial := SerialTxMiddleware(TxMiddlewareFunc(func(
+		state State, txBytes []byte, next TxHandlerFunc, isCheckTx bool,
+	) (TxHandlerResult, error) {
+		seen = append(seen, txBytes[0])
+		if txBytes[0] == 9 {
+			return TxHandlerResult{}, errors.New("throttled")
+		}
+		return next(state, txBytes, isCheckTx)
+	}))
+	handler := MiddlewareTxHandler(
+		[]TxMiddleware{serial},
+		TxHandlerFunc(func(state State, txBytes []byte, isCheckTx bool) (TxHandlerResult, error) {
+			return runTestTx(state, TxEffectsFromContext(state.Context()), txBytes)
+		}),
+		nil,
+	)
+	run := func(kvStore store.KVStore, effects *TxEffects, txBytes []byte) (TxHandlerResult, error) {
+		state := NewStoreState(WithTxEffects(context.Background(), effects), kvStore, abci.Header{}, nil, nil)
+		return handler.ProcessTx(state, txBytes, false)
+	}
+	deliver := func(kvStore store.KVStore, delivery *TxDelivery, txBytes []byte) (TxHandlerResult, error) {
+		state := NewStoreState(WithTxDelivery(context.Background(), delivery), kvStore, abci.Header{}, nil, nil)
+		return handler.ProcessTx(state, txBytes, false)
+	}
+
+	kvStore := newTestIAVLStore(t)
+	txs := [][]byte{testTx(1, 2, testTxOK), testTx(9, 3, testTxOK), testTx(3, 4, testTxOK), testTx(1, 5, testTxOK)}
+	speculation := NewParallelTxExecutor(4, run).Speculate(kvStore, txs)
+	require.Len(t, seen, 0)
+	for i, tx := range txs {
+		delivery := speculation.Deliver(kvStore, tx, 0, deliver)
+		require.Equal(t, i == 1, delivery.Err != nil, "tx %d", i)
+		require.Equal(t, i == 1, delivery.Effects() == nil, "tx %d", i)
+	}
+	require.Equal(t, []byte{1, 9, 3, 1}, seen)
+	// nothing the rejected tx did during its speculative execution should've been committed
+	require.Equal(t, uint64(0), getUint(kvStore, util.PrefixKey(testNoncePrefix, []byte{9})))
+	require.Equal(t, uint64(10), getUint(kvStore, util.PrefixKey(testAccountPrefix, []byte{3})))
+	require.Equal(t, uint64(2), getUint(kvStore, util.PrefixKey(testNoncePrefix, []byte{1})))
+}
+
+func TestSpeculativeStoreRange(t *testing.T) {
+	base := store.NewMemStore()
+	base.Set(util.PrefixKey(testAccountPrefix, []byte("a")), []byte("1"))
+	base.Set(util.PrefixKey(testAccountPrefix, []byte("b")), []byte("2"))
+	base.Set(util.PrefixKey(testAccountPrefix, []byte("c")), []byte("3"))
+	base.Set(util.PrefixKey(testNoncePrefix, []byte("a")), []byte("4"))
+
+	s := newSpeculativeStore(base)
+	s.Delete(util.PrefixKey(testAccountPrefix, []byte("b")))
+	s.Set(util.PrefixKey(testAccountPrefix, []byte("c")), []byte("5"))
+	s.Set(util.PrefixKey(testAccountPrefix, []byte("d")), []byte("6"))
+	s.Set(util.PrefixKey(testNoncePrefix, []byte("b")), []byte("7"))
+
+	entries := s.Range(testAccountPrefix)
+	require.Len(t, entries, 3)
+	require.Equal(t, []byte("a"), entries[0].Key)
+	require.Equal(t, []byte("1"), entries[0].Value)
+	require.Equal(t, []byte("c"), entries[1].Key)
+	require.Equal(t, []byte("5"), entries[1].Value)
+	require.Equal(t, []byte("d"), entries[2].Key)
+	require.Equal(t, []byte("6"), entries[2].Value)
+	// nothing should've been written to the underlying store
+	require.Nil(t, base.Get(util.PrefixKey(testAccountPrefix, []byte("d"))))
+}
@@ -103,7 +103,7 @@ func (c *FakeContextWithEVM) CallEVM(addr loom.Address, input []byte, value *loo
 	if c.useAccountBalanceManager {
 		createABM = c.AccountBalanceManager
 	}
-	vm := levm.NewLoomVm(c.State, nil, nil, createABM, false)
+	vm := levm.NewLoomVm(c.State, nil, nil, createABM, nil, false)
 	return vm.Call(c.ContractAddress(), addr, input, value)
 }
 
@@ -112,7 +112,7 @@ func (c *FakeContextWithEVM) StaticCallEVM(addr loom.Address, input []byte) ([]b
 	if c.useAccountBalanceManager {
 		createABM = c.AccountBalanceManager
 	}
-	vm := levm.NewLoomVm(c.State, nil, nil, createABM, false)
+	vm := levm.NewLoomVm(c.State, nil, nil, createABM, nil, false)
 	return vm.StaticCall(c.ContractAddress(), addr, input)
 }
 
@@ -0,0 +1,32 @@
+package plugin
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/loomnetwork/go-loom"
+	contract "github.com/loomnetwork/go-loom/plugin/contractpb"
+	"github.com/loomnetwork/loomchain/evm"
+)
+
+// GoContractCaller implements the evm.GoContractCaller interface, it allows EVM contracts to call
+// Go contracts via the PluginVM.
+type GoContractCaller struct {
+	vm *PluginVM
+}
+
+var _ evm.GoContractCaller = &GoContractCaller{}
+
+func NewGoContractCaller(vm *PluginVM) *GoContractCaller {
+	return &GoContractCaller{vm: vm}
+}
+
+func (c *GoContractCaller) Resolve(name string) (loom.Address, error) {
+	return c.vm.Registry.Resolve(name)
+}
+
+func (c *GoContractCaller) StaticCallMethod(
+	caller, addr loom.Address, method string, req, resp proto.Message,
+) error {
+	// The Go contract sees the caller as the contract that sent the call.
+	ctx := contract.WrapPluginStaticContext(c.vm.CreateContractContext(caller, caller, true))
+	return contract.StaticCallMethod(ctx, addr, method, req, resp)
+}
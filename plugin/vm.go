@@ -196,7 +196,9 @@ func (vm *PluginVM) CallEVM(caller, addr loom.Address, input []byte, value *loom
 			return nil, err
 		}
 	}
-	evm := levm.NewLoomVm(vm.State, vm.EventHandler, vm.receiptWriter, createABM, false)
+	evm := levm.NewLoomVm(
+		vm.State, vm.EventHandler, vm.receiptWriter, createABM, NewGoContractCaller(vm), false,
+	)
 	return evm.Call(caller, addr, input, value)
 }
 
@@ -209,7 +211,9 @@ func (vm *PluginVM) StaticCallEVM(caller, addr loom.Address, input []byte) ([]by
 			return nil, err
 		}
 	}
-	evm := levm.NewLoomVm(vm.State, vm.EventHandler, vm.receiptWriter, createABM, false)
+	evm := levm.NewLoomVm(
+		vm.State, vm.EventHandler, vm.receiptWriter, createABM, NewGoContractCaller(vm), false,
+	)
 	return evm.StaticCall(caller, addr, input)
 }
 
@@ -317,6 +321,10 @@ func (c *contractContext) EmitTopics(event []byte, topics ...string) {
 		OriginalRequest: c.req.Body,
 	}
 	height := uint64(c.State.Block().Height)
+	if effects := loomchain.TxEffectsFromContext(c.State.Context()); effects != nil {
+		effects.Post(height, &data)
+		return
+	}
 	c.eventHandler.Post(height, &data)
 }
 
@@ -44,6 +44,10 @@ func (eh *fakeEventHandler) Post(height uint64, e *ptypes.EventData) error {
 	return nil
 }
 
+func (eh *fakeEventHandler) SaveBlockEvents(_ uint64, _ time.Time) error {
+	return nil
+}
+
 func (eh *fakeEventHandler) EmitBlockTx(_ uint64, _ time.Time) error {
 	return nil
 }
@@ -136,7 +140,7 @@ func TestPluginVMContractContextCaller(t *testing.T) {
 	require.NoError(t, err)
 
 	vm := NewPluginVM(loader, state, createRegistry(state), &fakeEventHandler{}, nil, nil, nil, nil)
-	evm := levm.NewLoomVm(state, nil, nil, nil, false)
+	evm := levm.NewLoomVm(state, nil, nil, nil, nil, false)
 
 	// Deploy contracts
 	owner := loom.RootAddress("chain")
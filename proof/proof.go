@@ -0,0 +1,129 @@
+// Package proof provides helpers that can be used to verify the Merkle proofs returned by a Loom
+// node offline, so that light clients don't have to trust the node that served the proofs.
+//
+// App store proofs are returned by the /store ABCI query & the querystore RPC endpoint, and are
+// verified against the app hash stored in the header of the block that follows the block the
+// proof was generated for. EVM account & storage proofs are returned by eth_getProof, and are
+// verified against the Patricia root of the EVM state, which can itself be proven against the app
+// hash via an app store proof of EvmRootKey.
+package proof
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/pkg/errors"
+	"github.com/tendermint/iavl"
+	"github.com/tendermint/tendermint/crypto/merkle"
+)
+
+// EvmRootKey is the app store key under which the Patricia root of the EVM state is stored.
+var EvmRootKey = []byte("vmroot")
+
+var proofRuntime = newProofRuntime()
+
+func newProofRuntime() *merkle.ProofRuntime {
+	prt := merkle.NewProofRuntime()
+	prt.RegisterOpDecoder(iavl.ProofOpIAVLValue, iavl.IAVLValueOpDecoder)
+	prt.RegisterOpDecoder(iavl.ProofOpIAVLAbsence, iavl.IAVLAbsenceOpDecoder)
+	return prt
+}
+
+func keyPath(key []byte) string {
+	return merkle.KeyPath{}.AppendKey(key, merkle.KeyEncodingHex).String()
+}
+
+// VerifyValue checks that the given proof proves the given key is set to the given value in the
+// app store with the given app hash.
+func VerifyValue(appHash, key, value []byte, proof *merkle.Proof) error {
+	if proof == nil {
+		return errors.New("missing proof")
+	}
+	if err := proofRuntime.VerifyValue(proof, appHash, keyPath(key), value); err != nil {
+		return errors.Wrapf(err, "invalid proof for key %x", key)
+	}
+	return nil
+}
+
+// VerifyAbsence checks that the given proof proves the given key doesn't exist in the app store
+// with the given app hash.
+func VerifyAbsence(appHash, key []byte, proof *merkle.Proof) error {
+	if proof == nil {
+		return errors.New("missing proof")
+	}
+	if err := proofRuntime.VerifyAbsence(proof, appHash, keyPath(key)); err != nil {
+		return errors.Wrapf(err, "invalid absence proof for key %x", key)
+	}
+	return nil
+}
+
+// Account is the EVM account state stored in the leaves of the EVM state trie.
+type Account struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash // root of the account storage trie
+	CodeHash []byte
+}
+
+// VerifyAccountProof checks the given proof of an account in the EVM state trie with the given
+// root, and returns the account state. If the proof proves the account doesn't exist nil is
+// returned.
+func VerifyAccountProof(stateRoot common.Hash, addr common.Address, proof [][]byte) (*Account, error) {
+	value, _, err := trie.VerifyProof(stateRoot, crypto.Keccak256(addr[:]), newProofNodes(proof))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid proof for account %v", addr.Hex())
+	}
+	if value == nil {
+		return nil, nil
+	}
+	var account Account
+	if err := rlp.DecodeBytes(value, &account); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode account %v", addr.Hex())
+	}
+	return &account, nil
+}
+
+// VerifyStorageProof checks the given proof of a storage slot in the account storage trie with the
+// given root, and returns the value of the slot (with leading zeros stripped). If the proof proves
+// the slot isn't set nil is returned.
+func VerifyStorageProof(storageRoot common.Hash, key common.Hash, proof [][]byte) ([]byte, error) {
+	value, _, err := trie.VerifyProof(storageRoot, crypto.Keccak256(key[:]), newProofNodes(proof))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid proof for storage slot %v", key.Hex())
+	}
+	if value == nil {
+		return nil, nil
+	}
+	_, content, _, err := rlp.Split(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode storage slot %v", key.Hex())
+	}
+	return content, nil
+}
+
+// proofNodes is a trie.DatabaseReader containing the nodes of a Patricia trie proof, keyed by hash.
+type proofNodes map[string][]byte
+
+func newProofNodes(proof [][]byte) proofNodes {
+	nodes := make(proofNodes, len(proof))
+	for _, node := range proof {
+		nodes[string(crypto.Keccak256(node))] = node
+	}
+	return nodes
+}
+
+func (n proofNodes) Get(key []byte) ([]byte, error) {
+	node, ok := n[string(key)]
+	if !ok {
+		return nil, errors.Errorf("proof node %x not found", key)
+	}
+	return node, nil
+}
+
+func (n proofNodes) Has(key []byte) (bool, error) {
+	_, ok := n[string(key)]
+	return ok, nil
+}
@@ -0,0 +1,95 @@
+package proof
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/store"
+)
+
+func TestVerifyAppStoreProofs(t *testing.T) {
+	memDB, _ := db.LoadMemDB()
+	iavlStore, err := store.NewIAVLStore(memDB, 0, 0, 0)
+	require.NoError(t, err)
+	iavlStore.Set([]byte("abc"), []byte("1"))
+	iavlStore.Set([]byte("def"), []byte("2"))
+	iavlStore.Set(EvmRootKey, []byte("root1"))
+	appHash1, version1, err := iavlStore.SaveVersion()
+	require.NoError(t, err)
+	iavlStore.Set([]byte("abc"), []byte("3"))
+	iavlStore.Delete([]byte("def"))
+	appHash2, _, err := iavlStore.SaveVersion()
+	require.NoError(t, err)
+
+	value, proof, err := iavlStore.GetWithProof([]byte("abc"), version1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), value)
+	require.NoError(t, VerifyValue(appHash1, []byte("abc"), value, proof))
+	require.Error(t, VerifyValue(appHash1, []byte("abc"), []byte("3"), proof))
+	require.Error(t, VerifyValue(appHash2, []byte("abc"), value, proof))
+	require.Error(t, VerifyAbsence(appHash1, []byte("abc"), proof))
+
+	value, proof, err = iavlStore.GetWithProof(EvmRootKey, version1)
+	require.NoError(t, err)
+	require.NoError(t, VerifyValue(appHash1, EvmRootKey, []byte("root1"), proof))
+
+	// latest version
+	value, proof, err = iavlStore.GetWithProof([]byte("def"), 0)
+	require.NoError(t, err)
+	require.Nil(t, value)
+	require.NoError(t, VerifyAbsence(appHash2, []byte("def"), proof))
+	require.Error(t, VerifyAbsence(appHash1, []byte("def"), proof))
+	require.Error(t, VerifyValue(appHash2, []byte("def"), []byte("2"), proof))
+}
+
+func TestVerifyEvmStateProofs(t *testing.T) {
+	sdb, err := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+	require.NoError(t, err)
+	addr := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	otherAddr := common.HexToAddress("0x2000000000000000000000000000000000000002")
+	slot := common.HexToHash("0x01")
+	emptySlot := common.HexToHash("0x02")
+	sdb.SetNonce(addr, 5)
+	sdb.SetBalance(addr, big.NewInt(100))
+	sdb.SetState(addr, slot, common.HexToHash("0x2a"))
+	sdb.SetNonce(common.HexToAddress("0x3000000000000000000000000000000000000003"), 1)
+	stateRoot, err := sdb.Commit(true)
+	require.NoError(t, err)
+
+	accountProof, err := sdb.GetProof(addr)
+	require.NoError(t, err)
+	account, err := VerifyAccountProof(stateRoot, addr, accountProof)
+	require.NoError(t, err)
+	require.NotNil(t, account)
+	require.Equal(t, uint64(5), account.Nonce)
+	require.Equal(t, int64(100), account.Balance.Int64())
+	require.Equal(t, sdb.StorageTrie(addr).Hash(), account.Root)
+
+	// the proof shouldn't be valid for other accounts
+	_, err = VerifyAccountProof(stateRoot, otherAddr, accountProof)
+	require.Error(t, err)
+
+	storageProof, err := sdb.GetStorageProof(addr, slot)
+	require.NoError(t, err)
+	value, err := VerifyStorageProof(account.Root, slot, storageProof)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x2a}, value)
+
+	storageProof, err = sdb.GetStorageProof(addr, emptySlot)
+	require.NoError(t, err)
+	value, err = VerifyStorageProof(account.Root, emptySlot, storageProof)
+	require.NoError(t, err)
+	require.Nil(t, value)
+
+	accountProof, err = sdb.GetProof(otherAddr)
+	require.NoError(t, err)
+	account, err = VerifyAccountProof(stateRoot, otherAddr, accountProof)
+	require.NoError(t, err)
+	require.Nil(t, account)
+}
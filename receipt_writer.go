@@ -13,4 +13,9 @@ type WriteReceiptHandler interface {
 		logs []*eth_types.Log, blockHeight int64, caller, contract loom.Address, input []byte,
 	) []*types.EventData
 	CacheReceipt(state State, caller, addr loom.Address, events []*types.EventData, err error) ([]byte, error)
+	// InternalTxIndexingEnabled returns true if the internal txs made by EVM txs should be indexed.
+	InternalTxIndexingEnabled() bool
+	// CacheInternalTxs records the JSON encoded call tree of the internal txs made by the EVM tx
+	// whose receipt was cached last.
+	CacheInternalTxs(state State, internalTxs []byte)
 }
@@ -15,12 +15,22 @@ type ReadReceiptHandler interface {
 	GetPendingReceipt(txHash []byte) (types.EvmTxReceipt, error)
 	GetPendingTxHashList() [][]byte
 	GetCurrentReceipt() *types.EvmTxReceipt
+	// GetRevertData returns the data returned by the EVM when the tx with the given hash failed.
+	GetRevertData(txHash []byte) []byte
+	// GetInternalTxs returns the JSON encoded call tree of the internal txs made by the tx with the
+	// given hash, or nil if the internal txs of the tx weren't indexed.
+	GetInternalTxs(txHash []byte) []byte
 }
 
 type ReceiptHandlerStore interface {
 	CommitBlock(state State, height int64) error
 	CommitCurrentReceipt()
+	// CommitFailedReceipt stores the current receipt in the node-local failed receipts store.
+	CommitFailedReceipt(tmTxHash []byte)
 	DiscardCurrentReceipt()
+	// SetCurrentReceipt replaces the current receipt with one that was created while the tx was
+	// being executed speculatively.
+	SetCurrentReceipt(receipt *types.EvmTxReceipt, revertData, internalTxs []byte)
 	ClearData() error
 	Close() error
 }
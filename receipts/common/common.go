@@ -18,6 +18,36 @@ var (
 	ErrPendingReceiptNotFound = errors.New("Pending receipt not found")
 )
 
+// EvmTxError is passed to WriteReceiptHandler.CacheReceipt when an EVM tx fails, it records the
+// amount of gas consumed by the tx, and any data returned by the EVM (e.g. the revert reason).
+type EvmTxError struct {
+	Err        error
+	GasUsed    uint64
+	RevertData []byte
+}
+
+func (e *EvmTxError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the underlying error, this allows errors.Cause() to unwrap EvmTxError.
+func (e *EvmTxError) Cause() error {
+	return e.Err
+}
+
+// FailedTxReceipt is the receipt of an EVM tx that failed. The receipts of failed txs aren't
+// stored in the app state, instead each node stores them in a node-local store.
+type FailedTxReceipt struct {
+	Receipt *types.EvmTxReceipt
+	// Hash of the Tendermint tx the EVM tx was contained in.
+	TmTxHash []byte
+	// Data returned by the EVM when the tx was reverted.
+	RevertData []byte
+	// JSON encoded call tree of the internal txs made by the tx, only set if internal tx indexing
+	// is enabled.
+	InternalTxs []byte
+}
+
 func BlockHeightToBytes(height uint64) []byte {
 	heightB := make([]byte, 8)
 	binary.LittleEndian.PutUint64(heightB, height)
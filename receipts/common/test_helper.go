@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/loomnetwork/go-loom/plugin/types"
@@ -64,6 +65,13 @@ func MockStateTx(state loomchain.State, height, TxNum uint64) loomchain.State {
 	return loomchain.NewStoreState(context.Background(), state, header, nil, nil)
 }
 
+func MockStateWithTime(height uint64, blockTime time.Time) loomchain.State {
+	header := abci.Header{}
+	header.Height = int64(height)
+	header.Time = blockTime
+	return loomchain.NewStoreState(context.Background(), store.NewMemStore(), header, nil, nil)
+}
+
 func MockStateAt(state loomchain.State, newHeight uint64) loomchain.State {
 	header := abci.Header{}
 	header.Height = int64(newHeight)
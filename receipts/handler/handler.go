@@ -24,12 +24,19 @@ const (
 // ReceiptHandler implements loomchain.ReadReceiptHandler, loomchain.WriteReceiptHandler, and
 // loomchain.ReceiptHandlerStore interfaces.
 type ReceiptHandler struct {
-	eventHandler    loomchain.EventHandler
-	leveldbReceipts *leveldb.LevelDbReceipts
-	mutex           *sync.RWMutex
-	receiptsCache   []*types.EvmTxReceipt
-	txHashList      [][]byte
-	currentReceipt  *types.EvmTxReceipt
+	eventHandler        loomchain.EventHandler
+	leveldbReceipts     *leveldb.LevelDbReceipts
+	failedReceipts      *leveldb.LevelDbFailedReceipts
+	mutex               *sync.RWMutex
+	receiptsCache       []*types.EvmTxReceipt
+	failedReceiptsCache []*common.FailedTxReceipt
+	txHashList          [][]byte
+	currentReceipt      *types.EvmTxReceipt
+	currentRevertData   []byte
+	// Internal txs of the committed receipts in the current block, indexed by receipt tx hash.
+	internalTxsCache   map[string][]byte
+	currentInternalTxs []byte
+	indexInternalTxs   bool
 }
 
 func NewReceiptHandler(
@@ -37,23 +44,76 @@ func NewReceiptHandler(
 	maxReceipts uint64, evmAuxStore *evmaux.EvmAuxStore,
 ) *ReceiptHandler {
 	return &ReceiptHandler{
-		eventHandler:    eventHandler,
-		receiptsCache:   []*types.EvmTxReceipt{},
-		txHashList:      [][]byte{},
-		currentReceipt:  nil,
-		mutex:           &sync.RWMutex{},
-		leveldbReceipts: leveldb.NewLevelDbReceipts(evmAuxStore, maxReceipts),
+		eventHandler:     eventHandler,
+		receiptsCache:    []*types.EvmTxReceipt{},
+		internalTxsCache: map[string][]byte{},
+		txHashList:       [][]byte{},
+		currentReceipt:   nil,
+		mutex:            &sync.RWMutex{},
+		leveldbReceipts:  leveldb.NewLevelDbReceipts(evmAuxStore, maxReceipts),
+		failedReceipts:   leveldb.NewLevelDbFailedReceipts(evmAuxStore, maxReceipts),
 	}
 }
 
+// SetRetentionPolicy changes the policy that determines how long receipts are kept in the receipts
+// DB.
+func (r *ReceiptHandler) SetRetentionPolicy(policy *leveldb.ReceiptRetentionPolicy) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.leveldbReceipts.SetRetentionPolicy(policy)
+}
+
+// SetInternalTxIndexing enables or disables the indexing of the internal txs made by EVM txs.
+func (r *ReceiptHandler) SetInternalTxIndexing(enabled bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.indexInternalTxs = enabled
+}
+
+func (r *ReceiptHandler) InternalTxIndexingEnabled() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.indexInternalTxs
+}
+
+// GetReceipt looks up the receipt of a committed EVM tx, if the tx failed the receipt will be loaded
+// from the node-local failed receipts store.
 func (r *ReceiptHandler) GetReceipt(txHash []byte) (types.EvmTxReceipt, error) {
 	receipt, err := r.leveldbReceipts.GetReceipt(txHash)
 	if err != nil {
-		return receipt, errors.Wrapf(common.ErrTxReceiptNotFound, "GetReceipt: %v", err)
+		failedReceipt, failedErr := r.failedReceipts.GetReceipt(txHash)
+		if failedErr != nil {
+			return receipt, errors.Wrapf(common.ErrTxReceiptNotFound, "GetReceipt: %v", err)
+		}
+		return *failedReceipt.Receipt, nil
 	}
 	return receipt, nil
 }
 
+// GetRevertData returns the data returned by the EVM when the tx with the given hash was reverted,
+// or nil if the tx didn't fail, or its receipt is no longer available.
+func (r *ReceiptHandler) GetRevertData(txHash []byte) []byte {
+	failedReceipt, err := r.failedReceipts.GetReceipt(txHash)
+	if err != nil {
+		return nil
+	}
+	return failedReceipt.RevertData
+}
+
+// GetInternalTxs returns the JSON encoded call tree of the internal txs made by the tx with the
+// given hash, or nil if the internal txs of the tx weren't indexed, or are no longer available.
+func (r *ReceiptHandler) GetInternalTxs(txHash []byte) []byte {
+	internalTxs, err := r.leveldbReceipts.GetInternalTxs(txHash)
+	if err == nil && internalTxs != nil {
+		return internalTxs
+	}
+	failedReceipt, err := r.failedReceipts.GetReceipt(txHash)
+	if err != nil {
+		return nil
+	}
+	return failedReceipt.InternalTxs
+}
+
 func (r *ReceiptHandler) GetPendingReceipt(txHash []byte) (types.EvmTxReceipt, error) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
@@ -99,22 +159,65 @@ func (r *ReceiptHandler) CommitCurrentReceipt() {
 		defer r.mutex.Unlock()
 		r.receiptsCache = append(r.receiptsCache, r.currentReceipt)
 		r.txHashList = append(r.txHashList, r.currentReceipt.TxHash)
+		if len(r.currentInternalTxs) > 0 {
+			r.internalTxsCache[string(r.currentReceipt.TxHash)] = r.currentInternalTxs
+		}
 		r.currentReceipt = nil
+		r.currentRevertData = nil
+		r.currentInternalTxs = nil
 	}
 }
 
+// CommitFailedReceipt marks the current receipt as failed, it will be written to the node-local
+// failed receipts store when the block is committed. The receipt can then be looked up by either
+// its own tx hash, or the hash of the Tendermint tx the EVM tx was contained in.
+func (r *ReceiptHandler) CommitFailedReceipt(tmTxHash []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.currentReceipt != nil {
+		r.currentReceipt.Status = common.StatusTxFail
+		r.failedReceiptsCache = append(r.failedReceiptsCache, &common.FailedTxReceipt{
+			Receipt:     r.currentReceipt,
+			TmTxHash:    tmTxHash,
+			RevertData:  r.currentRevertData,
+			InternalTxs: r.currentInternalTxs,
+		})
+	}
+	r.currentReceipt = nil
+	r.currentRevertData = nil
+	r.currentInternalTxs = nil
+}
+
 func (r *ReceiptHandler) DiscardCurrentReceipt() {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 	r.currentReceipt = nil
+	r.currentRevertData = nil
+	r.currentInternalTxs = nil
+}
+
+func (r *ReceiptHandler) SetCurrentReceipt(receipt *types.EvmTxReceipt, revertData, internalTxs []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.currentReceipt = receipt
+	r.currentRevertData = revertData
+	r.currentInternalTxs = internalTxs
 }
 
 func (r *ReceiptHandler) CommitBlock(state loomchain.State, height int64) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	err := r.leveldbReceipts.CommitBlock(state, r.receiptsCache, uint64(height))
+	err := r.leveldbReceipts.CommitInternalTxs(r.internalTxsCache)
+	if err == nil {
+		err = r.leveldbReceipts.CommitBlock(state, r.receiptsCache, uint64(height))
+	}
+	if err == nil {
+		err = r.failedReceipts.CommitBlock(r.failedReceiptsCache)
+	}
 	r.txHashList = [][]byte{}
 	r.receiptsCache = []*types.EvmTxReceipt{}
+	r.internalTxsCache = map[string][]byte{}
+	r.failedReceiptsCache = nil
 	return err
 }
 
@@ -122,6 +225,10 @@ func (r *ReceiptHandler) CommitBlock(state loomchain.State, height int64) error
 func (r *ReceiptHandler) CacheReceipt(
 	state loomchain.State, caller, addr loom.Address, events []*types.EventData, txErr error,
 ) ([]byte, error) {
+	if effects := loomchain.TxEffectsFromContext(state.Context()); effects != nil {
+		return r.cacheSpeculativeReceipt(effects, state, caller, addr, events, txErr)
+	}
+
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 	var status int32
@@ -137,6 +244,61 @@ func (r *ReceiptHandler) CacheReceipt(
 	if err != nil {
 		return []byte{}, errors.Wrap(err, "receipt not written, returning empty hash")
 	}
+	// gas used is set after the tx hash is computed so it doesn't affect the hash
+	r.currentRevertData = nil
+	r.currentInternalTxs = nil
+	if evmErr, ok := txErr.(*common.EvmTxError); ok {
+		receipt.GasUsed = evmErr.GasUsed
+		r.currentRevertData = evmErr.RevertData
+	}
 	r.currentReceipt = &receipt
 	return r.currentReceipt.TxHash, err
 }
+
+// cacheSpeculativeReceipt records the receipt of a tx that's being executed speculatively in the
+// tx effects, the receipt will only be cached if the tx is committed.
+func (r *ReceiptHandler) cacheSpeculativeReceipt(
+	effects *loomchain.TxEffects,
+	state loomchain.State,
+	caller, addr loom.Address,
+	events []*types.EventData,
+	txErr error,
+) ([]byte, error) {
+	var status int32
+	if txErr == nil {
+		status = common.StatusTxSuccess
+	} else {
+		status = common.StatusTxFail
+	}
+	receipt, err := leveldb.WriteReceipt(
+		state.Block(), caller, addr, events, status,
+		nil, effects.EvmTxIndex, int64(auth.Nonce(state, caller)),
+	)
+	if err != nil {
+		return []byte{}, errors.Wrap(err, "receipt not written, returning empty hash")
+	}
+	height := uint64(receipt.BlockNumber)
+	for _, event := range events {
+		effects.Post(height, event)
+	}
+	effects.RevertData = nil
+	effects.InternalTxs = nil
+	if evmErr, ok := txErr.(*common.EvmTxError); ok {
+		receipt.GasUsed = evmErr.GasUsed
+		effects.RevertData = evmErr.RevertData
+	}
+	effects.Receipt = &receipt
+	return receipt.TxHash, nil
+}
+
+// CacheInternalTxs records the call tree of the internal txs made by the EVM tx whose receipt was
+// cached last, the internal txs will be stored along with the receipt when the block is committed.
+func (r *ReceiptHandler) CacheInternalTxs(state loomchain.State, internalTxs []byte) {
+	if effects := loomchain.TxEffectsFromContext(state.Context()); effects != nil {
+		effects.InternalTxs = internalTxs
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.currentInternalTxs = internalTxs
+}
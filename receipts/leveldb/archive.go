@@ -0,0 +1,245 @@
+package leveldb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/loomnetwork/go-loom/plugin/types"
+	evmaux "github.com/loomnetwork/loomchain/store/evm_aux"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	receiptArchiveFilePrefix = "receipts-"
+	receiptArchiveFileSuffix = ".jsonl.gz"
+	// Number of imported receipts written to the receipts DB in a single batch.
+	receiptImportBatchSize = 1000
+)
+
+// ReceiptArchive writes receipts to gzip compressed JSON lines files, each file contains the receipts
+// from a fixed range of blocks, so the archive rolls over to a new file once the receipts of the
+// last block in the range have been written. Each write is appended to the file as a separate gzip
+// member, so a file that's still being written to can be read at any time. Receipts may be written
+// to the archive more than once if the node crashes before the receipts are deleted from the
+// receipts DB, this is harmless since importing a receipt more than once has no effect.
+type ReceiptArchive struct {
+	dir           string
+	segmentBlocks uint64
+}
+
+// NewReceiptArchive creates an archive that writes to the given directory, each archive file will
+// contain the receipts from segmentBlocks consecutive blocks.
+func NewReceiptArchive(dir string, segmentBlocks uint64) (*ReceiptArchive, error) {
+	if segmentBlocks == 0 {
+		return nil, errors.New("receipt archive segment size must be greater than zero")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create receipt archive dir")
+	}
+	return &ReceiptArchive{dir: dir, segmentBlocks: segmentBlocks}, nil
+}
+
+// Write appends the given receipts to the archive, receipts must be ordered by block height.
+func (a *ReceiptArchive) Write(receipts []*types.EvmTxReceipt) error {
+	for len(receipts) > 0 {
+		segment := a.segmentPath(uint64(receipts[0].BlockNumber))
+		n := 1
+		for n < len(receipts) && a.segmentPath(uint64(receipts[n].BlockNumber)) == segment {
+			n++
+		}
+		if err := appendReceiptsToFile(segment, receipts[:n]); err != nil {
+			return errors.Wrapf(err, "failed to write receipts to %s", segment)
+		}
+		receipts = receipts[n:]
+	}
+	return nil
+}
+
+func (a *ReceiptArchive) segmentPath(height uint64) string {
+	first := (height / a.segmentBlocks) * a.segmentBlocks
+	last := first + a.segmentBlocks - 1
+	return filepath.Join(
+		a.dir, fmt.Sprintf("%s%012d-%012d%s", receiptArchiveFilePrefix, first, last, receiptArchiveFileSuffix),
+	)
+}
+
+func appendReceiptsToFile(path string, receipts []*types.EvmTxReceipt) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+	for _, receipt := range receipts {
+		if err := enc.Encode(receipt); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ReceiptArchiveFiles returns the paths of the archive files in the given directory, ordered by the
+// block range they cover. If path is a file it's returned as is.
+func ReceiptArchiveFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(path, receiptArchiveFilePrefix+"*"+receiptArchiveFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// ReadReceiptArchive calls fn with each receipt in the given archive file.
+func ReadReceiptArchive(path string, fn func(receipt *types.EvmTxReceipt) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", path)
+	}
+	defer zr.Close()
+	r := bufio.NewReader(zr)
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return errors.Wrapf(err, "failed to read %s", path)
+		}
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var receipt types.EvmTxReceipt
+			if jsonErr := json.Unmarshal(line, &receipt); jsonErr != nil {
+				return errors.Wrapf(jsonErr, "invalid receipt on line %d of %s", lineNum, path)
+			}
+			if fnErr := fn(&receipt); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// ReceiptImportStats summarizes the results of ImportReceiptArchive.
+type ReceiptImportStats struct {
+	// Number of receipts written to the receipts DB.
+	Imported uint64
+	// Number of receipts that were already in the receipts DB.
+	Skipped uint64
+	// Number of blocks whose tx hash list & bloom filter were restored.
+	Blocks uint64
+}
+
+// ImportReceiptArchive writes the receipts in the given archive file back to the receipts DB, so they
+// can be looked up by tx hash again. Receipts that are already in the DB are left as is. The
+// imported receipts aren't linked into the list of recent receipts, so they won't be deleted by the
+// retention policy or the max receipts limit. The tx hash list & bloom filter of each block are
+// restored from the imported receipts if they're missing.
+func ImportReceiptArchive(evmAuxStore *evmaux.EvmAuxStore, path string) (*ReceiptImportStats, error) {
+	stats := &ReceiptImportStats{}
+	db := evmAuxStore.DB()
+	batch := new(leveldb.Batch)
+	flush := func() error {
+		if batch.Len() == 0 {
+			return nil
+		}
+		if err := db.Write(batch, nil); err != nil {
+			return errors.Wrap(err, "failed to write receipts")
+		}
+		batch.Reset()
+		return nil
+	}
+
+	var height uint64
+	var blockReceipts []*types.EvmTxReceipt
+	// receipts that were archived more than once are usually next to each other
+	seen := map[string]bool{}
+	restoreBlockIndex := func() error {
+		if len(blockReceipts) == 0 {
+			return nil
+		}
+		hasIndex, err := evmAuxStore.HasTxHashList(height)
+		if err != nil {
+			return err
+		}
+		if !hasIndex {
+			if err := rebuildBlockIndex(evmAuxStore, height, blockReceipts, nil); err != nil {
+				return err
+			}
+			stats.Blocks++
+		}
+		blockReceipts = nil
+		seen = map[string]bool{}
+		return nil
+	}
+
+	err := ReadReceiptArchive(path, func(receipt *types.EvmTxReceipt) error {
+		if len(receipt.TxHash) == 0 || receipt.BlockNumber < 0 {
+			return nil
+		}
+		if uint64(receipt.BlockNumber) != height {
+			if err := restoreBlockIndex(); err != nil {
+				return err
+			}
+			height = uint64(receipt.BlockNumber)
+		}
+		if seen[string(receipt.TxHash)] {
+			return nil
+		}
+		seen[string(receipt.TxHash)] = true
+		blockReceipts = append(blockReceipts, receipt)
+
+		exists, err := db.Has(receipt.TxHash, nil)
+		if err != nil {
+			return err
+		}
+		if exists {
+			stats.Skipped++
+			return nil
+		}
+		item, err := proto.Marshal(&types.EvmTxReceiptListItem{Receipt: receipt})
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal receipt")
+		}
+		batch.Put(receipt.TxHash, item)
+		stats.Imported++
+		if batch.Len() >= receiptImportBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	if err := flush(); err != nil {
+		return stats, err
+	}
+	return stats, restoreBlockIndex()
+}
@@ -0,0 +1,208 @@
+package leveldb
+
+import (
+	"encoding/binary"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/receipts/common"
+	evmaux "github.com/loomnetwork/loomchain/store/evm_aux"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+var (
+	failedReceiptPrefix    = []byte("fr") // receipt tx hash -> receipt
+	failedRevertDataPrefix = []byte("fd") // receipt tx hash -> revert data
+	failedInternalTxPrefix = []byte("fi") // receipt tx hash -> internal txs
+	failedTmTxHashPrefix   = []byte("ft") // tendermint tx hash -> receipt tx hash
+	failedSeqPrefix        = []byte("fs") // sequence number -> [receipt tx hash, tendermint tx hash]
+
+	failedHeadKey = []byte("leveldb:failed:head")
+	failedTailKey = []byte("leveldb:failed:tail")
+)
+
+func failedSeqKey(seq uint64) []byte {
+	seqB := make([]byte, 8)
+	binary.BigEndian.PutUint64(seqB, seq)
+	return util.PrefixKey(failedSeqPrefix, seqB)
+}
+
+// LevelDbFailedReceipts stores the receipts of failed EVM txs in the node-local receipts DB.
+// Failed txs don't modify the app state, so their receipts only exist on the node that executed
+// them. Receipts are stored in the order they're committed, and once the number of stored
+// receipts exceeds MaxDbSize the oldest receipts are deleted.
+type LevelDbFailedReceipts struct {
+	MaxDbSize   uint64
+	evmAuxStore *evmaux.EvmAuxStore
+}
+
+func NewLevelDbFailedReceipts(evmAuxStore *evmaux.EvmAuxStore, maxReceipts uint64) *LevelDbFailedReceipts {
+	return &LevelDbFailedReceipts{
+		MaxDbSize:   maxReceipts,
+		evmAuxStore: evmAuxStore,
+	}
+}
+
+// GetReceipt looks up a failed tx receipt by either the tx hash in the receipt, or the hash of the
+// Tendermint tx the EVM tx was contained in.
+func (fr *LevelDbFailedReceipts) GetReceipt(txHash []byte) (*common.FailedTxReceipt, error) {
+	db := fr.evmAuxStore.DB()
+	var tmTxHash []byte
+	receiptHash, err := db.Get(util.PrefixKey(failedTmTxHashPrefix, txHash), nil)
+	if err == leveldb.ErrNotFound {
+		receiptHash = txHash
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "get failed receipt hash for %x", txHash)
+	} else {
+		tmTxHash = txHash
+	}
+
+	receiptProto, err := db.Get(util.PrefixKey(failedReceiptPrefix, receiptHash), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get failed receipt for %x", txHash)
+	}
+	receipt := types.EvmTxReceipt{}
+	if err := proto.Unmarshal(receiptProto, &receipt); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal failed receipt for %x", txHash)
+	}
+	revertData, err := db.Get(util.PrefixKey(failedRevertDataPrefix, receiptHash), nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return nil, errors.Wrapf(err, "get revert data for %x", txHash)
+	}
+	internalTxs, err := db.Get(util.PrefixKey(failedInternalTxPrefix, receiptHash), nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return nil, errors.Wrapf(err, "get internal txs for %x", txHash)
+	}
+	return &common.FailedTxReceipt{
+		Receipt:     &receipt,
+		TmTxHash:    tmTxHash,
+		RevertData:  revertData,
+		InternalTxs: internalTxs,
+	}, nil
+}
+
+// CommitBlock persists the receipts of the failed txs in a block, and deletes the oldest receipts
+// if the store has grown beyond its maximum size.
+func (fr *LevelDbFailedReceipts) CommitBlock(receipts []*common.FailedTxReceipt) error {
+	if len(receipts) == 0 {
+		return nil
+	}
+
+	db := fr.evmAuxStore.DB()
+	head, err := getUint64(db, failedHeadKey)
+	if err != nil {
+		return errors.Wrap(err, "get failed receipts head")
+	}
+	tail, err := getUint64(db, failedTailKey)
+	if err != nil {
+		return errors.Wrap(err, "get failed receipts tail")
+	}
+
+	tran, err := db.OpenTransaction()
+	if err != nil {
+		return errors.Wrap(err, "opening leveldb transaction")
+	}
+	defer tran.Discard()
+
+	for _, receipt := range receipts {
+		if receipt == nil || receipt.Receipt == nil || len(receipt.Receipt.TxHash) == 0 {
+			continue
+		}
+		receiptHash := receipt.Receipt.TxHash
+		receiptProto, err := proto.Marshal(receipt.Receipt)
+		if err != nil {
+			return errors.Wrap(err, "marshal failed receipt")
+		}
+		if err := tran.Put(util.PrefixKey(failedReceiptPrefix, receiptHash), receiptProto, nil); err != nil {
+			return errors.Wrap(err, "put failed receipt")
+		}
+		if len(receipt.RevertData) > 0 {
+			if err := tran.Put(util.PrefixKey(failedRevertDataPrefix, receiptHash), receipt.RevertData, nil); err != nil {
+				return errors.Wrap(err, "put revert data")
+			}
+		}
+		if len(receipt.InternalTxs) > 0 {
+			if err := tran.Put(util.PrefixKey(failedInternalTxPrefix, receiptHash), receipt.InternalTxs, nil); err != nil {
+				return errors.Wrap(err, "put internal txs")
+			}
+		}
+		if len(receipt.TmTxHash) > 0 {
+			if err := tran.Put(util.PrefixKey(failedTmTxHashPrefix, receipt.TmTxHash), receiptHash, nil); err != nil {
+				return errors.Wrap(err, "put tendermint tx hash")
+			}
+		}
+		hashes, err := proto.Marshal(&types.EthTxHashList{EthTxHash: [][]byte{receiptHash, receipt.TmTxHash}})
+		if err != nil {
+			return errors.Wrap(err, "marshal failed receipt hashes")
+		}
+		if err := tran.Put(failedSeqKey(tail), hashes, nil); err != nil {
+			return errors.Wrap(err, "put failed receipt hashes")
+		}
+		tail++
+	}
+
+	for ; tail-head > fr.MaxDbSize; head++ {
+		if err := removeFailedReceipt(tran, head); err != nil {
+			return errors.Wrapf(err, "remove failed receipt %d", head)
+		}
+	}
+
+	if err := putUint64(tran, failedHeadKey, head); err != nil {
+		return errors.Wrap(err, "saving failed receipts head")
+	}
+	if err := putUint64(tran, failedTailKey, tail); err != nil {
+		return errors.Wrap(err, "saving failed receipts tail")
+	}
+	if err := tran.Commit(); err != nil {
+		return errors.Wrap(err, "committing level db transaction")
+	}
+	return nil
+}
+
+func removeFailedReceipt(tran *leveldb.Transaction, seq uint64) error {
+	hashesProto, err := tran.Get(failedSeqKey(seq), nil)
+	if err != nil {
+		return err
+	}
+	hashes := types.EthTxHashList{}
+	if err := proto.Unmarshal(hashesProto, &hashes); err != nil {
+		return err
+	}
+	if len(hashes.EthTxHash) != 2 {
+		return errors.Errorf("invalid failed receipt hashes %v", hashes.EthTxHash)
+	}
+	receiptHash, tmTxHash := hashes.EthTxHash[0], hashes.EthTxHash[1]
+	if err := tran.Delete(util.PrefixKey(failedReceiptPrefix, receiptHash), nil); err != nil {
+		return err
+	}
+	if err := tran.Delete(util.PrefixKey(failedRevertDataPrefix, receiptHash), nil); err != nil {
+		return err
+	}
+	if err := tran.Delete(util.PrefixKey(failedInternalTxPrefix, receiptHash), nil); err != nil {
+		return err
+	}
+	if len(tmTxHash) > 0 {
+		if err := tran.Delete(util.PrefixKey(failedTmTxHashPrefix, tmTxHash), nil); err != nil {
+			return err
+		}
+	}
+	return tran.Delete(failedSeqKey(seq), nil)
+}
+
+func getUint64(db *leveldb.DB, key []byte) (uint64, error) {
+	valB, err := db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(valB), nil
+}
+
+func putUint64(tran *leveldb.Transaction, key []byte, val uint64) error {
+	valB := make([]byte, 8)
+	binary.BigEndian.PutUint64(valB, val)
+	return tran.Put(key, valB, nil)
+}
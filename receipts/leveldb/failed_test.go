@@ -0,0 +1,66 @@
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/loomnetwork/loomchain/receipts/common"
+	"github.com/stretchr/testify/require"
+)
+
+func makeDummyFailedReceipts(t *testing.T, num, block uint64) []*common.FailedTxReceipt {
+	var failed []*common.FailedTxReceipt
+	for i, receipt := range common.MakeDummyReceipts(t, num, block) {
+		receipt.Status = common.StatusTxFail
+		receipt.GasUsed = uint64(100 + i)
+		failed = append(failed, &common.FailedTxReceipt{
+			Receipt:     receipt,
+			TmTxHash:    []byte(fmt.Sprintf("tmtx:%d:%d", block, i)),
+			RevertData:  []byte(fmt.Sprintf("revert:%d:%d", block, i)),
+			InternalTxs: []byte(fmt.Sprintf(`{"type":"CALL","calls":[{"type":"CALL","gas":%d}]}`, i)),
+		})
+	}
+	return failed
+}
+
+func TestFailedReceiptsCyclicDB(t *testing.T) {
+	evmAuxStore, err := common.NewMockEvmAuxStore()
+	require.NoError(t, err)
+	defer evmAuxStore.ClearData()
+
+	maxSize := uint64(10)
+	store := NewLevelDbFailedReceipts(evmAuxStore, maxSize)
+
+	receipts1 := makeDummyFailedReceipts(t, 6, 1)
+	require.NoError(t, store.CommitBlock(receipts1))
+	receipts2 := makeDummyFailedReceipts(t, 6, 2)
+	require.NoError(t, store.CommitBlock(receipts2))
+
+	// the two oldest receipts should've been pruned
+	for _, expected := range receipts1[:2] {
+		_, err := store.GetReceipt(expected.Receipt.TxHash)
+		require.Error(t, err)
+		_, err = store.GetReceipt(expected.TmTxHash)
+		require.Error(t, err)
+	}
+
+	for _, expected := range append(receipts1[2:], receipts2...) {
+		// lookup by receipt tx hash
+		actual, err := store.GetReceipt(expected.Receipt.TxHash)
+		require.NoError(t, err)
+		require.Equal(t, expected.Receipt.TxHash, actual.Receipt.TxHash)
+		require.Equal(t, common.StatusTxFail, actual.Receipt.Status)
+		require.Equal(t, expected.Receipt.GasUsed, actual.Receipt.GasUsed)
+		require.Equal(t, expected.RevertData, actual.RevertData)
+		require.Equal(t, expected.InternalTxs, actual.InternalTxs)
+
+		// lookup by tendermint tx hash
+		actual, err = store.GetReceipt(expected.TmTxHash)
+		require.NoError(t, err)
+		require.Equal(t, expected.Receipt.TxHash, actual.Receipt.TxHash)
+		require.Equal(t, expected.TmTxHash, actual.TmTxHash)
+		require.Equal(t, expected.RevertData, actual.RevertData)
+	}
+
+	require.NoError(t, evmAuxStore.Close())
+}
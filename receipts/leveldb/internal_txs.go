@@ -0,0 +1,38 @@
+package leveldb
+
+import (
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+var internalTxPrefix = []byte("it") // receipt tx hash -> internal txs
+
+// GetInternalTxs returns the JSON encoded call tree of the internal txs made by the tx with the
+// given hash, or nil if the internal txs of the tx weren't indexed.
+func (lr *LevelDbReceipts) GetInternalTxs(txHash []byte) ([]byte, error) {
+	internalTxs, err := lr.evmAuxStore.DB().Get(util.PrefixKey(internalTxPrefix, txHash), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "get internal txs for %x", txHash)
+	}
+	return internalTxs, nil
+}
+
+// CommitInternalTxs stores the call trees of the internal txs made by the txs in a block, indexed
+// by the tx hash in the receipt of each tx. This should be called before the receipts in the block
+// are committed, the internal txs are deleted along with the receipts when the receipts expire.
+func (lr *LevelDbReceipts) CommitInternalTxs(internalTxs map[string][]byte) error {
+	if len(internalTxs) == 0 {
+		return nil
+	}
+	batch := new(leveldb.Batch)
+	for txHash, callTree := range internalTxs {
+		batch.Put(util.PrefixKey(internalTxPrefix, []byte(txHash)), callTree)
+	}
+	if err := lr.evmAuxStore.DB().Write(batch, nil); err != nil {
+		return errors.Wrap(err, "put internal txs")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package leveldb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/loomnetwork/loomchain/receipts/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInternalTxs(t *testing.T) {
+	evmAuxStore, err := common.NewMockEvmAuxStore()
+	require.NoError(t, err)
+	defer evmAuxStore.ClearData()
+
+	maxSize := uint64(10)
+	handler := NewLevelDbReceipts(evmAuxStore, maxSize)
+
+	commitBlock := func(height uint64, numReceipts uint64) map[string][]byte {
+		receipts := common.MakeDummyReceipts(t, numReceipts, height)
+		internalTxs := map[string][]byte{}
+		// the internal txs of every other tx are indexed
+		for i := 0; i < len(receipts); i += 2 {
+			internalTxs[string(receipts[i].TxHash)] = []byte(fmt.Sprintf(`{"type":"CALL","gas":%d}`, i))
+		}
+		require.NoError(t, handler.CommitInternalTxs(internalTxs))
+		require.NoError(t, handler.CommitBlock(common.MockState(height), receipts, height))
+		return internalTxs
+	}
+
+	block1 := commitBlock(1, 6)
+	for txHash, expected := range block1 {
+		actual, err := handler.GetInternalTxs([]byte(txHash))
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+	}
+	internalTxs, err := handler.GetInternalTxs([]byte("unknown tx"))
+	require.NoError(t, err)
+	require.Nil(t, internalTxs)
+
+	// the internal txs of pruned receipts should be deleted along with the receipts
+	block2 := commitBlock(2, 10)
+	for txHash := range block1 {
+		internalTxs, err := handler.GetInternalTxs([]byte(txHash))
+		require.NoError(t, err)
+		require.Nil(t, internalTxs)
+	}
+	for txHash, expected := range block2 {
+		actual, err := handler.GetInternalTxs([]byte(txHash))
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+	}
+}
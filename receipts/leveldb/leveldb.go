@@ -9,6 +9,7 @@ import (
 	"github.com/loomnetwork/go-loom"
 	"github.com/loomnetwork/go-loom/plugin/types"
 	loom_types "github.com/loomnetwork/go-loom/types"
+	"github.com/loomnetwork/go-loom/util"
 	"github.com/loomnetwork/loomchain"
 	"github.com/loomnetwork/loomchain/eth/bloom"
 	"github.com/loomnetwork/loomchain/log"
@@ -85,6 +86,7 @@ type LevelDbReceipts struct {
 	MaxDbSize   uint64
 	evmAuxStore *evmaux.EvmAuxStore
 	tran        *leveldb.Transaction
+	retention   *ReceiptRetentionPolicy
 }
 
 func NewLevelDbReceipts(evmAuxStore *evmaux.EvmAuxStore, maxReceipts uint64) *LevelDbReceipts {
@@ -189,16 +191,54 @@ func (lr *LevelDbReceipts) CommitBlock(state loomchain.State, receipts []*types.
 		}
 	}
 
+	var numToRemove uint64
 	if lr.MaxDbSize < size {
-		var numDeleted uint64
-		headHash, numDeleted, err = removeOldEntries(lr.tran, headHash, size-lr.MaxDbSize)
+		numToRemove = size - lr.MaxDbSize
+	}
+	blockTime := state.Block().Time
+	if lr.retention != nil && lr.retention.MaxAge > 0 {
+		if err := lr.evmAuxStore.SetBlockTime(lr.tran, height, blockTime); err != nil {
+			return errors.Wrap(err, "set block time")
+		}
+	}
+	expiredHeight, expiredTimes, err := lr.expiredHeight(lr.tran, height, blockTime)
+	if err != nil {
+		return err
+	}
+	if numToRemove > 0 || expiredHeight > 0 {
+		keys, oldReceipts, newHeadHash, err := findOldEntries(lr.tran, headHash, numToRemove, expiredHeight)
 		if err != nil {
 			return errors.Wrap(err, "removing old receipts")
 		}
-		if size < numDeleted {
-			return errors.Wrap(err, "invalid count of deleted receipts")
+		// If the receipts can't be archived they're kept until the next block is committed, the
+		// node shouldn't stop just because the archive is unavailable.
+		archived := true
+		if lr.retention != nil && lr.retention.Archive != nil && len(oldReceipts) > 0 {
+			if err := lr.retention.Archive.Write(oldReceipts); err != nil {
+				log.Error("Failed to archive receipts, receipts won't be deleted", "err", err)
+				archived = false
+			}
+		}
+		if archived {
+			for _, key := range keys {
+				if err := lr.tran.Delete(key, nil); err != nil {
+					return errors.Wrap(err, "removing old receipts")
+				}
+				if err := lr.tran.Delete(util.PrefixKey(internalTxPrefix, key), nil); err != nil {
+					return errors.Wrap(err, "removing old internal txs")
+				}
+			}
+			for _, h := range expiredTimes {
+				if err := lr.evmAuxStore.DeleteBlockTime(lr.tran, h); err != nil {
+					return errors.Wrap(err, "removing block times")
+				}
+			}
+			size -= uint64(len(keys))
+			headHash = newHeadHash
+			if len(headHash) == 0 {
+				tailHash = nil
+			}
 		}
-		size -= numDeleted
 	}
 	if err := setDBParams(lr.tran, size, headHash, tailHash); err != nil {
 		return errors.Wrap(err, "saving receipt db params")
@@ -230,28 +270,6 @@ func (lr *LevelDbReceipts) closeTransaction() {
 	}
 }
 
-func removeOldEntries(tran *leveldb.Transaction, head []byte, number uint64) ([]byte, uint64, error) {
-	itemsDeleted := uint64(0)
-	for i := uint64(0); i < number && len(head) > 0; i++ {
-		headItem, err := tran.Get(head, nil)
-		if err != nil {
-			return head, itemsDeleted, errors.Wrapf(err, "get head %s", string(head))
-		}
-		txHeadReceiptItem := types.EvmTxReceiptListItem{}
-		if err := proto.Unmarshal(headItem, &txHeadReceiptItem); err != nil {
-			return head, itemsDeleted, errors.Wrapf(err, "unmarshal head %s", string(headItem))
-		}
-		tran.Delete(head, nil)
-		itemsDeleted++
-		head = txHeadReceiptItem.NextTxHash
-	}
-	if itemsDeleted < number {
-		return head, itemsDeleted, errors.Errorf("Unable to delete %v receipts, only %v deleted", number, itemsDeleted)
-	}
-
-	return head, itemsDeleted, nil
-}
-
 func getDBParams(db *evmaux.EvmAuxStore) (size uint64, head, tail []byte, err error) {
 	notEmpty, err := db.DB().Has(currentDbSizeKey, nil)
 	if err != nil {
@@ -0,0 +1,102 @@
+package leveldb
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/loomchain/eth/bloom"
+	"github.com/loomnetwork/loomchain/receipts/common"
+	evmaux "github.com/loomnetwork/loomchain/store/evm_aux"
+	"github.com/pkg/errors"
+)
+
+// RebuildEvmAuxIndex regenerates the tx hash list & bloom filter of each block in the given height
+// range (inclusive) from the receipts stored in the receipts DB. Only blocks that still have
+// receipts in the DB can be reindexed, the indexes of blocks whose receipts have been pruned are
+// left as is. The onBlock callback is invoked after the indexes of each block are written, if it
+// returns an error reindexing stops. Rebuilding the indexes of a block more than once produces the
+// same result, so an interrupted rebuild can be restarted from any height.
+func RebuildEvmAuxIndex(
+	evmAuxStore *evmaux.EvmAuxStore, fromHeight, toHeight uint64, onBlock func(height uint64) error,
+) error {
+	size, next, _, err := getDBParams(evmAuxStore)
+	if err != nil {
+		return errors.Wrap(err, "failed to load receipts DB params")
+	}
+
+	// receipts are appended to the list in the order they're committed, so the receipts of each
+	// block are next to each other and ordered by height
+	var height uint64
+	var receipts []*types.EvmTxReceipt
+	for i := uint64(0); i < size && len(next) > 0; i++ {
+		data, err := evmAuxStore.DB().Get(next, nil)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load receipt %x", next)
+		}
+		var item types.EvmTxReceiptListItem
+		if err := proto.Unmarshal(data, &item); err != nil {
+			return errors.Wrapf(err, "failed to unmarshal receipt %x", next)
+		}
+		next = item.NextTxHash
+		if item.Receipt == nil || item.Receipt.BlockNumber < 0 {
+			continue
+		}
+
+		receiptHeight := uint64(item.Receipt.BlockNumber)
+		if receiptHeight != height && len(receipts) > 0 {
+			if err := rebuildBlockIndex(evmAuxStore, height, receipts, onBlock); err != nil {
+				return err
+			}
+			receipts = nil
+		}
+		if receiptHeight > toHeight {
+			return nil
+		}
+		height = receiptHeight
+		if height >= fromHeight {
+			receipts = append(receipts, item.Receipt)
+		}
+	}
+	if len(receipts) > 0 {
+		return rebuildBlockIndex(evmAuxStore, height, receipts, onBlock)
+	}
+	return nil
+}
+
+// rebuildBlockIndex writes the tx hash list & bloom filter of a single block, the same way
+// CommitBlock does.
+func rebuildBlockIndex(
+	evmAuxStore *evmaux.EvmAuxStore, height uint64, receipts []*types.EvmTxReceipt,
+	onBlock func(height uint64) error,
+) error {
+	var txHashes [][]byte
+	var events []*types.EventData
+	for _, receipt := range receipts {
+		if len(receipt.TxHash) == 0 {
+			continue
+		}
+		if receipt.Status == common.StatusTxSuccess {
+			txHashes = append(txHashes, receipt.TxHash)
+		}
+		events = append(events, receipt.Logs...)
+	}
+
+	tran, err := evmAuxStore.DB().OpenTransaction()
+	if err != nil {
+		return errors.Wrap(err, "opening leveldb transaction")
+	}
+	if err := evmAuxStore.SetTxHashList(tran, txHashes, height); err != nil {
+		tran.Discard()
+		return errors.Wrapf(err, "failed to set tx hash list at height %d", height)
+	}
+	if err := evmAuxStore.SetBloomFilter(tran, bloom.GenBloomFilter(events), height); err != nil {
+		tran.Discard()
+		return errors.Wrapf(err, "failed to set bloom filter at height %d", height)
+	}
+	if err := tran.Commit(); err != nil {
+		return errors.Wrap(err, "committing level db transaction")
+	}
+	if onBlock != nil {
+		return onBlock(height)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	loom "github.com/loomnetwork/go-loom"
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/receipts/common"
+	evmaux "github.com/loomnetwork/loomchain/store/evm_aux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildEvmAuxIndex(t *testing.T) {
+	evmAuxStore, err := common.NewMockEvmAuxStore()
+	require.NoError(t, err)
+	defer evmAuxStore.ClearData()
+	handler := NewLevelDbReceipts(evmAuxStore, 100)
+	defer handler.Close()
+
+	contract := loom.MustParseAddress("default:0x5cecd1f7261e1f4c684e297be3edf03b825e01c4")
+	state := common.MockState(1)
+	for height := uint64(1); height <= 5; height++ {
+		// no EVM txs in block 3
+		if height == 3 {
+			continue
+		}
+		var receipts []*types.EvmTxReceipt
+		for i := uint64(0); i < height; i++ {
+			receipts = append(receipts, common.MakeDummyReceipt(t, height, i, []*types.EventData{
+				{
+					BlockHeight: height,
+					Address:     contract.MarshalPB(),
+					Topics:      []string{fmt.Sprintf("topic%d", i)},
+				},
+			}))
+		}
+		receipts[0].Status = common.StatusTxFail
+		require.NoError(t, handler.CommitBlock(common.MockStateAt(state, height), receipts, height))
+	}
+
+	blooms := map[uint64][]byte{}
+	txHashes := map[uint64][][]byte{}
+	for height := uint64(1); height <= 5; height++ {
+		blooms[height] = evmAuxStore.GetBloomFilter(height)
+		txHashes[height], err = evmAuxStore.GetTxHashList(height)
+		require.NoError(t, err)
+	}
+	require.Len(t, txHashes[5], 4)
+	require.Nil(t, blooms[3])
+
+	// wipe the indexes
+	for height := uint64(1); height <= 5; height++ {
+		require.NoError(t, evmAuxStore.DB().Delete(util.PrefixKey(evmaux.BloomPrefix, heightKey(height)), nil))
+		require.NoError(t, evmAuxStore.DB().Delete(util.PrefixKey(evmaux.TxHashPrefix, heightKey(height)), nil))
+	}
+
+	var reindexed []uint64
+	onBlock := func(height uint64) error {
+		reindexed = append(reindexed, height)
+		return nil
+	}
+	require.NoError(t, RebuildEvmAuxIndex(evmAuxStore, 2, 4, onBlock))
+	require.Equal(t, []uint64{2, 4}, reindexed)
+	require.Nil(t, evmAuxStore.GetBloomFilter(1))
+	require.Nil(t, evmAuxStore.GetBloomFilter(5))
+
+	// rebuilding the same blocks again shouldn't change anything
+	reindexed = nil
+	require.NoError(t, RebuildEvmAuxIndex(evmAuxStore, 1, 10, onBlock))
+	require.Equal(t, []uint64{1, 2, 4, 5}, reindexed)
+	for height := uint64(1); height <= 5; height++ {
+		require.Equal(t, blooms[height], evmAuxStore.GetBloomFilter(height))
+		hashes, err := evmAuxStore.GetTxHashList(height)
+		require.NoError(t, err)
+		require.Equal(t, len(txHashes[height]), len(hashes))
+		for i := range hashes {
+			require.Equal(t, txHashes[height][i], hashes[i])
+		}
+	}
+}
+
+func heightKey(height uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, height)
+	return key
+}
@@ -0,0 +1,128 @@
+package leveldb
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// ReceiptRetentionConfig specifies how long receipts are kept in the receipts DB, in addition to the
+// overall limit on the number of receipts (EVMPersistentTxReceiptsMax).
+type ReceiptRetentionConfig struct {
+	// Receipts of txs in blocks more than this many blocks behind the latest block are deleted, zero
+	// means receipts won't be deleted based on their block height.
+	RetainBlocks uint64
+	// Receipts of txs in blocks that are more than this many seconds older than the latest block are
+	// deleted, zero means receipts won't be deleted based on their age.
+	RetainSeconds int64
+	// Export receipts to archive files before they're deleted, this includes receipts that are
+	// deleted due to the EVMPersistentTxReceiptsMax limit.
+	ArchiveEnabled bool
+	// Directory the archive files are written to, relative paths are relative to the node root dir.
+	ArchiveDir string
+	// Number of blocks covered by each archive file.
+	ArchiveSegmentBlocks uint64
+}
+
+func DefaultReceiptRetentionConfig() *ReceiptRetentionConfig {
+	return &ReceiptRetentionConfig{
+		ArchiveDir:           "receipts_archive",
+		ArchiveSegmentBlocks: 100000,
+	}
+}
+
+// Clone returns a deep clone of the config.
+func (c *ReceiptRetentionConfig) Clone() *ReceiptRetentionConfig {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	return &clone
+}
+
+// ReceiptRetentionPolicy determines which receipts are deleted when a block is committed.
+type ReceiptRetentionPolicy struct {
+	// Receipts in blocks more than this many blocks behind the latest block are deleted.
+	MaxBlocks uint64
+	// Receipts in blocks more than this many seconds older than the latest block are deleted.
+	MaxAge int64
+	// If set receipts are exported to this archive before they're deleted.
+	Archive *ReceiptArchive
+}
+
+// SetRetentionPolicy changes the retention policy, receipts that have expired will be deleted the
+// next time a block is committed.
+func (lr *LevelDbReceipts) SetRetentionPolicy(policy *ReceiptRetentionPolicy) {
+	lr.retention = policy
+}
+
+// expiredHeight returns the height of the most recent block whose receipts have expired due to the
+// retention policy, along with the heights of the recorded block times that have expired.
+func (lr *LevelDbReceipts) expiredHeight(
+	tran *leveldb.Transaction, height uint64, blockTime int64,
+) (uint64, []uint64, error) {
+	policy := lr.retention
+	if policy == nil {
+		return 0, nil, nil
+	}
+	var expiredHeight uint64
+	if policy.MaxBlocks > 0 && height > policy.MaxBlocks {
+		expiredHeight = height - policy.MaxBlocks
+	}
+	var expiredTimes []uint64
+	if policy.MaxAge > 0 {
+		// block times are only recorded for blocks that contain EVM txs, any receipts committed
+		// before the first recorded block time are older than that block
+		minTime := blockTime - policy.MaxAge
+		err := lr.evmAuxStore.ForEachBlockTime(tran, func(h uint64, t int64) bool {
+			if t >= minTime {
+				return false
+			}
+			expiredTimes = append(expiredTimes, h)
+			if h > expiredHeight {
+				expiredHeight = h
+			}
+			return true
+		})
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "failed to load block times")
+		}
+	}
+	return expiredHeight, expiredTimes, nil
+}
+
+// findOldEntries walks the receipts list from the head, and returns the keys & receipts of the first
+// minCount list items, followed by any items in blocks at or below maxHeight, and the new head of
+// the list.
+func findOldEntries(
+	tran *leveldb.Transaction, head []byte, minCount uint64, maxHeight uint64,
+) ([][]byte, []*types.EvmTxReceipt, []byte, error) {
+	var keys [][]byte
+	var receipts []*types.EvmTxReceipt
+	for len(head) > 0 {
+		headItem, err := tran.Get(head, nil)
+		if err != nil {
+			return nil, nil, head, errors.Wrapf(err, "get head %x", head)
+		}
+		var item types.EvmTxReceiptListItem
+		if err := proto.Unmarshal(headItem, &item); err != nil {
+			return nil, nil, head, errors.Wrapf(err, "unmarshal head %x", head)
+		}
+		if uint64(len(keys)) >= minCount &&
+			(item.Receipt == nil || item.Receipt.BlockNumber < 0 || uint64(item.Receipt.BlockNumber) > maxHeight) {
+			break
+		}
+		keys = append(keys, head)
+		if item.Receipt != nil {
+			receipts = append(receipts, item.Receipt)
+		}
+		head = item.NextTxHash
+	}
+	if uint64(len(keys)) < minCount {
+		return nil, nil, head, errors.Errorf(
+			"Unable to delete %v receipts, only %v deleted", minCount, len(keys),
+		)
+	}
+	return keys, receipts, head, nil
+}
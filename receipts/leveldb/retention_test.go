@@ -0,0 +1,151 @@
+package leveldb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/loomchain/receipts/common"
+	"github.com/stretchr/testify/require"
+)
+
+func commitTestBlocks(
+	t *testing.T, handler *LevelDbReceipts, fromHeight, toHeight uint64, receiptsPerBlock uint64,
+) map[uint64][]*types.EvmTxReceipt {
+	blockReceipts := map[uint64][]*types.EvmTxReceipt{}
+	genesis := time.Unix(1000000, 0)
+	for height := fromHeight; height <= toHeight; height++ {
+		receipts := common.MakeDummyReceipts(t, receiptsPerBlock, height)
+		// one block per minute
+		state := common.MockStateWithTime(height, genesis.Add(time.Duration(height)*time.Minute))
+		require.NoError(t, handler.CommitBlock(state, receipts, height))
+		blockReceipts[height] = receipts
+	}
+	return blockReceipts
+}
+
+func TestReceiptRetentionByHeight(t *testing.T) {
+	evmAuxStore, err := common.NewMockEvmAuxStore()
+	require.NoError(t, err)
+	defer evmAuxStore.ClearData()
+	handler := NewLevelDbReceipts(evmAuxStore, 1000)
+	defer handler.Close()
+	handler.SetRetentionPolicy(&ReceiptRetentionPolicy{MaxBlocks: 3})
+
+	blockReceipts := commitTestBlocks(t, handler, 1, 10, 2)
+	for height := uint64(1); height <= 10; height++ {
+		for _, receipt := range blockReceipts[height] {
+			_, err := handler.GetReceipt(receipt.TxHash)
+			if height > 7 {
+				require.NoError(t, err, "receipt at height %d should be retained", height)
+			} else {
+				require.Error(t, err, "receipt at height %d should be deleted", height)
+			}
+		}
+	}
+	size, head, tail, err := getDBParams(evmAuxStore)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), size)
+	require.Equal(t, blockReceipts[8][0].TxHash, head)
+	require.Equal(t, blockReceipts[10][1].TxHash, tail)
+}
+
+func TestReceiptRetentionByAge(t *testing.T) {
+	evmAuxStore, err := common.NewMockEvmAuxStore()
+	require.NoError(t, err)
+	defer evmAuxStore.ClearData()
+	handler := NewLevelDbReceipts(evmAuxStore, 1000)
+	defer handler.Close()
+	// receipts from the 5 most recent blocks should be retained
+	handler.SetRetentionPolicy(&ReceiptRetentionPolicy{MaxAge: 5 * 60})
+
+	blockReceipts := commitTestBlocks(t, handler, 1, 10, 1)
+	for height := uint64(1); height <= 10; height++ {
+		_, err := handler.GetReceipt(blockReceipts[height][0].TxHash)
+		if height >= 5 {
+			require.NoError(t, err, "receipt at height %d should be retained", height)
+		} else {
+			require.Error(t, err, "receipt at height %d should be deleted", height)
+		}
+	}
+	// block times of expired blocks should be deleted too
+	var heights []uint64
+	tran, err := evmAuxStore.DB().OpenTransaction()
+	require.NoError(t, err)
+	defer tran.Discard()
+	require.NoError(t, evmAuxStore.ForEachBlockTime(tran, func(height uint64, blockTime int64) bool {
+		heights = append(heights, height)
+		return true
+	}))
+	require.Equal(t, []uint64{5, 6, 7, 8, 9, 10}, heights)
+}
+
+func TestReceiptArchive(t *testing.T) {
+	archiveDir, err := ioutil.TempDir("", "receipts_archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(archiveDir)
+	archive, err := NewReceiptArchive(archiveDir, 5)
+	require.NoError(t, err)
+
+	evmAuxStore, err := common.NewMockEvmAuxStore()
+	require.NoError(t, err)
+	handler := NewLevelDbReceipts(evmAuxStore, 4)
+	handler.SetRetentionPolicy(&ReceiptRetentionPolicy{MaxBlocks: 2, Archive: archive})
+
+	blockReceipts := commitTestBlocks(t, handler, 1, 12, 3)
+	txHashLists := map[uint64][][]byte{}
+	for height := uint64(1); height <= 12; height++ {
+		txHashLists[height], err = evmAuxStore.GetTxHashList(height)
+		require.NoError(t, err)
+	}
+	require.NoError(t, handler.Close())
+	evmAuxStore.ClearData()
+
+	// the archived receipts should be in files covering 5 blocks each
+	files, err := ReceiptArchiveFiles(archiveDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(archiveDir, "receipts-000000000000-000000000004.jsonl.gz"),
+		filepath.Join(archiveDir, "receipts-000000000005-000000000009.jsonl.gz"),
+		filepath.Join(archiveDir, "receipts-000000000010-000000000014.jsonl.gz"),
+	}, files)
+
+	// import the archive into an empty receipts DB
+	evmAuxStore, err = common.NewMockEvmAuxStore()
+	require.NoError(t, err)
+	defer evmAuxStore.ClearData()
+	handler = NewLevelDbReceipts(evmAuxStore, 4)
+	defer handler.Close()
+	var imported uint64
+	for _, file := range files {
+		stats, err := ImportReceiptArchive(evmAuxStore, file)
+		require.NoError(t, err)
+		imported += stats.Imported
+	}
+	// all the receipts except the last receipt of block 11 & the receipts of block 12 should've been
+	// archived
+	require.Equal(t, uint64(12*3-4), imported)
+	for height := uint64(1); height <= 12; height++ {
+		for _, receipt := range blockReceipts[height] {
+			_, err := handler.GetReceipt(receipt.TxHash)
+			if height < 11 || (height == 11 && receipt != blockReceipts[11][2]) {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		}
+		if height <= 10 {
+			hashes, err := evmAuxStore.GetTxHashList(height)
+			require.NoError(t, err)
+			require.Equal(t, txHashLists[height], hashes)
+		}
+	}
+
+	// importing the same archive again shouldn't change anything
+	stats, err := ImportReceiptArchive(evmAuxStore, files[0])
+	require.NoError(t, err)
+	require.Equal(t, &ReceiptImportStats{Skipped: 12}, stats)
+}
@@ -3,6 +3,7 @@ package receipts
 import (
 	"github.com/loomnetwork/loomchain"
 	"github.com/loomnetwork/loomchain/receipts/handler"
+	"github.com/loomnetwork/loomchain/receipts/leveldb"
 	evmaux "github.com/loomnetwork/loomchain/store/evm_aux"
 )
 
@@ -31,6 +32,21 @@ func NewReceiptHandlerProvider(
 	}
 }
 
+// SetRetentionPolicy changes the policy that determines how long receipts are kept in the receipts
+// DB.
+func (h *ReceiptHandlerProvider) SetRetentionPolicy(policy *leveldb.ReceiptRetentionPolicy) {
+	if rh, ok := h.handler.(*handler.ReceiptHandler); ok {
+		rh.SetRetentionPolicy(policy)
+	}
+}
+
+// SetInternalTxIndexing enables or disables the indexing of the internal txs made by EVM txs.
+func (h *ReceiptHandlerProvider) SetInternalTxIndexing(enabled bool) {
+	if rh, ok := h.handler.(*handler.ReceiptHandler); ok {
+		rh.SetInternalTxIndexing(enabled)
+	}
+}
+
 func (h *ReceiptHandlerProvider) Store() loomchain.ReceiptHandlerStore {
 	return h.handler
 }
@@ -0,0 +1,533 @@
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+	abci "github.com/tendermint/tendermint/abci/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+
+	"github.com/loomnetwork/go-loom"
+	lauth "github.com/loomnetwork/go-loom/auth"
+	ltypes "github.com/loomnetwork/go-loom/types"
+	"github.com/loomnetwork/go-loom/vm"
+	"github.com/loomnetwork/loomchain"
+	"github.com/loomnetwork/loomchain/auth"
+	levm "github.com/loomnetwork/loomchain/evm"
+	"github.com/loomnetwork/loomchain/receipts/common"
+	"github.com/loomnetwork/loomchain/rpc/eth"
+)
+
+const (
+	// Default time limit for tracing a single tx, same as Geth.
+	defaultTraceTimeout = 5 * time.Second
+	// Default upper bound of the time limit a caller can set for tracing a single tx.
+	defaultMaxTraceTimeout = 30 * time.Second
+
+	deployTxID = uint32(1)
+	callTxID   = uint32(2)
+)
+
+// evmTx is an EVM tx extracted from a block.
+type evmTx struct {
+	hash   []byte
+	caller loom.Address
+	// empty if the tx deploys a contract
+	contract loom.Address
+	input    []byte
+	value    *loom.BigUInt
+	// true if the tx failed when it was originally executed
+	failed bool
+}
+
+// DebugTraceTransaction re-executes an EVM tx against the state the tx was originally executed
+// against, and returns the trace produced by the tracer specified in the config. The hash can be
+// either an EVM tx hash or a Tendermint tx hash.
+//
+// The state at the end of the previous block is used as the starting point, and any EVM txs that
+// precede the traced tx in the same block are re-executed first. Non-EVM txs can't be re-executed,
+// so if a successful non-EVM tx precedes the traced tx in the same block an error is returned.
+func (s *QueryServer) DebugTraceTransaction(hash eth.Data, config eth.JsonTraceConfig) (interface{}, error) {
+	txHash, err := eth.DecDataToBytes(hash)
+	if err != nil {
+		return nil, err
+	}
+	traceCfg, err := s.decTraceConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var height int64
+	index := -1
+	receipt, err := s.ReceiptHandlerProvider.Reader().GetReceipt(txHash)
+	if err == nil {
+		// The tx index in the receipt isn't the position of the tx in the block, so the tx is found
+		// by its hash once the block is loaded.
+		height = receipt.BlockNumber
+	} else if errors.Cause(err) == common.ErrTxReceiptNotFound {
+		txResult, err := s.BlockStore.GetTxResult(txHash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find tx %v", hash)
+		}
+		height, index = txResult.Height, int(txResult.Index)
+	} else {
+		return nil, err
+	}
+
+	txs, firstNonEvmTx, state, err := s.blockTraceState(height)
+	if err != nil {
+		return nil, err
+	}
+	defer state.Release()
+
+	if index < 0 {
+		for i, tx := range txs {
+			if tx != nil && bytes.Equal(tx.hash, txHash) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, errors.Errorf("tx %v not found in block %d", hash, height)
+		}
+	}
+	if index >= len(txs) || txs[index] == nil {
+		return nil, errors.Errorf("tx %v is not an EVM tx", hash)
+	}
+	if index > firstNonEvmTx {
+		return nil, errNonEvmTxPrecedes(height, firstNonEvmTx)
+	}
+	for _, tx := range txs[:index] {
+		if tx != nil && !tx.failed {
+			s.replayEvmTx(state, tx)
+		}
+	}
+	tx := txs[index]
+	result, err := s.traceEvmTx(state, tx.caller, tx.contract, tx.input, tx.value, traceCfg)
+	if err != nil {
+		return nil, err
+	}
+	return encTrace(result), nil
+}
+
+// DebugTraceCall executes the given call on top of the state at the given block height, and
+// returns the trace produced by the tracer specified in the config. If the call doesn't specify
+// a recipient the input is treated as contract byte-code and deployed instead. The call is never
+// persisted.
+func (s *QueryServer) DebugTraceCall(
+	query eth.JsonTxCallObject, block eth.BlockHeight, config eth.JsonTraceConfig,
+) (interface{}, error) {
+	traceCfg, err := s.decTraceConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	var caller, contract loom.Address
+	if len(query.From) > 0 {
+		caller, err = eth.DecDataToAddress(s.ChainID, query.From)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		caller = loom.RootAddress(s.ChainID)
+	}
+	if len(query.To) > 0 {
+		contract, err = eth.DecDataToAddress(s.ChainID, query.To)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var data []byte
+	if len(query.Data) > 2 {
+		data, err = eth.DecDataToBytes(query.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var value *loom.BigUInt
+	if len(query.Value) > 0 {
+		v, ok := new(big.Int).SetString(strings.TrimPrefix(string(query.Value), "0x"), 16)
+		if !ok {
+			return nil, errors.Errorf("invalid value %v", query.Value)
+		}
+		value = loom.NewBigUInt(v)
+	}
+	traceCfg.Gas = s.rpcGasCap()
+	if len(query.Gas) > 0 {
+		gas, err := eth.DecQuantityToUint(query.Gas)
+		if err != nil {
+			return nil, err
+		}
+		if gas < traceCfg.Gas {
+			traceCfg.Gas = gas
+		}
+	}
+
+	snapshot, err := s.ethStateAt(block)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+
+	callerAddr, err := auth.ResolveAccountAddress(caller, snapshot, s.AuthCfg, s.createAddressMapperCtx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve account address")
+	}
+	result, err := s.traceEvmTx(loomchain.NewScratchState(snapshot), callerAddr, contract, data, value, traceCfg)
+	if err != nil {
+		return nil, err
+	}
+	return encTrace(result), nil
+}
+
+// DebugTraceBlockByNumber re-executes all the EVM txs in the given block against the state at the
+// end of the previous block, and returns the traces produced by the tracer specified in the config.
+// Non-EVM txs are skipped, they can't be re-executed so the traces of any EVM txs that follow a
+// successful non-EVM tx contain an error instead.
+func (s *QueryServer) DebugTraceBlockByNumber(
+	block eth.BlockHeight, config eth.JsonTraceConfig,
+) ([]eth.JsonTxTrace, error) {
+	traceCfg, err := s.decTraceConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := s.StateProvider.ReadOnlyState()
+	height, err := eth.DecBlockHeight(snapshot.Block().Height, block)
+	snapshot.Release()
+	if err != nil {
+		return nil, err
+	}
+
+	txs, firstNonEvmTx, state, err := s.blockTraceState(int64(height))
+	if err != nil {
+		return nil, err
+	}
+	defer state.Release()
+
+	traces := []eth.JsonTxTrace{}
+	for i, tx := range txs {
+		if tx == nil {
+			continue
+		}
+		txTrace := eth.JsonTxTrace{TxHash: eth.EncBytes(tx.hash)}
+		if i > firstNonEvmTx {
+			txTrace.Error = errNonEvmTxPrecedes(int64(height), firstNonEvmTx).Error()
+			traces = append(traces, txTrace)
+			continue
+		}
+		txState := state
+		if tx.failed {
+			// the tx didn't change the state when it was originally executed, so it shouldn't
+			// affect the txs that follow it
+			txState = loomchain.NewScratchState(state)
+		}
+		result, err := s.traceEvmTx(txState, tx.caller, tx.contract, tx.input, tx.value, traceCfg)
+		if err != nil {
+			txTrace.Error = err.Error()
+		} else {
+			txTrace.Result = encTrace(result)
+		}
+		traces = append(traces, txTrace)
+	}
+	return traces, nil
+}
+
+// blockTraceState returns the EVM txs in the block at the given height, the index of the first
+// successful non-EVM tx in the block (or the number of txs in the block if there isn't one), and a
+// scratch state that starts off with the app state at the end of the previous block. The EVM txs
+// are indexed by their position in the block, non-EVM txs are nil.
+func (s *QueryServer) blockTraceState(height int64) ([]*evmTx, int, loomchain.State, error) {
+	if height <= 1 {
+		return nil, 0, nil, errors.Errorf("can't trace txs in block %d", height)
+	}
+	blockResult, err := s.BlockStore.GetBlockByHeight(&height)
+	if err != nil {
+		return nil, 0, nil, errors.Wrapf(err, "failed to load block %d", height)
+	}
+	blockResults, err := s.BlockStore.GetBlockResults(&height)
+	if err != nil {
+		return nil, 0, nil, errors.Wrapf(err, "failed to load results of block %d", height)
+	}
+	txs := make([]*evmTx, len(blockResult.Block.Data.Txs))
+	firstNonEvmTx := len(txs)
+	for i, tx := range blockResult.Block.Data.Txs {
+		if blockResults.Results == nil || i >= len(blockResults.Results.DeliverTx) {
+			break
+		}
+		txResult := blockResults.Results.DeliverTx[i]
+		txs[i], err = decodeEvmTx(tx, txResult)
+		if err != nil {
+			return nil, 0, nil, errors.Wrapf(err, "failed to decode tx %d in block %d", i, height)
+		}
+		failed := txResult.Code != abci.CodeTypeOK
+		if txs[i] != nil {
+			txs[i].failed = failed
+		} else if !failed && i < firstNonEvmTx {
+			// failed txs don't change the state, so only successful non-EVM txs matter
+			firstNonEvmTx = i
+		}
+	}
+
+	snapshot, err := s.StateProvider.ReadOnlyStateAt(height - 1)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	header := blockResult.Block.Header
+	state := loomchain.NewScratchState(&blockHeaderState{
+		State: snapshot,
+		block: ltypes.BlockHeader{
+			ChainID: header.ChainID,
+			Height:  header.Height,
+			Time:    header.Time.Unix(),
+			NumTxs:  int32(header.NumTxs),
+			LastBlockID: ltypes.BlockID{
+				Hash: header.LastBlockID.Hash,
+			},
+			ValidatorsHash: header.ValidatorsHash,
+			AppHash:        header.AppHash,
+			CurrentHash:    blockResult.BlockMeta.BlockID.Hash,
+		},
+	})
+	return txs, firstNonEvmTx, &releasableState{StoreState: state, snapshot: snapshot}, nil
+}
+
+func errNonEvmTxPrecedes(height int64, nonEvmTxIndex int) error {
+	return errors.Errorf(
+		"can't trace txs that follow the non-EVM tx %d in block %d, non-EVM txs can't be re-executed",
+		nonEvmTxIndex, height,
+	)
+}
+
+// replayEvmTx re-executes the given tx, errors are ignored since a tx that failed when it was
+// originally executed will fail again.
+func (s *QueryServer) replayEvmTx(state loomchain.State, tx *evmTx) {
+	vm, err := s.createEVM(state)
+	if err != nil {
+		return
+	}
+	if tx.contract.IsEmpty() {
+		_, _, _ = vm.Create(tx.caller, tx.input, tx.value)
+	} else {
+		_, _ = vm.Call(tx.caller, tx.contract, tx.input, tx.value)
+	}
+}
+
+func (s *QueryServer) traceEvmTx(
+	state loomchain.State, caller, contract loom.Address, input []byte, value *loom.BigUInt,
+	cfg levm.TraceConfig,
+) (interface{}, error) {
+	vm, err := s.createEVM(state)
+	if err != nil {
+		return nil, err
+	}
+	tracer, ok := vm.(levm.TxTracer)
+	if !ok {
+		return nil, errors.New("EVM is not available")
+	}
+	return tracer.Trace(caller, contract, input, value, cfg)
+}
+
+// blockHeaderState overrides the block header of the underlying state.
+type blockHeaderState struct {
+	loomchain.State
+	block ltypes.BlockHeader
+}
+
+func (s *blockHeaderState) Block() ltypes.BlockHeader {
+	return s.block
+}
+
+// releasableState is a scratch state that releases the snapshot it was created from when it's
+// released.
+type releasableState struct {
+	*loomchain.StoreState
+	snapshot loomchain.State
+}
+
+func (s *releasableState) Release() {
+	s.snapshot.Release()
+}
+
+// decodeEvmTx returns the EVM deployment or call in the given tx, or nil if it's not an EVM tx.
+func decodeEvmTx(tx tmtypes.Tx, txResult *abci.ResponseDeliverTx) (*evmTx, error) {
+	var signedTx lauth.SignedTx
+	if err := proto.Unmarshal(tx, &signedTx); err != nil {
+		return nil, err
+	}
+	var nonceTx lauth.NonceTx
+	if err := proto.Unmarshal(signedTx.Inner, &nonceTx); err != nil {
+		return nil, err
+	}
+	var txTx loomchain.Transaction
+	if err := proto.Unmarshal(nonceTx.Inner, &txTx); err != nil {
+		return nil, err
+	}
+	var msg vm.MessageTx
+	if err := proto.Unmarshal(txTx.Data, &msg); err != nil {
+		return nil, err
+	}
+	result := &evmTx{
+		caller: loom.UnmarshalAddressPB(msg.From),
+		value:  loom.NewBigUIntFromInt(0),
+	}
+
+	switch txTx.Id {
+	case deployTxID:
+		var deployTx vm.DeployTx
+		if err := proto.Unmarshal(msg.Data, &deployTx); err != nil {
+			return nil, err
+		}
+		if deployTx.VmType != vm.VMType_EVM {
+			return nil, nil
+		}
+		result.input = deployTx.Code
+		if deployTx.Value != nil {
+			result.value = &deployTx.Value.Value
+		}
+		var resp vm.DeployResponse
+		if err := proto.Unmarshal(txResult.Data, &resp); err == nil {
+			var respData vm.DeployResponseData
+			if err := proto.Unmarshal(resp.Output, &respData); err == nil && len(respData.TxHash) > 0 {
+				result.hash = respData.TxHash
+			}
+		}
+	case callTxID:
+		var callTx vm.CallTx
+		if err := proto.Unmarshal(msg.Data, &callTx); err != nil {
+			return nil, err
+		}
+		if callTx.VmType != vm.VMType_EVM {
+			return nil, nil
+		}
+		result.contract = loom.UnmarshalAddressPB(msg.To)
+		result.input = callTx.Input
+		if callTx.Value != nil {
+			result.value = &callTx.Value.Value
+		}
+		if len(txResult.Data) > 0 {
+			result.hash = txResult.Data
+		}
+	default:
+		return nil, nil
+	}
+	// The EVM tx hash from the DeliverTx result is used so the hash matches the one returned by
+	// eth_getBlockByNumber & the tx receipts, failed txs don't return any data though, so those
+	// have to be identified by the Tendermint tx hash instead (same as eth_getBlockByNumber).
+	if len(result.hash) == 0 {
+		result.hash = tx.Hash()
+	}
+	return result, nil
+}
+
+// decTraceConfig converts the given trace config, the timeout is clamped to MaxTraceTimeout so
+// callers can't disable it, or make the node spend an arbitrary amount of time tracing a tx.
+func (s *QueryServer) decTraceConfig(config eth.JsonTraceConfig) (levm.TraceConfig, error) {
+	maxTimeout := s.MaxTraceTimeout
+	if maxTimeout <= 0 {
+		maxTimeout = defaultMaxTraceTimeout
+	}
+	cfg := levm.TraceConfig{
+		Tracer:         config.Tracer,
+		DisableStorage: config.DisableStorage,
+		DisableMemory:  config.DisableMemory,
+		DisableStack:   config.DisableStack,
+		Limit:          config.Limit,
+		Timeout:        defaultTraceTimeout,
+	}
+	if len(config.Timeout) > 0 {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			return cfg, errors.Wrapf(err, "invalid timeout %s", config.Timeout)
+		}
+		cfg.Timeout = timeout
+	}
+	if cfg.Timeout <= 0 || cfg.Timeout > maxTimeout {
+		cfg.Timeout = maxTimeout
+	}
+	return cfg, nil
+}
+
+func encTrace(trace interface{}) interface{} {
+	switch t := trace.(type) {
+	case *levm.ExecutionTrace:
+		return encExecutionTrace(t)
+	case *levm.CallFrame:
+		return encCallFrame(t)
+	default:
+		return trace
+	}
+}
+
+func encExecutionTrace(trace *levm.ExecutionTrace) *eth.JsonExecutionTrace {
+	result := &eth.JsonExecutionTrace{
+		Gas:         trace.Gas,
+		Failed:      trace.Failed,
+		ReturnValue: fmt.Sprintf("%x", trace.ReturnValue),
+		StructLogs:  make([]eth.JsonStructLog, 0, len(trace.StructLogs)),
+	}
+	for _, log := range trace.StructLogs {
+		structLog := eth.JsonStructLog{
+			Pc:      log.Pc,
+			Op:      log.Op,
+			Gas:     log.Gas,
+			GasCost: log.GasCost,
+			Depth:   log.Depth,
+			Error:   log.Error,
+		}
+		if log.Stack != nil {
+			stack := make([]string, len(log.Stack))
+			for i, value := range log.Stack {
+				stack[i] = fmt.Sprintf("%064x", value)
+			}
+			structLog.Stack = &stack
+		}
+		if log.Memory != nil {
+			memory := make([]string, 0, (len(log.Memory)+31)/32)
+			for i := 0; i+32 <= len(log.Memory); i += 32 {
+				memory = append(memory, fmt.Sprintf("%x", log.Memory[i:i+32]))
+			}
+			structLog.Memory = &memory
+		}
+		if log.Storage != nil {
+			storage := make(map[string]string, len(log.Storage))
+			for key, value := range log.Storage {
+				storage[fmt.Sprintf("%x", key)] = fmt.Sprintf("%x", value)
+			}
+			structLog.Storage = &storage
+		}
+		result.StructLogs = append(result.StructLogs, structLog)
+	}
+	return result
+}
+
+func encCallFrame(frame *levm.CallFrame) eth.JsonCallFrame {
+	result := eth.JsonCallFrame{
+		Type:   frame.Type,
+		Output: eth.EncPtrBytes(frame.Output),
+		Error:  frame.Error,
+	}
+	if len(frame.From) > 0 {
+		result.From = eth.EncBytes(frame.From)
+	}
+	if len(frame.To) > 0 {
+		result.To = eth.EncBytes(frame.To)
+	}
+	if frame.Value != nil {
+		result.Value = eth.EncBigInt(*frame.Value)
+	}
+	if frame.Gas > 0 {
+		result.Gas = eth.EncUint(frame.Gas)
+		result.GasUsed = eth.EncUint(frame.GasUsed)
+	}
+	if frame.Type != "SELFDESTRUCT" {
+		result.Input = eth.EncBytes(frame.Input)
+	}
+	for _, call := range frame.Calls {
+		result.Calls = append(result.Calls, encCallFrame(call))
+	}
+	return result
+}
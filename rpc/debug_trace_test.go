@@ -0,0 +1,32 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/loomnetwork/loomchain/rpc/eth"
+)
+
+func TestDecTraceConfigTimeout(t *testing.T) {
+	qs := &QueryServer{MaxTraceTimeout: 10 * time.Second}
+	tests := []struct {
+		timeout  string
+		expected time.Duration
+	}{
+		{"", defaultTraceTimeout},
+		{"2s", 2 * time.Second},
+		{"0s", 10 * time.Second},
+		{"-1s", 10 * time.Second},
+		{"1h", 10 * time.Second},
+	}
+	for _, test := range tests {
+		cfg, err := qs.decTraceConfig(eth.JsonTraceConfig{Timeout: test.timeout})
+		require.NoError(t, err)
+		require.Equal(t, test.expected, cfg.Timeout, test.timeout)
+	}
+
+	_, err := qs.decTraceConfig(eth.JsonTraceConfig{Timeout: "soon"})
+	require.Error(t, err)
+}
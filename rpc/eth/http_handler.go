@@ -81,6 +81,10 @@ func (m *HttpRPCFunc) call(inValues []reflect.Value) (resp json.RawMessage, json
 	outValues := m.method.Call(inValues)
 
 	if outValues[1].Interface() != nil {
+		// Let methods return their own JSON-RPC errors, everything else is a generic server error
+		if jsonErr, ok := outValues[1].Interface().(*Error); ok {
+			return resp, jsonErr
+		}
 		return resp, NewErrorf(EcServer, "Server error", "loom error: %v", outValues[1].Interface())
 	}
 
@@ -58,6 +58,10 @@ type JsonTxReceipt struct {
 	Logs              []JsonLog `json:"logs"`
 	LogsBloom         Data      `json:"logsBloom,omitempty"`
 	Status            Quantity  `json:"status,omitempty"`
+	// Data returned by the EVM when the tx was reverted, only set for failed txs.
+	RevertReason Data `json:"revertReason,omitempty"`
+	// Call tree of the internal txs made by the tx, only set if internal tx indexing is enabled.
+	InternalTransactions *JsonCallFrame `json:"internalTransactions,omitempty"`
 }
 
 type JsonTxObject struct {
@@ -114,6 +118,79 @@ type JsonFilter struct {
 	BlockHash Data          `json:"blockhash,omitempty"`
 }
 
+// JsonAccountProof is returned by eth_getProof, see EIP-1186.
+type JsonAccountProof struct {
+	Address      Data               `json:"address"`
+	AccountProof []Data             `json:"accountProof"`
+	Balance      Quantity           `json:"balance"`
+	CodeHash     Data               `json:"codeHash"`
+	Nonce        Quantity           `json:"nonce"`
+	StorageHash  Data               `json:"storageHash"`
+	StorageProof []JsonStorageProof `json:"storageProof"`
+}
+
+type JsonStorageProof struct {
+	Key   Data     `json:"key"`
+	Value Quantity `json:"value"`
+	Proof []Data   `json:"proof"`
+}
+
+// JsonTraceConfig specifies how debug_traceTransaction, debug_traceCall, and
+// debug_traceBlockByNumber should trace txs, same as the Geth trace config.
+type JsonTraceConfig struct {
+	// "callTracer", "4byteTracer", or empty to collect struct logs
+	Tracer         string `json:"tracer,omitempty"`
+	DisableStorage bool   `json:"disableStorage,omitempty"`
+	DisableMemory  bool   `json:"disableMemory,omitempty"`
+	DisableStack   bool   `json:"disableStack,omitempty"`
+	Limit          int    `json:"limit,omitempty"`
+	// Duration string, e.g. "10s"
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// JsonExecutionTrace is returned by debug_trace* when no tracer is specified, the format of the
+// struct logs matches the format used by Geth.
+type JsonExecutionTrace struct {
+	Gas         uint64          `json:"gas"`
+	Failed      bool            `json:"failed"`
+	ReturnValue string          `json:"returnValue"`
+	StructLogs  []JsonStructLog `json:"structLogs"`
+}
+
+type JsonStructLog struct {
+	Pc      uint64             `json:"pc"`
+	Op      string             `json:"op"`
+	Gas     uint64             `json:"gas"`
+	GasCost uint64             `json:"gasCost"`
+	Depth   int                `json:"depth"`
+	Error   string             `json:"error,omitempty"`
+	Stack   *[]string          `json:"stack,omitempty"`
+	Memory  *[]string          `json:"memory,omitempty"`
+	Storage *map[string]string `json:"storage,omitempty"`
+}
+
+// JsonCallFrame is returned by debug_trace* when the callTracer is specified, and by
+// loom_getInternalTransactions.
+type JsonCallFrame struct {
+	Type    string          `json:"type"`
+	From    Data            `json:"from,omitempty"`
+	To      Data            `json:"to,omitempty"`
+	Value   Quantity        `json:"value,omitempty"`
+	Gas     Quantity        `json:"gas,omitempty"`
+	GasUsed Quantity        `json:"gasUsed,omitempty"`
+	Input   Data            `json:"input,omitempty"`
+	Output  *Data           `json:"output,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Calls   []JsonCallFrame `json:"calls,omitempty"`
+}
+
+// JsonTxTrace is the trace of a single tx in the result of debug_traceBlockByNumber.
+type JsonTxTrace struct {
+	TxHash Data        `json:"txHash"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
 func EncTxReceipt(receipt types.EvmTxReceipt) JsonTxReceipt {
 	return JsonTxReceipt{
 		TransactionIndex:  EncInt(int64(receipt.TransactionIndex)),
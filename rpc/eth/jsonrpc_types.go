@@ -45,6 +45,7 @@ const (
 	EcInvalidParams  ErrorCode = -32602 // Invalid method parameter(s).
 	EcInternal       ErrorCode = -32603 // Internal JSON-RPC error.
 	EcServer         ErrorCode = -32000 // Reserved for implementation-defined server-errors.
+	EcReverted       ErrorCode = 3      // EVM execution was reverted, same code as used by geth.
 )
 
 type Error struct {
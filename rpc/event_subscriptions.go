@@ -0,0 +1,252 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/pkg/errors"
+	rpctypes "github.com/tendermint/tendermint/rpc/lib/types"
+
+	"github.com/loomnetwork/loomchain/log"
+	"github.com/loomnetwork/loomchain/store"
+)
+
+const (
+	// Max number of events examined by a single event store query while a resumable subscription is
+	// catching up, the subscription keeps querying until it reaches the last saved event.
+	maxSubscriptionEventsScanned = 1000
+	// How often a resumable subscription checks for new events if the event store can't notify it.
+	subscriptionPollInterval = time.Second
+)
+
+// EventSubscriptionMessage is sent to the client of a resumable event subscription for each event
+// that matches the subscription topics.
+type EventSubscriptionMessage struct {
+	// ID of the subscription, as returned by subeventsfrom.
+	Subscription string `json:"subscription"`
+	// Cursor to pass to subeventsfrom in order to resume the subscription from the event that
+	// follows this one.
+	Cursor string           `json:"cursor"`
+	Event  *types.EventData `json:"event"`
+}
+
+// ResumableEventSubscriptions keeps track of the resumable event subscriptions of websocket clients.
+// Unlike subscriptions created via subevents, which only receive events published after they were
+// created, a resumable subscription streams events from the event store starting at a given block
+// height or cursor. Once it has caught up with the historical events it keeps streaming events as
+// they're saved to the store, so no events are skipped or sent twice when switching over to live
+// events.
+type ResumableEventSubscriptions struct {
+	subs   map[string]map[string]*resumableSubscription // remote addr -> subscription ID -> sub
+	nextID uint64
+	sync.Mutex
+}
+
+func NewResumableEventSubscriptions() *ResumableEventSubscriptions {
+	return &ResumableEventSubscriptions{
+		subs: map[string]map[string]*resumableSubscription{},
+	}
+}
+
+func (s *ResumableEventSubscriptions) add(remoteAddr string, sub *resumableSubscription) {
+	s.Lock()
+	defer s.Unlock()
+	s.nextID++
+	sub.id = fmt.Sprintf("0x%x", s.nextID)
+	if s.subs[remoteAddr] == nil {
+		s.subs[remoteAddr] = map[string]*resumableSubscription{}
+	}
+	s.subs[remoteAddr][sub.id] = sub
+}
+
+// Remove stops the subscription with the given ID.
+func (s *ResumableEventSubscriptions) Remove(remoteAddr string, id string) error {
+	s.Lock()
+	defer s.Unlock()
+	sub, ok := s.subs[remoteAddr][id]
+	if !ok {
+		return fmt.Errorf("subscription %s not found", id)
+	}
+	sub.stop()
+	delete(s.subs[remoteAddr], id)
+	if len(s.subs[remoteAddr]) == 0 {
+		delete(s.subs, remoteAddr)
+	}
+	return nil
+}
+
+// Purge stops all the subscriptions of a client, should be called when the client disconnects.
+func (s *ResumableEventSubscriptions) Purge(remoteAddr string) {
+	s.Lock()
+	defer s.Unlock()
+	for _, sub := range s.subs[remoteAddr] {
+		sub.stop()
+	}
+	delete(s.subs, remoteAddr)
+}
+
+func (s *ResumableEventSubscriptions) remove(remoteAddr string, sub *resumableSubscription) {
+	s.Lock()
+	defer s.Unlock()
+	if s.subs[remoteAddr][sub.id] == sub {
+		delete(s.subs[remoteAddr], sub.id)
+		if len(s.subs[remoteAddr]) == 0 {
+			delete(s.subs, remoteAddr)
+		}
+	}
+}
+
+// resumableSubscription streams events from the event store to a websocket client.
+type resumableSubscription struct {
+	id         string
+	topics     []string
+	eventStore store.EventStore
+	wsCtx      rpctypes.WSRPCContext
+	quit       chan struct{}
+	stopOnce   sync.Once
+}
+
+func (sub *resumableSubscription) stop() {
+	sub.stopOnce.Do(func() { close(sub.quit) })
+}
+
+// run streams events to the client, starting from the position specified by the filter, until the
+// subscription is stopped.
+func (sub *resumableSubscription) run(filter store.EventFilter) error {
+	notifier, _ := sub.eventStore.(store.EventStoreNotifier)
+	for {
+		// Grab the notification channel before querying the store so events saved while the query
+		// is running aren't missed.
+		var saved <-chan struct{}
+		if notifier != nil {
+			saved = notifier.EventsSaved()
+		}
+		for {
+			result, err := sub.eventStore.QueryEvents(filter)
+			if err != nil {
+				return err
+			}
+			for i, event := range result.Events {
+				if !sub.matches(event) {
+					continue
+				}
+				if !sub.send(result.EventCursors[i], event) {
+					return nil
+				}
+			}
+			// The filter doesn't filter out any events, so the next query can start right after the
+			// last event that was returned.
+			if len(result.EventCursors) > 0 {
+				filter.Cursor = result.EventCursors[len(result.EventCursors)-1]
+			}
+			if result.NextCursor == "" {
+				break
+			}
+			filter.Cursor = result.NextCursor
+		}
+		select {
+		case <-sub.quit:
+			return nil
+		case <-saved:
+		case <-time.After(subscriptionPollInterval):
+		}
+	}
+}
+
+// matches checks if the event was published to any of the subscription topics, the topics are
+// matched the same way as subevents topics, so the topic "contract" matches all the events, while
+// "contract:coin" matches all the events emitted by the coin contract.
+func (sub *resumableSubscription) matches(event *types.EventData) bool {
+	eventTopics := append([]string{"contract:" + event.PluginName}, event.Topics...)
+	for _, topic := range sub.topics {
+		for _, eventTopic := range eventTopics {
+			if eventTopic == topic || strings.HasPrefix(eventTopic, topic+":") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// send writes an event to the client, returns false if the subscription was stopped.
+func (sub *resumableSubscription) send(cursor string, event *types.EventData) bool {
+	result, err := json.Marshal(&EventSubscriptionMessage{
+		Subscription: sub.id,
+		Cursor:       cursor,
+		Event:        event,
+	})
+	if err != nil {
+		log.Error("Failed to marshal subscription event", "err", err)
+		return true
+	}
+	select {
+	case <-sub.quit:
+		return false
+	default:
+	}
+	sub.wsCtx.WriteRPCResponse(rpctypes.RPCResponse{
+		JSONRPC: "2.0",
+		ID:      rpctypes.JSONRPCStringID("0"),
+		Result:  result,
+	})
+	return true
+}
+
+// SubscribeFrom creates a resumable event subscription, the client will receive all the events that
+// match the given topics starting at fromHeight, or at the given cursor (if specified). Each message
+// carries the cursor the client can use to resume the subscription after reconnecting.
+func (s *QueryServer) SubscribeFrom(
+	wsCtx rpctypes.WSRPCContext, topics []string, fromHeight uint64, cursor string,
+) (string, error) {
+	if s.EventStore == nil || s.ResumableSubscriptions == nil {
+		return "", errors.New("event store is not available")
+	}
+	if fromHeight == 0 && cursor == "" {
+		return "", errors.New("fromHeight or cursor must be specified")
+	}
+	if len(topics) == 0 {
+		topics = append(topics, "contract")
+	}
+	filter := store.EventFilter{
+		FromBlock:  fromHeight,
+		ToBlock:    math.MaxInt64,
+		Cursor:     cursor,
+		MaxScanned: maxSubscriptionEventsScanned,
+	}
+	// check the cursor is valid & the events haven't been pruned before accepting the subscription
+	probe := filter
+	probe.MaxScanned = 1
+	if _, err := s.EventStore.QueryEvents(probe); err != nil {
+		return "", err
+	}
+
+	remoteAddr := wsCtx.GetRemoteAddr()
+	sub := &resumableSubscription{
+		topics:     topics,
+		eventStore: s.EventStore,
+		wsCtx:      wsCtx,
+		quit:       make(chan struct{}),
+	}
+	s.ResumableSubscriptions.add(remoteAddr, sub)
+	go func() {
+		if err := sub.run(filter); err != nil {
+			log.Error("Resumable event subscription failed", "id", sub.id, "remote", remoteAddr, "err", err)
+			wsCtx.WriteRPCResponse(rpctypes.RPCInternalError(rpctypes.JSONRPCStringID("0"), err))
+		}
+		s.ResumableSubscriptions.remove(remoteAddr, sub)
+	}()
+	return sub.id, nil
+}
+
+// UnSubscribeFrom stops a subscription created via SubscribeFrom.
+func (s *QueryServer) UnSubscribeFrom(wsCtx rpctypes.WSRPCContext, id string) (*WSEmptyResult, error) {
+	if s.ResumableSubscriptions == nil {
+		return nil, fmt.Errorf("subscription %s not found", id)
+	}
+	return &WSEmptyResult{}, s.ResumableSubscriptions.Remove(wsCtx.GetRemoteAddr(), id)
+}
@@ -0,0 +1,116 @@
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tendermint/libs/db"
+	rpctypes "github.com/tendermint/tendermint/rpc/lib/types"
+
+	"github.com/loomnetwork/loomchain/store"
+)
+
+type fakeWSConnection struct {
+	rpctypes.WSRPCConnection
+	remoteAddr string
+	msgs       chan *EventSubscriptionMessage
+}
+
+func (c *fakeWSConnection) GetRemoteAddr() string {
+	return c.remoteAddr
+}
+
+func (c *fakeWSConnection) WriteRPCResponse(resp rpctypes.RPCResponse) {
+	var msg EventSubscriptionMessage
+	if err := json.Unmarshal(resp.Result, &msg); err != nil {
+		panic(err)
+	}
+	c.msgs <- &msg
+}
+
+func (c *fakeWSConnection) receive(t *testing.T, n int) []*EventSubscriptionMessage {
+	var msgs []*EventSubscriptionMessage
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-c.msgs:
+			msgs = append(msgs, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d out of %d events", i, n)
+		}
+	}
+	select {
+	case msg := <-c.msgs:
+		t.Fatalf("received unexpected event at height %d", msg.Event.BlockHeight)
+	case <-time.After(100 * time.Millisecond):
+	}
+	return msgs
+}
+
+func TestResumableEventSubscription(t *testing.T) {
+	eventStore := store.NewKVEventStore(dbm.NewMemDB())
+	saveBlock := func(height uint64, pluginNames ...string) {
+		var events []*types.EventData
+		for _, pluginName := range pluginNames {
+			events = append(events, &types.EventData{
+				BlockHeight: height,
+				PluginName:  pluginName,
+				Topics:      []string{"event:" + pluginName},
+			})
+		}
+		require.NoError(t, eventStore.BatchSaveEvents(events))
+	}
+	for h := uint64(1); h <= 5; h++ {
+		saveBlock(h, "coin", "dposV3")
+	}
+
+	qs := &QueryServer{
+		EventStore:             eventStore,
+		ResumableSubscriptions: NewResumableEventSubscriptions(),
+	}
+	conn := &fakeWSConnection{remoteAddr: "client1", msgs: make(chan *EventSubscriptionMessage, 100)}
+	wsCtx := rpctypes.WSRPCContext{WSRPCConnection: conn}
+
+	// historical events are streamed first
+	id, err := qs.SubscribeFrom(wsCtx, []string{"contract:coin"}, 3, "")
+	require.NoError(t, err)
+	msgs := conn.receive(t, 3)
+	for i, msg := range msgs {
+		require.Equal(t, id, msg.Subscription)
+		require.Equal(t, uint64(3+i), msg.Event.BlockHeight)
+		require.Equal(t, "coin", msg.Event.PluginName)
+	}
+
+	// followed by new events as they're saved
+	saveBlock(6, "dposV3", "coin", "coin")
+	msgs = conn.receive(t, 2)
+	require.Equal(t, uint64(6), msgs[0].Event.BlockHeight)
+	require.Equal(t, uint64(6), msgs[1].Event.BlockHeight)
+
+	// the subscription can be resumed from the cursor of any event
+	conn2 := &fakeWSConnection{remoteAddr: "client2", msgs: make(chan *EventSubscriptionMessage, 100)}
+	wsCtx2 := rpctypes.WSRPCContext{WSRPCConnection: conn2}
+	_, err = qs.SubscribeFrom(wsCtx2, []string{"event:dposV3"}, 0, msgs[0].Cursor)
+	require.NoError(t, err)
+	conn2.receive(t, 0)
+	_, err = qs.SubscribeFrom(wsCtx2, []string{"contract"}, 0, msgs[0].Cursor)
+	require.NoError(t, err)
+	resumed := conn2.receive(t, 1)
+	require.Equal(t, msgs[1].Event, resumed[0].Event)
+	require.Equal(t, msgs[1].Cursor, resumed[0].Cursor)
+
+	// stopped subscriptions don't receive any more events
+	_, err = qs.UnSubscribeFrom(wsCtx, id)
+	require.NoError(t, err)
+	qs.ResumableSubscriptions.Purge("client2")
+	saveBlock(7, "coin", "dposV3")
+	conn.receive(t, 0)
+	conn2.receive(t, 0)
+
+	_, err = qs.SubscribeFrom(wsCtx, []string{"contract"}, 0, "")
+	require.Error(t, err)
+	_, err = qs.SubscribeFrom(wsCtx, []string{"contract"}, 0, "invalid")
+	require.Error(t, err)
+}
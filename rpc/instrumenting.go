@@ -33,7 +33,7 @@ func NewInstrumentingMiddleWare(
 
 // Query calls service Query and captures metrics
 func (m InstrumentingMiddleware) Query(
-	caller, contract string, query []byte, vmType vm.VMType,
+	caller, contract string, query []byte, vmType vm.VMType, height int64,
 ) (resp []byte, err error) {
 	defer func(begin time.Time) {
 		lvs := []string{"method", "Query", "error", fmt.Sprint(err != nil)}
@@ -41,7 +41,18 @@ func (m InstrumentingMiddleware) Query(
 		m.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
 	}(time.Now())
 
-	resp, err = m.next.Query(caller, contract, query, vmType)
+	resp, err = m.next.Query(caller, contract, query, vmType, height)
+	return
+}
+
+func (m InstrumentingMiddleware) QueryStore(key []byte, height int64, prove bool) (resp *StoreQueryResult, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "QueryStore", "error", fmt.Sprint(err != nil)}
+		m.requestCount.With(lvs...).Add(1)
+		m.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	resp, err = m.next.QueryStore(key, height, prove)
 	return
 }
 
@@ -77,6 +88,23 @@ func (m InstrumentingMiddleware) UnSubscribe(wsCtx rpctypes.WSRPCContext, topic
 	return m.next.UnSubscribe(wsCtx, topic)
 }
 
+func (m InstrumentingMiddleware) SubscribeFrom(
+	wsCtx rpctypes.WSRPCContext, topics []string, fromHeight uint64, cursor string,
+) (resp string, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "SubscribeFrom", "error", fmt.Sprint(err != nil)}
+		m.requestCount.With(lvs...).Add(1)
+		m.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	resp, err = m.next.SubscribeFrom(wsCtx, topics, fromHeight, cursor)
+	return
+}
+
+func (m InstrumentingMiddleware) UnSubscribeFrom(wsCtx rpctypes.WSRPCContext, id string) (*WSEmptyResult, error) {
+	return m.next.UnSubscribeFrom(wsCtx, id)
+}
+
 func (m InstrumentingMiddleware) Resolve(name string) (resp string, err error) {
 	defer func(begin time.Time) {
 		lvs := []string{"method", "Resolve", "error", fmt.Sprint(err != nil)}
@@ -111,6 +139,18 @@ func (m InstrumentingMiddleware) ContractEvents(
 	return
 }
 
+func (m InstrumentingMiddleware) QueryContractEvents(
+	fromBlock, toBlock uint64, contract string, topics []string, caller, txHash, cursor string, maxResults int,
+) (result *ContractEventsPage, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "QueryContractEvents", "error", fmt.Sprint(err != nil)}
+		m.requestCount.With(lvs...).Add(1)
+		m.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+	result, err = m.next.QueryContractEvents(fromBlock, toBlock, contract, topics, caller, txHash, cursor, maxResults)
+	return
+}
+
 func (m InstrumentingMiddleware) GetContractRecord(contractAddr string) (resp *types.ContractRecordResponse, err error) {
 	defer func(begin time.Time) {
 		lvs := []string{"method", "GetContractRecord", "error", fmt.Sprint(err != nil)}
@@ -513,6 +553,19 @@ func (m InstrumentingMiddleware) EthEstimateGas(query eth.JsonTxCallObject) (res
 	return
 }
 
+func (m InstrumentingMiddleware) EthGetProof(
+	address eth.Data, storageKeys []eth.Data, block eth.BlockHeight,
+) (resp *eth.JsonAccountProof, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "EthGetProof", "error", fmt.Sprint(err != nil)}
+		m.requestCount.With(lvs...).Add(1)
+		m.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	resp, err = m.next.EthGetProof(address, storageKeys, block)
+	return
+}
+
 func (m InstrumentingMiddleware) EthGasPrice() (resp eth.Quantity, err error) {
 	defer func(begin time.Time) {
 		lvs := []string{"method", "EthGasPrice", "error", fmt.Sprint(err != nil)}
@@ -535,6 +588,17 @@ func (m InstrumentingMiddleware) EthNetVersion() (resp string, err error) {
 	return
 }
 
+func (m InstrumentingMiddleware) EthChainId() (resp eth.Quantity, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "EthChainId", "error", fmt.Sprint(err != nil)}
+		m.requestCount.With(lvs...).Add(1)
+		m.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	resp, err = m.next.EthChainId()
+	return
+}
+
 func (m InstrumentingMiddleware) EthAccounts() (resp []eth.Data, err error) {
 	defer func(begin time.Time) {
 		lvs := []string{"method", "EthAccounts", "error", fmt.Sprint(err != nil)}
@@ -546,6 +610,56 @@ func (m InstrumentingMiddleware) EthAccounts() (resp []eth.Data, err error) {
 	return
 }
 
+func (m InstrumentingMiddleware) DebugTraceTransaction(
+	hash eth.Data, config eth.JsonTraceConfig,
+) (resp interface{}, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "DebugTraceTransaction", "error", fmt.Sprint(err != nil)}
+		m.requestCount.With(lvs...).Add(1)
+		m.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	resp, err = m.next.DebugTraceTransaction(hash, config)
+	return
+}
+
+func (m InstrumentingMiddleware) DebugTraceCall(
+	query eth.JsonTxCallObject, block eth.BlockHeight, config eth.JsonTraceConfig,
+) (resp interface{}, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "DebugTraceCall", "error", fmt.Sprint(err != nil)}
+		m.requestCount.With(lvs...).Add(1)
+		m.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	resp, err = m.next.DebugTraceCall(query, block, config)
+	return
+}
+
+func (m InstrumentingMiddleware) DebugTraceBlockByNumber(
+	block eth.BlockHeight, config eth.JsonTraceConfig,
+) (resp []eth.JsonTxTrace, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "DebugTraceBlockByNumber", "error", fmt.Sprint(err != nil)}
+		m.requestCount.With(lvs...).Add(1)
+		m.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	resp, err = m.next.DebugTraceBlockByNumber(block, config)
+	return
+}
+
+func (m InstrumentingMiddleware) LoomGetInternalTransactions(hash eth.Data) (resp *eth.JsonCallFrame, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"method", "LoomGetInternalTransactions", "error", fmt.Sprint(err != nil)}
+		m.requestCount.With(lvs...).Add(1)
+		m.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	resp, err = m.next.LoomGetInternalTransactions(hash)
+	return
+}
+
 func (m InstrumentingMiddleware) EthGetTransactionCount(
 	local eth.Data, block eth.BlockHeight,
 ) (resp eth.Quantity, err error) {
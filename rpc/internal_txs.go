@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/loomnetwork/loomchain"
+	"github.com/loomnetwork/loomchain/eth/query"
+	levm "github.com/loomnetwork/loomchain/evm"
+	"github.com/loomnetwork/loomchain/log"
+	"github.com/loomnetwork/loomchain/rpc/eth"
+)
+
+// LoomGetInternalTransactions returns the call tree of the internal txs (contract-to-contract calls
+// and value transfers) made by an EVM tx. The hash can be either an EVM tx hash or a Tendermint tx
+// hash. Internal txs are only indexed if EVMInternalTxIndexEnabled is set in loom.yml, nil is
+// returned if the internal txs of the tx weren't indexed.
+func (s *QueryServer) LoomGetInternalTransactions(hash eth.Data) (*eth.JsonCallFrame, error) {
+	txHash, err := eth.DecDataToBytes(hash)
+	if err != nil {
+		return nil, err
+	}
+	r := s.ReceiptHandlerProvider.Reader()
+	internalTxs := r.GetInternalTxs(txHash)
+	if internalTxs == nil {
+		// the receipts of committed txs are only indexed by the EVM tx hash
+		evmTxHash, err := s.evmTxHashFromTendermintHash(txHash)
+		if err != nil {
+			return nil, nil
+		}
+		internalTxs = r.GetInternalTxs(evmTxHash)
+	}
+	if internalTxs == nil {
+		return nil, nil
+	}
+	return decInternalTxs(internalTxs)
+}
+
+func (s *QueryServer) evmTxHashFromTendermintHash(hash []byte) ([]byte, error) {
+	txResult, err := s.BlockStore.GetTxResult(hash)
+	if err != nil {
+		return nil, err
+	}
+	blockResult, err := s.BlockStore.GetBlockByHeight(&txResult.Height)
+	if err != nil {
+		return nil, err
+	}
+	txObj, _, err := query.GetTxObjectFromBlockResult(blockResult, txResult, int64(txResult.Index))
+	if err != nil {
+		return nil, err
+	}
+	return eth.DecDataToBytes(txObj.Hash)
+}
+
+// addInternalTxs adds the call tree of the internal txs made by a tx to its receipt, if the
+// internal txs of the tx have been indexed.
+func addInternalTxs(r loomchain.ReadReceiptHandler, receipt *eth.JsonTxReceipt) {
+	txHash, err := eth.DecDataToBytes(receipt.TxHash)
+	if err != nil {
+		return
+	}
+	internalTxs := r.GetInternalTxs(txHash)
+	if internalTxs == nil {
+		return
+	}
+	receipt.InternalTransactions, err = decInternalTxs(internalTxs)
+	if err != nil {
+		log.Error("Failed to decode internal txs", "tx", receipt.TxHash, "err", err)
+	}
+}
+
+func decInternalTxs(internalTxs []byte) (*eth.JsonCallFrame, error) {
+	var callTree levm.CallFrame
+	if err := json.Unmarshal(internalTxs, &callTree); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal internal txs")
+	}
+	result := encCallFrame(&callTree)
+	return &result, nil
+}
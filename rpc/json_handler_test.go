@@ -47,8 +47,10 @@ var (
 		{"eth_unsubscribe", "EthUnsubscribe", ``},
 		{"eth_getBalance", "EthGetBalance", ``},
 		{"eth_estimateGas", "EthEstimateGas", ``},
+		{"eth_getProof", "EthGetProof", ``},
 		{"eth_gasPrice", "EthGasPrice", ``},
 		{"net_version", "EthNetVersion", ``},
+		{"eth_chainId", "EthChainId", ``},
 		{"eth_getTransactionCount", "EthGetTransactionCount", ``},
 		{"eth_accounts", "EthAccounts", ``},
 	}
@@ -67,7 +69,7 @@ func TestJsonRpcHandler(t *testing.T) {
 
 func testHttpJsonHandler(t *testing.T) {
 	qs := &MockQueryService{}
-	handler := MakeEthQueryServiceHandler(qs, testlog, nil)
+	handler := MakeEthQueryServiceHandler(qs, testlog, nil, false)
 
 	for _, test := range tests {
 		payload := `{"jsonrpc":"2.0","method":"` + test.method + `","params":[` + test.params + `],"id":99}`
@@ -81,7 +83,7 @@ func testHttpJsonHandler(t *testing.T) {
 
 func testBatchHttpJsonHandler(t *testing.T) {
 	qs := &MockQueryService{}
-	handler := MakeEthQueryServiceHandler(qs, testlog, nil)
+	handler := MakeEthQueryServiceHandler(qs, testlog, nil, false)
 
 	blockPayload := "["
 	first := true
@@ -118,7 +120,7 @@ func testEthSubscribeEthUnSubscribe(t *testing.T) {
 		AuthCfg:          auth.DefaultConfig(),
 		EthSubscriptions: eventHandler.EthSubscriptionSet(),
 	}
-	handler := MakeEthQueryServiceHandler(qs, testlog, hub)
+	handler := MakeEthQueryServiceHandler(qs, testlog, hub, false)
 
 	dialer := wstest.NewDialer(handler)
 	conn, _, err := dialer.Dial("ws://localhost/eth", nil)
@@ -145,7 +147,7 @@ func testMultipleWebsocketConnections(t *testing.T) {
 	hub := newHub()
 	go hub.run()
 	qs := &MockQueryService{}
-	handler := MakeEthQueryServiceHandler(qs, testlog, hub)
+	handler := MakeEthQueryServiceHandler(qs, testlog, hub, false)
 	conns := []*websocket.Conn{}
 	for _, test := range tests {
 		dialer := wstest.NewDialer(handler)
@@ -181,7 +183,7 @@ func testSingleWebsocketConnections(t *testing.T) {
 	hub := newHub()
 	go hub.run()
 	qs := &MockQueryService{}
-	handler := MakeEthQueryServiceHandler(qs, testlog, hub)
+	handler := MakeEthQueryServiceHandler(qs, testlog, hub, false)
 	dialer := wstest.NewDialer(handler)
 	conn, _, err := dialer.Dial("ws://localhost/eth", nil)
 	writeMutex := &sync.Mutex{}
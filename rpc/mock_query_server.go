@@ -18,13 +18,22 @@ type MockQueryService struct {
 	MethodsCalled []string
 }
 
-func (m *MockQueryService) Query(caller, contract string, query []byte, vmType vm.VMType) ([]byte, error) {
+func (m *MockQueryService) Query(
+	caller, contract string, query []byte, vmType vm.VMType, height int64,
+) ([]byte, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	m.MethodsCalled = append([]string{"Query"}, m.MethodsCalled...)
 	return nil, nil
 }
 
+func (m *MockQueryService) QueryStore(key []byte, height int64, prove bool) (*StoreQueryResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.MethodsCalled = append([]string{"QueryStore"}, m.MethodsCalled...)
+	return nil, nil
+}
+
 func (m *MockQueryService) Resolve(name string) (string, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -53,6 +62,22 @@ func (m *MockQueryService) UnSubscribe(wsCtx rpctypes.WSRPCContext, topics strin
 	return nil, nil
 }
 
+func (m *MockQueryService) SubscribeFrom(
+	wsCtx rpctypes.WSRPCContext, topics []string, fromHeight uint64, cursor string,
+) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.MethodsCalled = append([]string{"SubscribeFrom"}, m.MethodsCalled...)
+	return "", nil
+}
+
+func (m *MockQueryService) UnSubscribeFrom(wsCtx rpctypes.WSRPCContext, id string) (*WSEmptyResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.MethodsCalled = append([]string{"UnSubscribeFrom"}, m.MethodsCalled...)
+	return nil, nil
+}
+
 func (m *MockQueryService) QueryEnv() (*config.EnvInfo, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -221,6 +246,15 @@ func (m *MockQueryService) EthEstimateGas(query eth.JsonTxCallObject) (eth.Quant
 	return "", nil
 }
 
+func (m *MockQueryService) EthGetProof(
+	address eth.Data, storageKeys []eth.Data, block eth.BlockHeight,
+) (*eth.JsonAccountProof, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.MethodsCalled = append([]string{"EthGetProof"}, m.MethodsCalled...)
+	return nil, nil
+}
+
 func (m *MockQueryService) EthGasPrice() (eth.Quantity, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -235,6 +269,13 @@ func (m *MockQueryService) EthNetVersion() (string, error) {
 	return "", nil
 }
 
+func (m *MockQueryService) EthChainId() (eth.Quantity, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.MethodsCalled = append([]string{"EthChainId"}, m.MethodsCalled...)
+	return "", nil
+}
+
 func (m *MockQueryService) EthGetTransactionCount(address eth.Data, block eth.BlockHeight) (eth.Quantity, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -249,6 +290,38 @@ func (m *MockQueryService) EthAccounts() ([]eth.Data, error) {
 	return nil, nil
 }
 
+func (m *MockQueryService) DebugTraceTransaction(hash eth.Data, config eth.JsonTraceConfig) (interface{}, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.MethodsCalled = append([]string{"DebugTraceTransaction"}, m.MethodsCalled...)
+	return nil, nil
+}
+
+func (m *MockQueryService) DebugTraceCall(
+	query eth.JsonTxCallObject, block eth.BlockHeight, config eth.JsonTraceConfig,
+) (interface{}, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.MethodsCalled = append([]string{"DebugTraceCall"}, m.MethodsCalled...)
+	return nil, nil
+}
+
+func (m *MockQueryService) DebugTraceBlockByNumber(
+	block eth.BlockHeight, config eth.JsonTraceConfig,
+) ([]eth.JsonTxTrace, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.MethodsCalled = append([]string{"DebugTraceBlockByNumber"}, m.MethodsCalled...)
+	return nil, nil
+}
+
+func (m *MockQueryService) LoomGetInternalTransactions(hash eth.Data) (*eth.JsonCallFrame, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.MethodsCalled = append([]string{"LoomGetInternalTransactions"}, m.MethodsCalled...)
+	return nil, nil
+}
+
 func (m *MockQueryService) ContractEvents(
 	fromBlock uint64, toBlock uint64, contract string,
 ) (*types.ContractEventsResult, error) {
@@ -258,6 +331,15 @@ func (m *MockQueryService) ContractEvents(
 	return nil, nil
 }
 
+func (m *MockQueryService) QueryContractEvents(
+	fromBlock, toBlock uint64, contract string, topics []string, caller, txHash, cursor string, maxResults int,
+) (*ContractEventsPage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.MethodsCalled = append([]string{"QueryContractEvents"}, m.MethodsCalled...)
+	return nil, nil
+}
+
 func (m *MockQueryService) GetContractRecord(addr string) (*types.ContractRecordResponse, error) {
 	m.MethodsCalled = append([]string{"GetcontractRecord"}, m.MethodsCalled...)
 	return nil, nil
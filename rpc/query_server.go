@@ -6,6 +6,7 @@ import (
 	"math/big"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/phonkee/go-pubsub"
 	"github.com/pkg/errors"
 	abci "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/merkle"
 	ctypes "github.com/tendermint/tendermint/rpc/core/types"
 	rpctypes "github.com/tendermint/tendermint/rpc/lib/types"
 
@@ -51,11 +53,22 @@ const (
 
 	StatusTxSuccess = int32(1)
 	StatusTxFail    = int32(0)
+
+	// Number of events returned by QueryContractEvents if the caller doesn't specify a limit
+	defaultContractEventsPageSize = 100
+	maxContractEventsPageSize     = 1000
+	// Max number of events examined by a single QueryContractEvents call
+	maxContractEventsScanned = 10000
 )
 
 // StateProvider interface is used by QueryServer to access the read-only application state
 type StateProvider interface {
 	ReadOnlyState() loomchain.State
+	// ReadOnlyStateAt returns the read-only application state at the given block height.
+	ReadOnlyStateAt(height int64) (loomchain.State, error)
+	// GetWithProof returns the value of the given app store key at the given block height, along
+	// with a proof that can be verified against the app hash produced by the block at that height.
+	GetWithProof(key []byte, height int64) ([]byte, *merkle.Proof, int64, error)
 }
 
 // QueryServer provides the ability to query the current state of the DAppChain via RPC.
@@ -127,13 +140,24 @@ type QueryServer struct {
 	blockindex.BlockIndexStore
 	EventStore store.EventStore
 	AuthCfg    *auth.Config
+	// Caps the gas eth_estimateGas & debug_traceCall will give a tx, config.DefaultRPCGasCap is
+	// used if this is zero.
+	RPCGasCap uint64
+	// Upper bound of the time limit for tracing a single tx, 30 seconds if this is zero.
+	MaxTraceTimeout time.Duration
+	// If this is nil resumable event subscriptions won't be available.
+	ResumableSubscriptions *ResumableEventSubscriptions
 }
 
 var _ QueryService = &QueryServer{}
 
 // Query returns data of given contract from the application states
 // The contract parameter should be a hex-encoded local address prefixed by 0x
-func (s *QueryServer) Query(caller, contract string, query []byte, vmType vm.VMType) ([]byte, error) {
+// The height parameter can be used to query the state at a previous block height, if set to zero
+// the latest state will be queried.
+func (s *QueryServer) Query(
+	caller, contract string, query []byte, vmType vm.VMType, height int64,
+) ([]byte, error) {
 	var callerAddr loom.Address
 	var err error
 	if len(caller) == 0 {
@@ -154,11 +178,87 @@ func (s *QueryServer) Query(caller, contract string, query []byte, vmType vm.VMT
 		Local:   localContractAddr,
 	}
 
+	snapshot, err := s.stateAt(height)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+
 	if vmType == lvm.VMType_PLUGIN {
-		return s.queryPlugin(callerAddr, contractAddr, query)
+		return s.queryPlugin(snapshot, callerAddr, contractAddr, query)
 	} else {
-		return s.queryEvm(callerAddr, contractAddr, query)
+		return s.queryEvm(snapshot, callerAddr, contractAddr, query)
+	}
+}
+
+// StoreQueryResult is returned by QueryServer.QueryStore
+type StoreQueryResult struct {
+	Key    []byte `json:"key"`
+	Value  []byte `json:"value"`
+	Height int64  `json:"height"`
+	// Proof of the existence (or absence if the value is nil) of the key, only set if requested.
+	Proof *merkle.Proof `json:"proof,omitempty"`
+}
+
+// QueryStore returns the value of the given app store key at the given block height, if the height
+// is zero the latest state will be queried. If prove is true the result will contain an IAVL proof
+// of the key, which can be verified against the app hash in the header of the block that follows
+// the block at the returned height (see the proof package).
+func (s *QueryServer) QueryStore(key []byte, height int64, prove bool) (*StoreQueryResult, error) {
+	if len(key) == 0 {
+		return nil, errors.New("missing key")
 	}
+	if height < 0 {
+		return nil, errors.Errorf("invalid block height %d", height)
+	}
+	value, proof, height, err := s.StateProvider.GetWithProof(key, height)
+	if err != nil {
+		return nil, err
+	}
+	result := &StoreQueryResult{
+		Key:    key,
+		Value:  value,
+		Height: height,
+	}
+	if prove {
+		result.Proof = proof
+	}
+	return result, nil
+}
+
+// stateAt returns a read-only snapshot of the app state at the given block height, if the height
+// is zero the snapshot will be of the latest state.
+func (s *QueryServer) stateAt(height int64) (loomchain.State, error) {
+	snapshot := s.StateProvider.ReadOnlyState()
+	if height == 0 || height == snapshot.Block().Height {
+		return snapshot, nil
+	}
+	snapshot.Release()
+
+	if height < 0 {
+		return nil, errors.Errorf("invalid block height %d", height)
+	}
+	return s.StateProvider.ReadOnlyStateAt(height)
+}
+
+// ethStateAt returns a read-only snapshot of the app state at the given block height, if the height
+// is empty, "latest", or "pending" the snapshot will be of the latest state.
+func (s *QueryServer) ethStateAt(block eth.BlockHeight) (loomchain.State, error) {
+	snapshot := s.StateProvider.ReadOnlyState()
+	if block == "" {
+		return snapshot, nil
+	}
+	height, err := eth.DecBlockHeight(snapshot.Block().Height, block)
+	if err != nil {
+		snapshot.Release()
+		return nil, errors.Wrapf(err, "invalid block height %s", block)
+	}
+	// there's no pending state, so the latest state will have to do
+	if int64(height) >= snapshot.Block().Height {
+		return snapshot, nil
+	}
+	snapshot.Release()
+	return s.StateProvider.ReadOnlyStateAt(int64(height))
 }
 
 func (s *QueryServer) QueryEnv() (*config.EnvInfo, error) {
@@ -201,10 +301,9 @@ func (s *QueryServer) QueryEnv() (*config.EnvInfo, error) {
 	return &envInfo, err
 }
 
-func (s *QueryServer) queryPlugin(caller, contract loom.Address, query []byte) ([]byte, error) {
-	snapshot := s.StateProvider.ReadOnlyState()
-	defer snapshot.Release()
-
+func (s *QueryServer) queryPlugin(
+	snapshot loomchain.State, caller, contract loom.Address, query []byte,
+) ([]byte, error) {
 	callerAddr, err := auth.ResolveAccountAddress(caller, snapshot, s.AuthCfg, s.createAddressMapperCtx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to resolve account address")
@@ -242,34 +341,51 @@ func (s *QueryServer) queryPlugin(caller, contract loom.Address, query []byte) (
 	return resp.Body, nil
 }
 
-func (s *QueryServer) queryEvm(caller, contract loom.Address, query []byte) ([]byte, error) {
-	snapshot := s.StateProvider.ReadOnlyState()
-	defer snapshot.Release()
-
+func (s *QueryServer) queryEvm(
+	snapshot loomchain.State, caller, contract loom.Address, query []byte,
+) ([]byte, error) {
 	callerAddr, err := auth.ResolveAccountAddress(caller, snapshot, s.AuthCfg, s.createAddressMapperCtx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to resolve account address")
 	}
 
+	vm, err := s.createEVM(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return vm.StaticCall(callerAddr, contract, query)
+}
+
+// createEVM returns an EVM that operates on the given state, the EVM has access to the same account
+// balances & Go contracts as the EVM that executes txs.
+func (s *QueryServer) createEVM(state loomchain.State) (lvm.VM, error) {
+	pvm := lcp.NewPluginVM(
+		s.Loader,
+		state,
+		s.CreateRegistry(state),
+		nil,
+		log.Default,
+		s.NewABMFactory,
+		nil,
+		nil,
+	)
 	var createABM levm.AccountBalanceManagerFactoryFunc
 	if s.NewABMFactory != nil {
-		pvm := lcp.NewPluginVM(
-			s.Loader,
-			snapshot,
-			s.CreateRegistry(snapshot),
-			nil,
-			log.Default,
-			s.NewABMFactory,
-			nil,
-			nil,
-		)
+		var err error
 		createABM, err = s.NewABMFactory(pvm)
 		if err != nil {
 			return nil, err
 		}
 	}
-	vm := levm.NewLoomVm(snapshot, nil, nil, createABM, false)
-	return vm.StaticCall(callerAddr, contract, query)
+	return levm.NewLoomVm(state, nil, nil, createABM, lcp.NewGoContractCaller(pvm), false), nil
+}
+
+// rpcGasCap returns the maximum amount of gas that can be used by txs executed by RPC methods.
+func (s *QueryServer) rpcGasCap() uint64 {
+	if s.RPCGasCap == 0 {
+		return config.DefaultRPCGasCap
+	}
+	return s.RPCGasCap
 }
 
 // https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_call
@@ -291,7 +407,13 @@ func (s *QueryServer) EthCall(query eth.JsonTxCallObject, block eth.BlockHeight)
 	if err != nil {
 		return resp, err
 	}
-	bytes, err := s.queryEvm(caller, contract, data)
+	snapshot, err := s.ethStateAt(block)
+	if err != nil {
+		return resp, err
+	}
+	defer snapshot.Release()
+
+	bytes, err := s.queryEvm(snapshot, caller, contract, data)
 	return eth.EncBytes(bytes), err
 }
 
@@ -309,7 +431,7 @@ func (s *QueryServer) GetEvmCode(contract string) ([]byte, error) {
 	snapshot := s.StateProvider.ReadOnlyState()
 	defer snapshot.Release()
 
-	vm := levm.NewLoomVm(snapshot, nil, nil, nil, false)
+	vm := levm.NewLoomVm(snapshot, nil, nil, nil, nil, false)
 	return vm.GetCode(contractAddr)
 }
 
@@ -320,10 +442,13 @@ func (s *QueryServer) EthGetCode(address eth.Data, block eth.BlockHeight) (eth.D
 		return "", errors.Wrapf(err, "decoding input address parameter %v", address)
 	}
 
-	snapshot := s.StateProvider.ReadOnlyState()
+	snapshot, err := s.ethStateAt(block)
+	if err != nil {
+		return "", err
+	}
 	defer snapshot.Release()
 
-	evm := levm.NewLoomVm(snapshot, nil, nil, nil, false)
+	evm := levm.NewLoomVm(snapshot, nil, nil, nil, nil, false)
 	code, err := evm.GetCode(addr)
 	if err != nil {
 		return "", errors.Wrapf(err, "getting evm code for %v", address)
@@ -574,6 +699,85 @@ func (s *QueryServer) ContractEvents(
 	}, nil
 }
 
+// ContractEventsPage is a single page of events returned by QueryContractEvents.
+type ContractEventsPage struct {
+	Events    []*types.EventData `json:"events"`
+	FromBlock uint64             `json:"fromBlock"`
+	ToBlock   uint64             `json:"toBlock"`
+	// Cursor to pass in to the next query to fetch the next page of events, empty if there are no
+	// more events in the block range that may match the query.
+	NextCursor string `json:"nextCursor"`
+}
+
+// QueryContractEvents returns the events in the given block range that match the given contract,
+// topics, caller & tx hash (all of which are optional), at most maxResults events are returned at a
+// time, the rest can be fetched by passing the returned cursor back in.
+func (s *QueryServer) QueryContractEvents(
+	fromBlock, toBlock uint64, contract string, topics []string, caller, txHash, cursor string, maxResults int,
+) (*ContractEventsPage, error) {
+	if s.EventStore == nil {
+		return nil, errors.New("event store is not available")
+	}
+	if fromBlock == 0 {
+		return nil, fmt.Errorf("fromBlock not specified")
+	}
+	if toBlock == 0 {
+		snapshot := s.StateProvider.ReadOnlyState()
+		toBlock = uint64(snapshot.Block().Height)
+		snapshot.Release()
+	}
+	if toBlock < fromBlock {
+		return nil, fmt.Errorf("toBlock must be equal or greater than fromBlock")
+	}
+	if maxResults <= 0 {
+		maxResults = defaultContractEventsPageSize
+	} else if maxResults > maxContractEventsPageSize {
+		return nil, fmt.Errorf("maxResults exceeded, maximum: %v", maxContractEventsPageSize)
+	}
+
+	filter := store.EventFilter{
+		FromBlock:  fromBlock,
+		ToBlock:    toBlock,
+		Contract:   contract,
+		Topics:     topics,
+		Cursor:     cursor,
+		MaxResults: maxResults,
+		MaxScanned: maxContractEventsScanned,
+	}
+	if caller != "" {
+		var callerAddr loom.LocalAddress
+		var err error
+		if strings.Contains(caller, ":") {
+			addr, err := loom.ParseAddress(caller)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid caller")
+			}
+			callerAddr = addr.Local
+		} else if callerAddr, err = loom.LocalAddressFromHexString(caller); err != nil {
+			return nil, errors.Wrap(err, "invalid caller")
+		}
+		filter.Caller = callerAddr
+	}
+	if txHash != "" {
+		hash, err := hex.DecodeString(strings.TrimPrefix(txHash, "0x"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid txHash")
+		}
+		filter.TxHash = hash
+	}
+
+	result, err := s.EventStore.QueryEvents(filter)
+	if err != nil {
+		return nil, err
+	}
+	return &ContractEventsPage{
+		Events:     result.Events,
+		FromBlock:  fromBlock,
+		ToBlock:    toBlock,
+		NextCursor: result.NextCursor,
+	}, nil
+}
+
 func (s *QueryServer) GetContractRecord(contractAddrStr string) (*types.ContractRecordResponse, error) {
 	contractAddr, err := loom.ParseAddress(contractAddrStr)
 	if err != nil {
@@ -755,6 +959,7 @@ func (s *QueryServer) EthGetTransactionReceipt(hash eth.Data) (*eth.JsonTxReceip
 			}
 			return nil, err
 		}
+		addInternalTxs(r, resp)
 		return resp, nil
 	}
 	snapshot.Release()
@@ -778,7 +983,16 @@ func (s *QueryServer) EthGetTransactionReceipt(hash eth.Data) (*eth.JsonTxReceip
 		}
 		return nil, err
 	}
-	return completeReceipt(txResults, blockResult, &txReceipt), nil
+	txFailed := txReceipt.Status == StatusTxFail
+	jsonReceipt := completeReceipt(txResults, blockResult, &txReceipt)
+	if txFailed {
+		jsonReceipt.Status = eth.EncInt(int64(StatusTxFail))
+		if revertData := r.GetRevertData(txHash); len(revertData) > 0 {
+			jsonReceipt.RevertReason = eth.EncBytes(revertData)
+		}
+	}
+	addInternalTxs(r, jsonReceipt)
+	return jsonReceipt, nil
 }
 
 // https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_getblocktransactioncountbyhash
@@ -1025,15 +1239,11 @@ func (s *QueryServer) EthGetBalance(address eth.Data, block eth.BlockHeight) (et
 		return "", errors.Wrapf(err, "decoding input address parameter %v", address)
 	}
 
-	snapshot := s.StateProvider.ReadOnlyState()
-	defer snapshot.Release()
-	height, err := eth.DecBlockHeight(snapshot.Block().Height, block)
+	snapshot, err := s.ethStateAt(block)
 	if err != nil {
-		return "", errors.Wrapf(err, "invalid block height %s", block)
-	}
-	if int64(height) != snapshot.Block().Height {
-		return "", errors.Errorf("height %s not latest", block)
+		return "", err
 	}
+	defer snapshot.Release()
 
 	ctx, err := s.createStaticContractCtx(snapshot, "ethcoin")
 	if err != nil {
@@ -1050,8 +1260,143 @@ func (s *QueryServer) EthGetBalance(address eth.Data, block eth.BlockHeight) (et
 	return eth.EncBigInt(*amount.Int), nil
 }
 
+// EthEstimateGas executes the given tx against the latest state without persisting any changes,
+// and returns the smallest gas limit with which the tx succeeds. If the tx fails regardless of the
+// gas limit an error containing the revert reason (if any) is returned.
+// https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_estimategas
 func (s *QueryServer) EthEstimateGas(query eth.JsonTxCallObject) (eth.Quantity, error) {
-	return eth.Quantity("0x0"), nil
+	var err error
+	var caller, contract loom.Address
+	if len(query.From) > 0 {
+		caller, err = eth.DecDataToAddress(s.ChainID, query.From)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		caller = loom.RootAddress(s.ChainID)
+	}
+	// contract deployments don't specify a recipient
+	if len(query.To) > 0 {
+		contract, err = eth.DecDataToAddress(s.ChainID, query.To)
+		if err != nil {
+			return "", err
+		}
+	}
+	var data []byte
+	if len(query.Data) > 2 {
+		data, err = eth.DecDataToBytes(query.Data)
+		if err != nil {
+			return "", err
+		}
+	}
+	var value *loom.BigUInt
+	if len(query.Value) > 0 {
+		v, ok := new(big.Int).SetString(strings.TrimPrefix(string(query.Value), "0x"), 16)
+		if !ok {
+			return "", errors.Errorf("invalid value %v", query.Value)
+		}
+		value = loom.NewBigUInt(v)
+	}
+	// Txs that never terminate would otherwise be probed with practically unlimited gas.
+	maxGas := s.rpcGasCap()
+	if len(query.Gas) > 0 {
+		gas, err := eth.DecQuantityToUint(query.Gas)
+		if err != nil {
+			return "", err
+		}
+		if gas < maxGas {
+			maxGas = gas
+		}
+	}
+
+	snapshot := s.StateProvider.ReadOnlyState()
+	defer snapshot.Release()
+
+	// Same as Geth the search is also bounded by the block gas limit, if one is configured.
+	if blockGasLimit := snapshot.Config().GetEvm().GetGasLimit(); blockGasLimit > 0 && blockGasLimit < maxGas {
+		maxGas = blockGasLimit
+	}
+
+	callerAddr, err := auth.ResolveAccountAddress(caller, snapshot, s.AuthCfg, s.createAddressMapperCtx)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve account address")
+	}
+
+	gas, err := levm.EstimateGas(func(gas uint64) (uint64, []byte, error) {
+		// Each attempt must start from the same state, so changes made by the previous attempt
+		// are discarded by running each one in a fresh scratch state.
+		state := loomchain.NewScratchState(snapshot)
+		vm, err := s.createEVM(state)
+		if err != nil {
+			return 0, nil, err
+		}
+		estimator, ok := vm.(levm.GasEstimator)
+		if !ok {
+			return 0, nil, errors.New("EVM is not available")
+		}
+		return estimator.DryRun(callerAddr, contract, data, value, gas)
+	}, maxGas)
+	if err != nil {
+		if execErr, ok := err.(*levm.ExecutionError); ok && len(execErr.Data) > 0 {
+			return "", eth.NewError(eth.EcReverted, execErr.Error(), string(eth.EncBytes(execErr.Data)))
+		}
+		return "", err
+	}
+	return eth.EncUint(gas), nil
+}
+
+// EthGetProof returns the state of the given EVM account, and the values of the given storage slots,
+// along with Merkle proofs that can be verified against the Patricia root of the EVM state.
+// The Patricia root is tied to the app hash via the app store key proof.EvmRootKey.
+// https://github.com/ethereum/EIPs/blob/master/EIPS/eip-1186.md
+func (s *QueryServer) EthGetProof(
+	address eth.Data, storageKeys []eth.Data, block eth.BlockHeight,
+) (*eth.JsonAccountProof, error) {
+	addr, err := eth.DecDataToAddress(s.ChainID, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding input address parameter %v", address)
+	}
+	keys := make([][]byte, 0, len(storageKeys))
+	for _, storageKey := range storageKeys {
+		key, err := eth.DecDataToBytes(storageKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding storage key %v", storageKey)
+		}
+		keys = append(keys, key)
+	}
+
+	snapshot, err := s.ethStateAt(block)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+
+	prover, ok := levm.NewLoomVm(snapshot, nil, nil, nil, nil, false).(levm.ProofProvider)
+	if !ok {
+		return nil, errors.New("EVM is not available")
+	}
+	accountProof, err := prover.GetProof(addr, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &eth.JsonAccountProof{
+		Address:      address,
+		AccountProof: eth.EncBytesArray(accountProof.Proof),
+		Balance:      eth.EncBigInt(*accountProof.Balance),
+		CodeHash:     eth.EncBytes(accountProof.CodeHash),
+		Nonce:        eth.EncUint(accountProof.Nonce),
+		StorageHash:  eth.EncBytes(accountProof.StorageHash),
+		StorageProof: make([]eth.JsonStorageProof, 0, len(accountProof.StorageProof)),
+	}
+	for i, storageProof := range accountProof.StorageProof {
+		result.StorageProof = append(result.StorageProof, eth.JsonStorageProof{
+			Key:   storageKeys[i],
+			Value: eth.EncBigInt(*new(big.Int).SetBytes(storageProof.Value)),
+			Proof: eth.EncBytesArray(storageProof.Proof),
+		})
+	}
+	return result, nil
 }
 
 func (s *QueryServer) EthGasPrice() (eth.Quantity, error) {
@@ -1059,10 +1404,21 @@ func (s *QueryServer) EthGasPrice() (eth.Quantity, error) {
 }
 
 func (s *QueryServer) EthNetVersion() (string, error) {
+	return s.ethChainID().String(), nil
+}
+
+// https://github.com/ethereum/EIPs/blob/master/EIPS/eip-695.md
+func (s *QueryServer) EthChainId() (eth.Quantity, error) {
+	return eth.EncBigInt(*s.ethChainID()), nil
+}
+
+// ethChainID returns the number returned by net_version & eth_chainId, which is derived from the
+// Loom chain ID.
+func (s *QueryServer) ethChainID() *big.Int {
 	hash := sha3.SoliditySHA3(sha3.String(s.ChainID))
 	versionBigInt := new(big.Int)
 	versionBigInt.SetString(hex.EncodeToString(hash)[0:13], 16)
-	return versionBigInt.String(), nil
+	return versionBigInt
 }
 
 func (s *QueryServer) EthAccounts() ([]eth.Data, error) {
@@ -0,0 +1,247 @@
+// +build evm
+
+package rpc
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gogo/protobuf/proto"
+	"github.com/loomnetwork/go-loom"
+	lauth "github.com/loomnetwork/go-loom/auth"
+	"github.com/loomnetwork/go-loom/types"
+	lvm "github.com/loomnetwork/go-loom/vm"
+	"github.com/loomnetwork/loomchain"
+	"github.com/loomnetwork/loomchain/auth"
+	levm "github.com/loomnetwork/loomchain/evm"
+	llog "github.com/loomnetwork/loomchain/log"
+	rcommon "github.com/loomnetwork/loomchain/receipts/common"
+	registry "github.com/loomnetwork/loomchain/registry/factory"
+	"github.com/loomnetwork/loomchain/rpc/eth"
+	"github.com/loomnetwork/loomchain/store"
+	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/tendermint/abci/types"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmstate "github.com/tendermint/tendermint/state"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// Runtime byte-code of a contract that stores the word passed to it in slot 0, or returns the value
+// of slot 0 if it's called with less than a word of input.
+var storageCode = []byte{
+	0x60, 0x20, 0x36, 0x10, 0x60, 0x0e, 0x57, // JUMPI(14, LT(CALLDATASIZE, 32))
+	0x60, 0x00, 0x35, 0x60, 0x00, 0x55, 0x00, // SSTORE(0, CALLDATALOAD(0)), STOP
+	0x5b, 0x60, 0x00, 0x54, 0x60, 0x00, 0x52, // JUMPDEST, MSTORE(0, SLOAD(0))
+	0x60, 0x20, 0x60, 0x00, 0xf3, // RETURN(0, 32)
+}
+
+// Runtime byte-code of a contract that loops until it runs out of gas.
+var loopCode = []byte{0x5b, 0x60, 0x00, 0x56} // JUMPDEST, JUMP(0)
+
+// deployCode returns byte-code that deploys a contract with the given runtime byte-code.
+func deployCode(code []byte) []byte {
+	// PUSH1 len, DUP1, PUSH1 11, PUSH1 0, CODECOPY, PUSH1 0, RETURN
+	return append([]byte{0x60, byte(len(code)), 0x80, 0x60, 0x0b, 0x60, 0x00, 0x39, 0x60, 0x00, 0xf3}, code...)
+}
+
+func TestEthCallHistoricalState(t *testing.T) {
+	createRegistry, err := registry.NewRegistryFactory(registry.LatestRegistryVersion)
+	require.NoError(t, err)
+	sp := newAppStoreStateProvider(t, "default")
+	caller := loom.MustParseAddress("default:0xb16a379ec18d4093666f8f38b11a3071c920207d")
+
+	var contractAddr loom.Address
+	for i := int64(1); i <= 3; i++ {
+		sp.commitBlock(t, func(state loomchain.State) {
+			vm := levm.NewLoomVm(state, nil, nil, nil, nil, false)
+			if i == 1 {
+				_, contractAddr, err = vm.Create(caller, deployCode(storageCode), nil)
+				require.NoError(t, err)
+			}
+			_, err := vm.Call(caller, contractAddr, common.BigToHash(big.NewInt(i)).Bytes(), nil)
+			require.NoError(t, err)
+		})
+	}
+
+	qs := &QueryServer{
+		ChainID:        "default",
+		StateProvider:  sp,
+		Loader:         &queryableContractLoader{TMLogger: llog.Root.With("module", "contract")},
+		CreateRegistry: createRegistry,
+		BlockStore:     store.NewMockBlockStore(),
+		AuthCfg:        auth.DefaultConfig(),
+	}
+	query := eth.JsonTxCallObject{
+		From: eth.EncAddress(caller.MarshalPB()),
+		To:   eth.EncAddress(contractAddr.MarshalPB()),
+		Data: "0x00",
+	}
+	for height := int64(1); height <= 3; height++ {
+		result, err := qs.EthCall(query, eth.BlockHeight(eth.EncInt(height)))
+		require.NoError(t, err)
+		require.Equal(t, eth.EncBytes(common.BigToHash(big.NewInt(height)).Bytes()), result)
+	}
+
+	result, err := qs.EthCall(query, "latest")
+	require.NoError(t, err)
+	require.Equal(t, eth.EncBytes(common.BigToHash(big.NewInt(3)).Bytes()), result)
+}
+
+func TestEthEstimateGasIsCapped(t *testing.T) {
+	createRegistry, err := registry.NewRegistryFactory(registry.LatestRegistryVersion)
+	require.NoError(t, err)
+	sp := newAppStoreStateProvider(t, "default")
+	caller := loom.MustParseAddress("default:0xb16a379ec18d4093666f8f38b11a3071c920207d")
+
+	var contractAddr loom.Address
+	sp.commitBlock(t, func(state loomchain.State) {
+		vm := levm.NewLoomVm(state, nil, nil, nil, nil, false)
+		_, contractAddr, err = vm.Create(caller, deployCode(loopCode), nil)
+		require.NoError(t, err)
+	})
+
+	qs := &QueryServer{
+		ChainID:        "default",
+		StateProvider:  sp,
+		Loader:         &queryableContractLoader{TMLogger: llog.Root.With("module", "contract")},
+		CreateRegistry: createRegistry,
+		BlockStore:     store.NewMockBlockStore(),
+		AuthCfg:        auth.DefaultConfig(),
+		RPCGasCap:      100000,
+	}
+	query := eth.JsonTxCallObject{
+		From: eth.EncAddress(caller.MarshalPB()),
+		To:   eth.EncAddress(contractAddr.MarshalPB()),
+	}
+	_, err = qs.EthEstimateGas(query)
+	require.Error(t, err)
+
+	// a gas limit above the cap shouldn't lift it
+	query.Gas = eth.EncUint(math.MaxUint64)
+	_, err = qs.EthEstimateGas(query)
+	require.Error(t, err)
+}
+
+// traceBlockStore returns the same block & block results for every height.
+type traceBlockStore struct {
+	store.BlockStore
+	block   *ctypes.ResultBlock
+	results *ctypes.ResultBlockResults
+}
+
+func (s *traceBlockStore) GetBlockByHeight(height *int64) (*ctypes.ResultBlock, error) {
+	return s.block, nil
+}
+
+func (s *traceBlockStore) GetBlockResults(height *int64) (*ctypes.ResultBlockResults, error) {
+	return s.results, nil
+}
+
+type traceReceiptHandlerProvider struct {
+	loomchain.ReceiptHandlerProvider
+	loomchain.ReadReceiptHandler
+	receipts map[string]types.EvmTxReceipt
+}
+
+func (p *traceReceiptHandlerProvider) Reader() loomchain.ReadReceiptHandler {
+	return p
+}
+
+func (p *traceReceiptHandlerProvider) GetReceipt(txHash []byte) (types.EvmTxReceipt, error) {
+	receipt, ok := p.receipts[string(txHash)]
+	if !ok {
+		return receipt, rcommon.ErrTxReceiptNotFound
+	}
+	return receipt, nil
+}
+
+// encodeEvmCallTx returns a signed tx that calls the given EVM contract.
+func encodeEvmCallTx(t *testing.T, caller, contract loom.Address, input []byte) tmtypes.Tx {
+	callTx, err := proto.Marshal(&lvm.CallTx{VmType: lvm.VMType_EVM, Input: input})
+	require.NoError(t, err)
+	msgTx, err := proto.Marshal(&lvm.MessageTx{From: caller.MarshalPB(), To: contract.MarshalPB(), Data: callTx})
+	require.NoError(t, err)
+	txTx, err := proto.Marshal(&types.Transaction{Id: callTxID, Data: msgTx})
+	require.NoError(t, err)
+	nonceTx, err := proto.Marshal(&lauth.NonceTx{Inner: txTx, Sequence: 1})
+	require.NoError(t, err)
+	signedTx, err := proto.Marshal(&lauth.SignedTx{Inner: nonceTx})
+	require.NoError(t, err)
+	return signedTx
+}
+
+func TestDebugTraceTransactionInMultiTxBlock(t *testing.T) {
+	createRegistry, err := registry.NewRegistryFactory(registry.LatestRegistryVersion)
+	require.NoError(t, err)
+	sp := newAppStoreStateProvider(t, "default")
+	caller := loom.MustParseAddress("default:0xb16a379ec18d4093666f8f38b11a3071c920207d")
+
+	var contractAddr loom.Address
+	sp.commitBlock(t, func(state loomchain.State) {
+		vm := levm.NewLoomVm(state, nil, nil, nil, nil, false)
+		_, contractAddr, err = vm.Create(caller, deployCode(storageCode), nil)
+		require.NoError(t, err)
+	})
+
+	// block 2 contains two calls, the receipts of both report the index of the last tx in the block
+	inputs := [][]byte{common.BigToHash(big.NewInt(5)).Bytes(), common.BigToHash(big.NewInt(7)).Bytes()}
+	evmTxHashes := [][]byte{common.BytesToHash([]byte{1}).Bytes(), common.BytesToHash([]byte{2}).Bytes()}
+	txs := tmtypes.Txs{}
+	deliverTxs := []*abci.ResponseDeliverTx{}
+	receipts := map[string]types.EvmTxReceipt{}
+	for i, input := range inputs {
+		txs = append(txs, encodeEvmCallTx(t, caller, contractAddr, input))
+		deliverTxs = append(deliverTxs, &abci.ResponseDeliverTx{Code: abci.CodeTypeOK, Data: evmTxHashes[i]})
+		receipts[string(evmTxHashes[i])] = types.EvmTxReceipt{
+			TxHash:           evmTxHashes[i],
+			BlockNumber:      2,
+			TransactionIndex: int32(len(inputs) - 1),
+		}
+	}
+	sp.commitBlock(t, func(state loomchain.State) {
+		vm := levm.NewLoomVm(state, nil, nil, nil, nil, false)
+		for _, input := range inputs {
+			_, err := vm.Call(caller, contractAddr, input, nil)
+			require.NoError(t, err)
+		}
+	})
+
+	qs := &QueryServer{
+		ChainID:        "default",
+		StateProvider:  sp,
+		Loader:         &queryableContractLoader{TMLogger: llog.Root.With("module", "contract")},
+		CreateRegistry: createRegistry,
+		BlockStore: &traceBlockStore{
+			block: &ctypes.ResultBlock{
+				BlockMeta: &tmtypes.BlockMeta{},
+				Block: &tmtypes.Block{
+					Header: tmtypes.Header{ChainID: "default", Height: 2, NumTxs: int64(len(txs))},
+					Data:   tmtypes.Data{Txs: txs},
+				},
+			},
+			results: &ctypes.ResultBlockResults{
+				Height:  2,
+				Results: &tmstate.ABCIResponses{DeliverTx: deliverTxs},
+			},
+		},
+		ReceiptHandlerProvider: &traceReceiptHandlerProvider{receipts: receipts},
+		AuthCfg:                auth.DefaultConfig(),
+	}
+	traceCfg := eth.JsonTraceConfig{Tracer: levm.CallTracer}
+	for i, input := range inputs {
+		result, err := qs.DebugTraceTransaction(eth.EncBytes(evmTxHashes[i]), traceCfg)
+		require.NoError(t, err)
+		require.Equal(t, eth.EncBytes(input), result.(eth.JsonCallFrame).Input)
+	}
+
+	traces, err := qs.DebugTraceBlockByNumber("0x2", traceCfg)
+	require.NoError(t, err)
+	require.Len(t, traces, len(inputs))
+	for i, input := range inputs {
+		require.Equal(t, eth.EncBytes(evmTxHashes[i]), traces[i].TxHash)
+		require.Empty(t, traces[i].Error)
+		require.Equal(t, eth.EncBytes(input), traces[i].Result.(eth.JsonCallFrame).Input)
+	}
+}
@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -11,18 +12,24 @@ import (
 
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	proto "github.com/gogo/protobuf/proto"
+	"github.com/loomnetwork/go-loom"
 	lp "github.com/loomnetwork/go-loom/plugin"
 	"github.com/loomnetwork/go-loom/plugin/types"
+	lvm "github.com/loomnetwork/go-loom/vm"
 	"github.com/loomnetwork/loomchain"
 	"github.com/loomnetwork/loomchain/auth"
+	"github.com/loomnetwork/loomchain/builtin/plugins/ethcoin"
+	"github.com/loomnetwork/loomchain/db"
 	"github.com/loomnetwork/loomchain/eth/subs"
 	llog "github.com/loomnetwork/loomchain/log"
 	"github.com/loomnetwork/loomchain/plugin"
 	registry "github.com/loomnetwork/loomchain/registry/factory"
+	"github.com/loomnetwork/loomchain/rpc/eth"
 	"github.com/loomnetwork/loomchain/store"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 	abci "github.com/tendermint/tendermint/abci/types"
+	"github.com/tendermint/tendermint/crypto/merkle"
 	dbm "github.com/tendermint/tendermint/libs/db"
 	rpcclient "github.com/tendermint/tendermint/rpc/lib/client"
 )
@@ -72,6 +79,12 @@ func (c *queryableContract) StaticCall(ctx lp.StaticContext, req *lp.Request) (*
 		}
 		return nil, errors.New("unsupported content type")
 	}
+	if "value" == cmc.Method {
+		return &plugin.Response{
+			ContentType: lp.EncodingType_PROTOBUF3,
+			Body:        ctx.Get([]byte("value")),
+		}, nil
+	}
 	return nil, errors.New("invalid query")
 }
 
@@ -101,6 +114,71 @@ func (s *stateProvider) ReadOnlyState() loomchain.State {
 	)
 }
 
+func (s *stateProvider) ReadOnlyStateAt(height int64) (loomchain.State, error) {
+	return nil, fmt.Errorf("state at height %d is not available", height)
+}
+
+func (s *stateProvider) GetWithProof(key []byte, height int64) ([]byte, *merkle.Proof, int64, error) {
+	return nil, nil, 0, fmt.Errorf("state at height %d is not available", height)
+}
+
+// appStoreStateProvider provides access to the state stored in a real multi-version app store.
+type appStoreStateProvider struct {
+	ChainID string
+	store   *store.MultiWriterAppStore
+}
+
+func newAppStoreStateProvider(t *testing.T, chainID string) *appStoreStateProvider {
+	iavlStore, err := store.NewIAVLStore(dbm.NewMemDB(), 0, 0, 0)
+	require.NoError(t, err)
+	evmDB, err := db.LoadMemDB()
+	require.NoError(t, err)
+	appStore, err := store.NewMultiWriterAppStore(iavlStore, store.NewEvmStore(evmDB, 100), false)
+	require.NoError(t, err)
+	return &appStoreStateProvider{ChainID: chainID, store: appStore}
+}
+
+// commitBlock applies the given changes to the state, and saves the result as the next block.
+func (s *appStoreStateProvider) commitBlock(t *testing.T, update func(state loomchain.State)) {
+	update(loomchain.NewStoreState(
+		context.Background(),
+		s.store,
+		abci.Header{ChainID: s.ChainID, Height: s.store.Version() + 1},
+		nil,
+		nil,
+	))
+	_, _, err := s.store.SaveVersion()
+	require.NoError(t, err)
+}
+
+func (s *appStoreStateProvider) ReadOnlyState() loomchain.State {
+	return loomchain.NewStoreStateSnapshot(
+		nil,
+		s.store.GetSnapshot(),
+		abci.Header{ChainID: s.ChainID, Height: s.store.Version()},
+		nil,
+		nil,
+	)
+}
+
+func (s *appStoreStateProvider) ReadOnlyStateAt(height int64) (loomchain.State, error) {
+	snap, err := s.store.GetSnapshotAt(height)
+	if err != nil {
+		return nil, err
+	}
+	return loomchain.NewStoreStateSnapshot(
+		nil, snap, abci.Header{ChainID: s.ChainID, Height: height}, nil, nil,
+	), nil
+}
+
+func (s *appStoreStateProvider) GetWithProof(key []byte, height int64) ([]byte, *merkle.Proof, int64, error) {
+	if height == 0 {
+		height = s.store.Version()
+	}
+	value, proof, err := s.store.GetWithProof(key, height)
+	return value, proof, height, err
+}
+
 var testlog llog.TMLogger
 
 func TestQueryServer(t *testing.T) {
@@ -112,6 +190,7 @@ func TestQueryServer(t *testing.T) {
 	t.Run("Query Contract Events", testQueryServerContractEvents)
 	t.Run("Query Contract Events Without Event", testQueryServerContractEventsNoEventStore)
 	t.Run("Query Contract Information", testQueryServerGetContractRecord)
+	t.Run("Query Historical State", testQueryServerHistoricalState)
 }
 
 func testQueryServerContractQuery(t *testing.T) {
@@ -171,6 +250,64 @@ func testQueryServerContractQuery(t *testing.T) {
 
 }
 
+func testQueryServerHistoricalState(t *testing.T) {
+	loader := &queryableContractLoader{TMLogger: llog.Root.With("module", "contract")}
+	createRegistry, err := registry.NewRegistryFactory(registry.LatestRegistryVersion)
+	require.NoError(t, err)
+	sp := newAppStoreStateProvider(t, "default")
+	contractAddr := loom.MustParseAddress("default:0x005B17864f3adbF53b1384F2E6f2120c6652F779")
+	ethCoinAddr := loom.MustParseAddress("default:0x0000000000000000000000000000000000e7c011")
+	owner := loom.MustParseAddress("default:0xb16a379ec18d4093666f8f38b11a3071c920207d")
+
+	for i := int64(1); i <= 3; i++ {
+		sp.commitBlock(t, func(state loomchain.State) {
+			if i == 1 {
+				require.NoError(t, createRegistry(state).Register("ethcoin", ethCoinAddr, ethCoinAddr))
+			}
+			state.WithPrefix(loom.DataPrefix(contractAddr)).Set(
+				[]byte("value"), []byte(fmt.Sprintf("value%d", i)),
+			)
+			pvm := plugin.NewPluginVM(loader, state, createRegistry(state), nil, llog.Default, nil, nil, nil)
+			ctx, err := plugin.NewInternalContractContext("ethcoin", pvm, false)
+			require.NoError(t, err)
+			require.NoError(t, ethcoin.AddBalance(ctx, owner, loom.NewBigUIntFromInt(100)))
+		})
+	}
+
+	qs := &QueryServer{
+		ChainID:        "default",
+		StateProvider:  sp,
+		Loader:         loader,
+		CreateRegistry: createRegistry,
+		BlockStore:     store.NewMockBlockStore(),
+		AuthCfg:        auth.DefaultConfig(),
+	}
+	query, err := proto.Marshal(&lp.ContractMethodCall{Method: "value"})
+	require.NoError(t, err)
+	ownerAddr := eth.EncAddress(owner.MarshalPB())
+
+	for height := int64(1); height <= 3; height++ {
+		result, err := qs.Query("", "0x005B17864f3adbF53b1384F2E6f2120c6652F779", query, lvm.VMType_PLUGIN, height)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("value%d", height), string(result))
+
+		balance, err := qs.EthGetBalance(ownerAddr, eth.BlockHeight(eth.EncInt(height)))
+		require.NoError(t, err)
+		require.Equal(t, eth.EncInt(height*100), balance)
+	}
+
+	// the latest state is queried by default
+	result, err := qs.Query("", "0x005B17864f3adbF53b1384F2E6f2120c6652F779", query, lvm.VMType_PLUGIN, 0)
+	require.NoError(t, err)
+	require.Equal(t, "value3", string(result))
+	balance, err := qs.EthGetBalance(ownerAddr, "latest")
+	require.NoError(t, err)
+	require.Equal(t, eth.EncInt(300), balance)
+
+	_, err = qs.Query("", "0x005B17864f3adbF53b1384F2E6f2120c6652F779", query, lvm.VMType_PLUGIN, 4)
+	require.Error(t, err)
+}
+
 func testQueryServerNonce(t *testing.T) {
 	var qs QueryService = &QueryServer{
 		ChainID: "default",
@@ -407,6 +544,46 @@ func testQueryServerContractEvents(t *testing.T) {
 		_, err := rpcClient.Call("contractevents", params, result)
 		require.NotNil(t, err)
 	})
+
+	t.Run("Test query events by topic", func(t *testing.T) {
+		var events []*types.EventData
+		for i := 0; i < 5; i++ {
+			events = append(events, &types.EventData{
+				PluginName:  "plugin2",
+				BlockHeight: 2,
+				Topics:      []string{"event:transfer", fmt.Sprintf("event:%d", i%2)},
+				EncodedBody: []byte(fmt.Sprintf("event-%d-%d", 2, i)),
+			})
+		}
+		require.NoError(t, eventStore.BatchSaveEvents(events))
+
+		params := map[string]interface{}{}
+		params["fromBlock"] = 1
+		params["toBlock"] = 2
+		params["topics"] = []string{"event:transfer", "event:0"}
+		params["maxResults"] = 2
+
+		result := &ContractEventsPage{}
+		_, err := rpcClient.Call("querycontractevents", params, result)
+		require.NoError(t, err)
+		require.Len(t, result.Events, 2)
+		require.NotEqual(t, "", result.NextCursor)
+		require.Equal(t, []byte("event-2-0"), result.Events[0].EncodedBody)
+		require.Equal(t, []byte("event-2-2"), result.Events[1].EncodedBody)
+
+		params["cursor"] = result.NextCursor
+		result = &ContractEventsPage{}
+		_, err = rpcClient.Call("querycontractevents", params, result)
+		require.NoError(t, err)
+		require.Len(t, result.Events, 1)
+		require.Equal(t, "", result.NextCursor)
+		require.Equal(t, []byte("event-2-4"), result.Events[0].EncodedBody)
+
+		// page size exceeds the max
+		params["maxResults"] = maxContractEventsPageSize + 1
+		_, err = rpcClient.Call("querycontractevents", params, result)
+		require.Error(t, err)
+	})
 }
 
 func testQueryServerContractEventsNoEventStore(t *testing.T) {
@@ -467,3 +644,14 @@ func testQueryServerGetContractRecord(t *testing.T) {
 		require.NotNil(t, err)
 	})
 }
+
+func TestEthChainIdMatchesNetVersion(t *testing.T) {
+	qs := &QueryServer{ChainID: "default"}
+	netVersion, err := qs.EthNetVersion()
+	require.NoError(t, err)
+	chainID, err := qs.EthChainId()
+	require.NoError(t, err)
+	id, err := eth.DecQuantityToUint(chainID)
+	require.NoError(t, err)
+	require.Equal(t, netVersion, fmt.Sprint(id))
+}
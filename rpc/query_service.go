@@ -2,6 +2,8 @@ package rpc
 
 import (
 	"net/http"
+	"path/filepath"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -18,16 +20,21 @@ import (
 	"github.com/loomnetwork/loomchain/eth/subs"
 	"github.com/loomnetwork/loomchain/log"
 	"github.com/loomnetwork/loomchain/rpc/eth"
+	"github.com/loomnetwork/loomchain/store"
 	"github.com/loomnetwork/loomchain/vm"
+	"github.com/pkg/errors"
 )
 
 // QueryService provides necessary methods for the client to query application states
 type QueryService interface {
-	Query(caller, contract string, query []byte, vmType vm.VMType) ([]byte, error)
+	Query(caller, contract string, query []byte, vmType vm.VMType, height int64) ([]byte, error)
+	QueryStore(key []byte, height int64, prove bool) (*StoreQueryResult, error)
 	Resolve(name string) (string, error)
 	Nonce(key, account string) (uint64, error)
 	Subscribe(wsCtx rpctypes.WSRPCContext, topics []string) (*WSEmptyResult, error)
 	UnSubscribe(wsCtx rpctypes.WSRPCContext, topics string) (*WSEmptyResult, error)
+	SubscribeFrom(wsCtx rpctypes.WSRPCContext, topics []string, fromHeight uint64, cursor string) (string, error)
+	UnSubscribeFrom(wsCtx rpctypes.WSRPCContext, id string) (*WSEmptyResult, error)
 	QueryEnv() (*config.EnvInfo, error)
 	// New JSON web3 methods
 	EthBlockNumber() (eth.Quantity, error)
@@ -55,12 +62,23 @@ type QueryService interface {
 
 	EthGetBalance(address eth.Data, block eth.BlockHeight) (eth.Quantity, error)
 	EthEstimateGas(query eth.JsonTxCallObject) (eth.Quantity, error)
+	EthGetProof(address eth.Data, storageKeys []eth.Data, block eth.BlockHeight) (*eth.JsonAccountProof, error)
 	EthGasPrice() (eth.Quantity, error)
 	EthNetVersion() (string, error)
+	EthChainId() (eth.Quantity, error)
 	EthGetTransactionCount(local eth.Data, block eth.BlockHeight) (eth.Quantity, error)
 	EthAccounts() ([]eth.Data, error)
 
+	DebugTraceTransaction(hash eth.Data, config eth.JsonTraceConfig) (interface{}, error)
+	DebugTraceCall(query eth.JsonTxCallObject, block eth.BlockHeight, config eth.JsonTraceConfig) (interface{}, error)
+	DebugTraceBlockByNumber(block eth.BlockHeight, config eth.JsonTraceConfig) ([]eth.JsonTxTrace, error)
+
+	LoomGetInternalTransactions(hash eth.Data) (*eth.JsonCallFrame, error)
+
 	ContractEvents(fromBlock uint64, toBlock uint64, contract string) (*types.ContractEventsResult, error)
+	QueryContractEvents(
+		fromBlock, toBlock uint64, contract string, topics []string, caller, txHash, cursor string, maxResults int,
+	) (*ContractEventsPage, error)
 
 	GetContractRecord(contractAddr string) (*types.ContractRecordResponse, error)
 
@@ -84,6 +102,8 @@ type QueryService interface {
 type QueryEventBus struct {
 	Subs    loomchain.SubscriptionSet
 	EthSubs subs.LegacyEthSubscriptionSet
+	// Optional, subscriptions created via subeventsfrom.
+	ResumableSubs *ResumableEventSubscriptions
 }
 
 func (b *QueryEventBus) Subscribe(ctx context.Context,
@@ -99,6 +119,9 @@ func (b *QueryEventBus) UnsubscribeAll(ctx context.Context, subscriber string) e
 	log.Debug("Removing WS event subscriber", "address", subscriber)
 	b.EthSubs.Purge(subscriber)
 	b.Subs.Purge(subscriber)
+	if b.ResumableSubs != nil {
+		b.ResumableSubs.Purge(subscriber)
+	}
 	return nil
 }
 
@@ -108,11 +131,14 @@ func MakeQueryServiceHandler(svc QueryService, logger log.TMLogger, bus *QueryEv
 	codec := amino.NewCodec()
 	wsmux := http.NewServeMux()
 	routes := map[string]*rpcserver.RPCFunc{}
-	routes["query"] = rpcserver.NewRPCFunc(svc.Query, "caller,contract,query,vmType")
+	routes["query"] = rpcserver.NewRPCFunc(svc.Query, "caller,contract,query,vmType,height")
+	routes["querystore"] = rpcserver.NewRPCFunc(svc.QueryStore, "key,height,prove")
 	routes["env"] = rpcserver.NewRPCFunc(svc.QueryEnv, "")
 	routes["nonce"] = rpcserver.NewRPCFunc(svc.Nonce, "key,account")
 	routes["subevents"] = rpcserver.NewWSRPCFunc(svc.Subscribe, "topics")
 	routes["unsubevents"] = rpcserver.NewWSRPCFunc(svc.UnSubscribe, "topic")
+	routes["subeventsfrom"] = rpcserver.NewWSRPCFunc(svc.SubscribeFrom, "topics,fromHeight,cursor")
+	routes["unsubeventsfrom"] = rpcserver.NewWSRPCFunc(svc.UnSubscribeFrom, "id")
 	routes["resolve"] = rpcserver.NewRPCFunc(svc.Resolve, "name")
 	routes["evmtxreceipt"] = rpcserver.NewRPCFunc(svc.EvmTxReceipt, "txHash")
 	routes["getevmcode"] = rpcserver.NewRPCFunc(svc.GetEvmCode, "contract")
@@ -129,6 +155,9 @@ func MakeQueryServiceHandler(svc QueryService, logger log.TMLogger, bus *QueryEv
 	routes["getevmtransactionbyhash"] = rpcserver.NewRPCFunc(svc.GetEvmTransactionByHash, "txHash")
 	routes["evmsubscribe"] = rpcserver.NewWSRPCFunc(svc.EvmSubscribe, "method,filter")
 	routes["contractevents"] = rpcserver.NewRPCFunc(svc.ContractEvents, "fromBlock,toBlock,contract")
+	routes["querycontractevents"] = rpcserver.NewRPCFunc(
+		svc.QueryContractEvents, "fromBlock,toBlock,contract,topics,caller,txHash,cursor,maxResults",
+	)
 	routes["contractrecord"] = rpcserver.NewRPCFunc(svc.GetContractRecord, "contract")
 	rpcserver.RegisterRPCFuncs(wsmux, routes, codec, logger)
 	wm := rpcserver.NewWebsocketManager(routes, codec, rpcserver.EventSubscriber(bus))
@@ -152,8 +181,11 @@ func MakeQueryServiceHandler(svc QueryService, logger log.TMLogger, bus *QueryEv
 	return mux
 }
 
-// makeQueryServiceHandler returns a http handler mapping to query service
-func MakeEthQueryServiceHandler(svc QueryService, logger log.TMLogger, hub *Hub) http.Handler {
+// makeQueryServiceHandler returns a http handler mapping to query service, the debug_* routes are
+// only available if enableDebugRPC is true.
+func MakeEthQueryServiceHandler(
+	svc QueryService, logger log.TMLogger, hub *Hub, enableDebugRPC bool,
+) http.Handler {
 	wsmux := http.NewServeMux()
 	routesJson := map[string]eth.RPCFunc{}
 	routesJson["eth_blockNumber"] = eth.NewRPCFunc(svc.EthBlockNumber, "")
@@ -184,10 +216,20 @@ func MakeEthQueryServiceHandler(svc QueryService, logger log.TMLogger, hub *Hub)
 	routesJson["eth_accounts"] = eth.NewRPCFunc(svc.EthAccounts, "")
 	routesJson["eth_getBalance"] = eth.NewRPCFunc(svc.EthGetBalance, "address,block")
 	routesJson["eth_estimateGas"] = eth.NewRPCFunc(svc.EthEstimateGas, "query")
+	routesJson["eth_getProof"] = eth.NewRPCFunc(svc.EthGetProof, "address,storageKeys,block")
 	routesJson["eth_gasPrice"] = eth.NewRPCFunc(svc.EthGasPrice, "")
 	routesJson["net_version"] = eth.NewRPCFunc(svc.EthNetVersion, "")
+	routesJson["eth_chainId"] = eth.NewRPCFunc(svc.EthChainId, "")
 	routesJson["eth_getTransactionCount"] = eth.NewRPCFunc(svc.EthGetTransactionCount, "local,block")
 
+	if enableDebugRPC {
+		routesJson["debug_traceTransaction"] = eth.NewRPCFunc(svc.DebugTraceTransaction, "hash,config")
+		routesJson["debug_traceCall"] = eth.NewRPCFunc(svc.DebugTraceCall, "query,block,config")
+		routesJson["debug_traceBlockByNumber"] = eth.NewRPCFunc(svc.DebugTraceBlockByNumber, "block,config")
+	}
+
+	routesJson["loom_getInternalTransactions"] = eth.NewRPCFunc(svc.LoomGetInternalTransactions, "hash")
+
 	routesJson["eth_sendRawTransaction"] = eth.NewTendermintRPCFunc("eth_sendRawTransaction")
 	RegisterRPCFuncs(wsmux, routesJson, logger, hub)
 
@@ -204,8 +246,39 @@ func MakeEthQueryServiceHandler(svc QueryService, logger log.TMLogger, hub *Hub)
 	return mux
 }
 
-// MakeUnsafeQueryServiceHandler returns a http handler for unsafe RPC routes
-func MakeUnsafeQueryServiceHandler(logger log.TMLogger) http.Handler {
+// BackupResult is returned by the unsafe_backup RPC route.
+type BackupResult struct {
+	// Height the DBs were backed up at.
+	Height int64 `json:"height"`
+	// Directory the backup is being written to, the backup is complete once the manifest.json file
+	// appears in this directory.
+	Dir string `json:"dir"`
+}
+
+// backupCheckpointTimeout is how long the unsafe_backup route waits for the next block to be
+// committed.
+const backupCheckpointTimeout = 1 * time.Minute
+
+func makeBackupFunc(backups *store.BackupManager) func(dir string) (*BackupResult, error) {
+	return func(dir string) (*BackupResult, error) {
+		if dir == "" {
+			return nil, errors.New("backup dir not specified")
+		}
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		height, err := backups.RequestBackup(absDir, backupCheckpointTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return &BackupResult{Height: height, Dir: absDir}, nil
+	}
+}
+
+// MakeUnsafeQueryServiceHandler returns a http handler for unsafe RPC routes, the backup route is
+// only available if backups isn't nil.
+func MakeUnsafeQueryServiceHandler(logger log.TMLogger, backups *store.BackupManager) http.Handler {
 	codec := amino.NewCodec()
 	mux := http.NewServeMux()
 	routes := map[string]*rpcserver.RPCFunc{}
@@ -218,6 +291,10 @@ func MakeUnsafeQueryServiceHandler(logger log.TMLogger) http.Handler {
 	routes["unsafe_stop_cpu_profiler"] = rpcserver.NewRPCFunc(rpccore.UnsafeStopCPUProfiler, "")
 	routes["unsafe_write_heap_profile"] = rpcserver.NewRPCFunc(rpccore.UnsafeWriteHeapProfile, "filename")
 
+	if backups != nil {
+		routes["unsafe_backup"] = rpcserver.NewRPCFunc(makeBackupFunc(backups), "dir")
+	}
+
 	rpcserver.RegisterRPCFuncs(mux, routes, codec, logger)
 	return mux
 }
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/loomnetwork/loomchain/log"
+	"github.com/loomnetwork/loomchain/store"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	amino "github.com/tendermint/go-amino"
@@ -37,13 +38,13 @@ func init() {
 
 // RPCServer starts up HTTP servers that handle client requests.
 func RPCServer(
-	qsvc QueryService, logger log.TMLogger, bus *QueryEventBus, bindAddr string,
-	enableUnsafeRPC bool, unsafeRPCBindAddress string,
+	qsvc QueryService, logger log.TMLogger, bus *QueryEventBus, bindAddr string, enableDebugRPC bool,
+	enableUnsafeRPC bool, unsafeRPCBindAddress string, backups *store.BackupManager,
 ) error {
 	queryHandler := MakeQueryServiceHandler(qsvc, logger, bus)
 	hub := newHub()
 	go hub.run()
-	ethHandler := MakeEthQueryServiceHandler(qsvc, logger, hub)
+	ethHandler := MakeEthQueryServiceHandler(qsvc, logger, hub, enableDebugRPC)
 
 	// Add the nonce route to the TM routes so clients can query the nonce from the /websocket
 	// and /rpc endpoints.
@@ -94,7 +95,7 @@ func RPCServer(
 
 	if enableUnsafeRPC {
 		unsafeLogger := logger.With("interface", "unsafe")
-		unsafeHandler := MakeUnsafeQueryServiceHandler(unsafeLogger)
+		unsafeHandler := MakeUnsafeQueryServiceHandler(unsafeLogger, backups)
 		unsafeListener, err := rpcserver.Listen(
 			unsafeRPCBindAddress,
 			rpcserver.Config{MaxOpenConnections: 0},
@@ -0,0 +1,533 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/log"
+	"github.com/pkg/errors"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+const (
+	backupManifestFile = "manifest.json"
+
+	// BackupAppDBName is the name of the app.db source, app.db must be included in every backup.
+	BackupAppDBName = "app"
+)
+
+var (
+	// Key the Tendermint block store saves its current height under.
+	tmBlockStoreStateKey = []byte("blockStore")
+
+	errBackupHeightNotFlushed = errors.New("app.db hasn't been flushed to disk at the backup height")
+)
+
+// BackupDBManifest describes the backup of a single DB.
+type BackupDBManifest struct {
+	// Unique name of the DB within the backup, e.g. app, evm, blockstore.
+	Name string `json:"name"`
+	// Path the DB should be restored to, relative to the node root dir.
+	Path string `json:"path"`
+	// Backend the DB should be restored with.
+	Backend string `json:"backend"`
+	// Height of the last block committed to the DB, zero if the DB doesn't track block heights.
+	Height  int64 `json:"height"`
+	NumKeys int64 `json:"numKeys"`
+	// SHA256 hash of the backup file.
+	Hash []byte `json:"hash"`
+}
+
+func backupDBFile(name string) string {
+	return name + ".bak"
+}
+
+// BackupManifest describes a backup of the node DBs, the manifest is written after all the DBs
+// have been backed up, so a backup without a manifest is incomplete.
+type BackupManifest struct {
+	// Height of the last block committed to app.db when the backup was taken.
+	Height int64 `json:"height"`
+	// Root hash of the IAVL tree at Height.
+	AppHash []byte `json:"appHash"`
+	// Unix timestamp (in seconds) of the time the DB snapshots were taken.
+	CreatedAt int64              `json:"createdAt"`
+	DBs       []BackupDBManifest `json:"dbs"`
+}
+
+// BackupSource is one of the node DBs that should be included in backups.
+type BackupSource struct {
+	Name    string
+	Path    string
+	Backend string
+	// Returns a point-in-time snapshot of the DB.
+	Snapshot func() db.Snapshot
+	// Returns the height of the last block committed to the DB in the given snapshot, if nil the
+	// DB is assumed to be written in lock-step with app.db.
+	Height func(snapshot db.Snapshot) int64
+}
+
+// TendermintBlockStoreHeight returns the height of the last block saved to the given snapshot of
+// the Tendermint block store DB.
+func TendermintBlockStoreHeight(snapshot db.Snapshot) int64 {
+	data := snapshot.Get(tmBlockStoreStateKey)
+	if len(data) == 0 {
+		return 0
+	}
+	// Tendermint saves the height as amino JSON, which encodes int64 as a string.
+	var state struct {
+		Height int64 `json:"height,string"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Error("Failed to decode Tendermint block store state", "err", err)
+		return 0
+	}
+	return state.Height
+}
+
+// backupCheckpoint holds the DB snapshots taken for a backup.
+type backupCheckpoint struct {
+	manifest  *BackupManifest
+	snapshots []db.Snapshot
+}
+
+func (c *backupCheckpoint) release() {
+	for _, snapshot := range c.snapshots {
+		snapshot.Release()
+	}
+}
+
+type backupResult struct {
+	height int64
+	err    error
+}
+
+type backupRequest struct {
+	dir    string
+	result chan backupResult
+}
+
+// BackupManager takes consistent backups of the node DBs. The app calls Checkpoint after each block
+// is committed, at which point all the DBs are at the same height, if a backup has been requested
+// the DB snapshots are taken at that point, and then written out in the background.
+type BackupManager struct {
+	mutex   sync.Mutex
+	sources []BackupSource
+	pending *backupRequest
+	busy    bool
+}
+
+func NewBackupManager() *BackupManager {
+	return &BackupManager{}
+}
+
+// AddSource adds a DB to the set of DBs that will be backed up, the app.db source must be named
+// BackupAppDBName. Sources with the same name as a previously added source are ignored.
+func (m *BackupManager) AddSource(src BackupSource) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, existing := range m.sources {
+		if existing.Name == src.Name {
+			return
+		}
+	}
+	m.sources = append(m.sources, src)
+}
+
+// RequestBackup requests a backup of all the DBs to the given directory, and waits for the DB
+// snapshots to be taken at the end of the next block commit. Returns the height of the backup,
+// the backup itself is written in the background and is complete once its manifest is written.
+func (m *BackupManager) RequestBackup(dir string, timeout time.Duration) (int64, error) {
+	if err := checkBackupDir(dir); err != nil {
+		return 0, err
+	}
+	req := &backupRequest{
+		dir:    dir,
+		result: make(chan backupResult, 1),
+	}
+
+	m.mutex.Lock()
+	if m.busy || m.pending != nil {
+		m.mutex.Unlock()
+		return 0, errors.New("another backup is already in progress")
+	}
+	m.pending = req
+	m.mutex.Unlock()
+
+	var res backupResult
+	select {
+	case res = <-req.result:
+	case <-time.After(timeout):
+		m.mutex.Lock()
+		if m.pending == req {
+			m.pending = nil
+			m.mutex.Unlock()
+			return 0, errors.New("timed out waiting for the next block to be committed")
+		}
+		m.mutex.Unlock()
+		// the checkpoint was taken just as the request timed out
+		res = <-req.result
+	}
+	return res.height, res.err
+}
+
+// Checkpoint should be called once a block has been committed to all the DBs. If a backup has
+// been requested the DB snapshots are taken, and the backup is written out in the background.
+func (m *BackupManager) Checkpoint(height int64) {
+	m.mutex.Lock()
+	req := m.pending
+	if req == nil {
+		m.mutex.Unlock()
+		return
+	}
+	checkpoint, err := m.checkpoint(height)
+	if err == errBackupHeightNotFlushed {
+		// try again after the next block
+		m.mutex.Unlock()
+		return
+	}
+	m.pending = nil
+	if err != nil {
+		m.mutex.Unlock()
+		req.result <- backupResult{err: err}
+		return
+	}
+	m.busy = true
+	m.mutex.Unlock()
+
+	req.result <- backupResult{height: height}
+
+	go func() {
+		defer func() {
+			m.mutex.Lock()
+			m.busy = false
+			m.mutex.Unlock()
+		}()
+
+		startTime := time.Now()
+		log.Info("Writing node backup", "height", height, "dir", req.dir)
+		if _, err := writeBackup(req.dir, checkpoint); err != nil {
+			log.Error("Failed to write node backup", "height", height, "dir", req.dir, "err", err)
+			return
+		}
+		log.Info(
+			"Node backup complete", "height", height, "dir", req.dir,
+			"duration", time.Since(startTime).Seconds(),
+		)
+	}()
+}
+
+// Backup takes a backup of all the DBs at the given height and writes it to the given directory.
+// This should only be used when the DBs aren't being written to, i.e. while the node is stopped.
+func (m *BackupManager) Backup(dir string, height int64) (*BackupManifest, error) {
+	if err := checkBackupDir(dir); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	checkpoint, err := m.checkpoint(height)
+	m.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return writeBackup(dir, checkpoint)
+}
+
+// checkpoint takes snapshots of all the DBs, the caller must hold the mutex.
+func (m *BackupManager) checkpoint(height int64) (*backupCheckpoint, error) {
+	checkpoint := &backupCheckpoint{
+		manifest: &BackupManifest{
+			Height:    height,
+			CreatedAt: time.Now().Unix(),
+		},
+	}
+	for _, src := range m.sources {
+		checkpoint.snapshots = append(checkpoint.snapshots, src.Snapshot())
+	}
+
+	for i, src := range m.sources {
+		snapshot := checkpoint.snapshots[i]
+		dbHeight := height
+		if src.Height != nil {
+			dbHeight = src.Height(snapshot)
+		}
+		if src.Name == BackupAppDBName {
+			// With IAVLFlushInterval set the latest version may only exist in memory
+			checkpoint.manifest.AppHash = snapshot.Get(iavlRootKey(height))
+			if checkpoint.manifest.AppHash == nil {
+				checkpoint.release()
+				return nil, errBackupHeightNotFlushed
+			}
+		}
+		checkpoint.manifest.DBs = append(checkpoint.manifest.DBs, BackupDBManifest{
+			Name:    src.Name,
+			Path:    src.Path,
+			Backend: src.Backend,
+			Height:  dbHeight,
+		})
+	}
+	if checkpoint.manifest.AppHash == nil {
+		checkpoint.release()
+		return nil, errors.New("app.db must be included in the backup")
+	}
+	return checkpoint, nil
+}
+
+func checkBackupDir(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("backup dir %s already exists", dir)
+	}
+	return nil
+}
+
+// writeBackup writes the DB snapshots in the given checkpoint to the given directory, and then
+// releases the snapshots.
+func writeBackup(dir string, checkpoint *backupCheckpoint) (*BackupManifest, error) {
+	defer checkpoint.release()
+
+	tmpDir := dir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create backup dir")
+	}
+
+	manifest := checkpoint.manifest
+	for i := range manifest.DBs {
+		entry := &manifest.DBs[i]
+		numKeys, hash, err := writeBackupDB(
+			filepath.Join(tmpDir, backupDBFile(entry.Name)), checkpoint.snapshots[i],
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to back up %s", entry.Name)
+		}
+		entry.NumKeys = numKeys
+		entry.Hash = hash
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, backupManifestFile), manifestBytes, 0644); err != nil {
+		return nil, errors.Wrap(err, "failed to write backup manifest")
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return nil, errors.Wrap(err, "failed to finalize backup")
+	}
+	return manifest, nil
+}
+
+// writeBackupDB writes out all the keys & values in the given DB snapshot to a file, returns the
+// number of keys written, and the hash of the file.
+func writeBackupDB(path string, snapshot db.Snapshot) (int64, []byte, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(file, hasher))
+	var buf bytes.Buffer
+	var numKeys int64
+	iter := snapshot.NewIterator(nil, nil)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		buf.Reset()
+		writeSnapshotBytes(&buf, iter.Key())
+		writeSnapshotBytes(&buf, iter.Value())
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return 0, nil, err
+		}
+		numKeys++
+	}
+	if err := w.Flush(); err != nil {
+		return 0, nil, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, nil, err
+	}
+	return numKeys, hasher.Sum(nil), nil
+}
+
+// ReadBackupManifest loads the manifest of the backup in the given directory.
+func ReadBackupManifest(dir string) (*BackupManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read backup manifest")
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to parse backup manifest")
+	}
+	return &manifest, nil
+}
+
+// VerifyBackup checks that the DB backups in the given directory match the hashes & key counts in
+// the backup manifest, and that the DBs were backed up at consistent heights.
+func VerifyBackup(dir string) (*BackupManifest, error) {
+	manifest, err := ReadBackupManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.AppHash) == 0 {
+		return nil, errors.New("backup manifest doesn't contain the app hash")
+	}
+	hasAppDB := false
+	for _, entry := range manifest.DBs {
+		if entry.Name == BackupAppDBName {
+			hasAppDB = true
+		}
+		// Tendermint may save a block before the app commits it, but never after.
+		if entry.Height != 0 && entry.Height < manifest.Height {
+			return nil, fmt.Errorf(
+				"%s was backed up at height %d, which is behind the backup height %d",
+				entry.Name, entry.Height, manifest.Height,
+			)
+		}
+		if err := readBackupDB(dir, entry, nil); err != nil {
+			return nil, err
+		}
+	}
+	if !hasAppDB {
+		return nil, errors.New("backup doesn't contain app.db")
+	}
+	return manifest, nil
+}
+
+// readBackupDB reads the backup of the given DB, and verifies it against the manifest entry.
+// If the batch isn't nil every key & value is written to it.
+func readBackupDB(dir string, entry BackupDBManifest, batch *backupRestoreBatch) error {
+	file, err := os.Open(filepath.Join(dir, backupDBFile(entry.Name)))
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s backup", entry.Name)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	r := bufio.NewReader(io.TeeReader(file, hasher))
+	var numKeys int64
+	for {
+		key, err := readSnapshotBytes(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s backup", entry.Name)
+		}
+		value, err := readSnapshotBytes(r)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s backup", entry.Name)
+		}
+		if batch != nil {
+			batch.set(key, value)
+		}
+		numKeys++
+	}
+	if err := checkBackupHash(entry, hasher, numKeys); err != nil {
+		return err
+	}
+	if batch != nil {
+		batch.write()
+	}
+	return nil
+}
+
+func checkBackupHash(entry BackupDBManifest, hasher hash.Hash, numKeys int64) error {
+	if !bytes.Equal(hasher.Sum(nil), entry.Hash) {
+		return fmt.Errorf("%s backup hash mismatch", entry.Name)
+	}
+	if numKeys != entry.NumKeys {
+		return fmt.Errorf("%s backup contains %d keys, expected %d", entry.Name, numKeys, entry.NumKeys)
+	}
+	return nil
+}
+
+// backupRestoreBatch writes keys to a DB in batches of at most size keys.
+type backupRestoreBatch struct {
+	db    dbm.DB
+	batch dbm.Batch
+	size  int
+	count int
+}
+
+func (b *backupRestoreBatch) set(key, value []byte) {
+	if b.batch == nil {
+		b.batch = b.db.NewBatch()
+	}
+	b.batch.Set(key, value)
+	b.count++
+	if b.count >= b.size {
+		b.write()
+	}
+}
+
+func (b *backupRestoreBatch) write() {
+	if b.batch != nil {
+		b.batch.WriteSync()
+		b.batch = nil
+		b.count = 0
+	}
+}
+
+// RestoreBackup verifies the backup in the given directory, and then writes each DB in the backup
+// to the (empty) DB returned by open, the DBs are closed once they've been restored. The restored
+// app.db is verified against the app hash in the manifest.
+func RestoreBackup(
+	dir string, open func(entry BackupDBManifest) (dbm.DB, error), batchSize int,
+) (*BackupManifest, error) {
+	if batchSize <= 0 {
+		return nil, errors.New("invalid batch size")
+	}
+	manifest, err := VerifyBackup(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range manifest.DBs {
+		if err := restoreBackupDB(dir, entry, manifest, open, batchSize); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+func restoreBackupDB(
+	dir string, entry BackupDBManifest, manifest *BackupManifest,
+	open func(entry BackupDBManifest) (dbm.DB, error), batchSize int,
+) error {
+	dest, err := open(entry)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", entry.Name)
+	}
+	defer dest.Close()
+
+	if err := readBackupDB(dir, entry, &backupRestoreBatch{db: dest, size: batchSize}); err != nil {
+		return err
+	}
+	if entry.Name != BackupAppDBName {
+		return nil
+	}
+	iavlStore, err := NewIAVLStore(dest, 0, manifest.Height, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to load restored app.db")
+	}
+	if !bytes.Equal(iavlStore.Hash(), manifest.AppHash) {
+		return fmt.Errorf(
+			"restored app.db hash %X doesn't match app hash %X in backup manifest",
+			iavlStore.Hash(), manifest.AppHash,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+func TestBackupRestore(t *testing.T) {
+	appDB, _ := db.LoadMemDB()
+	evmDB, _ := db.LoadMemDB()
+	iavlStore, err := NewIAVLStore(appDB, 0, 0, 0)
+	require.NoError(t, err)
+	evmStore := NewEvmStore(evmDB, 100)
+	appStore, err := NewMultiWriterAppStore(iavlStore, evmStore, false)
+	require.NoError(t, err)
+
+	appStore.Set([]byte("abc"), []byte("1"))
+	appStore.Set(vmPrefixKey("abcd"), []byte("2"))
+	appStore.Set(rootHashKey, []byte("root1"))
+	_, _, err = appStore.SaveVersion()
+	require.NoError(t, err)
+
+	appStore.Set([]byte("abc"), []byte("3"))
+	appStore.Set(vmPrefixKey("efgh"), []byte("4"))
+	appStore.Set(rootHashKey, []byte("root2"))
+	appHash, version, err := appStore.SaveVersion()
+	require.NoError(t, err)
+
+	// Tendermint saves blocks before they're committed by the app
+	blockStoreDB := dbm.NewMemDB()
+	blockStoreDB.Set(tmBlockStoreStateKey, []byte(`{"height":"3"}`))
+
+	backups := NewBackupManager()
+	backups.AddSource(BackupSource{
+		Name:     BackupAppDBName,
+		Path:     "app.db",
+		Backend:  db.GoLevelDBBackend,
+		Snapshot: appDB.GetSnapshot,
+	})
+	backups.AddSource(BackupSource{
+		Name:     "evm",
+		Path:     "evm.db",
+		Backend:  db.GoLevelDBBackend,
+		Snapshot: evmDB.GetSnapshot,
+	})
+	backups.AddSource(BackupSource{
+		Name:    "blockstore",
+		Path:    "chaindata/data/blockstore.db",
+		Backend: db.GoLevelDBBackend,
+		Snapshot: func() db.Snapshot {
+			return db.SnapshotDB(blockStoreDB)
+		},
+		Height: TendermintBlockStoreHeight,
+	})
+
+	tmpDir, err := ioutil.TempDir("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	backupDir := filepath.Join(tmpDir, "1")
+
+	// versions that haven't been saved to app.db can't be backed up
+	_, err = backups.Backup(backupDir, version+1)
+	require.Error(t, err)
+
+	manifest, err := backups.Backup(backupDir, version)
+	require.NoError(t, err)
+	require.Equal(t, version, manifest.Height)
+	require.Equal(t, appHash, manifest.AppHash)
+	require.Len(t, manifest.DBs, 3)
+	require.Equal(t, version, manifest.DBs[0].Height)
+	require.Equal(t, int64(3), manifest.DBs[2].Height)
+	require.Equal(t, int64(1), manifest.DBs[2].NumKeys)
+
+	// existing backups shouldn't be overwritten
+	_, err = backups.Backup(backupDir, version)
+	require.Error(t, err)
+
+	restoredDBs := map[string]*db.MemDB{}
+	openDB := func(entry BackupDBManifest) (dbm.DB, error) {
+		restoredDBs[entry.Name], _ = db.LoadMemDB()
+		return restoredDBs[entry.Name], nil
+	}
+	_, err = RestoreBackup(backupDir, openDB, 2)
+	require.NoError(t, err)
+	restoredIAVLStore, err := NewIAVLStore(restoredDBs[BackupAppDBName], 0, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, version, restoredIAVLStore.Version())
+	require.Equal(t, appHash, restoredIAVLStore.Hash())
+	restoredEvmStore := NewEvmStore(restoredDBs["evm"], 100)
+	require.NoError(t, restoredEvmStore.LoadVersion(version))
+	restoredStore, err := NewMultiWriterAppStore(restoredIAVLStore, restoredEvmStore, false)
+	require.NoError(t, err)
+	require.Equal(t, []byte("3"), restoredStore.Get([]byte("abc")))
+	require.Equal(t, []byte("2"), restoredStore.Get(vmPrefixKey("abcd")))
+	require.Equal(t, []byte("4"), restoredStore.Get(vmPrefixKey("efgh")))
+	require.Equal(t, []byte("root2"), restoredStore.Get(rootHashKey))
+	require.Equal(t, []byte(`{"height":"3"}`), restoredDBs["blockstore"].Get(tmBlockStoreStateKey))
+
+	// tampered backups should be rejected
+	backupFile := filepath.Join(backupDir, backupDBFile("evm"))
+	data, err := ioutil.ReadFile(backupFile)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xFF
+	require.NoError(t, ioutil.WriteFile(backupFile, data, 0644))
+	_, err = VerifyBackup(backupDir)
+	require.Error(t, err)
+	_, err = RestoreBackup(backupDir, openDB, 2)
+	require.Error(t, err)
+}
+
+func TestRequestBackup(t *testing.T) {
+	appDB, _ := db.LoadMemDB()
+	iavlStore, err := NewIAVLStore(appDB, 0, 0, 0)
+	require.NoError(t, err)
+	iavlStore.Set([]byte("abc"), []byte("1"))
+	appHash, version, err := iavlStore.SaveVersion()
+	require.NoError(t, err)
+
+	backups := NewBackupManager()
+	backups.AddSource(BackupSource{
+		Name:     BackupAppDBName,
+		Path:     "app.db",
+		Backend:  db.GoLevelDBBackend,
+		Snapshot: appDB.GetSnapshot,
+	})
+
+	tmpDir, err := ioutil.TempDir("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	backupDir := filepath.Join(tmpDir, "1")
+
+	// no blocks committed
+	_, err = backups.RequestBackup(backupDir, 10*time.Millisecond)
+	require.Error(t, err)
+
+	results := make(chan backupResult, 1)
+	go func() {
+		height, err := backups.RequestBackup(backupDir, 10*time.Second)
+		results <- backupResult{height: height, err: err}
+	}()
+	var res backupResult
+	for done := false; !done; {
+		// the backup should be deferred until the version is saved to app.db
+		backups.Checkpoint(version + 1)
+		backups.Checkpoint(version)
+		select {
+		case res = <-results:
+			done = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	require.NoError(t, res.err)
+	require.Equal(t, version, res.height)
+
+	var manifest *BackupManifest
+	for i := 0; i < 100 && manifest == nil; i++ {
+		manifest, _ = ReadBackupManifest(backupDir)
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NotNil(t, manifest)
+	require.Equal(t, appHash, manifest.AppHash)
+	_, err = VerifyBackup(backupDir)
+	require.NoError(t, err)
+}
@@ -28,6 +28,8 @@ type BlockIndexStore interface {
 	GetBlockHeightByHash(hash []byte) (uint64, error)
 	// SetBlockHashAtHeight stores the block hash at the given height.
 	SetBlockHashAtHeight(height uint64, hash []byte)
+	// GetSnapshot returns a point-in-time snapshot of the underlying DB.
+	GetSnapshot() db.Snapshot
 	Close()
 }
 
@@ -81,6 +83,10 @@ func (bis *blockIndexStoreDB) SetBlockHashAtHeight(height uint64, hash []byte) {
 	bis.db.Set(hashKey(hash), heightBuffer)
 }
 
+func (bis *blockIndexStoreDB) GetSnapshot() db.Snapshot {
+	return bis.db.GetSnapshot()
+}
+
 func (bis *blockIndexStoreDB) Close() {
 	bis.db.Close()
 }
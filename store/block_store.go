@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -176,11 +177,31 @@ func filterMinMaxforCache(min, max int64) (int64, int64, error) {
 
 var _ BlockStore = &MockBlockStore{}
 
+// ErrBlockStoreUnavailable is returned by TendermintBlockStore until the Tendermint node has started.
+var ErrBlockStoreUnavailable = errors.New("Tendermint block store unavailable")
+
+// Set to 1 once the Tendermint node has started.
+var tendermintNodeStarted int32
+
+// SetTendermintNodeStarted must be called once the Tendermint node has started. TendermintBlockStore
+// relies on the Tendermint RPC core, which is only initialized when the node starts, and the node
+// replays any blocks the app is missing before then.
+func SetTendermintNodeStarted() {
+	atomic.StoreInt32(&tendermintNodeStarted, 1)
+}
+
 type TendermintBlockStore struct {
 }
 
 var _ BlockStore = &TendermintBlockStore{}
 
+func checkTendermintNodeStarted() error {
+	if atomic.LoadInt32(&tendermintNodeStarted) == 0 {
+		return ErrBlockStoreUnavailable
+	}
+	return nil
+}
+
 func NewTendermintBlockStore() BlockStore {
 	return &TendermintBlockStore{}
 }
@@ -217,6 +238,9 @@ func NewBlockStore(cfg *BlockStoreConfig) (BlockStore, error) {
 }
 
 func (s *TendermintBlockStore) GetBlockByHeight(height *int64) (*ctypes.ResultBlock, error) {
+	if err := checkTendermintNodeStarted(); err != nil {
+		return nil, err
+	}
 	blockResult, err := core.Block(height)
 	if err != nil {
 		return nil, err
@@ -248,6 +272,9 @@ func (s *TendermintBlockStore) GetBlockByHeight(height *int64) (*ctypes.ResultBl
 }
 
 func (s *TendermintBlockStore) GetBlockRangeByHeight(minHeight, maxHeight int64) (*ctypes.ResultBlockchainInfo, error) {
+	if err := checkTendermintNodeStarted(); err != nil {
+		return nil, err
+	}
 	blockResult, err := core.BlockchainInfo(minHeight, maxHeight)
 	if err != nil {
 		return nil, err
@@ -266,6 +293,9 @@ func (s *TendermintBlockStore) GetBlockRangeByHeight(minHeight, maxHeight int64)
 }
 
 func (s *TendermintBlockStore) GetBlockResults(height *int64) (*ctypes.ResultBlockResults, error) {
+	if err := checkTendermintNodeStarted(); err != nil {
+		return nil, err
+	}
 	blockResult, err := core.BlockResults(height)
 	if err != nil {
 		return nil, err
@@ -280,6 +310,9 @@ func (s *TendermintBlockStore) GetBlockResults(height *int64) (*ctypes.ResultBlo
 }
 
 func (s *TendermintBlockStore) GetTxResult(txHash []byte) (*ctypes.ResultTx, error) {
+	if err := checkTendermintNodeStarted(); err != nil {
+		return nil, err
+	}
 	txResult, err := core.Tx(txHash, false)
 	if err != nil {
 		return nil, err
@@ -18,6 +18,17 @@ type AppStoreConfig struct {
 	SaveEVMStateToIAVL bool
 
 	IAVLFlushInterval int64
+
+	// Number of blocks between app store snapshots, if zero snapshots won't be exported.
+	// Only versions that have been flushed to disk can be exported, so this should be a multiple
+	// of IAVLFlushInterval. This config works with AppStore Version 3 (MultiWriterAppStore) only.
+	SnapshotInterval int64
+	// Directory snapshots are written to, relative to the node root path.
+	SnapshotDir string
+	// Number of most recent snapshots to keep, older snapshots will be deleted.
+	NumSnapshotsToKeep int
+	// Max number of DB entries per snapshot chunk.
+	SnapshotChunkSize int
 }
 
 func DefaultConfig() *AppStoreConfig {
@@ -29,6 +40,10 @@ func DefaultConfig() *AppStoreConfig {
 		PruneBatchSize:     50,
 		SaveEVMStateToIAVL: false,
 		IAVLFlushInterval:  0, //default to zero until we know its ready
+		SnapshotInterval:   0,
+		SnapshotDir:        "snapshots",
+		NumSnapshotsToKeep: 2,
+		SnapshotChunkSize:  10000,
 	}
 }
 
@@ -0,0 +1,540 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/loomnetwork/go-loom"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/pkg/errors"
+)
+
+// Kinds of differences between two versions of a store.
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// Categories of app store keys, see DescribeAppStoreKey.
+const (
+	KeyCategoryEVM          = "evm"
+	KeyCategoryContract     = "contract"
+	KeyCategoryContractCode = "contract-code"
+	KeyCategoryFeature      = "feature"
+	KeyCategoryConfig       = "config"
+	KeyCategoryNonce        = "nonce"
+	KeyCategoryRegistry     = "registry"
+	KeyCategoryOther        = "other"
+)
+
+// Values longer than this are truncated by FormatAppStoreValue.
+const maxFormattedValueLen = 64
+
+var (
+	// key of the on-chain config in the app store
+	appStoreConfigKey = "config"
+
+	// go-geth stores the preimages of secure trie keys under this prefix
+	evmPreimagePrefix = []byte("secure-key-")
+
+	errEvmNodeNotFound = errors.New("EVM trie node not found")
+	errEvmDBReadOnly   = errors.New("EVM state is read-only")
+
+	// Maps the prefix of app store keys to the key category.
+	appStoreKeyCategories = map[string]string{
+		string(vmPrefix): KeyCategoryEVM,
+		"feature":        KeyCategoryFeature,
+		"nonce":          KeyCategoryNonce,
+		"registry":       KeyCategoryRegistry, // v1 registry
+		"reg_caddr":      KeyCategoryRegistry,
+		"reg_crec":       KeyCategoryRegistry,
+	}
+
+	// The layout of the Go contract keys is derived from go-loom so it stays in sync with it.
+	contractCodeKeyLayout = newContractKeyLayout(loom.TextKey)
+	contractDataKeyLayout = newContractKeyLayout(loom.DataPrefix)
+)
+
+// contractKeyLayout describes the bytes that precede & follow the contract address in the keys
+// generated by one of the go-loom key functions.
+type contractKeyLayout struct {
+	prefix []byte
+	suffix []byte
+}
+
+func newContractKeyLayout(keyFn func(loom.Address) []byte) contractKeyLayout {
+	addr := loom.RootAddress("")
+	for i := range addr.Local {
+		addr.Local[i] = byte(i + 1)
+	}
+	key := keyFn(addr)
+	i := bytes.Index(key, addr.Local)
+	return contractKeyLayout{
+		prefix: key[:i],
+		suffix: key[i+len(addr.Local):],
+	}
+}
+
+// match returns the contract address, and the rest of the key that follows the address & suffix,
+// or nil if the key doesn't match the layout.
+func (l contractKeyLayout) match(key []byte) (addr []byte, rest []byte) {
+	addrLen := len(loom.RootAddress("").Local)
+	if !bytes.HasPrefix(key, l.prefix) || len(key) < len(l.prefix)+addrLen {
+		return nil, nil
+	}
+	addr = key[len(l.prefix) : len(l.prefix)+addrLen]
+	rest = key[len(l.prefix)+addrLen:]
+	if !bytes.HasPrefix(rest, l.suffix) {
+		return nil, nil
+	}
+	return addr, rest[len(l.suffix):]
+}
+
+// keyPrefixName returns the part of the key that precedes the first separator.
+func keyPrefixName(key []byte) string {
+	if i := bytes.IndexByte(key, 0); i >= 0 {
+		return string(key[:i])
+	}
+	return ""
+}
+
+// KeyDiff describes an app store key that differs between two versions of the app store.
+type KeyDiff struct {
+	Kind string        `json:"kind"`
+	Key  hexutil.Bytes `json:"key"`
+	// Category of the key derived from its prefix, e.g. contract, feature, nonce.
+	Category string `json:"category"`
+	// Human readable form of the key.
+	Description string        `json:"description"`
+	OldValue    hexutil.Bytes `json:"oldValue,omitempty"`
+	NewValue    hexutil.Bytes `json:"newValue,omitempty"`
+}
+
+func newKeyDiff(kind string, key, oldValue, newValue []byte) *KeyDiff {
+	category, desc := DescribeAppStoreKey(key)
+	return &KeyDiff{
+		Kind:        kind,
+		Key:         key,
+		Category:    category,
+		Description: desc,
+		OldValue:    oldValue,
+		NewValue:    newValue,
+	}
+}
+
+// DescribeAppStoreKey returns the category of the given app store key, and a human readable form
+// of the key, based on the known key prefixes.
+func DescribeAppStoreKey(key []byte) (string, string) {
+	if string(key) == appStoreConfigKey {
+		return KeyCategoryConfig, appStoreConfigKey
+	}
+	if addr, rest := contractCodeKeyLayout.match(key); addr != nil && len(rest) == 0 {
+		return KeyCategoryContractCode, keyPrefixName(key) + "/0x" + hex.EncodeToString(addr)
+	}
+	if addr, rest := contractDataKeyLayout.match(key); addr != nil && len(rest) > 1 && rest[0] == 0 {
+		return KeyCategoryContract, keyPrefixName(key) + "/0x" + hex.EncodeToString(addr) + "/" +
+			formatKeyParts(rest[1:])
+	}
+	name := keyPrefixName(key)
+	category, ok := appStoreKeyCategories[name]
+	if !ok || name == "" {
+		return KeyCategoryOther, formatKeyParts(key)
+	}
+	return category, name + "/" + formatKeyParts(key[len(name)+1:])
+}
+
+// formatKeyParts splits a key into the parts separated by util.PrefixKey, printable parts are
+// formatted as strings, and the rest as hex.
+func formatKeyParts(key []byte) string {
+	parts := bytes.Split(key, []byte{0})
+	formatted := make([]string, len(parts))
+	for i, part := range parts {
+		if isPrintable(part) {
+			formatted[i] = string(part)
+		} else {
+			formatted[i] = "0x" + hex.EncodeToString(part)
+		}
+	}
+	return strings.Join(formatted, "/")
+}
+
+func isPrintable(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, r := range string(b) {
+		if r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatAppStoreValue returns a human readable form of the value of a key in the given category.
+func FormatAppStoreValue(category string, value []byte) string {
+	if value == nil {
+		return "<nil>"
+	}
+	switch category {
+	case KeyCategoryNonce:
+		if len(value) == 8 {
+			return fmt.Sprintf("%d", binary.BigEndian.Uint64(value))
+		}
+	case KeyCategoryFeature:
+		if bytes.Equal(value, []byte{1}) {
+			return "enabled"
+		} else if bytes.Equal(value, []byte{0}) {
+			return "disabled"
+		}
+	}
+	if len(value) > maxFormattedValueLen {
+		return fmt.Sprintf("0x%s... (%d bytes)", hex.EncodeToString(value[:maxFormattedValueLen]), len(value))
+	}
+	return "0x" + hex.EncodeToString(value)
+}
+
+// DiffKVReaders compares the keys with the given prefix in two readers, and calls fn in key order
+// with each key that was added, removed, or changed in the to reader relative to the from reader.
+func DiffKVReaders(from, to KVReader, prefix []byte, fn func(diff *KeyDiff) error) error {
+	fromIter := NewIterator(from, prefix, RangeOptions{})
+	defer fromIter.Close()
+	toIter := NewIterator(to, prefix, RangeOptions{})
+	defer toIter.Close()
+
+	fullKey := func(key []byte) []byte {
+		if len(prefix) == 0 {
+			return key
+		}
+		return util.PrefixKey(prefix, key)
+	}
+
+	for fromIter.Valid() || toIter.Valid() {
+		var diff *KeyDiff
+		cmp := 0
+		if !fromIter.Valid() {
+			cmp = 1
+		} else if !toIter.Valid() {
+			cmp = -1
+		} else {
+			cmp = bytes.Compare(fromIter.Key(), toIter.Key())
+		}
+		switch {
+		case cmp < 0:
+			diff = newKeyDiff(DiffRemoved, fullKey(fromIter.Key()), fromIter.Value(), nil)
+			fromIter.Next()
+		case cmp > 0:
+			diff = newKeyDiff(DiffAdded, fullKey(toIter.Key()), nil, toIter.Value())
+			toIter.Next()
+		default:
+			if !bytes.Equal(fromIter.Value(), toIter.Value()) {
+				diff = newKeyDiff(DiffChanged, fullKey(fromIter.Key()), fromIter.Value(), toIter.Value())
+			}
+			fromIter.Next()
+			toIter.Next()
+		}
+		if diff != nil {
+			if err := fn(diff); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EvmStateReader provides access to the EVM state stored under the vm prefix, e.g. an app store
+// snapshot, or an EvmStoreSnapshot.
+type EvmStateReader interface {
+	Get(key []byte) []byte
+}
+
+// EvmAccountState is the state of an EVM account at a particular version.
+type EvmAccountState struct {
+	Nonce       uint64        `json:"nonce"`
+	Balance     *big.Int      `json:"balance"`
+	StorageRoot hexutil.Bytes `json:"storageRoot"`
+	CodeHash    hexutil.Bytes `json:"codeHash"`
+}
+
+// EvmStorageDiff describes a storage slot of an EVM account that differs between two versions.
+type EvmStorageDiff struct {
+	Kind    string        `json:"kind"`
+	KeyHash hexutil.Bytes `json:"keyHash"`
+	// Storage slot, nil if its preimage wasn't found.
+	Key      hexutil.Bytes `json:"key,omitempty"`
+	OldValue hexutil.Bytes `json:"oldValue,omitempty"`
+	NewValue hexutil.Bytes `json:"newValue,omitempty"`
+}
+
+// EvmAccountDiff describes an EVM account that differs between two versions of the EVM state.
+type EvmAccountDiff struct {
+	Kind        string        `json:"kind"`
+	AddressHash hexutil.Bytes `json:"addressHash"`
+	// Account address, nil if its preimage wasn't found.
+	Address hexutil.Bytes    `json:"address,omitempty"`
+	Old     *EvmAccountState `json:"old,omitempty"`
+	New     *EvmAccountState `json:"new,omitempty"`
+	Storage []EvmStorageDiff `json:"storage,omitempty"`
+}
+
+// DiffEvmState compares the EVM state trie in two readers, and calls fn with each account that was
+// added, removed, or changed in the to reader relative to the from reader. The changed & added
+// accounts are reported first, followed by the removed accounts. If address isn't nil only the
+// account with that address is compared.
+func DiffEvmState(from, to EvmStateReader, address []byte, fn func(diff *EvmAccountDiff) error) error {
+	fromTrie, err := openEvmTrie(from, evmStateRoot(from))
+	if err != nil {
+		return errors.Wrap(err, "failed to load EVM state")
+	}
+	toTrie, err := openEvmTrie(to, evmStateRoot(to))
+	if err != nil {
+		return errors.Wrap(err, "failed to load EVM state")
+	}
+	d := &evmStateDiffer{from: from, to: to}
+
+	if address != nil {
+		addressHash := crypto.Keccak256(address)
+		oldValue, err := fromTrie.TryGet(addressHash)
+		if err != nil {
+			return err
+		}
+		newValue, err := toTrie.TryGet(addressHash)
+		if err != nil {
+			return err
+		}
+		kind := DiffChanged
+		if oldValue == nil {
+			kind = DiffAdded
+		} else if newValue == nil {
+			kind = DiffRemoved
+		}
+		if bytes.Equal(oldValue, newValue) {
+			return nil
+		}
+		diff, err := d.accountDiff(kind, addressHash, oldValue, newValue)
+		if err != nil {
+			return err
+		}
+		return fn(diff)
+	}
+
+	return diffEvmTries(fromTrie, toTrie, func(kind string, keyHash, oldValue, newValue []byte) error {
+		diff, err := d.accountDiff(kind, keyHash, oldValue, newValue)
+		if err != nil {
+			return err
+		}
+		return fn(diff)
+	})
+}
+
+// evmStateRoot returns the root of the EVM state trie in the given reader.
+func evmStateRoot(reader EvmStateReader) common.Hash {
+	root := reader.Get(rootHashKey)
+	if len(root) != common.HashLength {
+		// the EVM store uses a placeholder to indicate the state is empty
+		return common.Hash{}
+	}
+	return common.BytesToHash(root)
+}
+
+func openEvmTrie(reader EvmStateReader, root common.Hash) (*trie.Trie, error) {
+	if root == emptyTrieRoot {
+		root = common.Hash{}
+	}
+	return trie.New(root, trie.NewDatabase(&evmTrieDB{reader: reader}))
+}
+
+// diffEvmTries calls fn with every leaf that differs between two tries.
+func diffEvmTries(a, b *trie.Trie, fn func(kind string, key, oldValue, newValue []byte) error) error {
+	// leaves that were added to, or changed in b
+	diffIter, _ := trie.NewDifferenceIterator(a.NodeIterator(nil), b.NodeIterator(nil))
+	it := trie.NewIterator(diffIter)
+	for it.Next() {
+		oldValue, err := a.TryGet(it.Key)
+		if err != nil {
+			return err
+		}
+		kind := DiffChanged
+		if oldValue == nil {
+			kind = DiffAdded
+		}
+		if err := fn(kind, it.Key, oldValue, it.Value); err != nil {
+			return err
+		}
+	}
+	if it.Err != nil {
+		return it.Err
+	}
+
+	// leaves that were removed from b
+	diffIter, _ = trie.NewDifferenceIterator(b.NodeIterator(nil), a.NodeIterator(nil))
+	it = trie.NewIterator(diffIter)
+	for it.Next() {
+		newValue, err := b.TryGet(it.Key)
+		if err != nil {
+			return err
+		}
+		if newValue != nil {
+			continue // already reported as changed
+		}
+		if err := fn(DiffRemoved, it.Key, it.Value, nil); err != nil {
+			return err
+		}
+	}
+	return it.Err
+}
+
+type evmStateDiffer struct {
+	from, to EvmStateReader
+}
+
+// preimage looks up the preimage of the given secure trie key in both readers.
+func (d *evmStateDiffer) preimage(hash []byte) []byte {
+	key := util.PrefixKey(vmPrefix, append(append([]byte{}, evmPreimagePrefix...), hash...))
+	if preimage := d.to.Get(key); preimage != nil {
+		return preimage
+	}
+	return d.from.Get(key)
+}
+
+func (d *evmStateDiffer) accountDiff(kind string, addressHash, oldValue, newValue []byte) (*EvmAccountDiff, error) {
+	diff := &EvmAccountDiff{
+		Kind:        kind,
+		AddressHash: addressHash,
+		Address:     d.preimage(addressHash),
+	}
+	oldRoot := common.Hash{}
+	if oldValue != nil {
+		account, err := decodeEvmAccount(oldValue)
+		if err != nil {
+			return nil, err
+		}
+		diff.Old = account
+		oldRoot = common.BytesToHash(account.StorageRoot)
+	}
+	newRoot := common.Hash{}
+	if newValue != nil {
+		account, err := decodeEvmAccount(newValue)
+		if err != nil {
+			return nil, err
+		}
+		diff.New = account
+		newRoot = common.BytesToHash(account.StorageRoot)
+	}
+	if oldRoot == newRoot {
+		return diff, nil
+	}
+
+	oldStorage, err := openEvmTrie(d.from, oldRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load storage of account %x", addressHash)
+	}
+	newStorage, err := openEvmTrie(d.to, newRoot)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load storage of account %x", addressHash)
+	}
+	err = diffEvmTries(oldStorage, newStorage, func(kind string, keyHash, oldValue, newValue []byte) error {
+		storageDiff := EvmStorageDiff{
+			Kind:    kind,
+			KeyHash: keyHash,
+			Key:     d.preimage(keyHash),
+		}
+		// storage values are RLP encoded
+		if oldValue != nil {
+			if _, content, _, err := rlp.Split(oldValue); err == nil {
+				storageDiff.OldValue = content
+			}
+		}
+		if newValue != nil {
+			if _, content, _, err := rlp.Split(newValue); err == nil {
+				storageDiff.NewValue = content
+			}
+		}
+		diff.Storage = append(diff.Storage, storageDiff)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to diff storage of account %x", addressHash)
+	}
+	return diff, nil
+}
+
+func decodeEvmAccount(data []byte) (*EvmAccountState, error) {
+	var account evmAccount
+	if err := rlp.DecodeBytes(data, &account); err != nil {
+		return nil, errors.Wrap(err, "failed to decode EVM account")
+	}
+	return &EvmAccountState{
+		Nonce:       account.Nonce,
+		Balance:     account.Balance,
+		StorageRoot: account.Root[:],
+		CodeHash:    account.CodeHash,
+	}, nil
+}
+
+// evmTrieDB exposes the EVM state in a reader as a read-only go-ethereum DB.
+type evmTrieDB struct {
+	reader EvmStateReader
+}
+
+var _ ethdb.Database = &evmTrieDB{}
+
+func (db *evmTrieDB) Get(key []byte) ([]byte, error) {
+	value := db.reader.Get(util.PrefixKey(vmPrefix, key))
+	if value == nil {
+		return nil, errEvmNodeNotFound
+	}
+	return value, nil
+}
+
+func (db *evmTrieDB) Has(key []byte) (bool, error) {
+	return db.reader.Get(util.PrefixKey(vmPrefix, key)) != nil, nil
+}
+
+func (db *evmTrieDB) Put(key []byte, value []byte) error {
+	return errEvmDBReadOnly
+}
+
+func (db *evmTrieDB) Delete(key []byte) error {
+	return errEvmDBReadOnly
+}
+
+func (db *evmTrieDB) Close() {
+}
+
+func (db *evmTrieDB) NewBatch() ethdb.Batch {
+	return &evmTrieDBBatch{}
+}
+
+// evmTrieDBBatch discards all writes, since the EVM state is never modified while it's diffed.
+type evmTrieDBBatch struct{}
+
+func (b *evmTrieDBBatch) Put(key []byte, value []byte) error {
+	return errEvmDBReadOnly
+}
+
+func (b *evmTrieDBBatch) Delete(key []byte) error {
+	return errEvmDBReadOnly
+}
+
+func (b *evmTrieDBBatch) ValueSize() int {
+	return 0
+}
+
+func (b *evmTrieDBBatch) Write() error {
+	return errEvmDBReadOnly
+}
+
+func (b *evmTrieDBBatch) Reset() {
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/loomnetwork/go-loom"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffKVReaders(t *testing.T) {
+	appDB, _ := db.LoadMemDB()
+	iavlStore, err := NewIAVLStore(appDB, 0, 0, 0)
+	require.NoError(t, err)
+
+	contractAddr := loom.MustParseAddress("default:0x5cecd1f7261e1f4c684e297be3edf03b825e01c4")
+	contractKey := util.PrefixKey(loom.DataPrefix(contractAddr), []byte("count"))
+	nonceKey := util.PrefixKey([]byte("nonce"), []byte{0x01, 0x02})
+	featureKey := util.PrefixKey([]byte("feature"), []byte("test:1.0"))
+
+	iavlStore.Set(contractKey, []byte{1})
+	iavlStore.Set(nonceKey, []byte{0, 0, 0, 0, 0, 0, 0, 1})
+	iavlStore.Set([]byte("abc"), []byte("1"))
+	_, _, err = iavlStore.SaveVersion()
+	require.NoError(t, err)
+
+	iavlStore.Set(contractKey, []byte{2})
+	iavlStore.Set(featureKey, []byte{1})
+	iavlStore.Delete([]byte("abc"))
+	_, _, err = iavlStore.SaveVersion()
+	require.NoError(t, err)
+
+	from, err := iavlStore.GetSnapshotAt(1)
+	require.NoError(t, err)
+	defer from.Release()
+	to, err := iavlStore.GetSnapshotAt(2)
+	require.NoError(t, err)
+	defer to.Release()
+
+	var diffs []*KeyDiff
+	require.NoError(t, DiffKVReaders(from, to, nil, func(diff *KeyDiff) error {
+		diffs = append(diffs, diff)
+		return nil
+	}))
+	require.Len(t, diffs, 3)
+	require.Equal(t, DiffRemoved, diffs[0].Kind)
+	require.Equal(t, KeyCategoryOther, diffs[0].Category)
+	require.Equal(t, "abc", diffs[0].Description)
+	require.Equal(t, DiffChanged, diffs[1].Kind)
+	require.Equal(t, KeyCategoryContract, diffs[1].Category)
+	require.Equal(t, []byte(contractKey), []byte(diffs[1].Key))
+	require.Equal(t, []byte{1}, []byte(diffs[1].OldValue))
+	require.Equal(t, []byte{2}, []byte(diffs[1].NewValue))
+	require.Equal(t, DiffAdded, diffs[2].Kind)
+	require.Equal(t, KeyCategoryFeature, diffs[2].Category)
+	require.Equal(t, "feature/test:1.0", diffs[2].Description)
+	require.Equal(t, "enabled", FormatAppStoreValue(diffs[2].Category, diffs[2].NewValue))
+
+	// limited to a single contract
+	diffs = nil
+	require.NoError(t, DiffKVReaders(from, to, loom.DataPrefix(contractAddr), func(diff *KeyDiff) error {
+		diffs = append(diffs, diff)
+		return nil
+	}))
+	require.Len(t, diffs, 1)
+	require.Equal(t, []byte(contractKey), []byte(diffs[0].Key))
+
+	category, desc := DescribeAppStoreKey(nonceKey)
+	require.Equal(t, KeyCategoryNonce, category)
+	require.Equal(t, "nonce/0x0102", desc)
+	require.Equal(t, "1", FormatAppStoreValue(category, iavlStore.Get(nonceKey)))
+}
+
+func TestDiffEvmState(t *testing.T) {
+	evmDB, err := db.LoadMemDB()
+	require.NoError(t, err)
+	evmStore := NewEvmStore(evmDB, 100)
+	s := newEvmPruneTestState(t, evmStore)
+	s.populate()
+
+	diffEvmVersions := func(fromVersion, toVersion int64, address []byte) map[common.Address]*EvmAccountDiff {
+		from := evmStore.GetSnapshot(fromVersion)
+		defer from.Release()
+		to := evmStore.GetSnapshot(toVersion)
+		defer to.Release()
+		diffs := map[common.Address]*EvmAccountDiff{}
+		require.NoError(t, DiffEvmState(from, to, address, func(diff *EvmAccountDiff) error {
+			diffs[common.BytesToAddress(diff.Address)] = diff
+			return nil
+		}))
+		return diffs
+	}
+
+	diffs := diffEvmVersions(1, 2, nil)
+	require.Len(t, diffs, 3)
+	require.Equal(t, DiffChanged, diffs[pruneTestAddr1].Kind)
+	require.Len(t, diffs[pruneTestAddr1].Storage, 1)
+	slot := diffs[pruneTestAddr1].Storage[0]
+	require.Equal(t, DiffChanged, slot.Kind)
+	require.Equal(t, common.BigToHash(big.NewInt(1)).Bytes(), []byte(slot.Key))
+	require.Equal(t, []byte{1}, []byte(slot.OldValue))
+	require.Equal(t, []byte{100}, []byte(slot.NewValue))
+	require.Equal(t, DiffRemoved, diffs[pruneTestAddr2].Kind)
+	require.Nil(t, diffs[pruneTestAddr2].New)
+	require.Len(t, diffs[pruneTestAddr2].Storage, 5)
+	require.Equal(t, DiffAdded, diffs[pruneTestAddr4].Kind)
+	require.Equal(t, uint64(1), diffs[pruneTestAddr4].New.Nonce)
+
+	diffs = diffEvmVersions(2, 3, nil)
+	require.Len(t, diffs, 1)
+	require.Equal(t, int64(5), diffs[pruneTestAddr3].New.Balance.Int64())
+
+	// limited to a single account
+	require.Len(t, diffEvmVersions(1, 3, pruneTestAddr3.Bytes()), 1)
+	require.Len(t, diffEvmVersions(2, 3, pruneTestAddr1.Bytes()), 0)
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"encoding/binary"
+
+	"github.com/loomnetwork/go-loom/util"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+var (
+	outboxEventPrefix  = []byte("event")
+	outboxCursorPrefix = []byte("cursor")
+	outboxHeightKey    = []byte("height")
+)
+
+// OutboxBlock holds the events emitted in a single block, in the order they were emitted.
+type OutboxBlock struct {
+	Height uint64
+	Events [][]byte
+}
+
+// OutboxCursor describes how far along a dispatcher is in delivering the events in the outbox.
+type OutboxCursor struct {
+	Dispatcher string `json:"dispatcher"`
+	// Height of the last block whose events were delivered by the dispatcher.
+	Height uint64 `json:"height"`
+	// Number of blocks recorded in the outbox that the dispatcher hasn't caught up with yet.
+	Lag uint64 `json:"lag"`
+}
+
+// EventOutbox durably records the events emitted in each block until they've been delivered by all
+// the event dispatchers. Each dispatcher has its own cursor, which tracks the last block whose
+// events were delivered by the dispatcher, so events are delivered at least once even if a
+// dispatcher is unavailable for a while, or the node restarts.
+type EventOutbox struct {
+	db dbm.DB
+}
+
+func NewEventOutbox(db dbm.DB) *EventOutbox {
+	return &EventOutbox{db: db}
+}
+
+// SaveBlock records the events emitted in the block at the given height, replacing any events
+// previously recorded at that height (a block may be executed again after a crash). The events are
+// synced to disk before SaveBlock returns.
+func (o *EventOutbox) SaveBlock(height uint64, events [][]byte) error {
+	batch := o.db.NewBatch()
+	prefix := outboxBlockPrefix(height)
+	itr := o.db.Iterator(prefix, prefixRangeEnd(prefix))
+	for ; itr.Valid(); itr.Next() {
+		batch.Delete(itr.Key())
+	}
+	itr.Close()
+	for i, event := range events {
+		batch.Set(outboxEventKey(height, uint32(i)), event)
+	}
+	batch.Set(outboxHeightKey, uint64ToBytes(height))
+	batch.WriteSync()
+	return nil
+}
+
+// LastHeight returns the height of the last block recorded in the outbox.
+func (o *EventOutbox) LastHeight() uint64 {
+	return bytesToUint64(o.db.Get(outboxHeightKey))
+}
+
+// PendingBlocks returns up to maxBlocks blocks above the given height, blocks that didn't emit any
+// events are skipped.
+func (o *EventOutbox) PendingBlocks(afterHeight uint64, maxBlocks int) ([]*OutboxBlock, error) {
+	var blocks []*OutboxBlock
+	itr := o.db.Iterator(outboxBlockPrefix(afterHeight+1), prefixRangeEnd(outboxEventPrefix))
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		height, _ := outboxEventPosition(itr.Key())
+		if len(blocks) == 0 || blocks[len(blocks)-1].Height != height {
+			if len(blocks) == maxBlocks {
+				break
+			}
+			blocks = append(blocks, &OutboxBlock{Height: height})
+		}
+		block := blocks[len(blocks)-1]
+		block.Events = append(block.Events, itr.Value())
+	}
+	return blocks, nil
+}
+
+// Cursor returns the height of the last block whose events were delivered by the given dispatcher,
+// and false if the dispatcher doesn't have a cursor yet.
+func (o *EventOutbox) Cursor(dispatcher string) (uint64, bool) {
+	data := o.db.Get(outboxCursorKey(dispatcher))
+	if data == nil {
+		return 0, false
+	}
+	return bytesToUint64(data), true
+}
+
+// SetCursor records that all the events up to and including the given height have been delivered
+// by the given dispatcher.
+func (o *EventOutbox) SetCursor(dispatcher string, height uint64) {
+	o.db.Set(outboxCursorKey(dispatcher), uint64ToBytes(height))
+}
+
+// DeleteCursor removes the cursor of a dispatcher that's no longer in use, so it doesn't prevent
+// delivered events from being pruned.
+func (o *EventOutbox) DeleteCursor(dispatcher string) {
+	o.db.Delete(outboxCursorKey(dispatcher))
+}
+
+// Cursors returns the cursors of all the dispatchers, sorted by dispatcher name.
+func (o *EventOutbox) Cursors() []*OutboxCursor {
+	lastHeight := o.LastHeight()
+	prefix := util.PrefixKey(outboxCursorPrefix, []byte{})
+	var cursors []*OutboxCursor
+	itr := o.db.Iterator(prefix, prefixRangeEnd(prefix))
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		cursor := &OutboxCursor{
+			Dispatcher: string(itr.Key()[len(prefix):]),
+			Height:     bytesToUint64(itr.Value()),
+		}
+		if lastHeight > cursor.Height {
+			cursor.Lag = lastHeight - cursor.Height
+		}
+		cursors = append(cursors, cursor)
+	}
+	return cursors
+}
+
+// Prune deletes the events that have been delivered by all the dispatchers, and returns the number
+// of events deleted.
+func (o *EventOutbox) Prune() int {
+	cursors := o.Cursors()
+	if len(cursors) == 0 {
+		return 0
+	}
+	minHeight := cursors[0].Height
+	for _, cursor := range cursors[1:] {
+		if cursor.Height < minHeight {
+			minHeight = cursor.Height
+		}
+	}
+	batch := o.db.NewBatch()
+	numDeleted := 0
+	itr := o.db.Iterator(outboxBlockPrefix(0), outboxBlockPrefix(minHeight+1))
+	for ; itr.Valid(); itr.Next() {
+		batch.Delete(itr.Key())
+		numDeleted++
+	}
+	itr.Close()
+	if numDeleted > 0 {
+		batch.Write()
+	}
+	return numDeleted
+}
+
+func outboxBlockPrefix(height uint64) []byte {
+	return util.PrefixKey(outboxEventPrefix, uint64ToBytes(height))
+}
+
+func outboxEventKey(height uint64, index uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, index)
+	return util.PrefixKey(outboxEventPrefix, uint64ToBytes(height), buf)
+}
+
+// outboxEventPosition extracts the block height & event index from an outbox event key.
+func outboxEventPosition(key []byte) (uint64, uint32) {
+	// keys end with <height>0x00<index>
+	index := binary.BigEndian.Uint32(key[len(key)-4:])
+	height := binary.BigEndian.Uint64(key[len(key)-13 : len(key)-5])
+	return height, index
+}
+
+func outboxCursorKey(dispatcher string) []byte {
+	return util.PrefixKey(outboxCursorPrefix, []byte(dispatcher))
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+func TestEventOutbox(t *testing.T) {
+	outbox := NewEventOutbox(dbm.NewMemDB())
+	require.NoError(t, outbox.SaveBlock(1, [][]byte{[]byte("1-0"), []byte("1-1")}))
+	require.NoError(t, outbox.SaveBlock(2, nil))
+	require.NoError(t, outbox.SaveBlock(3, [][]byte{[]byte("3-0")}))
+	// a block that's executed again replaces the previously recorded events
+	require.NoError(t, outbox.SaveBlock(3, [][]byte{[]byte("3-0"), []byte("3-1")}))
+	require.Equal(t, uint64(3), outbox.LastHeight())
+
+	blocks, err := outbox.PendingBlocks(0, 10)
+	require.NoError(t, err)
+	require.Len(t, blocks, 2)
+	require.Equal(t, uint64(1), blocks[0].Height)
+	require.Equal(t, [][]byte{[]byte("1-0"), []byte("1-1")}, blocks[0].Events)
+	require.Equal(t, uint64(3), blocks[1].Height)
+	require.Equal(t, [][]byte{[]byte("3-0"), []byte("3-1")}, blocks[1].Events)
+
+	blocks, err = outbox.PendingBlocks(0, 1)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	blocks, err = outbox.PendingBlocks(1, 10)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, uint64(3), blocks[0].Height)
+
+	_, exists := outbox.Cursor("redis")
+	require.False(t, exists)
+	outbox.SetCursor("redis", 1)
+	outbox.SetCursor("db_indexer", 3)
+	cursors := outbox.Cursors()
+	require.Len(t, cursors, 2)
+	require.Equal(t, &OutboxCursor{Dispatcher: "db_indexer", Height: 3, Lag: 0}, cursors[0])
+	require.Equal(t, &OutboxCursor{Dispatcher: "redis", Height: 1, Lag: 2}, cursors[1])
+
+	// only the events delivered by all the dispatchers are pruned
+	require.Equal(t, 2, outbox.Prune())
+	blocks, err = outbox.PendingBlocks(0, 10)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	outbox.DeleteCursor("redis")
+	require.Equal(t, 2, outbox.Prune())
+	blocks, err = outbox.PendingBlocks(0, 10)
+	require.NoError(t, err)
+	require.Len(t, blocks, 0)
+}
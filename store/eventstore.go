@@ -1,13 +1,19 @@
 package store
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
 	"sync"
 
 	"github.com/gogo/protobuf/proto"
 	loom "github.com/loomnetwork/go-loom"
 	"github.com/loomnetwork/go-loom/plugin/types"
 	"github.com/loomnetwork/go-loom/util"
+	"github.com/pkg/errors"
 	dbm "github.com/tendermint/tendermint/libs/db"
 )
 
@@ -16,12 +22,44 @@ const (
 	pluginNameKeyPrefix            byte = 2
 	contractIDBlockHeightKeyPrefix byte = 3
 	lastContractIDKeyPrefix             = 5
+	topicIndexKeyPrefix            byte = 6
+	callerIndexKeyPrefix           byte = 7
+	txHashIndexKeyPrefix           byte = 8
+	prunedHeightKeyPrefix          byte = 9
 )
 
+// EventFilter specifies which events should be returned by the event store, all the criteria that
+// are set must be matched by an event for it to be returned.
 type EventFilter struct {
 	FromBlock uint64
 	ToBlock   uint64
 	Contract  string
+	// Only events emitted with all of these topics will be returned.
+	Topics []string
+	// Local address of the caller of the contract that emitted the event, for EVM contracts this is
+	// the tx origin.
+	Caller []byte
+	// Hash of the tx that emitted the event.
+	TxHash []byte
+	// Cursor returned by a previous query, the query resumes from the first event that wasn't
+	// returned by the previous query.
+	Cursor string
+	// Maximum number of events to return, zero means there's no limit.
+	MaxResults int
+	// Maximum number of events to examine, the query stops once this many events have been examined
+	// even if fewer than MaxResults matched the filter, zero means there's no limit.
+	MaxScanned int
+}
+
+// EventQueryResult is a single page of events returned by EventStore.QueryEvents.
+type EventQueryResult struct {
+	Events []*types.EventData
+	// Cursor to pass in to the next query to fetch the next page of events, empty if there are no
+	// more events that may match the filter.
+	NextCursor string
+	// Cursor of the position right after each of the returned events, a query that's passed one of
+	// these cursors resumes from the event that follows the corresponding event.
+	EventCursors []string
 }
 
 type EventStore interface {
@@ -32,16 +70,30 @@ type EventStore interface {
 	BatchSaveEvents(events []*types.EventData) error
 	// FilterEvents filters events that match the given filter
 	FilterEvents(filter EventFilter) ([]*types.EventData, error)
+	// QueryEvents returns a page of events that match the given filter, starting from the position
+	// specified by filter.Cursor (if any).
+	QueryEvents(filter EventFilter) (*EventQueryResult, error)
 	// ContractID mapping
 	GetContractID(pluginName string) uint64
 }
 
+// EventStoreNotifier is implemented by event stores that can notify readers when new events are
+// saved.
+type EventStoreNotifier interface {
+	// EventsSaved returns a channel that's closed the next time events are saved to the store.
+	EventsSaved() <-chan struct{}
+}
+
 type KVEventStore struct {
 	dbm.DB
 	sync.Mutex
+
+	savedMtx sync.Mutex
+	saved    chan struct{}
 }
 
 var _ EventStore = &KVEventStore{}
+var _ EventStoreNotifier = &KVEventStore{}
 
 func NewKVEventStore(db dbm.DB) *KVEventStore {
 	return &KVEventStore{DB: db}
@@ -54,6 +106,8 @@ func (s *KVEventStore) SaveEvent(contractID uint64, blockHeight uint64, eventInd
 	}
 	s.Set(prefixBlockHeightEventIndex(blockHeight, eventIndex), data)
 	s.Set(prefixContractIDBlockHightEventIndex(contractID, blockHeight, eventIndex), data)
+	indexEvent(s, blockHeight, eventIndex, eventData)
+	s.notifyEventsSaved()
 	return nil
 }
 
@@ -61,14 +115,7 @@ func (s *KVEventStore) BatchSaveEvents(events []*types.EventData) error {
 	// assume eevents is already sorted by event index
 	batch := s.NewBatch()
 	for i, event := range events {
-		// resolve contractID
-		// Go contract uses plugin name, EVM contract uses address
-		var contractID uint64
-		contractName := event.PluginName
-		if contractName == "" {
-			contractName = loom.UnmarshalAddressPB(event.Address).String()
-		}
-		contractID = s.GetContractID(contractName)
+		contractID := s.GetContractID(eventContractName(event))
 
 		data, err := proto.Marshal(event)
 		if err != nil {
@@ -77,43 +124,153 @@ func (s *KVEventStore) BatchSaveEvents(events []*types.EventData) error {
 		eventIndex := uint16(i)
 		batch.Set(prefixBlockHeightEventIndex(event.BlockHeight, eventIndex), data)
 		batch.Set(prefixContractIDBlockHightEventIndex(contractID, event.BlockHeight, eventIndex), data)
+		indexEvent(batch, event.BlockHeight, eventIndex, event)
 	}
 	batch.Write()
+	s.notifyEventsSaved()
 	return nil
 }
 
+func (s *KVEventStore) EventsSaved() <-chan struct{} {
+	s.savedMtx.Lock()
+	defer s.savedMtx.Unlock()
+	if s.saved == nil {
+		s.saved = make(chan struct{})
+	}
+	return s.saved
+}
+
+// notifyEventsSaved wakes up everyone waiting on the channel returned by EventsSaved.
+func (s *KVEventStore) notifyEventsSaved() {
+	s.savedMtx.Lock()
+	defer s.savedMtx.Unlock()
+	if s.saved != nil {
+		close(s.saved)
+		s.saved = nil
+	}
+}
+
 func (s *KVEventStore) FilterEvents(filter EventFilter) ([]*types.EventData, error) {
-	var events []*types.EventData
-	if filter.Contract != "" {
-		contractID := s.GetContractID(filter.Contract)
-		// Interator uses [start, end) so make sure we increase end inclusively
-		start := prefixContractIDBlockHight(contractID, filter.FromBlock)
-		end := prefixContractIDBlockHight(contractID, filter.ToBlock+1)
-		itr := s.Iterator(start, end)
-		defer itr.Close()
-		for ; itr.Valid(); itr.Next() {
-			var ed types.EventData
-			if err := proto.Unmarshal(itr.Value(), &ed); err != nil {
-				return nil, err
-			}
-			events = append(events, &ed)
+	filter.Cursor = ""
+	filter.MaxResults = 0
+	filter.MaxScanned = 0
+	result, err := s.QueryEvents(filter)
+	if err != nil {
+		return nil, err
+	}
+	return result.Events, nil
+}
+
+// QueryEvents returns a page of events that match the given filter. The most selective index that
+// applies to the filter is used to find candidate events, which are then checked against the rest
+// of the filter. Events saved before the topic, caller, and tx hash indexes were added can only be
+// found by block range & contract.
+func (s *KVEventStore) QueryEvents(filter EventFilter) (*EventQueryResult, error) {
+	startHeight, startIndex := filter.FromBlock, uint16(0)
+	if filter.Cursor != "" {
+		height, index, err := parseEventCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if height > startHeight {
+			startHeight, startIndex = height, index
+		} else if height == startHeight {
+			startIndex = index
+		}
+	}
+	if prunedHeight := s.queryPrunedHeight(filter.Contract); startHeight < prunedHeight {
+		return nil, errors.Wrapf(
+			ErrEventsPruned, "events before block %d are no longer available", prunedHeight,
+		)
+	}
+
+	result := &EventQueryResult{}
+	// criteria that's matched by the index doesn't need to be checked again
+	matchFilter := filter
+	var indexPrefix []byte
+	// only the block height & contract indexes store the event data, the rest refer to the block
+	// height index
+	indexHasData := false
+	switch {
+	case len(filter.TxHash) > 0:
+		indexPrefix = util.PrefixKey([]byte{txHashIndexKeyPrefix}, filter.TxHash)
+	case len(filter.Topics) > 0:
+		indexPrefix = util.PrefixKey([]byte{topicIndexKeyPrefix}, topicHash(filter.Topics[0]))
+	case len(filter.Caller) > 0:
+		indexPrefix = util.PrefixKey([]byte{callerIndexKeyPrefix}, filter.Caller)
+	case filter.Contract != "":
+		contractID := bytesToUint64(s.Get(prefixPluginName(filter.Contract)))
+		if contractID == 0 {
+			return result, nil
 		}
-	} else {
-		// Interator uses [start, end) so make sure we increase end inclusively
-		start := prefixBlockHeightEventIndex(filter.FromBlock, 0)
-		end := prefixBlockHeightEventIndex(filter.ToBlock+1, 0)
-		itr := s.Iterator(start, end)
-		defer itr.Close()
-		for ; itr.Valid(); itr.Next() {
-			var ed types.EventData
-			if err := proto.Unmarshal(itr.Value(), &ed); err != nil {
-				return nil, err
+		indexPrefix = util.PrefixKey([]byte{contractIDBlockHeightKeyPrefix}, uint64ToBytes(contractID))
+		indexHasData = true
+		matchFilter.Contract = ""
+	default:
+		indexPrefix = []byte{blockHeightKeyPrefix}
+		indexHasData = true
+	}
+
+	// Interator uses [start, end) so make sure we increase end inclusively
+	start := util.PrefixKey(indexPrefix, uint64ToBytes(startHeight), uint16ToBytes(startIndex))
+	end := util.PrefixKey(indexPrefix, uint64ToBytes(filter.ToBlock+1))
+	itr := s.Iterator(start, end)
+	defer itr.Close()
+	numScanned := 0
+	for ; itr.Valid(); itr.Next() {
+		height, index := eventPositionFromKey(itr.Key())
+		if (filter.MaxResults > 0 && len(result.Events) >= filter.MaxResults) ||
+			(filter.MaxScanned > 0 && numScanned >= filter.MaxScanned) {
+			result.NextCursor = formatEventCursor(height, index)
+			break
+		}
+		numScanned++
+
+		data := itr.Value()
+		if !indexHasData {
+			data = s.Get(prefixBlockHeightEventIndex(height, index))
+			if data == nil {
+				continue
 			}
-			events = append(events, &ed)
 		}
+		var ed types.EventData
+		if err := proto.Unmarshal(data, &ed); err != nil {
+			return nil, err
+		}
+		if !eventMatchesFilter(&ed, &matchFilter) {
+			continue
+		}
+		result.Events = append(result.Events, &ed)
+		result.EventCursors = append(result.EventCursors, formatEventCursorAfter(height, index))
 	}
+	return result, nil
+}
 
-	return events, nil
+// eventMatchesFilter checks if the given event matches the contract, topics, caller & tx hash
+// specified in the filter, the block range is assumed to be matched already.
+func eventMatchesFilter(event *types.EventData, filter *EventFilter) bool {
+	if filter.Contract != "" && eventContractName(event) != filter.Contract {
+		return false
+	}
+	if len(filter.TxHash) > 0 && !bytes.Equal(event.TxHash, filter.TxHash) {
+		return false
+	}
+	if len(filter.Caller) > 0 && (event.Caller == nil || !bytes.Equal(event.Caller.Local, filter.Caller)) {
+		return false
+	}
+	for _, topic := range filter.Topics {
+		found := false
+		for _, eventTopic := range event.Topics {
+			if eventTopic == topic {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
 func (s *KVEventStore) GetContractID(pluginName string) uint64 {
@@ -136,6 +293,76 @@ func (s *KVEventStore) nextContractID() uint64 {
 	return id
 }
 
+type kvSetter interface {
+	Set(key, value []byte)
+}
+
+// indexEvent writes the topic, caller & tx hash index entries for the given event.
+func indexEvent(w kvSetter, blockHeight uint64, eventIndex uint16, event *types.EventData) {
+	for _, key := range eventIndexKeys(blockHeight, eventIndex, event) {
+		w.Set(key, []byte{})
+	}
+}
+
+// eventIndexKeys returns the keys of the topic, caller & tx hash index entries for the given event.
+func eventIndexKeys(blockHeight uint64, eventIndex uint16, event *types.EventData) [][]byte {
+	height, index := uint64ToBytes(blockHeight), uint16ToBytes(eventIndex)
+	var keys [][]byte
+	for _, topic := range event.Topics {
+		keys = append(keys, util.PrefixKey([]byte{topicIndexKeyPrefix}, topicHash(topic), height, index))
+	}
+	if event.Caller != nil && len(event.Caller.Local) > 0 {
+		keys = append(keys, util.PrefixKey([]byte{callerIndexKeyPrefix}, event.Caller.Local, height, index))
+	}
+	if len(event.TxHash) > 0 {
+		keys = append(keys, util.PrefixKey([]byte{txHashIndexKeyPrefix}, event.TxHash, height, index))
+	}
+	return keys
+}
+
+// eventContractName returns the name the contract that emitted the event is indexed by, Go
+// contracts are indexed by plugin name, and EVM contracts by address.
+func eventContractName(event *types.EventData) string {
+	if event.PluginName != "" {
+		return event.PluginName
+	}
+	return loom.UnmarshalAddressPB(event.Address).String()
+}
+
+// topicHash returns the fixed size form of a topic that's used in index keys.
+func topicHash(topic string) []byte {
+	hash := sha256.Sum256([]byte(topic))
+	return hash[:]
+}
+
+// eventPositionFromKey extracts the block height & event index from the end of an event index key.
+func eventPositionFromKey(key []byte) (uint64, uint16) {
+	// keys end with <height>0x00<index>
+	index := binary.BigEndian.Uint16(key[len(key)-2:])
+	height := binary.BigEndian.Uint64(key[len(key)-11 : len(key)-3])
+	return height, index
+}
+
+func formatEventCursor(blockHeight uint64, eventIndex uint16) string {
+	return hex.EncodeToString(append(uint64ToBytes(blockHeight), uint16ToBytes(eventIndex)...))
+}
+
+// formatEventCursorAfter returns the cursor of the position that follows the given event.
+func formatEventCursorAfter(blockHeight uint64, eventIndex uint16) string {
+	if eventIndex == math.MaxUint16 {
+		return formatEventCursor(blockHeight+1, 0)
+	}
+	return formatEventCursor(blockHeight, eventIndex+1)
+}
+
+func parseEventCursor(cursor string) (uint64, uint16, error) {
+	b, err := hex.DecodeString(cursor)
+	if err != nil || len(b) != 10 {
+		return 0, 0, fmt.Errorf("invalid event cursor %s", cursor)
+	}
+	return binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint16(b[8:]), nil
+}
+
 func prefixBlockHeightEventIndex(blockHeight uint64, eventIndex uint16) []byte {
 	return util.PrefixKey([]byte{blockHeightKeyPrefix}, uint64ToBytes(blockHeight), uint16ToBytes(eventIndex))
 }
@@ -0,0 +1,311 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	loom "github.com/loomnetwork/go-loom"
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/log"
+	"github.com/pkg/errors"
+)
+
+// ErrEventsPruned is returned by event store queries for block ranges that contain pruned events.
+var ErrEventsPruned = errors.New("events have been pruned")
+
+// EventRetentionPolicy specifies how long events should be retained for, an event is pruned once it
+// exceeds either limit.
+type EventRetentionPolicy struct {
+	// Number of most recent blocks to retain events for, zero means there's no limit.
+	MaxBlocks uint64
+	// How long to retain events for, zero means there's no limit.
+	MaxAge time.Duration
+}
+
+// EventStorePruningConfig contains the settings used to prune old events from the event store.
+type EventStorePruningConfig struct {
+	// Retention policy for the events emitted by contracts that don't have an override.
+	Retention EventRetentionPolicy
+	// Overrides the retention policy for the events emitted by specific contracts, keyed by plugin
+	// name for Go contracts, and by address for EVM contracts (contract names are case-insensitive).
+	ContractRetention map[string]EventRetentionPolicy
+	// Maximum number of events to delete in a single batch.
+	BatchSize int
+	// How long to wait between pruning cycles.
+	Interval time.Duration
+	Logger   *loom.Logger
+}
+
+// EventPruneStats summarizes the results of a single event store pruning cycle.
+type EventPruneStats struct {
+	// Height of the most recent block that emitted events.
+	LatestHeight uint64
+	// Events below this height were pruned, unless the contract that emitted them has an override.
+	PrunedHeight uint64
+	// Number of events examined.
+	NumScannedEvents int
+	// Number of events deleted.
+	NumDeletedEvents int
+}
+
+func (s *EventPruneStats) String() string {
+	return fmt.Sprintf(
+		"latest height %d, pruned height %d, scanned %d events, deleted %d events",
+		s.LatestHeight, s.PrunedHeight, s.NumScannedEvents, s.NumDeletedEvents,
+	)
+}
+
+// EnablePruning starts a goroutine that periodically deletes the events that are no longer
+// retained by the given retention policies. Events are deleted in small batches, so saving new
+// events isn't blocked while the store is being pruned.
+func (s *KVEventStore) EnablePruning(cfg EventStorePruningConfig) error {
+	if cfg.BatchSize <= 0 {
+		return errors.New("event store pruning batch size must be greater than zero")
+	}
+	if cfg.Interval <= 0 {
+		return errors.New("event store pruning interval must be greater than zero")
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = log.Default
+	}
+	go func() {
+		for {
+			time.Sleep(cfg.Interval)
+			stats, err := s.Prune(cfg, time.Now())
+			if err != nil {
+				logger.Error("Failed to prune event store", "err", err)
+			} else {
+				logger.Info("Pruned event store", "stats", stats.String())
+			}
+		}
+	}()
+	return nil
+}
+
+// Prune deletes the events that are no longer retained by the given retention policies at the given
+// time. Retention by block count is relative to the most recent block that emitted events, and
+// retention by age relies on the block time stored in each event.
+func (s *KVEventStore) Prune(cfg EventStorePruningConfig, now time.Time) (*EventPruneStats, error) {
+	if cfg.BatchSize <= 0 {
+		return nil, errors.New("event store pruning batch size must be greater than zero")
+	}
+	stats := &EventPruneStats{}
+	latestHeight, err := s.latestEventHeight()
+	if err != nil || latestHeight == 0 {
+		return stats, err
+	}
+	stats.LatestHeight = latestHeight
+
+	// Height of the first retained block for each policy, keyed by lowercase contract name, the
+	// default policy is keyed by an empty string.
+	cutoffs := map[string]uint64{}
+	policies := map[string]EventRetentionPolicy{"": cfg.Retention}
+	for contract, policy := range cfg.ContractRetention {
+		policies[strings.ToLower(contract)] = policy
+	}
+	for contract, policy := range policies {
+		if cutoffs[contract], err = s.retentionCutoff(policy, latestHeight, now); err != nil {
+			return nil, err
+		}
+	}
+	s.deleteStalePrunedHeights(policies)
+
+	// Only the range between the lowest height that may still contain prunable events and the
+	// highest cutoff needs to be scanned.
+	fromHeight, toHeight := latestHeight+1, uint64(0)
+	for contract, cutoff := range cutoffs {
+		if prunedHeight := s.prunedHeight(contract); cutoff > prunedHeight {
+			if prunedHeight < fromHeight {
+				fromHeight = prunedHeight
+			}
+			if cutoff > toHeight {
+				toHeight = cutoff
+			}
+		}
+	}
+	if toHeight > 0 {
+		if err := s.pruneRange(fromHeight, toHeight, cutoffs, cfg.BatchSize, stats); err != nil {
+			return nil, err
+		}
+	}
+	// The pruned heights of contracts with overrides are recorded even if nothing was pruned, so
+	// queries for those contracts aren't checked against the default policy.
+	for contract, cutoff := range cutoffs {
+		key := prefixPrunedHeight(contract)
+		if data := s.Get(key); data == nil || cutoff > bytesToUint64(data) {
+			s.Set(key, uint64ToBytes(cutoff))
+		}
+	}
+	stats.PrunedHeight = s.prunedHeight("")
+	return stats, nil
+}
+
+// pruneRange deletes the events in [fromHeight, toHeight) that are below the cutoff height of the
+// contract that emitted them. The range is scanned one batch at a time, and each batch is written
+// separately, so the pruner doesn't hold on to an iterator, or a lock, for any length of time.
+func (s *KVEventStore) pruneRange(
+	fromHeight, toHeight uint64, cutoffs map[string]uint64, batchSize int, stats *EventPruneStats,
+) error {
+	contractIDs := map[string]uint64{}
+	start := prefixBlockHeightEventIndex(fromHeight, 0)
+	end := util.PrefixKey([]byte{blockHeightKeyPrefix}, uint64ToBytes(toHeight))
+	for {
+		batch := s.NewBatch()
+		numDeleted := 0
+		var nextStart []byte
+		itr := s.Iterator(start, end)
+		for ; itr.Valid(); itr.Next() {
+			if numDeleted >= batchSize {
+				nextStart = append([]byte{}, itr.Key()...)
+				break
+			}
+			stats.NumScannedEvents++
+			height, index := eventPositionFromKey(itr.Key())
+			var event types.EventData
+			if err := proto.Unmarshal(itr.Value(), &event); err != nil {
+				itr.Close()
+				return errors.Wrapf(err, "failed to unmarshal event %d:%d", height, index)
+			}
+			contract := eventContractName(&event)
+			cutoff, ok := cutoffs[strings.ToLower(contract)]
+			if !ok {
+				cutoff = cutoffs[""]
+			}
+			if height >= cutoff {
+				continue
+			}
+			contractID, ok := contractIDs[contract]
+			if !ok {
+				contractID = bytesToUint64(s.Get(prefixPluginName(contract)))
+				contractIDs[contract] = contractID
+			}
+			batch.Delete(prefixBlockHeightEventIndex(height, index))
+			if contractID != 0 {
+				batch.Delete(prefixContractIDBlockHightEventIndex(contractID, height, index))
+			}
+			for _, key := range eventIndexKeys(height, index, &event) {
+				batch.Delete(key)
+			}
+			numDeleted++
+		}
+		itr.Close()
+		if numDeleted > 0 {
+			batch.Write()
+			stats.NumDeletedEvents += numDeleted
+		}
+		if nextStart == nil {
+			return nil
+		}
+		start = nextStart
+	}
+}
+
+// retentionCutoff returns the height of the first block whose events are retained by the given
+// policy, or zero if the policy retains all events.
+func (s *KVEventStore) retentionCutoff(
+	policy EventRetentionPolicy, latestHeight uint64, now time.Time,
+) (uint64, error) {
+	cutoff := uint64(0)
+	if policy.MaxBlocks > 0 && latestHeight >= policy.MaxBlocks {
+		cutoff = latestHeight - policy.MaxBlocks + 1
+	}
+	if policy.MaxAge > 0 {
+		height, err := s.firstEventHeightSince(now.Add(-policy.MaxAge).Unix(), latestHeight)
+		if err != nil {
+			return 0, err
+		}
+		if height > cutoff {
+			cutoff = height
+		}
+	}
+	return cutoff, nil
+}
+
+// firstEventHeightSince returns the height of the first block that emitted events at or after the
+// given block time, or latestHeight+1 if there's no such block. Block times never decrease as the
+// height increases, so the height can be located via a binary search.
+func (s *KVEventStore) firstEventHeightSince(blockTime int64, latestHeight uint64) (uint64, error) {
+	lo, hi := uint64(0), latestHeight+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		event, err := s.firstEventFrom(mid)
+		if err != nil {
+			return 0, err
+		}
+		if event == nil || event.BlockTime >= blockTime {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, nil
+}
+
+// firstEventFrom returns the first event at or above the given height, or nil if there isn't one.
+func (s *KVEventStore) firstEventFrom(height uint64) (*types.EventData, error) {
+	itr := s.Iterator(prefixBlockHeightEventIndex(height, 0), []byte{blockHeightKeyPrefix + 1})
+	defer itr.Close()
+	if !itr.Valid() {
+		return nil, nil
+	}
+	var event types.EventData
+	if err := proto.Unmarshal(itr.Value(), &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// latestEventHeight returns the height of the most recent block that emitted events, or zero if the
+// store is empty.
+func (s *KVEventStore) latestEventHeight() (uint64, error) {
+	itr := s.ReverseIterator([]byte{blockHeightKeyPrefix}, []byte{blockHeightKeyPrefix + 1})
+	defer itr.Close()
+	if !itr.Valid() {
+		return 0, nil
+	}
+	height, _ := eventPositionFromKey(itr.Key())
+	return height, nil
+}
+
+// prunedHeight returns the height below which events emitted by the given contract have been
+// pruned, an empty contract name refers to the default retention policy.
+func (s *KVEventStore) prunedHeight(contract string) uint64 {
+	return bytesToUint64(s.Get(prefixPrunedHeight(strings.ToLower(contract))))
+}
+
+// queryPrunedHeight returns the height below which the events matching a query may have been
+// pruned, which is determined by the retention policy of the queried contract.
+func (s *KVEventStore) queryPrunedHeight(contract string) uint64 {
+	if contract != "" {
+		if data := s.Get(prefixPrunedHeight(strings.ToLower(contract))); data != nil {
+			return bytesToUint64(data)
+		}
+	}
+	return s.prunedHeight("")
+}
+
+// deleteStalePrunedHeights deletes the pruned heights recorded for contracts whose retention
+// overrides have been removed, so queries for those contracts fall back to the default policy.
+func (s *KVEventStore) deleteStalePrunedHeights(policies map[string]EventRetentionPolicy) {
+	prefix := []byte{prunedHeightKeyPrefix, 0}
+	var stale [][]byte
+	itr := s.Iterator(prefix, []byte{prunedHeightKeyPrefix + 1})
+	for ; itr.Valid(); itr.Next() {
+		contract := string(itr.Key()[len(prefix):])
+		if _, ok := policies[contract]; !ok {
+			stale = append(stale, append([]byte{}, itr.Key()...))
+		}
+	}
+	itr.Close()
+	for _, key := range stale {
+		s.Delete(key)
+	}
+}
+
+func prefixPrunedHeight(contract string) []byte {
+	return util.PrefixKey([]byte{prunedHeightKeyPrefix}, []byte(contract))
+}
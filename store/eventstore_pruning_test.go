@@ -0,0 +1,83 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/loomnetwork/go-loom/plugin/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+func TestEventStorePruning(t *testing.T) {
+	memdb := dbm.NewMemDB()
+	eventStore := NewKVEventStore(memdb)
+
+	for height := uint64(1); height <= 10; height++ {
+		var events []*types.EventData
+		for i := 0; i < 3; i++ {
+			events = append(events, &types.EventData{
+				PluginName:  fmt.Sprintf("plugin%d", i),
+				BlockHeight: height,
+				BlockTime:   int64(1000 + height*10),
+				Topics:      []string{"event:transfer"},
+				TxHash:      []byte(fmt.Sprintf("tx-%d-%d", height, i)),
+				EncodedBody: []byte(fmt.Sprintf("event-%d-%d", height, i)),
+			})
+		}
+		require.NoError(t, eventStore.BatchSaveEvents(events))
+	}
+
+	cfg := EventStorePruningConfig{
+		// retain blocks 6-10
+		Retention: EventRetentionPolicy{MaxBlocks: 5},
+		ContractRetention: map[string]EventRetentionPolicy{
+			// retain blocks 7-10
+			"plugin1": {MaxAge: 30 * time.Second},
+			// retain all blocks
+			"PLUGIN2": {},
+		},
+		BatchSize: 2,
+	}
+	stats, err := eventStore.Prune(cfg, time.Unix(1100, 0))
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), stats.LatestHeight)
+	require.Equal(t, uint64(6), stats.PrunedHeight)
+	require.Equal(t, 11, stats.NumDeletedEvents)
+
+	countEvents := func(contract string, fromBlock uint64) int {
+		events, err := eventStore.FilterEvents(EventFilter{FromBlock: fromBlock, ToBlock: 10, Contract: contract})
+		require.NoError(t, err)
+		return len(events)
+	}
+	require.Equal(t, 5, countEvents("plugin0", 6))
+	require.Equal(t, 4, countEvents("plugin1", 7))
+	require.Equal(t, 10, countEvents("plugin2", 1))
+	require.Equal(t, 14, countEvents("", 6))
+
+	// queries for pruned ranges should fail
+	_, err = eventStore.FilterEvents(EventFilter{FromBlock: 5, ToBlock: 10})
+	require.Equal(t, ErrEventsPruned, errors.Cause(err))
+	_, err = eventStore.FilterEvents(EventFilter{FromBlock: 6, ToBlock: 10, Contract: "plugin1"})
+	require.Equal(t, ErrEventsPruned, errors.Cause(err))
+
+	// the secondary indexes should only refer to the retained events
+	result, err := eventStore.QueryEvents(EventFilter{FromBlock: 6, ToBlock: 10, Topics: []string{"event:transfer"}})
+	require.NoError(t, err)
+	require.Len(t, result.Events, 14)
+	numTopicKeys := 0
+	itr := memdb.Iterator([]byte{topicIndexKeyPrefix}, []byte{topicIndexKeyPrefix + 1})
+	for ; itr.Valid(); itr.Next() {
+		numTopicKeys++
+	}
+	itr.Close()
+	require.Equal(t, 19, numTopicKeys)
+
+	// nothing else should be pruned until more blocks are added
+	stats, err = eventStore.Prune(cfg, time.Unix(1100, 0))
+	require.NoError(t, err)
+	require.Equal(t, 0, stats.NumDeletedEvents)
+	require.Equal(t, 0, stats.NumScannedEvents)
+}
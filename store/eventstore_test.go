@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/gogo/protobuf/proto"
+	loom "github.com/loomnetwork/go-loom"
 	"github.com/loomnetwork/go-loom/plugin/types"
 	"github.com/stretchr/testify/require"
 	dbm "github.com/tendermint/tendermint/libs/db"
@@ -228,3 +229,104 @@ func TestEventStoreFilterMultiplePluginsMemDB(t *testing.T) {
 	_, err = eventStore.FilterEvents(filter4)
 	require.Nil(t, err)
 }
+
+func TestEventStoreQueryEventsMemDB(t *testing.T) {
+	memdb := dbm.NewMemDB()
+	var eventStore EventStore = NewKVEventStore(memdb)
+
+	caller1 := loom.MustParseAddress("default:0xb16a379ec18d4093666f8f38b11a3071c920207d")
+	caller2 := loom.MustParseAddress("default:0x5cecd1f7261e1f4c684e297be3edf03b825e01c4")
+	for height := uint64(1); height <= 10; height++ {
+		var events []*types.EventData
+		for i := 0; i < 3; i++ {
+			caller := caller1
+			if i == 2 {
+				caller = caller2
+			}
+			events = append(events, &types.EventData{
+				PluginName:  fmt.Sprintf("plugin%d", i%2),
+				BlockHeight: height,
+				Topics:      []string{"event:transfer", fmt.Sprintf("event:%d", i)},
+				Caller:      caller.MarshalPB(),
+				TxHash:      []byte(fmt.Sprintf("tx-%d-%d", height, i)),
+				EncodedBody: []byte(fmt.Sprintf("event-%d-%d", height, i)),
+			})
+		}
+		require.NoError(t, eventStore.BatchSaveEvents(events))
+	}
+
+	result, err := eventStore.QueryEvents(EventFilter{
+		FromBlock: 2,
+		ToBlock:   4,
+		Topics:    []string{"event:1"},
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Events, 3)
+	require.Equal(t, "", result.NextCursor)
+	for i, event := range result.Events {
+		require.Equal(t, []byte(fmt.Sprintf("event-%d-1", i+2)), event.EncodedBody)
+	}
+
+	// all topics must match
+	result, err = eventStore.QueryEvents(EventFilter{
+		FromBlock: 1,
+		ToBlock:   10,
+		Topics:    []string{"event:transfer", "event:2"},
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Events, 10)
+
+	result, err = eventStore.QueryEvents(EventFilter{
+		FromBlock: 1,
+		ToBlock:   10,
+		Caller:    caller2.Local,
+		Contract:  "plugin0",
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Events, 10)
+	for _, event := range result.Events {
+		require.Equal(t, "plugin0", event.PluginName)
+		require.Equal(t, []byte(caller2.Local), []byte(event.Caller.Local))
+	}
+
+	result, err = eventStore.QueryEvents(EventFilter{
+		FromBlock: 1,
+		ToBlock:   10,
+		TxHash:    []byte("tx-7-0"),
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Events, 1)
+	require.Equal(t, []byte("event-7-0"), result.Events[0].EncodedBody)
+
+	// page through all the events emitted by a contract
+	var events []*types.EventData
+	filter := EventFilter{FromBlock: 1, ToBlock: 10, Contract: "plugin1", MaxResults: 4}
+	for {
+		result, err = eventStore.QueryEvents(filter)
+		require.NoError(t, err)
+		require.True(t, len(result.Events) <= 4)
+		events = append(events, result.Events...)
+		if result.NextCursor == "" {
+			break
+		}
+		filter.Cursor = result.NextCursor
+	}
+	require.Len(t, events, 10)
+	for i, event := range events {
+		require.Equal(t, []byte(fmt.Sprintf("event-%d-1", i+1)), event.EncodedBody)
+	}
+
+	// the query should stop after examining MaxScanned events even if nothing matched
+	result, err = eventStore.QueryEvents(EventFilter{
+		FromBlock:  1,
+		ToBlock:    10,
+		Topics:     []string{"event:transfer", "event:unknown"},
+		MaxScanned: 5,
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Events, 0)
+	require.NotEqual(t, "", result.NextCursor)
+
+	_, err = eventStore.QueryEvents(EventFilter{FromBlock: 1, ToBlock: 10, Cursor: "invalid"})
+	require.Error(t, err)
+}
@@ -10,13 +10,15 @@ import (
 	"github.com/pkg/errors"
 	"github.com/syndtr/goleveldb/leveldb"
 	goleveldb "github.com/syndtr/goleveldb/leveldb"
+	ldbutil "github.com/syndtr/goleveldb/leveldb/util"
 )
 
 var (
 	EvmAuxDBName = "receipts_db"
 
-	BloomPrefix  = []byte("bf")
-	TxHashPrefix = []byte("th")
+	BloomPrefix     = []byte("bf")
+	TxHashPrefix    = []byte("th")
+	BlockTimePrefix = []byte("bt")
 )
 
 func bloomFilterKey(height uint64) []byte {
@@ -27,6 +29,10 @@ func evmTxHashKey(height uint64) []byte {
 	return util.PrefixKey(TxHashPrefix, blockHeightToBytes(height))
 }
 
+func blockTimeKey(height uint64) []byte {
+	return util.PrefixKey(BlockTimePrefix, blockHeightToBytes(height))
+}
+
 func blockHeightToBytes(height uint64) []byte {
 	heightB := make([]byte, 8)
 	binary.BigEndian.PutUint64(heightB, height)
@@ -90,6 +96,40 @@ func (s *EvmAuxStore) SetTxHashList(tran *leveldb.Transaction, txHashList [][]by
 	return nil
 }
 
+// HasTxHashList checks if the tx hash list of the block at the given height has been stored.
+func (s *EvmAuxStore) HasTxHashList(height uint64) (bool, error) {
+	return s.db.Has(evmTxHashKey(height), nil)
+}
+
+// SetBlockTime records the time (in seconds since the Unix epoch) of a block that contains EVM txs.
+func (s *EvmAuxStore) SetBlockTime(tran *leveldb.Transaction, height uint64, blockTime int64) error {
+	timeB := make([]byte, 8)
+	binary.BigEndian.PutUint64(timeB, uint64(blockTime))
+	return tran.Put(blockTimeKey(height), timeB, nil)
+}
+
+// DeleteBlockTime removes the time of the block at the given height.
+func (s *EvmAuxStore) DeleteBlockTime(tran *leveldb.Transaction, height uint64) error {
+	return tran.Delete(blockTimeKey(height), nil)
+}
+
+// ForEachBlockTime calls fn with each recorded block time in ascending height order, until fn
+// returns false.
+func (s *EvmAuxStore) ForEachBlockTime(
+	tran *leveldb.Transaction, fn func(height uint64, blockTime int64) bool,
+) error {
+	prefix := util.PrefixKey(BlockTimePrefix, []byte{})
+	itr := tran.NewIterator(ldbutil.BytesPrefix(prefix), nil)
+	defer itr.Release()
+	for itr.Next() {
+		height := binary.BigEndian.Uint64(itr.Key()[len(prefix):])
+		if !fn(height, int64(binary.BigEndian.Uint64(itr.Value()))) {
+			break
+		}
+	}
+	return itr.Error()
+}
+
 func (s *EvmAuxStore) DB() *leveldb.DB {
 	return s.db
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/metrics"
@@ -58,6 +59,12 @@ type EvmStore struct {
 	lastSavedRoot []byte
 	rootCache     *lru.Cache
 	version       int64
+
+	// Commit holds this mutex while writing to evm.db, so that the pruner can't delete keys that
+	// are being written.
+	pruneMutex sync.Mutex
+	// Keys written since the current pruning cycle began, nil if pruning isn't in progress.
+	prunePreserved map[string]struct{}
 }
 
 // NewEvmStore returns a new instance of the store backed by the given DB.
@@ -145,6 +152,36 @@ func (s *EvmStore) Range(prefix []byte) plugin.RangeData {
 	return ret
 }
 
+// NewIterator returns an iterator that merges the uncommitted changes in the store with the keys
+// in evm.db.
+func (s *EvmStore) NewIterator(prefix []byte, opts RangeOptions) Iterator {
+	start, end := rangeBounds(prefix, opts)
+	var dbIter Iterator
+	if opts.Reverse {
+		dbIter = s.evmDB.ReverseIterator(start, end)
+	} else {
+		dbIter = s.evmDB.Iterator(start, end)
+	}
+
+	pending := make([]pendingWrite, 0)
+	for key, item := range s.cache {
+		if inRange([]byte(key), start, end) {
+			pending = append(pending, pendingWrite{
+				key:     []byte(key),
+				value:   item.Value,
+				deleted: item.Deleted,
+			})
+		}
+	}
+	// Same as Range, the root hash (vmvmroot) comes from EvmStore.rootHash
+	if inRange(rootHashKey, start, end) && s.evmDB.Has(rootHashKey) {
+		pending = append(pending, pendingWrite{key: rootHashKey, value: s.rootHash})
+	}
+
+	it := newMergeIterator(dbIter, pending, opts.Reverse)
+	return withLimit(newPrefixedIterator(it, prefix), opts.Limit)
+}
+
 func (s *EvmStore) Has(key []byte) bool {
 	// EvmStore always has Patricia root
 	if bytes.Equal(key, rootHashKey) {
@@ -201,10 +238,16 @@ func (s *EvmStore) Commit(version int64) []byte {
 
 	s.rootCache.Add(version, currentRoot)
 
+	s.pruneMutex.Lock()
+	defer s.pruneMutex.Unlock()
+
 	batch := s.evmDB.NewBatch()
 	for key, item := range s.cache {
 		if !item.Deleted {
 			batch.Set([]byte(key), item.Value)
+			if s.prunePreserved != nil {
+				s.prunePreserved[key] = struct{}{}
+			}
 		} else {
 			batch.Delete([]byte(key))
 		}
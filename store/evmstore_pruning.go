@@ -0,0 +1,429 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"runtime"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/loomnetwork/go-loom"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/log"
+	"github.com/pkg/errors"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Root hash of an empty Patricia trie
+	emptyTrieRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+	// Hash of empty contract code
+	emptyCodeHash = crypto.Keccak256(nil)
+	// Patricia trie nodes & contract code are stored under vm\0<keccak256 of the value>
+	evmHashKeyLen = len(vmPrefix) + 1 + common.HashLength
+
+	evmPruneDuration metrics.Histogram
+)
+
+func init() {
+	evmPruneDuration = kitprometheus.NewSummaryFrom(
+		stdprometheus.SummaryOpts{
+			Namespace:  "loomchain",
+			Subsystem:  "evmstore",
+			Name:       "prune_duration",
+			Help:       "How long a single EVM store pruning cycle took to execute (in seconds)",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}, []string{"error"},
+	)
+}
+
+// EvmStorePruningConfig contains the settings used to prune stale EVM state from the EVM store.
+type EvmStorePruningConfig struct {
+	// Number of the most recent versions of the EVM state to retain, this should match the number
+	// of versions retained by the app store.
+	MaxVersions int64
+	// Maximum number of stale keys to delete in a single batch.
+	BatchSize int
+	// How long to wait between pruning cycles.
+	Interval time.Duration
+	Logger   *loom.Logger
+}
+
+// EvmPruneStats summarizes the results of a single EVM store pruning cycle.
+type EvmPruneStats struct {
+	// Oldest version of the EVM state that was retained.
+	OldestVersion int64
+	// Number of distinct EVM roots the reachable state was marked from.
+	NumRetainedRoots int
+	// Number of trie nodes & contract code entries reachable from the retained roots.
+	NumMarkedKeys int
+	// Number of trie nodes & contract code entries deleted.
+	NumDeletedKeys int
+	// Number of stale EVM roots deleted.
+	NumDeletedRoots int
+}
+
+func (s *EvmPruneStats) String() string {
+	return fmt.Sprintf(
+		"oldest version %d, %d retained roots, %d reachable keys, deleted %d keys & %d roots",
+		s.OldestVersion, s.NumRetainedRoots, s.NumMarkedKeys, s.NumDeletedKeys, s.NumDeletedRoots,
+	)
+}
+
+// PruneEvmDatabase deletes all the Patricia trie nodes & contract code in the given evm.db that
+// aren't reachable from the EVM roots of the last maxVersions versions (up to & including
+// latestVersion). The DB must not be in use while it's being pruned.
+func PruneEvmDatabase(evmDB db.DBWrapper, latestVersion, maxVersions int64, batchSize int) (*EvmPruneStats, error) {
+	pruner := &evmStatePruner{
+		evmDB:     evmDB,
+		batchSize: batchSize,
+	}
+	snapshot := evmDB.GetSnapshot()
+	defer snapshot.Release()
+	return pruner.prune(snapshot, latestVersion, maxVersions)
+}
+
+// EnablePruning starts a goroutine that periodically deletes EVM state that's no longer reachable
+// from the EVM roots of the retained versions. Stale state is located via mark & sweep, so each
+// pruning cycle needs enough memory to hold the hashes of all the trie nodes in the retained
+// versions of the EVM state.
+func (s *EvmStore) EnablePruning(cfg EvmStorePruningConfig) error {
+	if cfg.MaxVersions < 2 {
+		return errors.New("EVM store pruning requires at least two retained versions")
+	}
+	if cfg.BatchSize <= 0 {
+		return errors.New("EVM store pruning batch size must be greater than zero")
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = log.Default
+	}
+	pruner := &evmStatePruner{
+		evmDB:     s.evmDB,
+		store:     s,
+		batchSize: cfg.BatchSize,
+	}
+	go func() {
+		for {
+			time.Sleep(cfg.Interval)
+			stats, err := pruner.pruneStore(cfg.MaxVersions)
+			if err != nil {
+				logger.Error("Failed to prune EVM store", "err", err)
+			} else {
+				logger.Info("Pruned EVM store", "stats", stats.String())
+			}
+		}
+	}()
+	return nil
+}
+
+// beginPruning starts tracking the keys written by Commit, and returns the current version along
+// with a snapshot of evm.db that reflects the state of the store at that version.
+func (s *EvmStore) beginPruning() (int64, db.Snapshot) {
+	s.pruneMutex.Lock()
+	defer s.pruneMutex.Unlock()
+
+	s.prunePreserved = make(map[string]struct{})
+	return s.version, s.evmDB.GetSnapshot()
+}
+
+func (s *EvmStore) endPruning() {
+	s.pruneMutex.Lock()
+	defer s.pruneMutex.Unlock()
+
+	s.prunePreserved = nil
+}
+
+// evmStatePruner implements mark & sweep garbage collection of EVM state.
+type evmStatePruner struct {
+	evmDB db.DBWrapper
+	// store is nil when pruning offline, otherwise sweeping is synchronized with EvmStore.Commit
+	// so that keys written after the mark phase started are never deleted.
+	store     *EvmStore
+	batchSize int
+}
+
+func (p *evmStatePruner) pruneStore(maxVersions int64) (*EvmPruneStats, error) {
+	version, snapshot := p.store.beginPruning()
+	defer p.store.endPruning()
+	defer snapshot.Release()
+	return p.prune(snapshot, version, maxVersions)
+}
+
+func (p *evmStatePruner) prune(
+	snapshot db.Snapshot, latestVersion, maxVersions int64,
+) (stats *EvmPruneStats, err error) {
+	defer func(begin time.Time) {
+		lvs := []string{"error", fmt.Sprint(err != nil)}
+		evmPruneDuration.With(lvs...).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	if maxVersions < 1 {
+		return nil, errors.New("at least one version must be retained")
+	}
+	stats = &EvmPruneStats{
+		OldestVersion: latestVersion - maxVersions + 1,
+	}
+	if stats.OldestVersion < 1 {
+		stats.OldestVersion = 1
+	}
+
+	roots, staleRootKeys := findRetainedEvmRoots(snapshot, stats.OldestVersion)
+	stats.NumRetainedRoots = len(roots)
+	if len(roots) == 0 {
+		return stats, nil
+	}
+
+	marker := newEvmStateMarker(snapshot)
+	for _, root := range roots {
+		if err := marker.markStateTrie(root); err != nil {
+			// The retained state is incomplete, sweeping would just make matters worse.
+			return nil, errors.Wrapf(err, "failed to mark state reachable from EVM root %x", root)
+		}
+	}
+	stats.NumMarkedKeys = len(marker.marked)
+
+	stats.NumDeletedRoots = p.deleteKeys(staleRootKeys)
+	stats.NumDeletedKeys = p.sweep(snapshot, marker.marked)
+	return stats, nil
+}
+
+// sweep deletes all the trie nodes & contract code in the snapshot that haven't been marked.
+func (p *evmStatePruner) sweep(snapshot db.Snapshot, marked map[string]struct{}) int {
+	prefix := util.PrefixKey(vmPrefix, nil)
+	it := snapshot.NewIterator(prefix, prefixRangeEnd(prefix))
+	defer it.Close()
+
+	numDeleted := 0
+	stale := make([][]byte, 0, p.batchSize)
+	for ; it.Valid(); it.Next() {
+		key := it.Key()
+		if len(key) != evmHashKeyLen {
+			continue
+		}
+		hash := key[len(prefix):]
+		if _, ok := marked[string(hash)]; ok {
+			continue
+		}
+		// Only delete content-addressed values, anything else that happens to have a key of the
+		// same length doesn't belong to the EVM state.
+		if !bytes.Equal(crypto.Keccak256(it.Value()), hash) {
+			continue
+		}
+		stale = append(stale, append([]byte{}, key...))
+		if len(stale) >= p.batchSize {
+			numDeleted += p.deleteKeys(stale)
+			stale = stale[:0]
+			// give other goroutines a chance to access the DB between batches
+			runtime.Gosched()
+		}
+	}
+	return numDeleted + p.deleteKeys(stale)
+}
+
+// deleteKeys deletes the given keys from evm.db in batches, skipping any keys that have been
+// written by EvmStore.Commit since pruning began.
+func (p *evmStatePruner) deleteKeys(keys [][]byte) int {
+	numDeleted := 0
+	for len(keys) > 0 {
+		n := p.batchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		numDeleted += p.deleteBatch(keys[:n])
+		keys = keys[n:]
+	}
+	return numDeleted
+}
+
+func (p *evmStatePruner) deleteBatch(keys [][]byte) int {
+	if p.store != nil {
+		p.store.pruneMutex.Lock()
+		defer p.store.pruneMutex.Unlock()
+	}
+	numDeleted := 0
+	batch := p.evmDB.NewBatch()
+	for _, key := range keys {
+		if p.store != nil {
+			if _, ok := p.store.prunePreserved[string(key)]; ok {
+				continue
+			}
+		}
+		batch.Delete(key)
+		numDeleted++
+	}
+	batch.Write()
+	return numDeleted
+}
+
+// findRetainedEvmRoots returns the distinct EVM roots of all the versions starting at the oldest
+// version, and the keys of the roots of any older versions. Roots are only saved when they change,
+// so the state of the oldest version is the state of the last root saved at or before it.
+func findRetainedEvmRoots(snapshot db.Snapshot, oldestVersion int64) ([]common.Hash, [][]byte) {
+	prefix := util.PrefixKey(vmPrefix, evmRootPrefix)
+	it := snapshot.NewIterator(prefix, prefixRangeEnd(prefix))
+	defer it.Close()
+
+	var roots []common.Hash
+	var staleKeys [][]byte
+	var baseKey []byte
+	var baseRoot []byte
+	seen := map[common.Hash]bool{}
+	for ; it.Valid(); it.Next() {
+		version, err := getVersionFromEvmRootKey(it.Key())
+		if err != nil {
+			continue
+		}
+		if version <= oldestVersion {
+			if baseKey != nil {
+				staleKeys = append(staleKeys, baseKey)
+			}
+			baseKey = append([]byte{}, it.Key()...)
+			baseRoot = append([]byte{}, it.Value()...)
+			continue
+		}
+		if len(it.Value()) == common.HashLength {
+			root := common.BytesToHash(it.Value())
+			if !seen[root] {
+				seen[root] = true
+				roots = append(roots, root)
+			}
+		}
+	}
+	if len(baseRoot) == common.HashLength {
+		root := common.BytesToHash(baseRoot)
+		if !seen[root] {
+			roots = append(roots, root)
+		}
+	}
+	return roots, staleKeys
+}
+
+// evmStateMarker marks all the trie nodes & contract code reachable from EVM roots.
+type evmStateMarker struct {
+	snapshot EvmStateReader
+	// hashes of all the trie nodes & contract code reachable from the roots marked so far
+	marked map[string]struct{}
+}
+
+func newEvmStateMarker(snapshot EvmStateReader) *evmStateMarker {
+	return &evmStateMarker{
+		snapshot: snapshot,
+		marked:   make(map[string]struct{}),
+	}
+}
+
+// evmAccount is the EVM account state stored in the leaves of the state trie.
+type evmAccount struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash
+	CodeHash []byte
+}
+
+func (m *evmStateMarker) markStateTrie(root common.Hash) error {
+	return m.markNode(root[:], func(value []byte) error {
+		var account evmAccount
+		if err := rlp.DecodeBytes(value, &account); err != nil {
+			return errors.Wrap(err, "failed to decode account")
+		}
+		if account.Root != emptyTrieRoot {
+			if err := m.markNode(account.Root[:], nil); err != nil {
+				return errors.Wrapf(err, "failed to mark storage trie %x", account.Root)
+			}
+		}
+		if !bytes.Equal(account.CodeHash, emptyCodeHash) {
+			m.marked[string(account.CodeHash)] = struct{}{}
+		}
+		return nil
+	})
+}
+
+// markNode marks the trie node with the given hash, along with all its descendants. Subtries that
+// have already been marked are skipped, since all their descendants must've been marked too.
+func (m *evmStateMarker) markNode(hash []byte, onLeaf func(value []byte) error) error {
+	if _, ok := m.marked[string(hash)]; ok {
+		return nil
+	}
+	enc := m.snapshot.Get(util.PrefixKey(vmPrefix, hash))
+	if enc == nil {
+		return errors.Errorf("missing trie node %x", hash)
+	}
+	if err := m.walkNode(enc, onLeaf); err != nil {
+		return errors.Wrapf(err, "invalid trie node %x", hash)
+	}
+	// Only mark the node once all its descendants have been marked, otherwise a failure to mark
+	// a descendant could result in a partially marked subtrie being skipped later on.
+	m.marked[string(hash)] = struct{}{}
+	return nil
+}
+
+// walkNode decodes an RLP encoded trie node, and marks its children.
+func (m *evmStateMarker) walkNode(enc []byte, onLeaf func(value []byte) error) error {
+	elems, _, err := rlp.SplitList(enc)
+	if err != nil {
+		return err
+	}
+	numElems, err := rlp.CountValues(elems)
+	if err != nil {
+		return err
+	}
+	switch numElems {
+	case 2: // short node
+		key, rest, err := rlp.SplitString(elems)
+		if err != nil {
+			return err
+		}
+		// the hex-prefix encoded key of a leaf node has the terminator flag set
+		if len(key) > 0 && key[0]&0x20 != 0 {
+			value, _, err := rlp.SplitString(rest)
+			if err != nil {
+				return err
+			}
+			if onLeaf != nil {
+				return onLeaf(value)
+			}
+			return nil
+		}
+		return m.walkChild(rest, onLeaf)
+	case 17: // full node, the 17th element is the value, which isn't used by state tries
+		for i := 0; i < 16; i++ {
+			_, _, rest, err := rlp.Split(elems)
+			if err != nil {
+				return err
+			}
+			if err := m.walkChild(elems[:len(elems)-len(rest)], onLeaf); err != nil {
+				return err
+			}
+			elems = rest
+		}
+		return nil
+	default:
+		return errors.Errorf("unexpected number of elements %d", numElems)
+	}
+}
+
+// walkChild marks the child referenced by the given RLP encoded reference, which is either the
+// hash of the child, the child itself if its encoding is shorter than a hash, or empty.
+func (m *evmStateMarker) walkChild(ref []byte, onLeaf func(value []byte) error) error {
+	kind, content, _, err := rlp.Split(ref)
+	if err != nil {
+		return err
+	}
+	switch {
+	case kind == rlp.List:
+		return m.walkNode(ref, onLeaf)
+	case len(content) == common.HashLength:
+		return m.markNode(content, onLeaf)
+	case len(content) == 0:
+		return nil
+	default:
+		return errors.Errorf("invalid child reference %x", content)
+	}
+}
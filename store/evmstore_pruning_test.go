@@ -0,0 +1,161 @@
+package store
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	pruneTestAddr1 = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	pruneTestAddr2 = common.HexToAddress("0x2000000000000000000000000000000000000002")
+	pruneTestAddr3 = common.HexToAddress("0x3000000000000000000000000000000000000003")
+	pruneTestAddr4 = common.HexToAddress("0x4000000000000000000000000000000000000004")
+	pruneTestCode  = []byte{0x60, 0x01, 0x60, 0x02}
+)
+
+// evmPruneTestState writes EVM state generated by go-ethereum to an EvmStore.
+type evmPruneTestState struct {
+	t        *testing.T
+	memDB    *ethdb.MemDatabase
+	sdb      *state.StateDB
+	evmStore *EvmStore
+	roots    []common.Hash
+}
+
+func newEvmPruneTestState(t *testing.T, evmStore *EvmStore) *evmPruneTestState {
+	memDB := ethdb.NewMemDatabase()
+	sdb, err := state.New(common.Hash{}, state.NewDatabase(memDB))
+	require.NoError(t, err)
+	return &evmPruneTestState{t: t, memDB: memDB, sdb: sdb, evmStore: evmStore}
+}
+
+func (s *evmPruneTestState) commit(version int64) {
+	s.write(s.evmStore)
+	s.evmStore.Commit(version)
+}
+
+// write commits the current EVM state, and writes it to the given store.
+func (s *evmPruneTestState) write(kvStore KVWriter) common.Hash {
+	root, err := s.sdb.Commit(true)
+	require.NoError(s.t, err)
+	require.NoError(s.t, s.sdb.Database().TrieDB().Commit(root, false))
+	for _, key := range s.memDB.Keys() {
+		value, err := s.memDB.Get(key)
+		require.NoError(s.t, err)
+		kvStore.Set(util.PrefixKey(vmPrefix, key), value)
+	}
+	kvStore.Set(rootHashKey, root[:])
+	s.roots = append(s.roots, root)
+	return root
+}
+
+// populate saves 3 versions of EVM state, the 2nd version deletes an account that was created in
+// the 1st version.
+func (s *evmPruneTestState) populate() {
+	for i := int64(1); i <= 5; i++ {
+		s.sdb.SetState(pruneTestAddr1, common.BigToHash(big.NewInt(i)), common.BigToHash(big.NewInt(i)))
+		s.sdb.SetState(pruneTestAddr2, common.BigToHash(big.NewInt(i)), common.BigToHash(big.NewInt(i*10)))
+	}
+	s.sdb.SetCode(pruneTestAddr1, pruneTestCode)
+	s.sdb.SetCode(pruneTestAddr2, append(pruneTestCode, 0x00))
+	s.sdb.SetNonce(pruneTestAddr3, 1)
+	s.commit(1)
+
+	s.sdb.SetState(pruneTestAddr1, common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(100)))
+	s.sdb.Suicide(pruneTestAddr2)
+	s.sdb.SetNonce(pruneTestAddr4, 1)
+	s.commit(2)
+
+	s.sdb.SetBalance(pruneTestAddr3, big.NewInt(5))
+	s.commit(3)
+}
+
+func markEvmState(evmDB db.DBWrapper, root common.Hash) error {
+	snapshot := evmDB.GetSnapshot()
+	defer snapshot.Release()
+	return newEvmStateMarker(snapshot).markStateTrie(root)
+}
+
+func TestPruneEvmDatabase(t *testing.T) {
+	evmDB, err := db.LoadMemDB()
+	require.NoError(t, err)
+	evmStore := NewEvmStore(evmDB, 100)
+	s := newEvmPruneTestState(t, evmStore)
+	s.populate()
+
+	deletedCodeKey := util.PrefixKey(vmPrefix, crypto.Keccak256(append(pruneTestCode, 0x00)))
+	retainedCodeKey := util.PrefixKey(vmPrefix, crypto.Keccak256(pruneTestCode))
+	// a key that looks like a trie node, but its value doesn't match its hash
+	unknownKey := util.PrefixKey(vmPrefix, crypto.Keccak256([]byte("unknown")))
+	evmDB.Set(unknownKey, []byte("value"))
+	require.True(t, evmDB.Has(deletedCodeKey))
+
+	for _, root := range s.roots {
+		require.NoError(t, markEvmState(evmDB, root))
+	}
+
+	stats, err := PruneEvmDatabase(evmDB, 3, 2, 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), stats.OldestVersion)
+	require.Equal(t, 2, stats.NumRetainedRoots)
+	require.Equal(t, 1, stats.NumDeletedRoots)
+	require.True(t, stats.NumDeletedKeys > 0)
+
+	// the state of the retained versions should still be intact
+	require.NoError(t, markEvmState(evmDB, s.roots[1]))
+	require.NoError(t, markEvmState(evmDB, s.roots[2]))
+	require.Error(t, markEvmState(evmDB, s.roots[0]))
+	require.False(t, evmDB.Has(evmRootKey(1)))
+	require.True(t, evmDB.Has(evmRootKey(2)))
+	require.False(t, evmDB.Has(deletedCodeKey))
+	require.True(t, evmDB.Has(retainedCodeKey))
+	require.True(t, evmDB.Has(unknownKey))
+
+	require.NoError(t, evmStore.LoadVersion(2))
+	root, _ := evmStore.Version()
+	require.Equal(t, s.roots[1][:], root)
+
+	// nothing left to prune
+	stats, err = PruneEvmDatabase(evmDB, 3, 2, 2)
+	require.NoError(t, err)
+	require.Equal(t, 0, stats.NumDeletedKeys)
+	require.Equal(t, 0, stats.NumDeletedRoots)
+}
+
+func TestPruneEvmStoreWhileCommitting(t *testing.T) {
+	evmDB, err := db.LoadMemDB()
+	require.NoError(t, err)
+	evmStore := NewEvmStore(evmDB, 100)
+	s := newEvmPruneTestState(t, evmStore)
+	s.populate()
+
+	deletedCodeKey := util.PrefixKey(vmPrefix, crypto.Keccak256(append(pruneTestCode, 0x00)))
+	deletedCode := evmDB.Get(deletedCodeKey)
+
+	pruner := &evmStatePruner{evmDB: evmDB, store: evmStore, batchSize: 100}
+	version, snapshot := evmStore.beginPruning()
+	defer snapshot.Release()
+	require.Equal(t, int64(3), version)
+
+	// stale code that's written again after the pruning cycle began shouldn't be deleted
+	evmStore.Set(deletedCodeKey, deletedCode)
+	evmStore.Commit(4)
+
+	stats, err := pruner.prune(snapshot, version, 2)
+	evmStore.endPruning()
+	require.NoError(t, err)
+	require.True(t, stats.NumDeletedKeys > 0)
+	require.True(t, evmDB.Has(deletedCodeKey))
+	require.NoError(t, markEvmState(evmDB, s.roots[2]))
+	require.Error(t, markEvmState(evmDB, s.roots[0]))
+
+	require.Error(t, evmStore.EnablePruning(EvmStorePruningConfig{MaxVersions: 1, BatchSize: 100}))
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/metrics"
@@ -12,6 +13,7 @@ import (
 	"github.com/pkg/errors"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/tendermint/iavl"
+	"github.com/tendermint/tendermint/crypto/merkle"
 	dbm "github.com/tendermint/tendermint/libs/db"
 )
 
@@ -48,6 +50,13 @@ type IAVLStore struct {
 	tree          *iavl.MutableTree
 	maxVersions   int64 // maximum number of versions to keep when pruning
 	flushInterval int64 // how often we persist to disk
+
+	// versionMutex guards the tree versions while snapshots of older versions are being created,
+	// pinned versions are being read by snapshots so they mustn't be deleted until the snapshots
+	// are released, and the deletion of any pinned version that should've been pruned is deferred.
+	versionMutex   sync.Mutex
+	pinnedVersions map[int64]int
+	deferredPrunes []int64
 }
 
 func (s *IAVLStore) Delete(key []byte) {
@@ -121,6 +130,12 @@ func (s *IAVLStore) Range(prefix []byte) plugin.RangeData {
 	return ret
 }
 
+// NewIterator returns an iterator over the keys with the given prefix in the working tree, the keys
+// are loaded from the tree in small batches.
+func (s *IAVLStore) NewIterator(prefix []byte, opts RangeOptions) Iterator {
+	return newIAVLIterator(s.tree.ImmutableTree, prefix, opts)
+}
+
 func (s *IAVLStore) Hash() []byte {
 	return s.tree.Hash()
 }
@@ -135,6 +150,9 @@ func (s *IAVLStore) SaveVersion() ([]byte, int64, error) {
 		iavlSaveVersionDuration.Observe(time.Since(begin).Seconds())
 	}(time.Now())
 
+	s.versionMutex.Lock()
+	defer s.versionMutex.Unlock()
+
 	oldVersion := s.Version()
 
 	var version int64
@@ -175,9 +193,22 @@ func (s *IAVLStore) Prune() error {
 		pruneTime.With(lvs...).Observe(time.Since(begin).Seconds())
 	}(time.Now())
 
-	if s.tree.VersionExists(oldVer) {
-		if err = s.tree.DeleteVersion(oldVer); err != nil {
-			return errors.Wrapf(err, "failed to delete tree version %d", oldVer)
+	s.versionMutex.Lock()
+	defer s.versionMutex.Unlock()
+
+	// Versions that couldn't be deleted previously because they were pinned are retried first.
+	// NOTE: Deferred versions are forgotten if the node stops before they're deleted.
+	versions := append(s.deferredPrunes, oldVer)
+	s.deferredPrunes = nil
+	for _, ver := range versions {
+		if s.pinnedVersions[ver] > 0 {
+			s.deferredPrunes = append(s.deferredPrunes, ver)
+			continue
+		}
+		if s.tree.VersionExists(ver) {
+			if err = s.tree.DeleteVersion(ver); err != nil {
+				return errors.Wrapf(err, "failed to delete tree version %d", ver)
+			}
 		}
 	}
 	return nil
@@ -190,6 +221,74 @@ func (s *IAVLStore) GetSnapshot() Snapshot {
 	}
 }
 
+// GetSnapshotAt returns a read-only snapshot of the given tree version, the version must not have
+// been pruned. If version is zero the snapshot will be of the latest saved version. The version
+// won't be pruned until the snapshot is released.
+func (s *IAVLStore) GetSnapshotAt(version int64) (Snapshot, error) {
+	tree, err := s.pinImmutableTree(version)
+	if err != nil {
+		return nil, err
+	}
+	return &immutableTreeSnapshot{tree: tree, store: s}, nil
+}
+
+// GetWithProof returns the value of the given key at the given tree version, along with an IAVL
+// existence or absence proof that can be verified against the root hash of that tree version.
+func (s *IAVLStore) GetWithProof(key []byte, version int64) ([]byte, *merkle.Proof, error) {
+	tree, err := s.pinImmutableTree(version)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer s.unpinVersion(tree.Version())
+	return getIAVLProof(tree, key)
+}
+
+// pinImmutableTree loads the given tree version, and pins it so it won't be pruned until
+// unpinVersion is called. If version is zero the latest saved version is loaded.
+func (s *IAVLStore) pinImmutableTree(version int64) (*iavl.ImmutableTree, error) {
+	s.versionMutex.Lock()
+	defer s.versionMutex.Unlock()
+
+	if version == 0 {
+		version = s.Version()
+	}
+	if version == 0 {
+		return iavl.NewImmutableTree(nil, 0), nil
+	}
+	if !s.tree.VersionExists(version) {
+		return nil, fmt.Errorf("tree version %d doesn't exist", version)
+	}
+	tree, err := s.tree.GetImmutable(version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load immutable tree for version %v", version)
+	}
+	if s.pinnedVersions == nil {
+		s.pinnedVersions = map[int64]int{}
+	}
+	s.pinnedVersions[version]++
+	return tree, nil
+}
+
+// unpinVersion releases a tree version pinned by pinImmutableTree.
+func (s *IAVLStore) unpinVersion(version int64) {
+	s.versionMutex.Lock()
+	defer s.versionMutex.Unlock()
+
+	if s.pinnedVersions[version] > 1 {
+		s.pinnedVersions[version]--
+	} else {
+		delete(s.pinnedVersions, version)
+	}
+}
+
+// isVersionPinned checks if the given tree version is being read by a snapshot.
+func (s *IAVLStore) isVersionPinned(version int64) bool {
+	s.versionMutex.Lock()
+	defer s.versionMutex.Unlock()
+
+	return s.pinnedVersions[version] > 0
+}
+
 // NewIAVLStore creates a new IAVLStore.
 // maxVersions can be used to specify how many versions should be retained, if set to zero then
 // old versions will never been deleted.
@@ -221,3 +320,80 @@ type iavlStoreSnapshot struct {
 func (s *iavlStoreSnapshot) Release() {
 	// noop
 }
+
+// immutableTreeSnapshot is a read-only snapshot of a previously saved IAVL tree version, the
+// version is pinned in the store until the snapshot is released.
+type immutableTreeSnapshot struct {
+	tree  *iavl.ImmutableTree
+	store *IAVLStore
+}
+
+func (s *immutableTreeSnapshot) Has(key []byte) bool {
+	return s.tree.Has(key)
+}
+
+func (s *immutableTreeSnapshot) Get(key []byte) []byte {
+	_, val := s.tree.Get(key)
+	return val
+}
+
+func (s *immutableTreeSnapshot) Range(prefix []byte) plugin.RangeData {
+	return rangeImmutableTree(s.tree, prefix)
+}
+
+func (s *immutableTreeSnapshot) NewIterator(prefix []byte, opts RangeOptions) Iterator {
+	return newIAVLIterator(s.tree, prefix, opts)
+}
+
+func (s *immutableTreeSnapshot) Release() {
+	if s.tree != nil {
+		s.store.unpinVersion(s.tree.Version())
+		s.tree = nil
+	}
+}
+
+// rangeImmutableTree returns all the keys (and values) in the given tree that have the given prefix,
+// the prefix is stripped from the returned keys.
+func rangeImmutableTree(tree *iavl.ImmutableTree, prefix []byte) plugin.RangeData {
+	ret := make(plugin.RangeData, 0)
+
+	keys, values, _, err := tree.GetRangeWithProof(prefix, prefixRangeEnd(prefix), 0)
+	if err != nil {
+		log.Error("failed to get range", "prefix", string(prefix), "err", err)
+		return ret
+	}
+
+	for i, k := range keys {
+		// Tree range gives all keys that has prefix but it does not check zero byte
+		// after the prefix. So we have to check zero byte after prefix using util.HasPrefix
+		if util.HasPrefix(k, prefix) {
+			k, err = util.UnprefixKey(k, prefix)
+			if err != nil {
+				panic(err)
+			}
+		} else { // Skip this key as it does not have the prefix
+			continue
+		}
+
+		ret = append(ret, &plugin.RangeEntry{
+			Key:   k,
+			Value: values[i],
+		})
+	}
+	return ret
+}
+
+func newIAVLIterator(tree *iavl.ImmutableTree, prefix []byte, opts RangeOptions) Iterator {
+	start, end := rangeBounds(prefix, opts)
+	it := newBatchIterator(start, end, opts.Reverse,
+		func(start, end []byte, reverse bool, limit int) (keys, values [][]byte) {
+			tree.IterateRange(start, end, !reverse, func(key, value []byte) bool {
+				keys = append(keys, key)
+				values = append(values, value)
+				return len(keys) >= limit
+			})
+			return keys, values
+		},
+	)
+	return withLimit(newPrefixedIterator(it, prefix), opts.Limit)
+}
@@ -0,0 +1,422 @@
+package store
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/loomnetwork/go-loom/plugin"
+	"github.com/loomnetwork/go-loom/util"
+)
+
+// Number of keys loaded into memory at a time by iterators that can't stream keys directly from
+// the underlying store.
+const iteratorBatchSize = 100
+
+// RangeOptions specifies the subset of keys with a particular prefix that an iterator should return.
+type RangeOptions struct {
+	// Start is the first key (inclusive) in the range, it shouldn't include the prefix.
+	// If nil the range starts with the first key that has the prefix.
+	Start []byte
+	// End is the last key (exclusive) in the range, it shouldn't include the prefix.
+	// If nil the range ends with the last key that has the prefix.
+	End []byte
+	// Reverse iterates from the end of the range to the start.
+	Reverse bool
+	// Limit is the maximum number of keys the iterator will return, zero means no limit.
+	Limit int
+}
+
+// Iterator iterates over a range of keys in a store. An iterator shouldn't be used after the store
+// it was created from is modified, and must be closed when it's no longer needed.
+type Iterator interface {
+	Valid() bool
+	Next()
+	// Key returns the current key, the prefix the iterator was created with is stripped from the key.
+	Key() []byte
+	Value() []byte
+	Close()
+}
+
+// IterableKVReader is implemented by readers that can stream a range of keys, rather than loading
+// all the keys in the range into memory like KVReader.Range does.
+type IterableKVReader interface {
+	KVReader
+	// NewIterator returns an iterator over the keys with the given prefix, the keys are returned in
+	// the same form as KVReader.Range returns them. If the prefix is empty the iterator ranges over
+	// all the keys in the store.
+	NewIterator(prefix []byte, opts RangeOptions) Iterator
+}
+
+// NewIterator returns an iterator over the keys with the given prefix in the given reader. If the
+// reader doesn't implement IterableKVReader the iterator falls back to loading all the keys with
+// the given prefix into memory via KVReader.Range.
+func NewIterator(reader KVReader, prefix []byte, opts RangeOptions) Iterator {
+	if r, ok := reader.(IterableKVReader); ok {
+		return r.NewIterator(prefix, opts)
+	}
+	var entries plugin.RangeData
+	for _, entry := range reader.Range(prefix) {
+		if inRange(entry.Key, opts.Start, opts.End) {
+			entries = append(entries, entry)
+		}
+	}
+	return withLimit(newSliceIterator(entries, opts.Reverse), opts.Limit)
+}
+
+// RangeWithOptions returns the keys with the given prefix in the given reader that match the given
+// options, the prefix is stripped from the returned keys.
+func RangeWithOptions(reader KVReader, prefix []byte, opts RangeOptions) plugin.RangeData {
+	it := NewIterator(reader, prefix, opts)
+	defer it.Close()
+
+	ret := make(plugin.RangeData, 0)
+	for ; it.Valid(); it.Next() {
+		ret = append(ret, &plugin.RangeEntry{
+			Key:   it.Key(),
+			Value: it.Value(),
+		})
+	}
+	return ret
+}
+
+// rangeBounds returns the full keys that bound the range of keys with the given prefix.
+func rangeBounds(prefix []byte, opts RangeOptions) (start, end []byte) {
+	if len(prefix) == 0 {
+		return opts.Start, opts.End
+	}
+	start = util.PrefixKey(prefix, opts.Start)
+	if opts.End != nil {
+		end = util.PrefixKey(prefix, opts.End)
+	} else {
+		end = prefixRangeEnd(util.PrefixKey(prefix, nil))
+	}
+	return start, end
+}
+
+// inRange checks if the given key is in the range [start, end), nil bounds are ignored.
+func inRange(key, start, end []byte) bool {
+	return (start == nil || bytes.Compare(key, start) >= 0) && (end == nil || bytes.Compare(key, end) < 0)
+}
+
+// stripPrefix removes the given prefix (and the separator that follows it) from a key.
+func stripPrefix(key, prefix []byte) []byte {
+	if len(prefix) == 0 {
+		return key
+	}
+	return key[len(prefix)+1:]
+}
+
+// prefixedIterator strips the given prefix from the keys returned by the underlying iterator.
+type prefixedIterator struct {
+	Iterator
+	prefix []byte
+}
+
+func newPrefixedIterator(it Iterator, prefix []byte) Iterator {
+	if len(prefix) == 0 {
+		return it
+	}
+	return &prefixedIterator{Iterator: it, prefix: prefix}
+}
+
+func (it *prefixedIterator) Key() []byte {
+	return stripPrefix(it.Iterator.Key(), it.prefix)
+}
+
+// limitIterator stops the underlying iterator after the given number of keys.
+type limitIterator struct {
+	Iterator
+	remaining int
+}
+
+func withLimit(it Iterator, limit int) Iterator {
+	if limit <= 0 {
+		return it
+	}
+	return &limitIterator{Iterator: it, remaining: limit}
+}
+
+func (it *limitIterator) Valid() bool {
+	return it.remaining > 0 && it.Iterator.Valid()
+}
+
+func (it *limitIterator) Next() {
+	it.remaining--
+	if it.remaining > 0 {
+		it.Iterator.Next()
+	}
+}
+
+// sliceIterator iterates over entries that have already been loaded into memory.
+type sliceIterator struct {
+	entries plugin.RangeData
+	pos     int
+}
+
+// newSliceIterator sorts the given entries (in place) and returns an iterator over them.
+func newSliceIterator(entries plugin.RangeData, reverse bool) *sliceIterator {
+	sort.Slice(entries, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(entries[i].Key, entries[j].Key) > 0
+		}
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+	return &sliceIterator{entries: entries}
+}
+
+func (it *sliceIterator) Valid() bool {
+	return it.pos < len(it.entries)
+}
+
+func (it *sliceIterator) Next() {
+	it.pos++
+}
+
+func (it *sliceIterator) Key() []byte {
+	return it.entries[it.pos].Key
+}
+
+func (it *sliceIterator) Value() []byte {
+	return it.entries[it.pos].Value
+}
+
+func (it *sliceIterator) Close() {
+	it.entries = nil
+}
+
+// batchLoader loads up to limit keys (and values) from the range [start, end) in ascending or
+// descending order.
+type batchLoader func(start, end []byte, reverse bool, limit int) (keys, values [][]byte)
+
+// batchIterator iterates over a range of keys by loading a batch of keys into memory at a time.
+type batchIterator struct {
+	start, end []byte
+	reverse    bool
+	load       batchLoader
+	keys       [][]byte
+	values     [][]byte
+	pos        int
+	done       bool
+}
+
+func newBatchIterator(start, end []byte, reverse bool, load batchLoader) *batchIterator {
+	it := &batchIterator{
+		start:   start,
+		end:     end,
+		reverse: reverse,
+		load:    load,
+	}
+	it.loadBatch()
+	return it
+}
+
+func (it *batchIterator) loadBatch() {
+	it.keys, it.values = it.load(it.start, it.end, it.reverse, iteratorBatchSize)
+	it.pos = 0
+	if len(it.keys) < iteratorBatchSize {
+		it.done = true
+		return
+	}
+	lastKey := it.keys[len(it.keys)-1]
+	if it.reverse {
+		it.end = lastKey
+	} else {
+		// the smallest key that follows the last key
+		it.start = append(append(make([]byte, 0, len(lastKey)+1), lastKey...), 0)
+	}
+}
+
+func (it *batchIterator) Valid() bool {
+	return it.pos < len(it.keys)
+}
+
+func (it *batchIterator) Next() {
+	it.pos++
+	if it.pos >= len(it.keys) && !it.done {
+		it.loadBatch()
+	}
+}
+
+func (it *batchIterator) Key() []byte {
+	return it.keys[it.pos]
+}
+
+func (it *batchIterator) Value() []byte {
+	return it.values[it.pos]
+}
+
+func (it *batchIterator) Close() {
+	it.keys = nil
+	it.values = nil
+	it.done = true
+}
+
+// pendingWrite is an uncommitted set or delete of a key.
+type pendingWrite struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+func sortPendingWrites(writes []pendingWrite, reverse bool) {
+	sort.Slice(writes, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(writes[i].key, writes[j].key) > 0
+		}
+		return bytes.Compare(writes[i].key, writes[j].key) < 0
+	})
+}
+
+// mergeIterator merges pending writes with the keys returned by the underlying iterator, pending
+// writes take precedence over the underlying keys, and pending deletes hide them.
+type mergeIterator struct {
+	parent  Iterator
+	pending []pendingWrite // sorted in the same order as the keys returned by the parent iterator
+	reverse bool
+	key     []byte
+	value   []byte
+	valid   bool
+}
+
+func newMergeIterator(parent Iterator, pending []pendingWrite, reverse bool) *mergeIterator {
+	sortPendingWrites(pending, reverse)
+	it := &mergeIterator{
+		parent:  parent,
+		pending: pending,
+		reverse: reverse,
+	}
+	it.Next()
+	return it
+}
+
+func (it *mergeIterator) Valid() bool {
+	return it.valid
+}
+
+func (it *mergeIterator) Next() {
+	for {
+		parentValid := it.parent.Valid()
+		if !parentValid && len(it.pending) == 0 {
+			it.valid = false
+			return
+		}
+		var cmp int
+		if !parentValid {
+			cmp = 1
+		} else if len(it.pending) == 0 {
+			cmp = -1
+		} else {
+			cmp = bytes.Compare(it.parent.Key(), it.pending[0].key)
+			if it.reverse {
+				cmp = -cmp
+			}
+		}
+
+		if cmp < 0 {
+			it.key, it.value, it.valid = it.parent.Key(), it.parent.Value(), true
+			it.parent.Next()
+			return
+		}
+		write := it.pending[0]
+		it.pending = it.pending[1:]
+		if cmp == 0 {
+			it.parent.Next()
+		}
+		if !write.deleted {
+			it.key, it.value, it.valid = write.key, write.value, true
+			return
+		}
+	}
+}
+
+func (it *mergeIterator) Key() []byte {
+	return it.key
+}
+
+func (it *mergeIterator) Value() []byte {
+	return it.value
+}
+
+func (it *mergeIterator) Close() {
+	it.parent.Close()
+	it.pending = nil
+	it.valid = false
+}
+
+// unionIterator merges two iterators that don't return any of the same keys.
+type unionIterator struct {
+	a, b    Iterator
+	reverse bool
+	cur     Iterator
+}
+
+func newUnionIterator(a, b Iterator, reverse bool) *unionIterator {
+	it := &unionIterator{a: a, b: b, reverse: reverse}
+	it.selectNext()
+	return it
+}
+
+func (it *unionIterator) selectNext() {
+	if !it.a.Valid() {
+		it.cur = it.b
+		return
+	}
+	if !it.b.Valid() {
+		it.cur = it.a
+		return
+	}
+	cmp := bytes.Compare(it.a.Key(), it.b.Key())
+	if it.reverse {
+		cmp = -cmp
+	}
+	if cmp <= 0 {
+		it.cur = it.a
+	} else {
+		it.cur = it.b
+	}
+}
+
+func (it *unionIterator) Valid() bool {
+	return it.cur.Valid()
+}
+
+func (it *unionIterator) Next() {
+	it.cur.Next()
+	it.selectNext()
+}
+
+func (it *unionIterator) Key() []byte {
+	return it.cur.Key()
+}
+
+func (it *unionIterator) Value() []byte {
+	return it.cur.Value()
+}
+
+func (it *unionIterator) Close() {
+	it.a.Close()
+	it.b.Close()
+}
+
+// filterIterator skips the keys returned by the underlying iterator that match the given filter.
+type filterIterator struct {
+	Iterator
+	skip func(key []byte) bool
+}
+
+func newFilterIterator(it Iterator, skip func(key []byte) bool) *filterIterator {
+	f := &filterIterator{Iterator: it, skip: skip}
+	f.skipFiltered()
+	return f
+}
+
+func (it *filterIterator) skipFiltered() {
+	for it.Iterator.Valid() && it.skip(it.Iterator.Key()) {
+		it.Iterator.Next()
+	}
+}
+
+func (it *filterIterator) Next() {
+	it.Iterator.Next()
+	it.skipFiltered()
+}
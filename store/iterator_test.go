@@ -0,0 +1,175 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/stretchr/testify/require"
+)
+
+var iteratorTestPrefix = []byte("it")
+
+func iteratorTestKey(i int) []byte {
+	return []byte(fmt.Sprintf("k%03d", i))
+}
+
+// populateIteratorTestStore writes more keys than fit into a single iterator batch, along with
+// a few keys that share part of the prefix and shouldn't be returned by the iterator.
+func populateIteratorTestStore(s KVWriter, numKeys int) {
+	for i := 0; i < numKeys; i++ {
+		s.Set(util.PrefixKey(iteratorTestPrefix, iteratorTestKey(i)), []byte(fmt.Sprintf("v%d", i)))
+	}
+	s.Set([]byte("i"), []byte("x"))
+	s.Set(util.PrefixKey([]byte("it2"), iteratorTestKey(0)), []byte("x"))
+	s.Set(append(append([]byte{}, iteratorTestPrefix...), 1), []byte("x"))
+}
+
+func iterateKeys(it Iterator) []string {
+	defer it.Close()
+	keys := []string{}
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	return keys
+}
+
+func expectedIteratorKeys(from, to int, reverse bool) []string {
+	keys := []string{}
+	for i := from; i < to; i++ {
+		keys = append(keys, string(iteratorTestKey(i)))
+	}
+	if reverse {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	return keys
+}
+
+func (ts *StoreTestSuite) VerifyIterator(s KVReader, numKeys int) {
+	require := ts.Require()
+	prefix := iteratorTestPrefix
+
+	keys := iterateKeys(NewIterator(s, prefix, RangeOptions{}))
+	require.Equal(expectedIteratorKeys(0, numKeys, false), keys, ts.StoreName)
+
+	keys = iterateKeys(NewIterator(s, prefix, RangeOptions{Reverse: true}))
+	require.Equal(expectedIteratorKeys(0, numKeys, true), keys, ts.StoreName)
+
+	opts := RangeOptions{Start: iteratorTestKey(50), End: iteratorTestKey(160)}
+	keys = iterateKeys(NewIterator(s, prefix, opts))
+	require.Equal(expectedIteratorKeys(50, 160, false), keys, ts.StoreName)
+
+	opts.Reverse = true
+	keys = iterateKeys(NewIterator(s, prefix, opts))
+	require.Equal(expectedIteratorKeys(50, 160, true), keys, ts.StoreName)
+
+	opts.Limit = 3
+	keys = iterateKeys(NewIterator(s, prefix, opts))
+	require.Equal(expectedIteratorKeys(157, 160, true), keys, ts.StoreName)
+
+	it := NewIterator(s, prefix, RangeOptions{Start: iteratorTestKey(7), Limit: 1})
+	require.True(it.Valid())
+	require.Equal([]byte("v7"), it.Value())
+	it.Close()
+}
+
+func (ts *StoreTestSuite) TestStoreIterator() {
+	require := ts.Require()
+	numKeys := iteratorBatchSize*2 + 50
+	populateIteratorTestStore(ts.store, numKeys)
+	ts.VerifyIterator(ts.store, numKeys)
+	_, _, err := ts.store.SaveVersion()
+	require.NoError(err)
+	ts.VerifyIterator(ts.store, numKeys)
+}
+
+func TestEvmStoreIterator(t *testing.T) {
+	evmDB, err := db.LoadMemDB()
+	require.NoError(t, err)
+	evmStore := NewEvmStore(evmDB, 100)
+	prefix := []byte("vm")
+	for i := 0; i < 10; i++ {
+		evmStore.Set(util.PrefixKey(prefix, iteratorTestKey(i)), []byte("1"))
+	}
+	evmStore.Commit(1)
+	evmStore.Delete(util.PrefixKey(prefix, iteratorTestKey(3)))
+	evmStore.Set(util.PrefixKey(prefix, iteratorTestKey(4)), []byte("2"))
+	evmStore.Set(util.PrefixKey(prefix, iteratorTestKey(10)), []byte("2"))
+
+	expected := []string{"k000", "k001", "k002", "k004", "k005", "k006", "k007", "k008", "k009", "k010"}
+	require.Equal(t, expected, iterateKeys(evmStore.NewIterator(prefix, RangeOptions{})))
+	keys := iterateKeys(evmStore.NewIterator(prefix, RangeOptions{Reverse: true, Limit: 8}))
+	require.Equal(t, []string{"k010", "k009", "k008", "k007", "k006", "k005", "k004", "k002"}, keys)
+
+	it := evmStore.NewIterator(prefix, RangeOptions{Start: iteratorTestKey(4)})
+	require.Equal(t, []byte("2"), it.Value())
+	it.Close()
+}
+
+func TestCacheTxIteratorMergesPendingWrites(t *testing.T) {
+	s := NewMemStore()
+	prefix := iteratorTestPrefix
+	for i := 0; i < 10; i++ {
+		s.Set(util.PrefixKey(prefix, iteratorTestKey(i)), []byte("1"))
+	}
+	tx := newCacheTx(s)
+	tx.Delete(util.PrefixKey(prefix, iteratorTestKey(0)))
+	tx.Delete(util.PrefixKey(prefix, iteratorTestKey(5)))
+	tx.Set(util.PrefixKey(prefix, iteratorTestKey(5)), []byte("2"))
+	tx.Set(util.PrefixKey(prefix, iteratorTestKey(6)), []byte("2"))
+	tx.Delete(util.PrefixKey(prefix, iteratorTestKey(9)))
+	tx.Set(util.PrefixKey(prefix, iteratorTestKey(11)), []byte("2"))
+	tx.Delete(util.PrefixKey(prefix, iteratorTestKey(12)))
+	tx.Set(util.PrefixKey([]byte("other"), iteratorTestKey(1)), []byte("2"))
+
+	expected := []string{"k001", "k002", "k003", "k004", "k005", "k006", "k007", "k008", "k011"}
+	require.Equal(t, expected, iterateKeys(tx.NewIterator(prefix, RangeOptions{})))
+
+	keys := iterateKeys(tx.NewIterator(prefix, RangeOptions{Reverse: true, Limit: 4}))
+	require.Equal(t, []string{"k011", "k008", "k007", "k006"}, keys)
+
+	opts := RangeOptions{Start: iteratorTestKey(4), End: iteratorTestKey(7)}
+	entries := RangeWithOptions(tx, prefix, opts)
+	require.Len(t, entries, 3)
+	require.Equal(t, []byte("1"), entries[0].Value)
+	require.Equal(t, []byte("2"), entries[1].Value)
+	require.Equal(t, []byte("2"), entries[2].Value)
+
+	// pending writes shouldn't leak into the underlying store
+	require.Len(t, iterateKeys(s.NewIterator(prefix, RangeOptions{})), 10)
+}
+
+func TestPrefixStoreIterator(t *testing.T) {
+	s := NewMemStore()
+	populateIteratorTestStore(s, 20)
+	prefixStore := PrefixKVStore(iteratorTestPrefix, s)
+
+	keys := iterateKeys(NewIterator(prefixStore, nil, RangeOptions{Start: iteratorTestKey(15)}))
+	require.Equal(t, expectedIteratorKeys(15, 20, false), keys)
+
+	s.Set(util.PrefixKey(iteratorTestPrefix, []byte("sub"), []byte("a")), []byte("1"))
+	s.Set(util.PrefixKey(iteratorTestPrefix, []byte("sub"), []byte("b")), []byte("2"))
+	keys = iterateKeys(NewIterator(prefixStore, []byte("sub"), RangeOptions{Reverse: true}))
+	require.Equal(t, []string{"b", "a"}, keys)
+}
+
+// rangeOnlyReader hides the iterator implemented by the underlying reader.
+type rangeOnlyReader struct {
+	KVReader
+}
+
+func TestNewIteratorFallsBackToRange(t *testing.T) {
+	memDB, _ := db.LoadMemDB()
+	s, err := NewIAVLStore(memDB, 0, 0, 0)
+	require.NoError(t, err)
+	populateIteratorTestStore(s, 20)
+
+	keys := iterateKeys(NewIterator(rangeOnlyReader{s}, iteratorTestPrefix, RangeOptions{}))
+	require.Equal(t, expectedIteratorKeys(0, 20, false), keys)
+	opts := RangeOptions{Start: iteratorTestKey(5), End: iteratorTestKey(15), Reverse: true, Limit: 2}
+	keys = iterateKeys(NewIterator(rangeOnlyReader{s}, iteratorTestPrefix, opts))
+	require.Equal(t, expectedIteratorKeys(13, 15, true), keys)
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/loomnetwork/go-loom/plugin"
+	"github.com/tendermint/tendermint/crypto/merkle"
 )
 
 type LogParams struct {
@@ -90,6 +91,10 @@ func (s *LogStore) Range(prefix []byte) plugin.RangeData {
 	return val
 }
 
+func (s *LogStore) NewIterator(prefix []byte, opts RangeOptions) Iterator {
+	return NewIterator(s.store, prefix, opts)
+}
+
 func (s *LogStore) Get(key []byte) []byte {
 	val := s.store.Get(key)
 	if s.params.LogGet {
@@ -129,3 +134,11 @@ func (s *LogStore) Prune() error {
 func (s *LogStore) GetSnapshot() Snapshot {
 	return s.store.GetSnapshot()
 }
+
+func (s *LogStore) GetSnapshotAt(version int64) (Snapshot, error) {
+	return s.store.GetSnapshotAt(version)
+}
+
+func (s *LogStore) GetWithProof(key []byte, version int64) ([]byte, *merkle.Proof, error) {
+	return getWithProof(s.store, key, version)
+}
@@ -38,6 +38,21 @@ func (m *MemStore) Range(prefix []byte) plugin.RangeData {
 	return ret
 }
 
+// NewIterator returns an iterator over a copy of the keys with the given prefix.
+func (m *MemStore) NewIterator(prefix []byte, opts RangeOptions) Iterator {
+	start, end := rangeBounds(prefix, opts)
+	entries := make(plugin.RangeData, 0)
+	for key, value := range m.store {
+		if inRange([]byte(key), start, end) {
+			entries = append(entries, &plugin.RangeEntry{
+				Key:   stripPrefix([]byte(key), prefix),
+				Value: value,
+			})
+		}
+	}
+	return withLimit(newSliceIterator(entries, opts.Reverse), opts.Limit)
+}
+
 // Get returns nil iff key doesn't exist. Panics on nil key.
 func (m *MemStore) Get(key []byte) []byte {
 	return m.store[string(key)]
@@ -79,3 +94,7 @@ func (m *MemStore) Prune() error {
 func (m *MemStore) GetSnapshot() Snapshot {
 	panic("not implemented")
 }
+
+func (m *MemStore) GetSnapshotAt(version int64) (Snapshot, error) {
+	panic("not implemented")
+}
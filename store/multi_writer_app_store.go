@@ -12,10 +12,10 @@ import (
 	"github.com/loomnetwork/go-loom/plugin"
 	"github.com/loomnetwork/go-loom/util"
 	"github.com/loomnetwork/loomchain/db"
-	"github.com/loomnetwork/loomchain/log"
 	"github.com/pkg/errors"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/tendermint/iavl"
+	"github.com/tendermint/tendermint/crypto/merkle"
 )
 
 var (
@@ -64,6 +64,7 @@ type MultiWriterAppStore struct {
 	evmStore                   *EvmStore
 	lastSavedTree              unsafe.Pointer // *iavl.ImmutableTree
 	onlySaveEvmStateToEvmStore bool
+	snapshotExporter           *SnapshotExporter
 }
 
 // NewMultiWriterAppStore creates a new NewMultiWriterAppStore.
@@ -148,6 +149,51 @@ func (s *MultiWriterAppStore) Range(prefix []byte) plugin.RangeData {
 	return s.appStore.Range(prefix)
 }
 
+// NewIterator iterates over the keys in the store prefixed by the given prefix.
+func (s *MultiWriterAppStore) NewIterator(prefix []byte, opts RangeOptions) Iterator {
+	if len(prefix) == 0 {
+		return newMultiWriterIterator(s.appStore.NewIterator, s.evmStore.NewIterator, opts)
+	}
+
+	if bytes.Equal(prefix, vmPrefix) || util.HasPrefix(prefix, vmPrefix) {
+		return s.evmStore.NewIterator(prefix, opts)
+	}
+	return s.appStore.NewIterator(prefix, opts)
+}
+
+// newMultiWriterIterator returns an iterator over all the keys in a multi-writer store, the EVM
+// state keys come from the EVM store and the rest from the IAVL store. Any EVM state keys that are
+// still in the IAVL store are skipped since reads of those keys always go to the EVM store.
+func newMultiWriterIterator(
+	newAppIterator, newEvmIterator func(prefix []byte, opts RangeOptions) Iterator, opts RangeOptions,
+) Iterator {
+	appOpts := opts
+	appOpts.Limit = 0
+	appIter := newFilterIterator(newAppIterator(nil, appOpts), func(key []byte) bool {
+		return util.HasPrefix(key, vmPrefix)
+	})
+
+	// Restrict the EVM store iterator to the EVM state keys within the requested range
+	evmOpts := RangeOptions{
+		Start:   util.PrefixKey(vmPrefix, nil),
+		End:     prefixRangeEnd(util.PrefixKey(vmPrefix, nil)),
+		Reverse: opts.Reverse,
+	}
+	if opts.Start != nil && bytes.Compare(opts.Start, evmOpts.Start) > 0 {
+		evmOpts.Start = opts.Start
+	}
+	if opts.End != nil && bytes.Compare(opts.End, evmOpts.End) < 0 {
+		evmOpts.End = opts.End
+	}
+	var evmIter Iterator
+	if bytes.Compare(evmOpts.Start, evmOpts.End) < 0 {
+		evmIter = newEvmIterator(nil, evmOpts)
+	} else {
+		evmIter = newSliceIterator(nil, opts.Reverse)
+	}
+	return withLimit(newUnionIterator(appIter, evmIter, opts.Reverse), opts.Limit)
+}
+
 func (s *MultiWriterAppStore) Hash() []byte {
 	return s.appStore.Hash()
 }
@@ -180,9 +226,17 @@ func (s *MultiWriterAppStore) SaveVersion() ([]byte, int64, error) {
 	}
 	hash, version, err := s.appStore.SaveVersion()
 	s.setLastSavedTreeToVersion(version)
+	if err == nil && s.snapshotExporter != nil && s.snapshotExporter.shouldExport(version) {
+		s.snapshotExporter.export(version, s.evmStore.GetSnapshot(version))
+	}
 	return hash, version, err
 }
 
+// EnableSnapshots enables periodic export of app store snapshots by the given exporter.
+func (s *MultiWriterAppStore) EnableSnapshots(exporter *SnapshotExporter) {
+	s.snapshotExporter = exporter
+}
+
 func (s *MultiWriterAppStore) setLastSavedTreeToVersion(version int64) error {
 	var err error
 	var tree *iavl.ImmutableTree
@@ -213,9 +267,40 @@ func (s *MultiWriterAppStore) GetSnapshot() Snapshot {
 	return newMultiWriterStoreSnapshot(evmDbSnapshot, appStoreTree)
 }
 
+// GetSnapshotAt returns a read-only snapshot of the app store & the EVM state at the given version.
+// The version must not have been pruned from the app store, if version is zero the snapshot will be
+// of the latest saved version. The version won't be pruned from the app store until the snapshot is
+// released.
+func (s *MultiWriterAppStore) GetSnapshotAt(version int64) (Snapshot, error) {
+	if version == 0 {
+		return s.GetSnapshot(), nil
+	}
+	appStoreTree, err := s.appStore.pinImmutableTree(version)
+	if err != nil {
+		return nil, err
+	}
+	evmDbSnapshot := s.evmStore.GetSnapshot(appStoreTree.Version())
+	snap := newMultiWriterStoreSnapshot(evmDbSnapshot, appStoreTree)
+	snap.appStore = s.appStore
+	return snap, nil
+}
+
+// GetWithProof returns the value of the given key at the given version, along with a proof that can
+// be verified against the app hash of that version. Proofs are only available for keys stored in
+// the IAVL tree, the EVM state is tied to the app hash via the Patricia root stored under the
+// vmroot key, so EVM state proofs have to be obtained from the Patricia tree instead.
+func (s *MultiWriterAppStore) GetWithProof(key []byte, version int64) ([]byte, *merkle.Proof, error) {
+	if util.HasPrefix(key, vmPrefix) {
+		return nil, nil, errors.New("EVM state keys can't be proven against the app hash directly")
+	}
+	return s.appStore.GetWithProof(key, version)
+}
+
 type multiWriterStoreSnapshot struct {
 	evmDbSnapshot db.Snapshot
 	appStoreTree  *iavl.ImmutableTree
+	// set if the app store tree version is pinned, and must be unpinned on release
+	appStore *IAVLStore
 }
 
 func newMultiWriterStoreSnapshot(evmDbSnapshot db.Snapshot, appStoreTree *iavl.ImmutableTree) *multiWriterStoreSnapshot {
@@ -227,6 +312,9 @@ func newMultiWriterStoreSnapshot(evmDbSnapshot db.Snapshot, appStoreTree *iavl.I
 
 func (s *multiWriterStoreSnapshot) Release() {
 	s.evmDbSnapshot.Release()
+	if s.appStore != nil && s.appStoreTree != nil {
+		s.appStore.unpinVersion(s.appStoreTree.Version())
+	}
 	s.appStoreTree = nil
 }
 
@@ -276,29 +364,40 @@ func (s *multiWriterStoreSnapshot) Range(prefix []byte) plugin.RangeData {
 	}
 
 	// Otherwise iterate over the IAVL tree
-	keys, values, _, err := s.appStoreTree.GetRangeWithProof(prefix, prefixRangeEnd(prefix), 0)
-	if err != nil {
-		log.Error("failed to get range", "prefix", string(prefix), "err", err)
-		return ret
-	}
+	return rangeImmutableTree(s.appStoreTree, prefix)
+}
 
-	for i, k := range keys {
-		// Tree range gives all keys that has prefix but it does not check zero byte
-		// after the prefix. So we have to check zero byte after prefix using util.HasPrefix
-		if util.HasPrefix(k, prefix) {
-			k, err = util.UnprefixKey(k, prefix)
-			if err != nil {
-				panic(err)
-			}
-		} else { // Skip this key as it does not have the prefix
-			continue
-		}
+// NewIterator iterates over the keys in the snapshot prefixed by the given prefix.
+func (s *multiWriterStoreSnapshot) NewIterator(prefix []byte, opts RangeOptions) Iterator {
+	if len(prefix) == 0 {
+		return newMultiWriterIterator(s.newAppStoreIterator, s.newEvmIterator, opts)
+	}
 
-		ret = append(ret, &plugin.RangeEntry{
-			Key:   k,
-			Value: values[i],
-		})
+	if bytes.Equal(prefix, vmPrefix) || util.HasPrefix(prefix, vmPrefix) {
+		return s.newEvmIterator(prefix, opts)
 	}
+	return s.newAppStoreIterator(prefix, opts)
+}
 
-	return ret
+func (s *multiWriterStoreSnapshot) newAppStoreIterator(prefix []byte, opts RangeOptions) Iterator {
+	return newIAVLIterator(s.appStoreTree, prefix, opts)
+}
+
+func (s *multiWriterStoreSnapshot) newEvmIterator(prefix []byte, opts RangeOptions) Iterator {
+	start, end := rangeBounds(prefix, opts)
+	var it Iterator = s.evmDbSnapshot.NewIterator(start, end)
+	// evm.db snapshots can only be iterated in ascending order, so reverse iteration requires the
+	// whole range to be loaded into memory.
+	if opts.Reverse {
+		entries := make(plugin.RangeData, 0)
+		for ; it.Valid(); it.Next() {
+			entries = append(entries, &plugin.RangeEntry{
+				Key:   append([]byte{}, it.Key()...),
+				Value: append([]byte{}, it.Value()...),
+			})
+		}
+		it.Close()
+		it = newSliceIterator(entries, true)
+	}
+	return withLimit(newPrefixedIterator(it, prefix), opts.Limit)
 }
@@ -251,6 +251,122 @@ func (m *MultiWriterAppStoreTestSuite) TestMultiWriterAppStoreSaveVersion() {
 	require.False(store.Has(vmPrefixKey("gg")))
 }
 
+func (m *MultiWriterAppStoreTestSuite) TestMultiWriterAppStoreSnapShotAtVersion() {
+	require := m.Require()
+	store, err := mockMultiWriterStore(10)
+	require.NoError(err)
+
+	store.Set(evmDBFeatureKey, []byte{1})
+	store.Set(vmPrefixKey("abcd"), []byte("hello"))
+	store.Set(rootHashKey, []byte("root1"))
+	store.Set([]byte("abcd"), []byte("data1"))
+	_, version, err := store.SaveVersion()
+	require.NoError(err)
+	require.Equal(int64(1), version)
+
+	store.Set(rootHashKey, []byte("root2"))
+	store.Set([]byte("abcd"), []byte("data2"))
+	store.Set([]byte("dcba"), []byte("data2"))
+	_, version, err = store.SaveVersion()
+	require.NoError(err)
+	require.Equal(int64(2), version)
+
+	snapshotv1, err := store.GetSnapshotAt(1)
+	require.NoError(err)
+	defer snapshotv1.Release()
+	require.Equal([]byte("data1"), snapshotv1.Get([]byte("abcd")))
+	require.False(snapshotv1.Has([]byte("dcba")))
+	require.Equal([]byte("root1"), snapshotv1.Get(rootHashKey))
+	require.Equal([]byte("hello"), snapshotv1.Get(vmPrefixKey("abcd")))
+
+	snapshotv2, err := store.GetSnapshotAt(0)
+	require.NoError(err)
+	defer snapshotv2.Release()
+	require.Equal([]byte("data2"), snapshotv2.Get([]byte("abcd")))
+	require.Equal([]byte("data2"), snapshotv2.Get([]byte("dcba")))
+	require.Equal([]byte("root2"), snapshotv2.Get(rootHashKey))
+
+	_, err = store.GetSnapshotAt(3)
+	require.Error(err)
+}
+
+func (m *MultiWriterAppStoreTestSuite) TestMultiWriterAppStoreIteratorWithoutPrefix() {
+	require := m.Require()
+	store, err := mockMultiWriterStore(10)
+	require.NoError(err)
+
+	store.Set([]byte("abcd"), []byte("1"))
+	store.Set([]byte("a"), []byte("2"))
+	store.Set([]byte("zz"), []byte("3"))
+	store.Set(vmPrefixKey("abcd"), []byte("4"))
+	store.Set(vmPrefixKey("dd"), []byte("5"))
+	// EVM state left over in the IAVL store should be ignored
+	store.appStore.Set(vmPrefixKey("stale"), []byte("6"))
+
+	expected := []string{"a", "abcd", string(vmPrefixKey("abcd")), string(vmPrefixKey("dd")), "zz"}
+	require.Equal(expected, iterateKeys(store.NewIterator(nil, RangeOptions{})))
+	keys := iterateKeys(store.NewIterator(nil, RangeOptions{Reverse: true, Limit: 3}))
+	require.Equal([]string{"zz", string(vmPrefixKey("dd")), string(vmPrefixKey("abcd"))}, keys)
+	opts := RangeOptions{Start: []byte("abcd"), End: vmPrefixKey("b")}
+	require.Equal(expected[1:3], iterateKeys(store.NewIterator(nil, opts)))
+	opts = RangeOptions{Start: []byte("b"), End: []byte("c")}
+	require.Empty(iterateKeys(store.NewIterator(nil, opts)))
+
+	_, _, err = store.SaveVersion()
+	require.NoError(err)
+	store.Set(vmPrefixKey("ee"), []byte("7"))
+
+	snapshot := store.GetSnapshot()
+	defer snapshot.Release()
+	expected = []string{
+		"a", "abcd", string(vmPrefixKey("abcd")), string(vmPrefixKey("dd")), string(evmRootKey(1)),
+		string(rootKey), "zz",
+	}
+	require.Equal(expected, iterateKeys(NewIterator(snapshot, nil, RangeOptions{})))
+	keys = iterateKeys(NewIterator(snapshot, nil, RangeOptions{Reverse: true}))
+	require.Len(keys, len(expected))
+	for i, key := range keys {
+		require.Equal(expected[len(expected)-1-i], key)
+	}
+	keys = iterateKeys(NewIterator(snapshot, vmPrefix, RangeOptions{Reverse: true, Limit: 2}))
+	require.Equal([]string{string(evmRootKey(1)[len(vmPrefix)+1:]), "dd"}, keys)
+}
+
+func (m *MultiWriterAppStoreTestSuite) TestMultiWriterAppStoreSnapshotAtVersionIsNotPruned() {
+	require := m.Require()
+	memDb, _ := db.LoadMemDB()
+	iavlStore, err := NewIAVLStore(memDb, 2, 0, 0)
+	require.NoError(err)
+	memDb, _ = db.LoadMemDB()
+	store, err := NewMultiWriterAppStore(iavlStore, NewEvmStore(memDb, 100), false)
+	require.NoError(err)
+
+	saveVersion := func(val string) {
+		store.Set([]byte("abcd"), []byte(val))
+		_, _, err := store.SaveVersion()
+		require.NoError(err)
+		require.NoError(store.Prune())
+	}
+	saveVersion("data1")
+	saveVersion("data2")
+
+	snapshotv1, err := store.GetSnapshotAt(1)
+	require.NoError(err)
+	// version 1 should've been pruned by now, but the snapshot is still reading it
+	saveVersion("data3")
+	saveVersion("data4")
+	require.Equal([]byte("data1"), snapshotv1.Get([]byte("abcd")))
+	require.True(iavlStore.tree.VersionExists(1))
+	require.False(iavlStore.tree.VersionExists(2))
+
+	// once the snapshot is released the version is pruned
+	snapshotv1.Release()
+	saveVersion("data5")
+	require.False(iavlStore.tree.VersionExists(1))
+	_, err = store.GetSnapshotAt(1)
+	require.Error(err)
+}
+
 func mockMultiWriterStore(flushInterval int64) (*MultiWriterAppStore, error) {
 	memDb, _ := db.LoadMemDB()
 	iavlStore, err := NewIAVLStore(memDb, 0, 0, flushInterval)
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+	"github.com/tendermint/iavl"
+	"github.com/tendermint/tendermint/crypto/merkle"
+)
+
+// ProvableStore is implemented by stores that can produce Merkle proofs for the keys they contain.
+type ProvableStore interface {
+	// GetWithProof returns the value of the given key at the given version, along with a proof of
+	// existence (or absence if the key doesn't exist) that can be verified against the hash of the
+	// store at that version. If version is zero the latest saved version is used.
+	GetWithProof(key []byte, version int64) ([]byte, *merkle.Proof, error)
+}
+
+// getWithProof obtains a proof from the given store if it's provable.
+func getWithProof(s VersionedKVStore, key []byte, version int64) ([]byte, *merkle.Proof, error) {
+	ps, ok := s.(ProvableStore)
+	if !ok {
+		return nil, nil, errors.New("store doesn't support proofs")
+	}
+	return ps.GetWithProof(key, version)
+}
+
+// getIAVLProof returns the value of the given key in the given tree, along with an IAVL existence
+// proof if the key exists, or an IAVL absence proof if it doesn't.
+func getIAVLProof(tree *iavl.ImmutableTree, key []byte) ([]byte, *merkle.Proof, error) {
+	value, rangeProof, err := tree.GetWithProof(key)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get proof for key %x", key)
+	}
+	var op merkle.ProofOp
+	if value != nil {
+		op = iavl.NewIAVLValueOp(key, rangeProof).ProofOp()
+	} else {
+		op = iavl.NewIAVLAbsenceOp(key, rangeProof).ProofOp()
+	}
+	return value, &merkle.Proof{Ops: []merkle.ProofOp{op}}, nil
+}
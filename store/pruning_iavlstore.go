@@ -13,6 +13,7 @@ import (
 	"github.com/loomnetwork/loomchain/log"
 	"github.com/pkg/errors"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/tendermint/tendermint/crypto/merkle"
 	dbm "github.com/tendermint/tendermint/libs/db"
 )
 
@@ -147,6 +148,18 @@ func (s *PruningIAVLStore) Range(prefix []byte) plugin.RangeData {
 	return s.store.Range(prefix)
 }
 
+// NewIterator returns an iterator over the keys with the given prefix in the working tree, the
+// store is locked while the iterator loads each batch of keys.
+func (s *PruningIAVLStore) NewIterator(prefix []byte, opts RangeOptions) Iterator {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return &pruningIAVLStoreIterator{
+		Iterator: s.store.NewIterator(prefix, opts),
+		mutex:    s.mutex,
+	}
+}
+
 func (s *PruningIAVLStore) Hash() []byte {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -184,6 +197,22 @@ func (s *PruningIAVLStore) GetSnapshot() Snapshot {
 	}
 }
 
+// GetSnapshotAt returns a read-only snapshot of the given version, the version won't be pruned until
+// the snapshot is released.
+func (s *PruningIAVLStore) GetSnapshotAt(version int64) (Snapshot, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.store.GetSnapshotAt(version)
+}
+
+func (s *PruningIAVLStore) GetWithProof(key []byte, version int64) ([]byte, *merkle.Proof, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.store.GetWithProof(key, version)
+}
+
 func (s *PruningIAVLStore) prune() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -210,6 +239,10 @@ func (s *PruningIAVLStore) prune() error {
 	}
 
 	for i := s.oldestVer; i <= endVer; i++ {
+		// versions that are being read by snapshots are deleted in a later cycle
+		if s.store.isVersionPinned(i) {
+			break
+		}
 		if s.store.tree.VersionExists(i) {
 			if err = s.deleteVersion(i); err != nil {
 				return errors.Wrapf(err, "failed to delete tree version %d", i)
@@ -262,6 +295,20 @@ func (s *PruningIAVLStore) loopWithInterval(step func() error, interval time.Dur
 	}
 }
 
+// pruningIAVLStoreIterator locks the store while the underlying iterator loads the next batch of
+// keys, which may happen on any call to Next.
+type pruningIAVLStoreIterator struct {
+	Iterator
+	mutex *sync.RWMutex
+}
+
+func (it *pruningIAVLStoreIterator) Next() {
+	it.mutex.RLock()
+	defer it.mutex.RUnlock()
+
+	it.Iterator.Next()
+}
+
 type pruningIAVLStoreSnapshot struct {
 	*PruningIAVLStore
 }
@@ -0,0 +1,532 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/log"
+	"github.com/pkg/errors"
+	dbm "github.com/tendermint/tendermint/libs/db"
+)
+
+const (
+	snapshotManifestFile = "manifest.json"
+
+	// Each snapshot chunk entry is tagged with the DB it belongs to.
+	snapshotEntryAppDB byte = 1
+	snapshotEntryEvmDB byte = 2
+)
+
+var (
+	// Keys used by the IAVL node DB to store the tree nodes & the root node hash of each version.
+	iavlNodeKeyPrefix = []byte("n")
+	iavlRootKeyPrefix = []byte("r")
+)
+
+func iavlRootKey(version int64) []byte {
+	key := make([]byte, 9)
+	key[0] = iavlRootKeyPrefix[0]
+	binary.BigEndian.PutUint64(key[1:], uint64(version))
+	return key
+}
+
+// SnapshotConfig controls how often app store snapshots are exported.
+type SnapshotConfig struct {
+	// Number of blocks between snapshots, if zero snapshots won't be exported.
+	Interval int64
+	// Directory snapshots are written to, each snapshot is stored in a sub-directory named after
+	// the height of the snapshot.
+	Dir string
+	// Number of most recent snapshots to keep, older snapshots are deleted.
+	NumToKeep int
+	// Max number of DB entries per chunk.
+	ChunkSize int
+}
+
+// SnapshotChunk describes a single chunk of a snapshot.
+type SnapshotChunk struct {
+	// SHA256 hash of the chunk file.
+	Hash       []byte `json:"hash"`
+	NumEntries int    `json:"numEntries"`
+}
+
+// SnapshotManifest describes a snapshot of the app store, the manifest is written after all the
+// chunks of the snapshot, so a snapshot without a manifest is incomplete.
+type SnapshotManifest struct {
+	Height int64 `json:"height"`
+	// Root hash of the IAVL tree at Height, which should match the app hash in the block header
+	// at Height + 1.
+	AppHash []byte `json:"appHash"`
+	// Root of the EVM Patricia tree at Height.
+	EvmRoot []byte          `json:"evmRoot"`
+	Chunks  []SnapshotChunk `json:"chunks"`
+}
+
+func snapshotChunkFile(index int) string {
+	return fmt.Sprintf("chunk-%06d.bin", index)
+}
+
+// ReadSnapshotManifest loads the manifest of the snapshot in the given directory.
+func ReadSnapshotManifest(dir string) (*SnapshotManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, snapshotManifestFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read snapshot manifest")
+	}
+	var manifest SnapshotManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to parse snapshot manifest")
+	}
+	return &manifest, nil
+}
+
+// snapshotWriter splits the DB entries written to it into chunks.
+type snapshotWriter struct {
+	dir       string
+	chunkSize int
+	manifest  *SnapshotManifest
+	buf       bytes.Buffer
+	count     int
+}
+
+func (w *snapshotWriter) write(kind byte, key, value []byte) error {
+	w.buf.WriteByte(kind)
+	writeSnapshotBytes(&w.buf, key)
+	writeSnapshotBytes(&w.buf, value)
+	w.count++
+	if w.count >= w.chunkSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *snapshotWriter) flush() error {
+	if w.count == 0 {
+		return nil
+	}
+	index := len(w.manifest.Chunks)
+	if err := ioutil.WriteFile(filepath.Join(w.dir, snapshotChunkFile(index)), w.buf.Bytes(), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write snapshot chunk %d", index)
+	}
+	hash := sha256.Sum256(w.buf.Bytes())
+	w.manifest.Chunks = append(w.manifest.Chunks, SnapshotChunk{
+		Hash:       hash[:],
+		NumEntries: w.count,
+	})
+	w.buf.Reset()
+	w.count = 0
+	return nil
+}
+
+func writeSnapshotBytes(buf *bytes.Buffer, b []byte) {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(b)))
+	buf.Write(size[:n])
+	buf.Write(b)
+}
+
+func readSnapshotBytes(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ExportSnapshot writes a snapshot of the app store at the given version to the given directory.
+// The snapshot contains the IAVL nodes reachable from the root of the given version (rather than
+// just the keys & values, since the IAVL node hashes depend on the versions the nodes were created
+// in), and the EVM root of the given version along with the Patricia trie nodes & contract code
+// reachable from it.
+// NOTE: Only versions that have been flushed to disk can be exported.
+func ExportSnapshot(
+	appDB db.Snapshot, evmDB db.Snapshot, version int64, dir string, chunkSize int,
+) (*SnapshotManifest, error) {
+	if chunkSize <= 0 {
+		return nil, errors.New("invalid snapshot chunk size")
+	}
+	rootKey := iavlRootKey(version)
+	if !appDB.Has(rootKey) {
+		return nil, fmt.Errorf("version %d hasn't been saved to app.db", version)
+	}
+	appHash := appDB.Get(rootKey)
+
+	tmpDir := dir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create snapshot dir")
+	}
+	w := &snapshotWriter{
+		dir:       tmpDir,
+		chunkSize: chunkSize,
+		manifest: &SnapshotManifest{
+			Height:  version,
+			AppHash: appHash,
+		},
+	}
+
+	if err := w.write(snapshotEntryAppDB, rootKey, appHash); err != nil {
+		return nil, err
+	}
+	if len(appHash) > 0 {
+		err := walkIAVLNodes(appDB, appHash, func(key, node []byte) error {
+			return w.write(snapshotEntryAppDB, key, node)
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to export app.db at version %d", version)
+		}
+	}
+
+	evmRootKey, evmRoot := lastEvmRootAt(evmDB, version)
+	if evmRootKey != nil {
+		w.manifest.EvmRoot = evmRoot
+		if err := w.write(snapshotEntryEvmDB, evmRootKey, evmRoot); err != nil {
+			return nil, err
+		}
+		hashes, err := reachableEvmState(evmDB, evmRoot)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to export evm.db at version %d", version)
+		}
+		for _, hash := range hashes {
+			key := util.PrefixKey(vmPrefix, hash)
+			value := evmDB.Get(key)
+			if value == nil {
+				return nil, errors.Errorf("contract code %x not found", hash)
+			}
+			if err := w.write(snapshotEntryEvmDB, key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	manifestBytes, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, snapshotManifestFile), manifestBytes, 0644); err != nil {
+		return nil, errors.Wrap(err, "failed to write snapshot manifest")
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return nil, errors.Wrap(err, "failed to finalize snapshot")
+	}
+	return w.manifest, nil
+}
+
+// lastEvmRootAt returns the key & value of the last EVM root saved at or before the given version,
+// EVM roots are only saved when they change.
+func lastEvmRootAt(evmDB db.Snapshot, version int64) ([]byte, []byte) {
+	var rootKey, root []byte
+	iter := evmDB.NewIterator(util.PrefixKey(vmPrefix, evmRootPrefix), prefixRangeEnd(evmRootKey(version)))
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		rootKey = append([]byte{}, iter.Key()...)
+		root = append([]byte{}, iter.Value()...)
+	}
+	return rootKey, root
+}
+
+// reachableEvmState returns the hashes of all the Patricia trie nodes & contract code reachable
+// from the given EVM root, in ascending order.
+func reachableEvmState(reader EvmStateReader, root []byte) ([][]byte, error) {
+	// The EVM root of an empty state is saved as defaultRoot
+	if bytes.Equal(root, defaultRoot) || bytes.Equal(root, emptyTrieRoot[:]) {
+		return nil, nil
+	}
+	if len(root) != common.HashLength {
+		return nil, errors.Errorf("invalid EVM root %x", root)
+	}
+	marker := newEvmStateMarker(reader)
+	if err := marker.markStateTrie(common.BytesToHash(root)); err != nil {
+		return nil, err
+	}
+	hashes := make([][]byte, 0, len(marker.marked))
+	for hash := range marker.marked {
+		hashes = append(hashes, []byte(hash))
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i], hashes[j]) < 0
+	})
+	return hashes, nil
+}
+
+// walkIAVLNodes calls fn with the DB key & encoded bytes of every node in the IAVL tree with the
+// given root hash.
+func walkIAVLNodes(appDB db.Snapshot, rootHash []byte, fn func(key, node []byte) error) error {
+	stack := [][]byte{rootHash}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		key := append(append([]byte{}, iavlNodeKeyPrefix...), hash...)
+		node := appDB.Get(key)
+		if node == nil {
+			return fmt.Errorf("IAVL node %X not found", hash)
+		}
+		if err := fn(key, node); err != nil {
+			return err
+		}
+		left, right, err := decodeIAVLNodeChildren(node)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode IAVL node %X", hash)
+		}
+		if left != nil {
+			stack = append(stack, right, left)
+		}
+	}
+	return nil
+}
+
+// decodeIAVLNodeChildren returns the hashes of the children of an amino encoded IAVL node, or
+// nil if the node is a leaf node. IAVL nodes are encoded as:
+// height (varint), size (varint), version (varint), key (bytes), and then either the value of a
+// leaf node, or the left & right child hashes of an inner node.
+func decodeIAVLNodeChildren(node []byte) ([]byte, []byte, error) {
+	r := bufio.NewReader(bytes.NewReader(node))
+	height, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := 0; i < 2; i++ { // size & version
+		if _, err := binary.ReadVarint(r); err != nil {
+			return nil, nil, err
+		}
+	}
+	if _, err := readSnapshotBytes(r); err != nil { // key
+		return nil, nil, err
+	}
+	if height == 0 {
+		return nil, nil, nil
+	}
+	left, err := readSnapshotBytes(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	right, err := readSnapshotBytes(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return left, right, nil
+}
+
+// RestoreSnapshot writes the contents of the snapshot in the given directory to the given (empty)
+// DBs. The chunks are verified against the hashes in the manifest, and the restored IAVL tree is
+// verified against the given app hash, which should be obtained from a trusted source (e.g. the
+// block store). The restored EVM state is then verified against the EVM root stored in the IAVL
+// tree.
+func RestoreSnapshot(dir string, appDB, evmDB dbm.DB, appHash []byte) (*SnapshotManifest, error) {
+	manifest, err := ReadSnapshotManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(manifest.AppHash, appHash) {
+		return nil, fmt.Errorf(
+			"snapshot app hash %X doesn't match expected app hash %X at height %d",
+			manifest.AppHash, appHash, manifest.Height,
+		)
+	}
+
+	for i, chunk := range manifest.Chunks {
+		data, err := ioutil.ReadFile(filepath.Join(dir, snapshotChunkFile(i)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read snapshot chunk %d", i)
+		}
+		hash := sha256.Sum256(data)
+		if !bytes.Equal(hash[:], chunk.Hash) {
+			return nil, fmt.Errorf("snapshot chunk %d hash mismatch", i)
+		}
+		if err := restoreSnapshotChunk(data, chunk.NumEntries, appDB, evmDB, manifest.EvmRoot); err != nil {
+			return nil, errors.Wrapf(err, "failed to restore snapshot chunk %d", i)
+		}
+	}
+
+	iavlStore, err := NewIAVLStore(appDB, 0, manifest.Height, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load restored app store")
+	}
+	if !bytes.Equal(iavlStore.Hash(), appHash) {
+		return nil, fmt.Errorf("restored app store hash %X doesn't match app hash %X", iavlStore.Hash(), appHash)
+	}
+
+	// The manifest isn't trusted, so the EVM root it contains must match the one in the IAVL tree
+	// that has just been verified, which is looked up the same way as in NewMultiWriterAppStore.
+	evmRoot := iavlStore.Get(rootKey)
+	if evmRoot == nil {
+		evmRoot = iavlStore.Get(util.PrefixKey(vmPrefix, rootKey))
+		if evmRoot == nil {
+			evmRoot = defaultRoot
+		}
+	}
+	if !bytes.Equal(evmRoot, manifest.EvmRoot) {
+		return nil, fmt.Errorf("snapshot EVM root %X doesn't match app store EVM root %X", manifest.EvmRoot, evmRoot)
+	}
+	// Every restored trie node has already been checked against its hash, so if all the nodes
+	// reachable from the root are present the EVM state is intact.
+	hashes, err := reachableEvmState(evmDB, evmRoot)
+	if err != nil {
+		return nil, errors.Wrap(err, "restored EVM state is incomplete")
+	}
+	for _, hash := range hashes {
+		if !evmDB.Has(util.PrefixKey(vmPrefix, hash)) {
+			return nil, errors.Errorf("restored EVM state is missing contract code %x", hash)
+		}
+	}
+	return manifest, nil
+}
+
+func restoreSnapshotChunk(data []byte, numEntries int, appDB, evmDB dbm.DB, evmRoot []byte) error {
+	appBatch := appDB.NewBatch()
+	evmBatch := evmDB.NewBatch()
+	r := bufio.NewReader(bytes.NewReader(data))
+	for i := 0; i < numEntries; i++ {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		key, err := readSnapshotBytes(r)
+		if err != nil {
+			return err
+		}
+		value, err := readSnapshotBytes(r)
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case snapshotEntryAppDB:
+			appBatch.Set(key, value)
+		case snapshotEntryEvmDB:
+			if err := checkEvmSnapshotEntry(key, value, evmRoot); err != nil {
+				return err
+			}
+			evmBatch.Set(key, value)
+		default:
+			return fmt.Errorf("invalid snapshot entry type %d", kind)
+		}
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return errors.New("unexpected data at the end of the chunk")
+	}
+	appBatch.WriteSync()
+	evmBatch.WriteSync()
+	return nil
+}
+
+// checkEvmSnapshotEntry checks that an evm.db entry in a snapshot is either the EVM root of the
+// snapshot, or a Patricia trie node or contract code stored under its hash.
+func checkEvmSnapshotEntry(key, value, evmRoot []byte) error {
+	if util.HasPrefix(key, util.PrefixKey(vmPrefix, evmRootPrefix)) {
+		if !bytes.Equal(value, evmRoot) {
+			return errors.Errorf("EVM root %X doesn't match snapshot EVM root %X", value, evmRoot)
+		}
+		return nil
+	}
+	if len(key) != evmHashKeyLen || !util.HasPrefix(key, vmPrefix) {
+		return errors.Errorf("unexpected evm.db key %X", key)
+	}
+	if !bytes.Equal(crypto.Keccak256(value), key[evmHashKeyLen-common.HashLength:]) {
+		return errors.Errorf("evm.db value doesn't match its hash %X", key)
+	}
+	return nil
+}
+
+// SnapshotExporter periodically exports snapshots of the MultiWriterAppStore.
+type SnapshotExporter struct {
+	appDB db.DBWrapper
+	cfg   SnapshotConfig
+	busy  int32
+}
+
+func NewSnapshotExporter(appDB db.DBWrapper, cfg SnapshotConfig) *SnapshotExporter {
+	return &SnapshotExporter{
+		appDB: appDB,
+		cfg:   cfg,
+	}
+}
+
+func (e *SnapshotExporter) shouldExport(version int64) bool {
+	return e.cfg.Interval > 0 && version%e.cfg.Interval == 0
+}
+
+// export writes out a snapshot of the given DB snapshots in the background, the DB snapshots are
+// released once the export is done.
+func (e *SnapshotExporter) export(version int64, evmDB db.Snapshot) {
+	appDB := e.appDB.GetSnapshot()
+	if !atomic.CompareAndSwapInt32(&e.busy, 0, 1) {
+		log.Error("Skipping app store snapshot, previous snapshot hasn't finished", "version", version)
+		appDB.Release()
+		evmDB.Release()
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&e.busy, 0)
+		defer appDB.Release()
+		defer evmDB.Release()
+
+		dir := filepath.Join(e.cfg.Dir, strconv.FormatInt(version, 10))
+		manifest, err := ExportSnapshot(appDB, evmDB, version, dir, e.cfg.ChunkSize)
+		if err != nil {
+			log.Error("Failed to export app store snapshot", "version", version, "err", err)
+			return
+		}
+		log.Info(
+			"Exported app store snapshot", "version", version, "chunks", len(manifest.Chunks), "dir", dir,
+		)
+		if err := pruneSnapshots(e.cfg.Dir, e.cfg.NumToKeep); err != nil {
+			log.Error("Failed to prune app store snapshots", "err", err)
+		}
+	}()
+}
+
+// pruneSnapshots deletes all but the most recent numToKeep snapshots in the given directory.
+func pruneSnapshots(dir string, numToKeep int) error {
+	if numToKeep <= 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var heights []int64
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		height, err := strconv.ParseInt(f.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] > heights[j] })
+	for i := numToKeep; i < len(heights); i++ {
+		if err := os.RemoveAll(filepath.Join(dir, strconv.FormatInt(heights[i], 10))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportRestoreSnapshot(t *testing.T) {
+	appDB, _ := db.LoadMemDB()
+	evmDB, _ := db.LoadMemDB()
+	iavlStore, err := NewIAVLStore(appDB, 0, 0, 0)
+	require.NoError(t, err)
+	evmStore := NewEvmStore(evmDB, 100)
+	appStore, err := NewMultiWriterAppStore(iavlStore, evmStore, false)
+	require.NoError(t, err)
+	evmState := newEvmPruneTestState(t, evmStore)
+
+	appStore.Set([]byte("abc"), []byte("1"))
+	appStore.Set([]byte("def"), []byte("2"))
+	evmState.sdb.SetCode(pruneTestAddr1, pruneTestCode)
+	evmState.sdb.SetCode(pruneTestAddr2, append(pruneTestCode, 0x00))
+	evmState.sdb.SetState(pruneTestAddr1, common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(1)))
+	evmState.write(appStore)
+	_, _, err = appStore.SaveVersion()
+	require.NoError(t, err)
+
+	appStore.Set([]byte("ghi"), []byte("4"))
+	appStore.Set([]byte("abc"), []byte("5"))
+	evmState.sdb.Suicide(pruneTestAddr2)
+	evmState.sdb.SetState(pruneTestAddr1, common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(2)))
+	evmRoot := evmState.write(appStore)
+	appHash, version, err := appStore.SaveVersion()
+	require.NoError(t, err)
+
+	appStore.Set([]byte("abc"), []byte("7"))
+	evmState.sdb.SetNonce(pruneTestAddr3, 1)
+	evmState.write(appStore)
+	_, _, err = appStore.SaveVersion()
+	require.NoError(t, err)
+
+	tmpDir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	snapshotDir := filepath.Join(tmpDir, "2")
+
+	manifest, err := ExportSnapshot(appDB, evmStore.GetSnapshot(version), version, snapshotDir, 3)
+	require.NoError(t, err)
+	require.Equal(t, version, manifest.Height)
+	require.Equal(t, appHash, manifest.AppHash)
+	require.Equal(t, evmRoot[:], manifest.EvmRoot)
+	require.True(t, len(manifest.Chunks) > 1)
+
+	// the snapshot must match the expected app hash
+	newAppDB, _ := db.LoadMemDB()
+	newEvmDB, _ := db.LoadMemDB()
+	_, err = RestoreSnapshot(snapshotDir, newAppDB, newEvmDB, []byte("invalid"))
+	require.Error(t, err)
+
+	_, err = RestoreSnapshot(snapshotDir, newAppDB, newEvmDB, appHash)
+	require.NoError(t, err)
+	restoredIAVLStore, err := NewIAVLStore(newAppDB, 0, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, version, restoredIAVLStore.Version())
+	require.Equal(t, appHash, restoredIAVLStore.Hash())
+	restoredEvmStore := NewEvmStore(newEvmDB, 100)
+	require.NoError(t, restoredEvmStore.LoadVersion(version))
+	restoredStore, err := NewMultiWriterAppStore(restoredIAVLStore, restoredEvmStore, false)
+	require.NoError(t, err)
+	require.Equal(t, []byte("5"), restoredStore.Get([]byte("abc")))
+	require.Equal(t, []byte("2"), restoredStore.Get([]byte("def")))
+	require.Equal(t, []byte("4"), restoredStore.Get([]byte("ghi")))
+	require.Equal(t, evmRoot[:], restoredStore.Get(rootHashKey))
+	require.NoError(t, markEvmState(newEvmDB, evmRoot))
+	// only the EVM state reachable from the EVM root of the snapshot should've been exported
+	require.Error(t, markEvmState(newEvmDB, evmState.roots[0]))
+	require.False(t, newEvmDB.Has(util.PrefixKey(vmPrefix, crypto.Keccak256(append(pruneTestCode, 0x00)))))
+	require.True(t, newEvmDB.Has(util.PrefixKey(vmPrefix, crypto.Keccak256(pruneTestCode))))
+	require.False(t, newEvmDB.Has(evmRootKey(1)))
+	require.False(t, newEvmDB.Has(evmRootKey(3)))
+	for _, entry := range readSnapshotEntries(t, snapshotDir) {
+		if entry.kind == snapshotEntryEvmDB {
+			require.NoError(t, checkEvmSnapshotEntry(entry.key, entry.value, evmRoot[:]))
+		}
+	}
+
+	// Snapshots with tampered EVM state should be rejected even if the manifest is consistent
+	tamperedDir := filepath.Join(tmpDir, "tampered")
+	restoreTampered := func(tamper func(entry *snapshotTestEntry) bool) error {
+		entries := readSnapshotEntries(t, snapshotDir)
+		tampered := make([]snapshotTestEntry, 0, len(entries))
+		evmRoot := manifest.EvmRoot
+		for _, entry := range entries {
+			if tamper(&entry) {
+				tampered = append(tampered, entry)
+				if util.HasPrefix(entry.key, util.PrefixKey(vmPrefix, evmRootPrefix)) {
+					evmRoot = entry.value
+				}
+			}
+		}
+		writeSnapshotEntries(t, tamperedDir, manifest, evmRoot, tampered)
+		appDB, _ := db.LoadMemDB()
+		evmDB, _ := db.LoadMemDB()
+		_, err := RestoreSnapshot(tamperedDir, appDB, evmDB, appHash)
+		return err
+	}
+	require.NoError(t, restoreTampered(func(entry *snapshotTestEntry) bool { return true }))
+	// a trie node that doesn't match its hash
+	codeKey := util.PrefixKey(vmPrefix, crypto.Keccak256(pruneTestCode))
+	require.Error(t, restoreTampered(func(entry *snapshotTestEntry) bool {
+		if bytes.Equal(entry.key, codeKey) {
+			entry.value = append(entry.value, 0x00)
+		}
+		return true
+	}))
+	// missing contract code
+	require.Error(t, restoreTampered(func(entry *snapshotTestEntry) bool {
+		return !bytes.Equal(entry.key, codeKey)
+	}))
+	// missing trie node
+	storageRootKey := util.PrefixKey(vmPrefix, evmState.sdb.StorageTrie(pruneTestAddr1).Hash().Bytes())
+	require.Error(t, restoreTampered(func(entry *snapshotTestEntry) bool {
+		return !bytes.Equal(entry.key, storageRootKey)
+	}))
+	// an EVM root that doesn't match the one in the app store
+	require.Error(t, restoreTampered(func(entry *snapshotTestEntry) bool {
+		if util.HasPrefix(entry.key, util.PrefixKey(vmPrefix, evmRootPrefix)) {
+			entry.value = evmState.roots[0][:]
+		}
+		return true
+	}))
+	// an entry that isn't part of the EVM state
+	require.Error(t, restoreTampered(func(entry *snapshotTestEntry) bool {
+		if util.HasPrefix(entry.key, util.PrefixKey(vmPrefix, evmRootPrefix)) {
+			entry.key = vmPrefixKey("abcd")
+		}
+		return true
+	}))
+
+	// tampered chunks should be rejected
+	chunkFile := filepath.Join(snapshotDir, snapshotChunkFile(0))
+	chunk, err := ioutil.ReadFile(chunkFile)
+	require.NoError(t, err)
+	chunk[len(chunk)-1] ^= 0xFF
+	require.NoError(t, ioutil.WriteFile(chunkFile, chunk, 0644))
+	newAppDB, _ = db.LoadMemDB()
+	newEvmDB, _ = db.LoadMemDB()
+	_, err = RestoreSnapshot(snapshotDir, newAppDB, newEvmDB, appHash)
+	require.Error(t, err)
+}
+
+type snapshotTestEntry struct {
+	kind       byte
+	key, value []byte
+}
+
+func readSnapshotEntries(t *testing.T, dir string) []snapshotTestEntry {
+	manifest, err := ReadSnapshotManifest(dir)
+	require.NoError(t, err)
+	var entries []snapshotTestEntry
+	for i, chunk := range manifest.Chunks {
+		data, err := ioutil.ReadFile(filepath.Join(dir, snapshotChunkFile(i)))
+		require.NoError(t, err)
+		r := bufio.NewReader(bytes.NewReader(data))
+		for j := 0; j < chunk.NumEntries; j++ {
+			var entry snapshotTestEntry
+			entry.kind, err = r.ReadByte()
+			require.NoError(t, err)
+			entry.key, err = readSnapshotBytes(r)
+			require.NoError(t, err)
+			entry.value, err = readSnapshotBytes(r)
+			require.NoError(t, err)
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// writeSnapshotEntries writes a snapshot with a valid manifest containing the given entries.
+func writeSnapshotEntries(
+	t *testing.T, dir string, manifest *SnapshotManifest, evmRoot []byte, entries []snapshotTestEntry,
+) {
+	require.NoError(t, os.RemoveAll(dir))
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	w := &snapshotWriter{
+		dir:       dir,
+		chunkSize: 3,
+		manifest: &SnapshotManifest{
+			Height:  manifest.Height,
+			AppHash: manifest.AppHash,
+			EvmRoot: evmRoot,
+		},
+	}
+	for _, entry := range entries {
+		require.NoError(t, w.write(entry.kind, entry.key, entry.value))
+	}
+	require.NoError(t, w.flush())
+	manifestBytes, err := json.Marshal(w.manifest)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, snapshotManifestFile), manifestBytes, 0644))
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "snapshots")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"100", "200", "300", "1000", "other"} {
+		require.NoError(t, os.Mkdir(filepath.Join(tmpDir, name), 0755))
+	}
+	require.NoError(t, pruneSnapshots(tmpDir, 2))
+
+	files, err := ioutil.ReadDir(tmpDir)
+	require.NoError(t, err)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	require.Equal(t, []string{"1000", "300", "other"}, names)
+}
@@ -54,6 +54,10 @@ type VersionedKVStore interface {
 	// Delete old version of the store
 	Prune() error
 	GetSnapshot() Snapshot
+	// GetSnapshotAt returns a read-only snapshot of the store at the given version, the version
+	// must not have been pruned from the store. If version is zero the snapshot will be of the
+	// latest saved version.
+	GetSnapshotAt(version int64) (Snapshot, error)
 }
 
 type cacheItem struct {
@@ -114,11 +118,33 @@ func (c *cacheTx) Set(key, val []byte) {
 	c.setCache(key, val, false)
 }
 
+// Range ignores any pending writes in the tx, it's been this way since day one so changing it would
+// break consensus, use NewIterator instead.
 func (c *cacheTx) Range(prefix []byte) plugin.RangeData {
-	//TODO cache ranges???
 	return c.store.Range(prefix)
 }
 
+// NewIterator returns an iterator that merges the pending sets & deletes in the tx with the keys in
+// the underlying store.
+func (c *cacheTx) NewIterator(prefix []byte, opts RangeOptions) Iterator {
+	start, end := rangeBounds(prefix, opts)
+	pending := make([]pendingWrite, 0)
+	for key, item := range c.cache {
+		if inRange([]byte(key), start, end) {
+			pending = append(pending, pendingWrite{
+				key:     stripPrefix([]byte(key), prefix),
+				value:   item.Value,
+				deleted: item.Deleted,
+			})
+		}
+	}
+	// the limit can only be applied after the pending writes are merged in
+	storeOpts := opts
+	storeOpts.Limit = 0
+	it := newMergeIterator(NewIterator(c.store, prefix, storeOpts), pending, opts.Reverse)
+	return withLimit(it, opts.Limit)
+}
+
 func (c *cacheTx) Has(key []byte) bool {
 	if item, ok := c.cache[string(key)]; ok {
 		return !item.Deleted
@@ -175,6 +201,13 @@ func (r *prefixReader) Range(prefix []byte) plugin.RangeData {
 	return r.reader.Range(util.PrefixKey(r.prefix, prefix))
 }
 
+func (r *prefixReader) NewIterator(prefix []byte, opts RangeOptions) Iterator {
+	if len(prefix) == 0 {
+		return NewIterator(r.reader, r.prefix, opts)
+	}
+	return NewIterator(r.reader, util.PrefixKey(r.prefix, prefix), opts)
+}
+
 func (r *prefixReader) Get(key []byte) []byte {
 	return r.reader.Get(util.PrefixKey(r.prefix, key))
 }
@@ -0,0 +1,348 @@
+package store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/registry"
+	"github.com/pkg/errors"
+)
+
+// Kinds of contracts tracked by StorageUsageReport.
+const (
+	GoContract  = "go"
+	EVMContract = "evm"
+)
+
+// Sub-prefixes used to break down the storage used by EVM contracts.
+const (
+	evmUsageAccount = "account"
+	evmUsageCode    = "code"
+	evmUsageStorage = "storage"
+	// Go contract code (or rather its name & version) isn't stored under the contract data prefix
+	goUsageCode = "<code>"
+)
+
+// StorageUsage is the number of keys, and the number of bytes taken up by the keys & values, in
+// some subset of a store.
+type StorageUsage struct {
+	NumKeys    int64 `json:"numKeys"`
+	KeyBytes   int64 `json:"keyBytes"`
+	ValueBytes int64 `json:"valueBytes"`
+}
+
+// TotalBytes returns the total number of bytes taken up by keys & values.
+func (u StorageUsage) TotalBytes() int64 {
+	return u.KeyBytes + u.ValueBytes
+}
+
+func (u *StorageUsage) add(keyLen, valueLen int) {
+	u.NumKeys++
+	u.KeyBytes += int64(keyLen)
+	u.ValueBytes += int64(valueLen)
+}
+
+func (u StorageUsage) sub(other StorageUsage) StorageUsage {
+	return StorageUsage{
+		NumKeys:    u.NumKeys - other.NumKeys,
+		KeyBytes:   u.KeyBytes - other.KeyBytes,
+		ValueBytes: u.ValueBytes - other.ValueBytes,
+	}
+}
+
+// ContractStorageUsage is the storage used by a single Go or EVM contract.
+type ContractStorageUsage struct {
+	StorageUsage
+	Kind    string `json:"kind"`
+	Address string `json:"address"`
+	// Name of the contract in the registry, empty if the contract doesn't have a name.
+	Name string `json:"name,omitempty"`
+	// Breakdown of the storage used by the contract by the first part of the key, e.g. a Go
+	// contract might store balances under one prefix, and allowances under another.
+	Prefixes map[string]*StorageUsage `json:"prefixes"`
+}
+
+func (u *ContractStorageUsage) add(prefix string, keyLen, valueLen int) {
+	u.StorageUsage.add(keyLen, valueLen)
+	prefixUsage, ok := u.Prefixes[prefix]
+	if !ok {
+		prefixUsage = &StorageUsage{}
+		u.Prefixes[prefix] = prefixUsage
+	}
+	prefixUsage.add(keyLen, valueLen)
+}
+
+// StorageUsageReport is the storage used by the app store (and EVM state) at a particular version,
+// or if the report is generated by StorageUsageReport.Growth, the change in storage usage between
+// two versions.
+type StorageUsageReport struct {
+	Version int64 `json:"version"`
+	// Storage used by all the keys in the app store.
+	Total StorageUsage `json:"total"`
+	// Breakdown of the storage used by the app store by the first part of each key.
+	Prefixes map[string]*StorageUsage `json:"prefixes"`
+	// Storage used by each contract, keyed by the kind & address of the contract.
+	Contracts map[string]*ContractStorageUsage `json:"contracts"`
+	// Storage used by all the EVM accounts (including the ones without code or storage), only the
+	// leaves of the state & storage tries are counted, not the intermediate trie nodes.
+	EvmAccounts StorageUsage `json:"evmAccounts"`
+}
+
+func newStorageUsageReport(version int64) *StorageUsageReport {
+	return &StorageUsageReport{
+		Version:   version,
+		Prefixes:  map[string]*StorageUsage{},
+		Contracts: map[string]*ContractStorageUsage{},
+	}
+}
+
+func (r *StorageUsageReport) contract(kind string, addr []byte) *ContractStorageUsage {
+	address := "0x" + hex.EncodeToString(addr)
+	key := kind + ":" + address
+	usage, ok := r.Contracts[key]
+	if !ok {
+		usage = &ContractStorageUsage{
+			Kind:     kind,
+			Address:  address,
+			Prefixes: map[string]*StorageUsage{},
+		}
+		r.Contracts[key] = usage
+	}
+	return usage
+}
+
+// TopContracts returns up to n contracts sorted by the number of bytes they take up, in descending
+// order. If n is zero all the contracts are returned.
+func (r *StorageUsageReport) TopContracts(n int) []*ContractStorageUsage {
+	contracts := make([]*ContractStorageUsage, 0, len(r.Contracts))
+	for _, usage := range r.Contracts {
+		contracts = append(contracts, usage)
+	}
+	sort.Slice(contracts, func(i, j int) bool {
+		if contracts[i].TotalBytes() != contracts[j].TotalBytes() {
+			return contracts[i].TotalBytes() > contracts[j].TotalBytes()
+		}
+		return contracts[i].Address < contracts[j].Address
+	})
+	if n > 0 && n < len(contracts) {
+		contracts = contracts[:n]
+	}
+	return contracts
+}
+
+// Growth returns a report with the change in storage usage since the given (earlier) report.
+func (r *StorageUsageReport) Growth(prev *StorageUsageReport) *StorageUsageReport {
+	growth := newStorageUsageReport(r.Version)
+	growth.Total = r.Total.sub(prev.Total)
+	growth.EvmAccounts = r.EvmAccounts.sub(prev.EvmAccounts)
+	growth.Prefixes = diffUsageMaps(r.Prefixes, prev.Prefixes)
+	for key, usage := range r.Contracts {
+		prevUsage, ok := prev.Contracts[key]
+		if !ok {
+			prevUsage = &ContractStorageUsage{}
+		}
+		growth.Contracts[key] = &ContractStorageUsage{
+			StorageUsage: usage.StorageUsage.sub(prevUsage.StorageUsage),
+			Kind:         usage.Kind,
+			Address:      usage.Address,
+			Name:         usage.Name,
+			Prefixes:     diffUsageMaps(usage.Prefixes, prevUsage.Prefixes),
+		}
+	}
+	for key, prevUsage := range prev.Contracts {
+		if _, ok := r.Contracts[key]; !ok {
+			growth.Contracts[key] = &ContractStorageUsage{
+				StorageUsage: StorageUsage{}.sub(prevUsage.StorageUsage),
+				Kind:         prevUsage.Kind,
+				Address:      prevUsage.Address,
+				Name:         prevUsage.Name,
+				Prefixes:     diffUsageMaps(nil, prevUsage.Prefixes),
+			}
+		}
+	}
+	return growth
+}
+
+func diffUsageMaps(cur, prev map[string]*StorageUsage) map[string]*StorageUsage {
+	diff := map[string]*StorageUsage{}
+	for key, usage := range cur {
+		prevUsage, ok := prev[key]
+		if !ok {
+			prevUsage = &StorageUsage{}
+		}
+		growth := usage.sub(*prevUsage)
+		diff[key] = &growth
+	}
+	for key, prevUsage := range prev {
+		if _, ok := cur[key]; !ok {
+			growth := StorageUsage{}.sub(*prevUsage)
+			diff[key] = &growth
+		}
+	}
+	return diff
+}
+
+// AnalyzeStorageUsage scans all the keys in the given app store snapshot and reports the storage
+// used by each key prefix, and by each Go contract. If evmState isn't nil the EVM state trie it
+// contains is walked to report the storage used by each EVM contract, this can take a while for
+// large state tries.
+func AnalyzeStorageUsage(version int64, app KVReader, evmState EvmStateReader) (*StorageUsageReport, error) {
+	report := newStorageUsageReport(version)
+	names := map[string]string{}
+
+	it := NewIterator(app, nil, RangeOptions{})
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		key, value := it.Key(), it.Value()
+		report.Total.add(len(key), len(value))
+
+		prefix := keyPrefixName(key)
+		if prefix == "" {
+			prefix = string(key)
+		}
+		if !isPrintable([]byte(prefix)) {
+			prefix = "<binary>"
+		}
+		prefixUsage, ok := report.Prefixes[prefix]
+		if !ok {
+			prefixUsage = &StorageUsage{}
+			report.Prefixes[prefix] = prefixUsage
+		}
+		prefixUsage.add(len(key), len(value))
+
+		if addr, rest := contractCodeKeyLayout.match(key); addr != nil && len(rest) == 0 {
+			report.contract(GoContract, addr).add(goUsageCode, len(key), len(value))
+		} else if addr, rest := contractDataKeyLayout.match(key); addr != nil && len(rest) > 1 && rest[0] == 0 {
+			report.contract(GoContract, addr).add(contractKeyPrefix(rest[1:]), len(key), len(value))
+		} else if prefix == "registry" || prefix == "reg_crec" {
+			// both versions of the registry store the contract record under these prefixes
+			var record registry.Record
+			if err := proto.Unmarshal(value, &record); err == nil && record.Address != nil &&
+				record.Address.Local != nil && record.Name != "" {
+				names["0x"+hex.EncodeToString(record.Address.Local)] = record.Name
+			}
+		}
+	}
+
+	if evmState != nil {
+		if err := analyzeEvmStorageUsage(report, evmState); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, usage := range report.Contracts {
+		usage.Name = names[usage.Address]
+	}
+	return report, nil
+}
+
+// contractKeyPrefix returns the first part of a contract key, which is usually the name of a
+// collection of contract data.
+func contractKeyPrefix(key []byte) string {
+	parts := bytes.SplitN(key, []byte{0}, 2)
+	if len(parts) == 1 {
+		return "<root>"
+	}
+	if isPrintable(parts[0]) {
+		return string(parts[0])
+	}
+	return "0x" + hex.EncodeToString(parts[0])
+}
+
+// analyzeEvmStorageUsage walks the EVM state trie and records the storage used by each account that
+// has code or storage.
+func analyzeEvmStorageUsage(report *StorageUsageReport, evmState EvmStateReader) error {
+	stateTrie, err := openEvmTrie(evmState, evmStateRoot(evmState))
+	if err != nil {
+		return errors.Wrap(err, "failed to load EVM state")
+	}
+	d := &evmStateDiffer{from: evmState, to: evmState}
+	it := trie.NewIterator(stateTrie.NodeIterator(nil))
+	for it.Next() {
+		report.EvmAccounts.add(len(it.Key), len(it.Value))
+		var account evmAccount
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			return errors.Wrapf(err, "failed to decode EVM account %x", it.Key)
+		}
+		hasCode := len(account.CodeHash) > 0 && !bytes.Equal(account.CodeHash, emptyCodeHash[:])
+		hasStorage := account.Root != emptyTrieRoot && account.Root != (common.Hash{})
+		if !hasCode && !hasStorage {
+			continue
+		}
+
+		addr := d.preimage(it.Key)
+		if addr == nil {
+			addr = it.Key
+		}
+		usage := report.contract(EVMContract, addr)
+		usage.add(evmUsageAccount, len(it.Key), len(it.Value))
+		if hasCode {
+			code := evmState.Get(util.PrefixKey(vmPrefix, account.CodeHash))
+			usage.add(evmUsageCode, len(account.CodeHash), len(code))
+		}
+		if hasStorage {
+			storageTrie, err := openEvmTrie(evmState, account.Root)
+			if err != nil {
+				return errors.Wrapf(err, "failed to load storage of EVM account %x", it.Key)
+			}
+			storageIt := trie.NewIterator(storageTrie.NodeIterator(nil))
+			for storageIt.Next() {
+				usage.add(evmUsageStorage, len(storageIt.Key), len(storageIt.Value))
+			}
+			if storageIt.Err != nil {
+				return errors.Wrapf(storageIt.Err, "failed to walk storage of EVM account %x", it.Key)
+			}
+		}
+	}
+	return errors.Wrap(it.Err, "failed to walk EVM state")
+}
+
+// AnalyzeDBUsage reports the number of keys & bytes in a raw DB snapshot, broken down by the first
+// part of each key. Unlike AnalyzeStorageUsage this includes all the versions of the data in the
+// DB, e.g. all the trie nodes in evm.db that haven't been pruned yet.
+func AnalyzeDBUsage(snapshot db.Snapshot) (StorageUsage, map[string]*StorageUsage) {
+	var total StorageUsage
+	prefixes := map[string]*StorageUsage{}
+	it := snapshot.NewIterator(nil, nil)
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		key, value := it.Key(), it.Value()
+		total.add(len(key), len(value))
+		prefix := keyPrefixName(key)
+		if bytes.Equal([]byte(prefix), vmPrefix) {
+			prefix += "/" + evmDBKeyKind(key[len(vmPrefix)+1:])
+		} else if prefix == "" || !isPrintable([]byte(prefix)) {
+			prefix = "<other>"
+		}
+		prefixUsage, ok := prefixes[prefix]
+		if !ok {
+			prefixUsage = &StorageUsage{}
+			prefixes[prefix] = prefixUsage
+		}
+		prefixUsage.add(len(key), len(value))
+	}
+	return total, prefixes
+}
+
+// evmDBKeyKind returns the kind of data stored under a key in evm.db (minus the vm prefix).
+func evmDBKeyKind(key []byte) string {
+	switch {
+	case len(key) == common.HashLength:
+		// trie nodes & contract code are both keyed by their hash
+		return "hashed"
+	case bytes.HasPrefix(key, evmPreimagePrefix):
+		return "preimage"
+	case bytes.HasPrefix(key, util.PrefixKey(evmRootPrefix, nil)):
+		return "root"
+	case bytes.Equal(key, rootKey):
+		return "root"
+	}
+	return "other"
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	proto "github.com/gogo/protobuf/proto"
+	"github.com/loomnetwork/go-loom"
+	"github.com/loomnetwork/go-loom/util"
+	"github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/registry"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeStorageUsage(t *testing.T) {
+	appDB, _ := db.LoadMemDB()
+	iavlStore, err := NewIAVLStore(appDB, 0, 0, 0)
+	require.NoError(t, err)
+
+	contractAddr := loom.MustParseAddress("default:0x5cecd1f7261e1f4c684e297be3edf03b825e01c4")
+	record, err := proto.Marshal(&registry.Record{
+		Name:    "coin",
+		Address: contractAddr.MarshalPB(),
+		Owner:   contractAddr.MarshalPB(),
+	})
+	require.NoError(t, err)
+	iavlStore.Set(util.PrefixKey([]byte("reg_crec"), contractAddr.Bytes()), record)
+	iavlStore.Set(loom.TextKey(contractAddr), []byte("coin:1.0.0"))
+	iavlStore.Set(util.PrefixKey(loom.DataPrefix(contractAddr), []byte("balance"), []byte("a")), []byte("1"))
+	iavlStore.Set(util.PrefixKey(loom.DataPrefix(contractAddr), []byte("balance"), []byte("b")), []byte("2"))
+	iavlStore.Set(util.PrefixKey([]byte("nonce"), []byte("a")), []byte{1})
+	_, _, err = iavlStore.SaveVersion()
+	require.NoError(t, err)
+
+	iavlStore.Set(util.PrefixKey(loom.DataPrefix(contractAddr), []byte("allowance"), []byte("a")), []byte("10"))
+	iavlStore.Delete(util.PrefixKey([]byte("nonce"), []byte("a")))
+	_, _, err = iavlStore.SaveVersion()
+	require.NoError(t, err)
+
+	analyze := func(version int64) *StorageUsageReport {
+		snapshot, err := iavlStore.GetSnapshotAt(version)
+		require.NoError(t, err)
+		defer snapshot.Release()
+		report, err := AnalyzeStorageUsage(version, snapshot, nil)
+		require.NoError(t, err)
+		return report
+	}
+
+	report1 := analyze(1)
+	require.Equal(t, int64(5), report1.Total.NumKeys)
+	require.Equal(t, int64(1), report1.Prefixes["nonce"].NumKeys)
+	require.Len(t, report1.Contracts, 1)
+	contract := report1.TopContracts(1)[0]
+	require.Equal(t, GoContract, contract.Kind)
+	require.Equal(t, "coin", contract.Name)
+	require.Equal(t, int64(3), contract.NumKeys)
+	require.Equal(t, int64(2), contract.Prefixes["balance"].NumKeys)
+	require.Equal(t, int64(2), contract.Prefixes["balance"].ValueBytes)
+	require.Equal(t, int64(1), contract.Prefixes[goUsageCode].NumKeys)
+
+	growth := analyze(2).Growth(report1)
+	require.Equal(t, int64(0), growth.Total.NumKeys)
+	require.Equal(t, int64(-1), growth.Prefixes["nonce"].NumKeys)
+	contract = growth.TopContracts(1)[0]
+	require.Equal(t, int64(1), contract.NumKeys)
+	require.Equal(t, int64(2), contract.Prefixes["allowance"].ValueBytes)
+	require.Equal(t, int64(0), contract.Prefixes["balance"].NumKeys)
+}
+
+func TestAnalyzeEvmStorageUsage(t *testing.T) {
+	evmDB, err := db.LoadMemDB()
+	require.NoError(t, err)
+	evmStore := NewEvmStore(evmDB, 100)
+	s := newEvmPruneTestState(t, evmStore)
+	s.populate()
+
+	appDB, _ := db.LoadMemDB()
+	iavlStore, err := NewIAVLStore(appDB, 0, 0, 0)
+	require.NoError(t, err)
+
+	contractKey := func(addr common.Address) string {
+		return EVMContract + ":0x" + hex.EncodeToString(addr.Bytes())
+	}
+	analyze := func(version int64) *StorageUsageReport {
+		snapshot := evmStore.GetSnapshot(version)
+		defer snapshot.Release()
+		report, err := AnalyzeStorageUsage(version, iavlStore.GetSnapshot(), snapshot)
+		require.NoError(t, err)
+		return report
+	}
+
+	report1 := analyze(1)
+	require.Equal(t, int64(3), report1.EvmAccounts.NumKeys)
+	require.Len(t, report1.Contracts, 2)
+	contract := report1.Contracts[contractKey(pruneTestAddr1)]
+	require.NotNil(t, contract)
+	require.Equal(t, int64(5), contract.Prefixes[evmUsageStorage].NumKeys)
+	require.Equal(t, int64(len(pruneTestCode)), contract.Prefixes[evmUsageCode].ValueBytes)
+
+	// the 2nd contract was deleted in version 2
+	growth := analyze(2).Growth(report1)
+	require.Len(t, growth.Contracts, 2)
+	require.Equal(t, int64(0), growth.EvmAccounts.NumKeys)
+	require.Equal(t, int64(0), growth.Contracts[contractKey(pruneTestAddr1)].NumKeys)
+	require.Equal(t, int64(-7), growth.Contracts[contractKey(pruneTestAddr2)].NumKeys)
+
+	total, prefixes := AnalyzeDBUsage(evmDB.GetSnapshot())
+	require.Equal(t, int64(3), prefixes["vm/root"].NumKeys)
+	require.True(t, prefixes["vm/hashed"].NumKeys > 0)
+	require.True(t, total.NumKeys > prefixes["vm/hashed"].NumKeys)
+}
@@ -14,6 +14,7 @@ import (
 	loom "github.com/loomnetwork/go-loom"
 	"github.com/pkg/errors"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/tendermint/tendermint/crypto/merkle"
 )
 
 const separator = "|"
@@ -378,6 +379,26 @@ func (c *versionedCachingStore) GetSnapshot() Snapshot {
 	)
 }
 
+// GetSnapshotAt returns a read-only snapshot of the underlying store at the given version.
+// Snapshots of older versions bypass the cache since it only tracks the most recent versions of
+// each key.
+func (c *versionedCachingStore) GetSnapshotAt(version int64) (Snapshot, error) {
+	if version == 0 || version == c.version-1 {
+		return c.GetSnapshot(), nil
+	}
+	return c.VersionedKVStore.GetSnapshotAt(version)
+}
+
+// NewIterator bypasses the cache, which only tracks individual keys.
+func (c *versionedCachingStore) NewIterator(prefix []byte, opts RangeOptions) Iterator {
+	return NewIterator(c.VersionedKVStore, prefix, opts)
+}
+
+// GetWithProof bypasses the cache, proofs can only be generated by the underlying store.
+func (c *versionedCachingStore) GetWithProof(key []byte, version int64) ([]byte, *merkle.Proof, error) {
+	return getWithProof(c.VersionedKVStore, key, version)
+}
+
 // CachingStoreSnapshot is a read-only CachingStore with specified version
 type versionedCachingStoreSnapshot struct {
 	Snapshot
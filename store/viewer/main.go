@@ -13,7 +13,7 @@ func main() {
 		Short: "tool for viewing loom db",
 	}
 
-	rootCmd.AddCommand(loadCmd())
+	rootCmd.AddCommand(loadCmd(), usageCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	cdb "github.com/loomnetwork/loomchain/db"
+	"github.com/loomnetwork/loomchain/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+type usageFlags struct {
+	Version        int64
+	CompareVersion int64
+	Backend        string
+	EvmDBPath      string
+	EvmBackend     string
+	SkipEVM        bool
+	RawEvmDB       bool
+	Top            int
+	Format         string
+}
+
+// usageResult is the JSON output of the usage command.
+type usageResult struct {
+	Usage  *store.StorageUsageReport `json:"usage"`
+	Growth *store.StorageUsageReport `json:"growth,omitempty"`
+	// Raw usage of evm.db, including all the versions of the EVM state that haven't been pruned.
+	EvmDB *evmDBUsage `json:"evmDB,omitempty"`
+}
+
+type evmDBUsage struct {
+	Total    store.StorageUsage             `json:"total"`
+	Prefixes map[string]*store.StorageUsage `json:"prefixes"`
+}
+
+func usageCmd() *cobra.Command {
+	var flags usageFlags
+	cmd := &cobra.Command{
+		Use:   "usage <path/to/app.db>",
+		Short: "Reports the storage used by each contract and key prefix",
+		Long: "Scans app.db (and evm.db if it exists alongside app.db) at the given version, and reports " +
+			"the number of keys & bytes used by each key prefix, and by each Go & EVM contract. " +
+			"If --compare-version is specified the growth since that version is reported too.",
+		Example: "  loomdb-viewer usage app.db --top 10\n" +
+			"  loomdb-viewer usage app.db --version 2000 --compare-version 1000 --format json",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if flags.Format != "text" && flags.Format != "json" {
+				return fmt.Errorf("invalid format %s, must be text or json", flags.Format)
+			}
+			return reportStorageUsage(args[0], flags)
+		},
+	}
+	cmdFlags := cmd.Flags()
+	cmdFlags.Int64Var(&flags.Version, "version", 0, "Version of app.db to analyze (defaults to the last version)")
+	cmdFlags.Int64Var(&flags.CompareVersion, "compare-version", 0, "Earlier version to compute growth from")
+	cmdFlags.StringVar(&flags.Backend, "backend", cdb.GoLevelDBBackend, "Backend of app.db")
+	cmdFlags.StringVar(
+		&flags.EvmDBPath, "evm-db", "", "Path to evm.db (defaults to evm.db in the same dir as app.db)",
+	)
+	cmdFlags.StringVar(&flags.EvmBackend, "evm-backend", cdb.GoLevelDBBackend, "Backend of evm.db")
+	cmdFlags.BoolVar(&flags.SkipEVM, "skip-evm", false, "Don't walk the EVM state to analyze EVM contracts")
+	cmdFlags.BoolVar(
+		&flags.RawEvmDB, "raw-evm-db", false,
+		"Also report the raw usage of evm.db, which includes all the unpruned versions of the EVM state",
+	)
+	cmdFlags.IntVar(&flags.Top, "top", 20, "Number of contracts to report (0 to report all contracts)")
+	cmdFlags.StringVar(&flags.Format, "format", "text", "Output format (text or json)")
+	return cmd
+}
+
+func reportStorageUsage(appDBPath string, flags usageFlags) error {
+	absPath, err := filepath.Abs(appDBPath)
+	if err != nil {
+		return err
+	}
+	dbDir := path.Dir(absPath)
+	appDB, err := cdb.LoadDB(flags.Backend, strings.TrimSuffix(path.Base(absPath), ".db"), dbDir, 256, 4, false)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load %s", appDBPath)
+	}
+	defer appDB.Close()
+	iavlStore, err := store.NewIAVLStore(appDB, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	var evmDB cdb.DBWrapper
+	var evmStore *store.EvmStore
+	evmDBPath := flags.EvmDBPath
+	if evmDBPath == "" {
+		evmDBPath = filepath.Join(dbDir, "evm.db")
+	}
+	if _, err := os.Stat(evmDBPath); err == nil {
+		evmDBPath, err = filepath.Abs(evmDBPath)
+		if err != nil {
+			return err
+		}
+		evmDB, err = cdb.LoadDB(
+			flags.EvmBackend, strings.TrimSuffix(path.Base(evmDBPath), ".db"), path.Dir(evmDBPath), 256, 4, false,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load %s", evmDBPath)
+		}
+		defer evmDB.Close()
+		evmStore = store.NewEvmStore(evmDB, 100)
+	} else if flags.EvmDBPath != "" {
+		return errors.Wrapf(err, "failed to find %s", flags.EvmDBPath)
+	}
+
+	analyze := func(version int64) (*store.StorageUsageReport, error) {
+		appSnapshot, err := iavlStore.GetSnapshotAt(version)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load app.db version %d", version)
+		}
+		defer appSnapshot.Release()
+		var evmState store.EvmStateReader
+		if !flags.SkipEVM {
+			if evmStore != nil {
+				evmSnapshot := evmStore.GetSnapshot(version)
+				defer evmSnapshot.Release()
+				evmState = evmSnapshot
+			} else {
+				// the EVM state is stored in app.db
+				evmState = appSnapshot
+			}
+		}
+		return store.AnalyzeStorageUsage(version, appSnapshot, evmState)
+	}
+
+	version := flags.Version
+	if version == 0 {
+		version = iavlStore.Version()
+	}
+	result := &usageResult{}
+	if result.Usage, err = analyze(version); err != nil {
+		return err
+	}
+	if flags.CompareVersion > 0 {
+		prev, err := analyze(flags.CompareVersion)
+		if err != nil {
+			return err
+		}
+		result.Growth = result.Usage.Growth(prev)
+	}
+	if flags.RawEvmDB && evmDB != nil {
+		snapshot := evmDB.GetSnapshot()
+		total, prefixes := store.AnalyzeDBUsage(snapshot)
+		snapshot.Release()
+		result.EvmDB = &evmDBUsage{Total: total, Prefixes: prefixes}
+	}
+
+	if flags.Format == "json" {
+		output, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	fmt.Printf("Storage usage at version %d\n\n", version)
+	printStorageUsageReport(result.Usage, flags.Top)
+	if result.Growth != nil {
+		fmt.Printf("\nGrowth from version %d to %d\n\n", flags.CompareVersion, version)
+		printStorageUsageReport(result.Growth, flags.Top)
+	}
+	if result.EvmDB != nil {
+		fmt.Printf("\nRaw usage of %s\n\n", evmDBPath)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "PREFIX\tKEYS\tBYTES")
+		printUsageMap(w, "", result.EvmDB.Prefixes)
+		fmt.Fprintf(w, "total\t%d\t%d\n", result.EvmDB.Total.NumKeys, result.EvmDB.Total.TotalBytes())
+		w.Flush()
+	}
+	return nil
+}
+
+func printStorageUsageReport(report *store.StorageUsageReport, top int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PREFIX\tKEYS\tBYTES")
+	printUsageMap(w, "", report.Prefixes)
+	fmt.Fprintf(w, "total\t%d\t%d\n", report.Total.NumKeys, report.Total.TotalBytes())
+	fmt.Fprintf(w, "evm accounts\t%d\t%d\n", report.EvmAccounts.NumKeys, report.EvmAccounts.TotalBytes())
+	w.Flush()
+
+	contracts := report.TopContracts(top)
+	if len(contracts) == 0 {
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTRACT\tKIND\tNAME\tKEYS\tBYTES")
+	for _, contract := range contracts {
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%d\t%d\n",
+			contract.Address, contract.Kind, contract.Name, contract.NumKeys, contract.TotalBytes(),
+		)
+		printUsageMap(w, "\t\t  ", contract.Prefixes)
+	}
+	w.Flush()
+}
+
+// printUsageMap prints the given usage breakdown sorted by the number of bytes used, the indent is
+// prepended to each line and may contain tabs to skip columns.
+func printUsageMap(w *tabwriter.Writer, indent string, usage map[string]*store.StorageUsage) {
+	prefixes := make([]string, 0, len(usage))
+	for prefix := range usage {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		a, b := usage[prefixes[i]].TotalBytes(), usage[prefixes[j]].TotalBytes()
+		if a != b {
+			return a > b
+		}
+		return prefixes[i] < prefixes[j]
+	})
+	for _, prefix := range prefixes {
+		fmt.Fprintf(w, "%s%s\t%d\t%d\n", indent, prefix, usage[prefix].NumKeys, usage[prefix].TotalBytes())
+	}
+}